@@ -0,0 +1,90 @@
+// Command maskcheck compares a mask file with the geofence polygon it was
+// meant to cover and reports tiles where the two disagree. It exits non-zero
+// on any discrepancy so it can gate a deploy.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"splat-boston/internal/geo"
+)
+
+func main() {
+	maskPath := flag.String("mask", os.Getenv("BOSTON_MASK_PATH"), "mask file (defaults to $BOSTON_MASK_PATH)")
+	fencePath := flag.String("geojson", "greater_boston_polygon.geojson", "geofence polygon")
+	boundsFlag := flag.String("bounds", "", `tile bounds a headerless mask was built with: "minx,miny,maxx,maxy"; omit for a mask file with a header`)
+	sample := flag.Int("sample", 10, "example tiles to print for each kind of discrepancy")
+	tileMeters := flag.Float64("tile-meters", 10, "tile size in meters the mask was built for")
+	flag.Parse()
+
+	if *maskPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var bounds *geo.Bounds
+	if *boundsFlag != "" {
+		bounds = new(geo.Bounds)
+		if _, err := fmt.Sscanf(*boundsFlag, "%d,%d,%d,%d", &bounds.MinX, &bounds.MinY, &bounds.MaxX, &bounds.MaxY); err != nil {
+			log.Fatalf("Invalid -bounds %q: %v", *boundsFlag, err)
+		}
+	}
+
+	fence, err := loadFence(*fencePath)
+	if err != nil {
+		log.Fatalf("Failed to load geofence: %v", err)
+	}
+	proj, err := geo.NewProjection(*tileMeters, geo.DefaultProjection.ChunkSize)
+	if err != nil {
+		log.Fatalf("Invalid -tile-meters: %v", err)
+	}
+	mask, err := loadMask(*maskPath, bounds, proj)
+	if err != nil {
+		log.Fatalf("Failed to load mask: %v", err)
+	}
+	proj = mask.Projection()
+
+	report := geo.CompareMask(mask, fence, *sample)
+	fmt.Printf("checked %d tiles\n", report.Checked)
+	printDiscrepancies(proj, "allowed by mask but outside geofence", report.OutsideFence, report.OutsideFenceSample)
+	printDiscrepancies(proj, "inside geofence but not allowed by mask", report.Uncovered, report.UncoveredSample)
+
+	if !report.Ok() {
+		os.Exit(1)
+	}
+	fmt.Println("mask matches geofence")
+}
+
+func loadFence(path string) (*geo.Polygon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return geo.LoadGeoJSON(f)
+}
+
+// loadMask reads a headerless mask built with bounds and proj, or, with no
+// bounds, a mask file that records its own
+func loadMask(path string, bounds *geo.Bounds, proj geo.Projection) (*geo.Mask, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if bounds == nil {
+		return geo.ReadMask(f)
+	}
+	return geo.LoadMaskIn(f, *bounds, proj)
+}
+
+func printDiscrepancies(proj geo.Projection, label string, count int64, sample [][2]int64) {
+	fmt.Printf("%d tiles %s\n", count, label)
+	for _, t := range sample {
+		lat, lon := proj.TileXYToLatLon(t[0], t[1])
+		fmt.Printf("  tile (%d, %d) at %.6f, %.6f\n", t[0], t[1], lat, lon)
+	}
+}
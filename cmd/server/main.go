@@ -1,91 +1,335 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"splat-boston/internal/api"
+	"splat-boston/internal/canvas"
 	"splat-boston/internal/geo"
+	"splat-boston/internal/rate"
 	redisclient "splat-boston/internal/redis"
 	"splat-boston/internal/ws"
 )
 
 func main() {
+	if err := run(); err != nil {
+		fatal("Server failed", err)
+	}
+}
+
+// run starts the server and returns once it has shut down, so the deferred
+// cleanup happens before main exits
+func run() error {
+	logger, err := newLogger(getEnv("LOG_LEVEL", "info"), getEnv("LOG_FORMAT", "text"))
+	if err != nil {
+		fatal("Invalid logging config", err)
+	}
+	slog.SetDefault(logger)
+
 	// Load configuration from environment
 	config := api.Config{
-		EnableTurnstile: getEnvBool("ENABLE_TURNSTILE", false),
-		TurnstileSecret: getEnv("TURNSTILE_SECRET", ""),
-		GeofenceRadiusM: getEnvFloat("GEOFENCE_RADIUS_M", 300.0),
-		SpeedMaxKmh:     getEnvFloat("SPEED_MAX_KMH", 150.0),
-		PaintCooldownMs: getEnvInt("PAINT_COOLDOWN_MS", 5000),
-		WSWriteBuffer:   getEnvInt("WS_WRITE_BUFFER", 1048576),
-		WSPingIntervalS: getEnvInt("WS_PING_INTERVAL_S", 20),
+		EnableTurnstile:  getEnvBool("ENABLE_TURNSTILE", false),
+		TurnstileSecret:  getEnv("TURNSTILE_SECRET", ""),
+		GeofenceRadiusM:  getEnvFloat("GEOFENCE_RADIUS_M", 300.0),
+		SpeedMaxKmh:      getEnvFloat("SPEED_MAX_KMH", 150.0),
+		EnableSpeedLimit: getEnvBool("ENABLE_SPEED_LIMIT", true),
+		LimiterTTLS:      getEnvInt("LIMITER_TTL_S", 3600),
+		PaintCooldownMs:  getEnvInt("PAINT_COOLDOWN_MS", 5000),
+		WSWriteBuffer:    getEnvInt("WS_WRITE_BUFFER", 1048576),
+		WSPingIntervalS:  getEnvInt("WS_PING_INTERVAL_S", 20),
+
+		TrustClientCoords:    getEnvBool("TRUST_CLIENT_COORDS", false),
+		CoordsToleranceTiles: getEnvInt("COORDS_TOLERANCE_TILES", 0),
+		RequireSubscription:  getEnvBool("REQUIRE_SUBSCRIPTION", false),
+
+		TileMeters: getEnvFloat("TILE_METERS", 10),
+
+		NetHintMaxKm:   getEnvFloat("NET_HINT_MAX_KM", 50),
+		NetHintEnforce: getEnvBool("NET_HINT_ENFORCE", false),
+
+		ChunkMaxAgeS:       getEnvInt("CHUNK_MAX_AGE_S", 2),
+		ChunkMaxAgeJitterS: getEnvInt("CHUNK_MAX_AGE_JITTER_S", 1),
+		StaleChunks:        getEnvInt("STALE_CHUNKS", 0),
+
+		ChunkGzipLevel:    getEnvInt("CHUNK_GZIP_LEVEL", 1),
+		ChunkGzipMinBytes: getEnvInt("CHUNK_GZIP_MIN_BYTES", 1024),
+
+		EnableWarmupCooldown: getEnvBool("ENABLE_WARMUP_COOLDOWN", false),
+		WarmupCooldownMs:     getEnvInt("WARMUP_COOLDOWN_MS", 1000),
+
+		EnableStreak:      getEnvBool("ENABLE_STREAK", false),
+		StreakBonusPct:    getEnvInt("STREAK_BONUS_PCT", 5),
+		StreakMaxBonusPct: getEnvInt("STREAK_MAX_BONUS_PCT", 50),
+
+		WSLagRatio:           getEnvFloat("WS_LAG_RATIO", 0),
+		WSCoalesceIntervalMs: getEnvInt("WS_COALESCE_INTERVAL_MS", 250),
+		WSRegisterBuffer:     getEnvInt("WS_REGISTER_BUFFER", 1024),
+
+		WSRecentDeltas:   getEnvInt("WS_RECENT_DELTAS", 64),
+		WSRecentWindowMs: getEnvInt("WS_RECENT_WINDOW_MS", 5000),
+
+		WSMaxRoomRate:        getEnvInt("WS_MAX_ROOM_RATE", 0),
+		WSSnapshotIntervalMs: getEnvInt("WS_SNAPSHOT_INTERVAL_MS", 1000),
+
+		WSPresenceIntervalMs: getEnvInt("WS_PRESENCE_INTERVAL_MS", 1000),
+		WSActivityIntervalMs: getEnvInt("WS_ACTIVITY_INTERVAL_MS", 1000),
+		WSMaxLifetimeS:       getEnvInt("WS_MAX_LIFETIME_S", 0),
+		WSMaxLifetimeJitterS: getEnvInt("WS_MAX_LIFETIME_JITTER_S", 300),
+		WSMaxRoomsPerConn:    getEnvInt("WS_MAX_ROOMS_PER_CONN", 64),
+		WSDropOldest:         getEnvBool("WS_DROP_OLDEST", false),
+
+		WSRequireAuth: getEnvBool("WS_REQUIRE_AUTH", false),
+		WSTokenSecret: getEnv("WS_TOKEN_SECRET", ""),
+		WSTokenTTLS:   getEnvInt("WS_TOKEN_TTL_S", 600),
+
+		WSCompression:      getEnvBool("WS_COMPRESSION", false),
+		WSCompressMinBytes: getEnvInt("WS_COMPRESS_MIN_BYTES", 256),
+
+		KafkaBrokers: getEnv("KAFKA_BROKERS", ""),
+		KafkaTopic:   getEnv("KAFKA_TOPIC", "paint-events"),
+
+		DuplicateWindowMs: getEnvInt("DUPLICATE_WINDOW_MS", 1000),
+		UndoWindowS:       getEnvInt("UNDO_WINDOW_S", 30),
+
+		RedisTimeoutMs: getEnvInt("REDIS_TIMEOUT_MS", 2000),
+
+		StrictPaintJSON: getEnvBool("STRICT_PAINT_JSON", false),
+
+		BanStrikes:       getEnvInt("BAN_STRIKES", 0),
+		BanStrikeWindowS: getEnvInt("BAN_STRIKE_WINDOW_S", 600),
+
+		CDNInvalidateURL:        getEnv("CDN_INVALIDATE_URL", ""),
+		CDNInvalidateDebounceMs: getEnvInt("CDN_INVALIDATE_DEBOUNCE_MS", 1000),
+
+		CORSOrigins: getEnv("CORS_ORIGINS", "*"),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		PaintQueueSize:      getEnvInt("PAINT_QUEUE_SIZE", 0),
+		PaintQueueTimeoutMs: getEnvInt("PAINT_QUEUE_TIMEOUT_MS", 2000),
+
+		// READ_RATE_PER_MIN is the old name, from when the window was fixed
+		ReadRateLimit:   getEnvInt("READ_RATE_LIMIT", getEnvInt("READ_RATE_PER_MIN", 0)),
+		ReadRateWindowS: getEnvInt("READ_RATE_WINDOW_S", 60),
+		ReadRateBurst:   getEnvInt("READ_RATE_BURST", 0),
+
+		ObserverKeys:    getEnv("OBSERVER_KEYS", ""),
+		ObserverMaxAgeS: getEnvInt("OBSERVER_MAX_AGE_S", 300),
+
+		ReadHeaderTimeoutMs: getEnvInt("HTTP_READ_HEADER_TIMEOUT_MS", 5000),
+		ReadTimeoutMs:       getEnvInt("HTTP_READ_TIMEOUT_MS", 30000),
+		WriteTimeoutMs:      getEnvInt("HTTP_WRITE_TIMEOUT_MS", 60000),
+		IdleTimeoutMs:       getEnvInt("HTTP_IDLE_TIMEOUT_MS", 120000),
+	}
+
+	if list := getEnv("PAINT_COOLDOWN_BY_COLOR", ""); list != "" {
+		cooldowns, err := api.ParseCooldownByColor(list)
+		if err != nil {
+			fatal("Invalid PAINT_COOLDOWN_BY_COLOR", err)
+		}
+		config.PaintCooldownByColor = cooldowns
+	}
+
+	if bounds := getEnv("WORLD_BOUNDS", ""); bounds != "" {
+		world, err := api.ParseWorldBounds(bounds)
+		if err != nil {
+			fatal("Invalid WORLD_BOUNDS", err)
+		}
+		config.WorldBounds = &world
+	}
+
+	proxies, err := api.ParseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
+	config.TrustedProxies = proxies
+
+	penalties, err := rate.ParsePenalties(getEnv("BAN_PENALTIES", "1m,10m,1h"))
+	if err != nil {
+		fatal("Invalid BAN_PENALTIES", err)
+	}
+	config.BanPenalties = penalties
+
+	if path := getEnv("PALETTE_FILE", ""); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			fatal("Failed to open PALETTE_FILE", err)
+		}
+		config.Palette, err = api.LoadPalette(f)
+		f.Close()
+		if err != nil {
+			fatal("Invalid PALETTE_FILE", err)
+		}
+	}
+
+	if path := getEnv("PALETTES_FILE", ""); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			fatal("Failed to open PALETTES_FILE", err)
+		}
+		config.Palettes, err = api.LoadPalettes(f)
+		f.Close()
+		if err != nil {
+			fatal("Invalid PALETTES_FILE", err)
+		}
 	}
 
 	bindAddr := getEnv("BIND_ADDR", ":8080")
+	adminBindAddr := getEnv("ADMIN_BIND_ADDR", "")
 	redisURL := getEnv("REDIS_URL", "redis://localhost:6379")
+	useRedisTime := getEnvBool("USE_REDIS_TIME", false)
+	redisWriteRetries := getEnvInt("REDIS_WRITE_RETRIES", 3)
+	redisWriteBackoff := time.Duration(getEnvInt("REDIS_WRITE_BACKOFF_MS", 10)) * time.Millisecond
+	wsHistoryLen := getEnvInt("WS_HISTORY_LEN", 1024)
+	chunkTTLDays := getEnvInt("CHUNK_TTL_DAYS", 0)
+	maxClockSkew := time.Duration(getEnvInt("MAX_CLOCK_SKEW_MS", 1000)) * time.Millisecond
+	resetSchedule := getEnv("RESET_SCHEDULE", "")
+	resetTimezone := getEnv("RESET_TIMEZONE", "America/New_York")
+	epochPoll := time.Duration(getEnvInt("EPOCH_POLL_MS", 5000)) * time.Millisecond
+	deltaFanout := getEnvBool("DELTA_FANOUT", false)
+	shutdownGrace := time.Duration(getEnvInt("SHUTDOWN_GRACE_S", 25)) * time.Second
+
+	// instance identifies this server to the others sharing Redis
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("%s:%d", hostname, os.Getpid())
 
 	// Connect to Redis
 	rdb, err := redisclient.NewClient(redisURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		fatal("Failed to connect to Redis", err)
 	}
 	defer rdb.Close()
 
-	log.Println("Connected to Redis")
+	slog.Info("Connected to Redis")
+
+	// Paint timestamps come from this server's clock unless USE_REDIS_TIME
+	// is set, so warn when the two disagree
+	rdb.UseRedisTime(useRedisTime)
+	rdb.SetWriteRetry(redisWriteRetries, redisWriteBackoff)
+	rdb.KeepHistory(wsHistoryLen)
+	rdb.ExpireChunks(time.Duration(chunkTTLDays) * 24 * time.Hour)
+	if skew, err := rdb.ClockSkew(); err != nil {
+		slog.Warn("Failed to check clock skew against Redis", "err", err)
+	} else if skew > maxClockSkew || skew < -maxClockSkew {
+		slog.Warn("Server clock differs from Redis TIME", "skew", skew, "useRedisTime", useRedisTime)
+	}
 
 	// Create WebSocket hub
-	hub := ws.NewHub()
+	hub := ws.NewHubWithConfig(config.HubConfig())
+	hub.SetSnapshotSource(rdb)
+	redisTimeout := time.Duration(config.RedisTimeoutMs) * time.Millisecond
+	hub.SetHistorySource(api.NewDeltaHistory(rdb, redisTimeout))
+
+	// With several instances behind a load balancer, each one's deltas
+	// must reach clients connected to the others
+	if deltaFanout {
+		bus := api.NewDeltaBus(rdb, hub, instance, redisTimeout)
+		defer bus.Close()
+		hub.SetBus(bus)
+		slog.Info("Delta fan-out over Redis pub/sub enabled", "instance", instance)
+	}
 	go hub.Run()
 
-	log.Println("WebSocket hub started")
+	slog.Info("WebSocket hub started")
 
-	// Load mask (optional - for now we'll use nil)
-	var mask *geo.Mask = nil
+	// Every instance watches the canvas epoch; when a reset is scheduled
+	// they all race for it and one performs it
+	loc, err := time.LoadLocation(resetTimezone)
+	if err != nil {
+		fatal("Invalid RESET_TIMEZONE", err)
+	}
+	schedule, err := canvas.ParseSchedule(resetSchedule, loc)
+	if err != nil {
+		fatal("Invalid RESET_SCHEDULE", err)
+	}
+	scheduler := canvas.NewScheduler(rdb, schedule, instance, hub.SetEpoch)
+	scheduler.Start(epochPoll)
+	defer scheduler.Close()
+	if !schedule.Empty() {
+		slog.Info("Next canvas reset scheduled", "at", schedule.Next(time.Now()).Format(time.RFC3339))
+	}
+
+	// Paints are checked against the mask when one is configured, and the
+	// geofence box otherwise
+	mask, err := loadMask(getEnv("BOSTON_MASK_PATH", ""))
+	if err != nil {
+		fatal("Invalid BOSTON_MASK_PATH", err)
+	}
+	if err := config.CheckMask(mask); err != nil {
+		fatal("Invalid mask", err)
+	}
 
 	// Create handler
 	handler := api.NewHandler(rdb, hub, config, mask)
+	defer handler.Close()
 
-	// CORS middleware
-	corsMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			// Allow requests from any origin in development
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			
-			// Handle preflight
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			
-			next(w, r)
-		}
+	public, admin := handler.Routes(adminBindAddr != "")
+
+	// Operator endpoints get their own listener when ADMIN_BIND_ADDR is set,
+	// so they can stay on an internal interface
+	servers := []*http.Server{config.NewServer(bindAddr, public)}
+	slog.Info("Starting server", "addr", bindAddr)
+	if admin != nil {
+		servers = append(servers, config.NewServer(adminBindAddr, admin))
+		slog.Info("Starting admin server", "addr", adminBindAddr)
 	}
 
-	// Setup routes with CORS
-	http.HandleFunc("/state/chunk", corsMiddleware(handler.GetChunk))
-	http.HandleFunc("/paint", corsMiddleware(handler.PostPaint))
-	http.HandleFunc("/sub", corsMiddleware(handler.HandleWebSocket))
+	// SIGTERM, as on a deploy, drains in-flight requests and WebSockets
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return handler.Serve(ctx, shutdownGrace, servers...)
+}
 
-	// Health check endpoint
-	http.HandleFunc("/healthz", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		if err := rdb.Ping(); err != nil {
-			http.Error(w, "Redis unhealthy", 500)
-			return
-		}
-		w.WriteHeader(200)
-		w.Write([]byte("OK"))
-	}))
+// newLogger builds the server's logger from LOG_LEVEL (debug, info, warn or
+// error) and LOG_FORMAT (text or json)
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := slog.HandlerOptions{Level: lvl}
 
-	// Start server
-	log.Printf("Starting server on %s", bindAddr)
-	if err := http.ListenAndServe(bindAddr, nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, &opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, &opts)), nil
 	}
+	return nil, fmt.Errorf("unknown LOG_FORMAT %q, want text or json", format)
+}
+
+// fatal logs a startup failure and exits
+// loadMask reads the mask file written by geo.Mask.WriteTo at path, or
+// returns nil for an empty path
+func loadMask(path string) (geo.TileMask, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mask, err := geo.ReadMask(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	slog.Info("Loaded mask", "path", path, "bounds", mask.Bounds(), "tileMeters", mask.Projection().TileMeters)
+	return mask, nil
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// RequireAdmin wraps an admin handler with a bearer token check against
+// ADMIN_TOKEN. Admin endpoints are disabled when no token is configured.
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.config.AdminToken == "" {
+			writeError(w, 403, CodeAdminDisabled, "admin disabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) != 1 {
+			writeError(w, 401, CodeUnauthorized, "unauthorized")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ExplainRequest asks how a paint by subject would be judged
+type ExplainRequest struct {
+	// Subject is the identity limits are tracked by (currently the client IP)
+	Subject string       `json:"subject"`
+	Paint   PaintRequest `json:"paint"`
+}
+
+// ExplainResponse reports every paint check for a hypothetical paint
+type ExplainResponse struct {
+	Subject             string       `json:"subject"`
+	Allowed             bool         `json:"allowed"`
+	CooldownRemainingMs int64        `json:"cooldownRemainingMs"`
+	Checks              []PaintCheck `json:"checks"`
+}
+
+// PostExplain handles POST /admin/explain. It runs the paint checks for a
+// subject without short-circuiting and without mutating any state.
+func (h *Handler) PostExplain(w http.ResponseWriter, r *http.Request) {
+	var req ExplainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+
+	if req.Subject == "" {
+		writeError(w, 400, CodeBadRequest, "missing subject")
+		return
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+
+	checks := []PaintCheck{
+		h.checkBan(ctx, req.Subject),
+		h.checkCooldown(req.Subject, req.Paint.Color),
+		h.checkSpeed(req.Subject, req.Paint, false),
+		h.checkGeofence(req.Paint),
+		h.checkOffset(req.Paint),
+		h.checkChunk(req.Paint),
+		h.checkCoords(req.Paint),
+		h.checkSubscription(req.Subject, req.Paint),
+		h.checkNetHint(req.Paint),
+		h.checkMask(req.Paint),
+		h.checkColor(req.Paint),
+		h.checkPalette(req.Paint),
+		h.checkPaletteID(ctx, req.Paint),
+	}
+
+	response := ExplainResponse{
+		Subject:             req.Subject,
+		Allowed:             true,
+		CooldownRemainingMs: h.cooldownLimiter.GetCooldownRemaining(h.cooldownKey(req.Subject, req.Paint.Color), h.paintCooldown()).Milliseconds(),
+		Checks:              checks,
+	}
+	for _, check := range checks {
+		if !check.Pass {
+			response.Allowed = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkPalette reports whether the chunk's region palette permits the
+// color. PostPaint leaves this to the paint script, so only explain uses it.
+func (h *Handler) checkPalette(req PaintRequest) PaintCheck {
+	allowed, err := h.rdb.IsColorAllowed(req.Cx, req.Cy, req.Color)
+	if err != nil {
+		return failed("palette", fmt.Sprintf("redis: %v", err), 500, CodeRedis, "redis error")
+	}
+	if !allowed {
+		return failed("palette", fmt.Sprintf("color %d not in chunk (%d, %d) palette", req.Color, req.Cx, req.Cy), 403, CodeColorNotAllowed, "color not allowed")
+	}
+	return passed("palette", "")
+}
+
+// MaskRequest opens (allowed) or closes a rectangle of tiles, inclusive and
+// in global tile coordinates
+type MaskRequest struct {
+	MinX    int64 `json:"minX"`
+	MinY    int64 `json:"minY"`
+	MaxX    int64 `json:"maxX"`
+	MaxY    int64 `json:"maxY"`
+	Allowed bool  `json:"allowed"`
+}
+
+// MaskResponse reports how many tiles a MaskRequest changed
+type MaskResponse struct {
+	Tiles int `json:"tiles"`
+}
+
+// PostMask handles POST /admin/mask, editing the loaded mask and telling
+// subscribers of the affected chunks so they can update their overlay. Only
+// this instance's mask changes.
+func (h *Handler) PostMask(w http.ResponseWriter, r *http.Request) {
+	var req MaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+	if req.MinX > req.MaxX || req.MinY > req.MaxY {
+		writeError(w, 400, CodeBadRequest, "empty rectangle")
+		return
+	}
+	if h.mask == nil {
+		writeError(w, 409, CodeNoMask, "no mask loaded")
+		return
+	}
+
+	// Only the part inside the mask changes, so only that is announced
+	bounds := h.mask.Bounds()
+	change := ws.MaskChange{
+		Type:    "mask",
+		MinX:    max(req.MinX, bounds.MinX),
+		MinY:    max(req.MinY, bounds.MinY),
+		MaxX:    min(req.MaxX, bounds.MaxX),
+		MaxY:    min(req.MaxY, bounds.MaxY),
+		Allowed: req.Allowed,
+	}
+	tiles := h.mask.SetRect(change.MinX, change.MinY, change.MaxX, change.MaxY, change.Allowed)
+	if tiles > 0 {
+		minCx, minCy := h.proj.ChunkOf(change.MinX, change.MinY)
+		maxCx, maxCy := h.proj.ChunkOf(change.MaxX, change.MaxY)
+		h.hub.NotifyChunks(minCx, minCy, maxCx, maxCy, change)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MaskResponse{Tiles: tiles})
+}
+
+// BackgroundRequest sets the color a chunk's unpainted tiles read as; 0
+// clears it
+type BackgroundRequest struct {
+	Cx    int64 `json:"cx"`
+	Cy    int64 `json:"cy"`
+	Color uint8 `json:"color"`
+}
+
+// PostBackground handles POST /admin/background. Tiles already painted keep
+// their color; only those still unpainted take the background, and they
+// remain unpainted as far as paints and deltas are concerned.
+func (h *Handler) PostBackground(w http.ResponseWriter, r *http.Request) {
+	var req BackgroundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+	if req.Color > 15 {
+		writeError(w, 400, CodeInvalidColor, "invalid color")
+		return
+	}
+
+	if err := h.rdb.SetChunkBackground(req.Cx, req.Cy, req.Color); err != nil {
+		h.redisError("background", err)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+	h.hub.NotifyChunks(req.Cx, req.Cy, req.Cx, req.Cy, ws.BackgroundChange{
+		Type:  "background",
+		Cx:    req.Cx,
+		Cy:    req.Cy,
+		Color: req.Color,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PaletteRequest assigns a chunk a named palette; an empty PaletteID
+// returns it to the default
+type PaletteRequest struct {
+	Cx        int64  `json:"cx"`
+	Cy        int64  `json:"cy"`
+	PaletteID string `json:"paletteId"`
+}
+
+// PostPalette handles POST /admin/palette. The chunk's tiles keep their
+// color indexes and are redrawn in the new palette's colors; paints must
+// then carry the new palette id.
+func (h *Handler) PostPalette(w http.ResponseWriter, r *http.Request) {
+	var req PaletteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+	if _, ok := h.config.Palettes[req.PaletteID]; req.PaletteID != "" && !ok {
+		writeError(w, 400, CodeUnknownPalette, "unknown palette")
+		return
+	}
+
+	if err := h.rdb.SetChunkPaletteID(req.Cx, req.Cy, req.PaletteID); err != nil {
+		h.redisError("palette_id", err)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+	h.hub.NotifyChunks(req.Cx, req.Cy, req.Cx, req.Cy, ws.PaletteChange{
+		Type:      "palette",
+		Cx:        req.Cx,
+		Cy:        req.Cy,
+		PaletteID: req.PaletteID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FillRequest paints a rectangle of a chunk one color. The corners are
+// tile coordinates within the chunk, 0-255, inclusive.
+type FillRequest struct {
+	Cx    int64 `json:"cx"`
+	Cy    int64 `json:"cy"`
+	MinX  int   `json:"minX"`
+	MinY  int   `json:"minY"`
+	MaxX  int   `json:"maxX"`
+	MaxY  int   `json:"maxY"`
+	Color uint8 `json:"color"`
+}
+
+// FillResponse reports the fill's seq and timestamp
+type FillResponse struct {
+	Seq uint64 `json:"seq"`
+	Ts  int64  `json:"ts"`
+}
+
+// PostFill handles POST /admin/fill, painting a rectangle in one write
+// with one seq, and sending subscribers a single fill notice in place of a
+// delta per tile. Cooldowns, the geofence and the mask don't apply; a
+// chunk's region palette does.
+func (h *Handler) PostFill(w http.ResponseWriter, r *http.Request) {
+	var req FillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+	if req.Color > 15 {
+		writeError(w, 400, CodeInvalidColor, "invalid color")
+		return
+	}
+	if req.MinX < 0 || req.MinY < 0 || req.MaxX >= chunkWidth || req.MaxY >= chunkWidth || req.MinX > req.MaxX || req.MinY > req.MaxY {
+		writeError(w, 400, CodeInvalidRect, fmt.Sprintf("rectangle must be within 0-%d with min <= max", chunkWidth-1))
+		return
+	}
+	if check := h.checkChunk(PaintRequest{Cx: req.Cx, Cy: req.Cy}); !check.Pass {
+		check.reject(w)
+		return
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	seq, ts, err := h.rdb.FillRectContext(ctx, req.Cx, req.Cy, req.MinX, req.MinY, req.MaxX, req.MaxY, req.Color)
+	if err == redisclient.ErrColorNotAllowed {
+		writeError(w, 403, CodeColorNotAllowed, "color not allowed")
+		return
+	}
+	if err != nil {
+		h.redisError("fill", err, "cx", req.Cx, "cy", req.Cy)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+	h.hub.PublishFill(ws.RegionFill{
+		Cx:    req.Cx,
+		Cy:    req.Cy,
+		Seq:   seq,
+		Ts:    ts,
+		MinX:  req.MinX,
+		MinY:  req.MinY,
+		MaxX:  req.MaxX,
+		MaxY:  req.MaxY,
+		Color: req.Color,
+	})
+	h.logger.Info("api: region filled", "cx", req.Cx, "cy", req.Cy, "seq", seq,
+		"minX", req.MinX, "minY", req.MinY, "maxX", req.MaxX, "maxY", req.MaxY, "color", req.Color)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FillResponse{Seq: seq, Ts: ts})
+}
+
+// GetSnapshot handles GET /admin/snapshot, streaming every painted chunk in
+// the snapshot format RestoreSnapshot reads. Paints carry on meanwhile. A
+// large canvas outlasts the server's write timeout, so it doesn't apply.
+func (h *Handler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	liftDeadlines(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"splat-%d.snapshot\"", time.Now().Unix()))
+
+	// Once streaming has started the status can't change, so a failure
+	// leaves a truncated file, which RestoreSnapshot rejects
+	chunks, err := h.rdb.SnapshotAllChunks(w)
+	if err != nil {
+		h.redisError("snapshot", err, "chunks", chunks)
+		return
+	}
+	h.logger.Info("api: snapshot sent", "chunks", chunks)
+}
+
+// RoomsResponse lists the hub's active rooms
+type RoomsResponse struct {
+	Rooms []ws.RoomStat `json:"rooms"`
+}
+
+// GetRooms handles GET /admin/rooms, each chunk with subscribers on this
+// instance and how many it has
+func (h *Handler) GetRooms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RoomsResponse{Rooms: h.hub.ListRooms()})
+}
+
+// RestoreResponse reports how many chunks a restore wrote and the canvas
+// epoch it moved to
+type RestoreResponse struct {
+	Chunks int    `json:"chunks"`
+	Epoch  uint64 `json:"epoch"`
+}
+
+// PostRestore handles POST /admin/restore, loading a snapshot from the
+// request body over the current canvas. The upload may be slower than the
+// server's read timeout allows, so that is lifted.
+func (h *Handler) PostRestore(w http.ResponseWriter, r *http.Request) {
+	liftDeadlines(w)
+	chunks, epoch, err := h.rdb.RestoreSnapshot(r.Body)
+	if errors.Is(err, redisclient.ErrSnapshotFormat) {
+		writeError(w, 400, CodeBadRequest, err.Error())
+		return
+	}
+
+	// Subscribers here reload at once, as after a reset; other instances
+	// see the new epoch at their next poll
+	h.hub.SetEpoch(epoch)
+	if err != nil {
+		h.redisError("restore", err, "chunks", chunks)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RestoreResponse{Chunks: chunks, Epoch: h.hub.Epoch()})
+}
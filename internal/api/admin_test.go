@@ -0,0 +1,559 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"splat-boston/internal/bits"
+	"splat-boston/internal/geo"
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// postExplain sends an authenticated explain request
+func postExplain(h *Handler, req ExplainRequest, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/admin/explain", bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.RequireAdmin(h.PostExplain)(w, r)
+	return w
+}
+
+func TestExplainFlagsGeofenceAndCooldown(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	// Put the subject into cooldown with a real paint
+	if w := postPaint(h, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("Paint failed: %d", w.Code)
+	}
+
+	paint := bostonPaint(1, 2)
+	paint.Lat, paint.Lon = 40.7128, -74.0060 // New York
+	w := postExplain(h, ExplainRequest{Subject: "10.0.0.1", Paint: paint}, "secret")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var report ExplainResponse
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Allowed {
+		t.Errorf("Expected paint to be disallowed")
+	}
+	if report.CooldownRemainingMs <= 0 {
+		t.Errorf("Expected cooldown remaining, got %dms", report.CooldownRemainingMs)
+	}
+
+	results := make(map[string]bool)
+	for _, check := range report.Checks {
+		results[check.Name] = check.Pass
+	}
+	for name, want := range map[string]bool{
+		"cooldown": false,
+		"geofence": false,
+		"color":    true,
+		"palette":  true,
+	} {
+		got, ok := results[name]
+		if !ok {
+			t.Errorf("Report is missing the %s check", name)
+		} else if got != want {
+			t.Errorf("Check %s: expected pass=%v, got %v", name, want, got)
+		}
+	}
+
+	// Explaining must not have touched the tile
+	colors, err := h.rdb.GetTileColors([]redisclient.TileRef{{O: 1}})
+	if err != nil || colors[0] != 0 {
+		t.Errorf("Explain mutated state: colors=%v err=%v", colors, err)
+	}
+}
+
+func TestExplainRequiresAdminToken(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	w := postExplain(h, ExplainRequest{Subject: "10.0.0.1", Paint: bostonPaint(0, 1)}, "wrong")
+	if w.Code != 401 {
+		t.Errorf("Expected 401 with a bad token, got %d", w.Code)
+	}
+
+	// The right token without the Bearer scheme is refused too
+	body, _ := json.Marshal(ExplainRequest{Subject: "10.0.0.1", Paint: bostonPaint(0, 1)})
+	r := httptest.NewRequest(http.MethodPost, "/admin/explain", bytes.NewReader(body))
+	r.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	h.RequireAdmin(h.PostExplain)(w, r)
+	if w.Code != 401 {
+		t.Errorf("Expected 401 for a bare token, got %d", w.Code)
+	}
+}
+
+// postMask sends an authenticated mask edit
+func postMask(h *Handler, req MaskRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/admin/mask", bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.RequireAdmin(h.PostMask)(w, r)
+	return w
+}
+
+func TestPostMaskOpensAreaAndNotifiesSubscribers(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	if w := postMask(h, MaskRequest{MaxX: 1, MaxY: 1, Allowed: true}); w.Code != 409 {
+		t.Errorf("Expected 409 without a mask, got %d", w.Code)
+	}
+
+	// A closed mask around the paint's tile
+	paint := paintAt(42.3601, -71.0589, 3)
+	x, y := geo.LatLonToTileXY(paint.Lat, paint.Lon)
+	h.mask = geo.NewMask(geo.Bounds{MinX: x - 10, MinY: y - 10, MaxX: x + 10, MaxY: y + 10}, 10)
+	if w := postPaint(h, paint, "10.0.0.1"); w.Code != 403 {
+		t.Fatalf("Expected the masked tile to be rejected, got %d", w.Code)
+	}
+
+	cx, cy := geo.ChunkOf(x, y)
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	sub, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/sub?cx=%d&cy=%d", server.URL[4:], cx, cy), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer sub.Close()
+	for deadline := time.Now().Add(time.Second); h.hub.GetSubscriberCount(fmt.Sprintf("%d:%d", cx, cy)) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Open a rectangle that runs past the mask's edge
+	w := postMask(h, MaskRequest{MinX: x - 1, MinY: y - 1, MaxX: x + 100, MaxY: y + 1, Allowed: true})
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp MaskResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Tiles != 12*3 {
+		t.Errorf("Expected 36 tiles inside the mask, got %+v (%v)", resp, err)
+	}
+
+	sub.SetReadDeadline(time.Now().Add(time.Second))
+	var change ws.MaskChange
+	if err := sub.ReadJSON(&change); err != nil {
+		t.Fatalf("Expected a mask notice: %v", err)
+	}
+	want := ws.MaskChange{Type: "mask", MinX: x - 1, MinY: y - 1, MaxX: x + 10, MaxY: y + 1, Allowed: true}
+	if change != want {
+		t.Errorf("Expected %+v, got %+v", want, change)
+	}
+
+	if w := postPaint(h, paint, "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected the opened tile to be paintable, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostBackgroundAppliesToUnpaintedTiles(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	if w := postPaint(h, bostonPaint(1, 3), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+
+	setBackground := func(req BackgroundRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, "/admin/background", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.RequireAdmin(h.PostBackground)(w, r)
+		return w
+	}
+	if w := setBackground(BackgroundRequest{Color: 16}); w.Code != 400 {
+		t.Errorf("Expected 400 for color 16, got %d", w.Code)
+	}
+	if w := setBackground(BackgroundRequest{Color: 6}); w.Code != 204 {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Untouched tiles read as the background; the painted one keeps its color
+	colors, err := h.rdb.GetTileColors([]redisclient.TileRef{{O: 0}, {O: 1}, {O: 65535}, {Cx: 1, O: 0}})
+	if err != nil {
+		t.Fatalf("GetTileColors failed: %v", err)
+	}
+	if want := []uint8{6, 3, 6, 0}; fmt.Sprint(colors) != fmt.Sprint(want) {
+		t.Errorf("Expected colors %v, got %v", want, colors)
+	}
+
+	// Chunk reads keep 0 for unpainted tiles and name the background
+	r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0&format=rle", nil)
+	w := httptest.NewRecorder()
+	h.GetChunk(w, r)
+	if got := w.Header().Get("X-Background-Color"); got != "6" {
+		t.Errorf("Expected X-Background-Color 6, got %q", got)
+	}
+	var chunk ChunkRLE
+	if err := json.NewDecoder(w.Body).Decode(&chunk); err != nil || chunk.Background != 6 || chunk.Runs[0] != [2]int{0, 1} {
+		t.Errorf("Expected background 6 and a leading unpainted run, got %+v (%v)", chunk, err)
+	}
+
+	// Clearing it makes untouched tiles read as unpainted again
+	if w := setBackground(BackgroundRequest{Color: 0}); w.Code != 204 {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if colors, _ := h.rdb.GetTileColors([]redisclient.TileRef{{O: 0}}); colors[0] != 0 {
+		t.Errorf("Expected 0 after clearing the background, got %d", colors[0])
+	}
+}
+
+func TestPostFillPaintsRegionAndNotifiesSubscribers(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	// One paint inside the rectangle and one outside it
+	for _, o := range []int{1, 5 * 256} {
+		if w := postPaint(h, bostonPaint(o, 3), fmt.Sprintf("10.0.0.%d", o%7)); w.Code != 200 {
+			t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	sub, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=0&cy=0", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer sub.Close()
+	for deadline := time.Now().Add(time.Second); h.hub.GetSubscriberCount("0:0") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fill := func(token string, req FillRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, "/admin/fill", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.RequireAdmin(h.PostFill)(w, r)
+		return w
+	}
+	if w := fill("wrong", FillRequest{MaxX: 1, MaxY: 1}); w.Code != 401 {
+		t.Errorf("Expected 401 without the admin token, got %d", w.Code)
+	}
+	for _, bad := range []FillRequest{{MaxX: 256}, {MinX: 3, MaxX: 2}, {MinY: -1}} {
+		if w := fill("secret", bad); w.Code != 400 || !strings.Contains(w.Body.String(), CodeInvalidRect) {
+			t.Errorf("%+v: expected 400 %s, got %d: %s", bad, CodeInvalidRect, w.Code, w.Body.String())
+		}
+	}
+	if w := fill("secret", FillRequest{Color: 16}); w.Code != 400 {
+		t.Errorf("Expected 400 for color 16, got %d", w.Code)
+	}
+
+	w := fill("secret", FillRequest{MinX: 0, MinY: 0, MaxX: 99, MaxY: 2, Color: 4})
+	var resp FillResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); w.Code != 200 || err != nil || resp.Seq != 3 {
+		t.Fatalf("Expected the fill at seq 3, got %d %+v: %s", w.Code, resp, w.Body.String())
+	}
+	if seq, _ := h.rdb.GetChunkSeq(0, 0); seq != 3 {
+		t.Errorf("Expected the fill to bump the seq once, to 3, got %d", seq)
+	}
+
+	data, err := h.rdb.GetChunkBits(0, 0)
+	if err != nil {
+		t.Fatalf("GetChunkBits failed: %v", err)
+	}
+	for o := 0; o < chunkWidth*chunkWidth; o++ {
+		x, y := o%chunkWidth, o/chunkWidth
+		want := uint8(0)
+		switch {
+		case x <= 99 && y <= 2:
+			want = 4
+		case o == 5*256:
+			want = 3
+		}
+		if got := bits.GetNibble(data, o); got != want {
+			t.Fatalf("tile %d = %d, want %d", o, got, want)
+		}
+	}
+
+	// Subscribers get one notice, not 300 deltas
+	sub.SetReadDeadline(time.Now().Add(time.Second))
+	var notice ws.RegionFill
+	if err := sub.ReadJSON(&notice); err != nil {
+		t.Fatalf("Expected a fill notice: %v", err)
+	}
+	want := ws.RegionFill{Type: "fill", Seq: 3, Ts: resp.Ts, MaxX: 99, MaxY: 2, Color: 4}
+	if notice != want {
+		t.Errorf("Expected %+v, got %+v", want, notice)
+	}
+	sub.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, msg, err := sub.ReadMessage(); err == nil {
+		t.Errorf("Expected nothing after the notice, got %s", msg)
+	}
+}
+
+func TestChunkPalettesDrawIndexesDifferently(t *testing.T) {
+	neon := DefaultPalette
+	neon[3] = "#39FF14"
+	config := testConfig()
+	config.AdminToken = "secret"
+	config.Palettes = map[string][16]string{"neon": neon}
+	h, _ := newTestHandler(t, config)
+
+	setPalette := func(req PaletteRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, "/admin/palette", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.RequireAdmin(h.PostPalette)(w, r)
+		return w
+	}
+	if w := setPalette(PaletteRequest{Cx: 1, PaletteID: "pastel"}); w.Code != 400 {
+		t.Errorf("Expected 400 for an unknown palette, got %d", w.Code)
+	}
+	if w := setPalette(PaletteRequest{Cx: 1, PaletteID: "neon"}); w.Code != 204 {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Paints must name their chunk's palette
+	neonPaint := bostonPaint(0, 3)
+	neonPaint.Cx = 1
+	wrong := neonPaint
+	wrong.PaletteID = ""
+	if w := postPaint(h, wrong, "10.0.0.1"); w.Code != 409 || !strings.Contains(w.Body.String(), CodePaletteMismatch) {
+		t.Errorf("Expected 409 %s, got %d: %s", CodePaletteMismatch, w.Code, w.Body.String())
+	}
+	wrong = bostonPaint(0, 3)
+	wrong.PaletteID = "neon"
+	if w := postPaint(h, wrong, "10.0.0.2"); w.Code != 409 {
+		t.Errorf("Expected 409 for a neon paint on a default chunk, got %d", w.Code)
+	}
+	neonPaint.PaletteID = "neon"
+	if w := postPaint(h, neonPaint, "10.0.0.3"); w.Code != 200 {
+		t.Fatalf("neon paint: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := postPaint(h, bostonPaint(0, 3), "10.0.0.4"); w.Code != 200 {
+		t.Fatalf("default paint: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// A client draws each chunk with the palette its X-Palette-Id names
+	w := httptest.NewRecorder()
+	h.GetConfig(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var cfg ConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	render := func(cx int64) string {
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/state/chunk?cx=%d&cy=0", cx), nil)
+		w := httptest.NewRecorder()
+		h.GetChunk(w, r)
+		palette := cfg.Palette
+		if id := w.Header().Get("X-Palette-Id"); id != "" {
+			palette = cfg.Palettes[id]
+		}
+		return palette[bits.GetNibble(w.Body.Bytes(), 0)]
+	}
+	if got := render(0); got != DefaultPalette[3] {
+		t.Errorf("Expected the default chunk's index 3 drawn %s, got %s", DefaultPalette[3], got)
+	}
+	if got := render(1); got != "#39FF14" {
+		t.Errorf("Expected the neon chunk's index 3 drawn #39FF14, got %s", got)
+	}
+}
+
+func TestGetFullRenderCompositesChunks(t *testing.T) {
+	neon := DefaultPalette
+	neon[3] = "#39FF14"
+	config := testConfig()
+	config.AdminToken = "secret"
+	config.Palettes = map[string][16]string{"neon": neon}
+	h, _ := newTestHandler(t, config)
+
+	// A 2×2 canvas: index 3 at each chunk's origin, and tile (5, 1) of the
+	// south-east chunk in index 9
+	for _, c := range [][2]int64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		if _, _, _, err := h.rdb.PaintTile(c[0], c[1], 0, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, _, err := h.rdb.PaintTile(1, 1, chunkWidth+5, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rdb.SetChunkPaletteID(1, 0, "neon"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rdb.SetChunkBackground(0, 1, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/render/full.png", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.RequireAdmin(h.GetFullRender)(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(2*chunkWidth, 2*chunkWidth) {
+		t.Fatalf("Expected a %d×%d image, got %v", 2*chunkWidth, 2*chunkWidth, size)
+	}
+
+	hex := func(x, y int) string {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A == 0 {
+			return "transparent"
+		}
+		return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want string
+	}{
+		{0, 0, DefaultPalette[3]},
+		{1, 0, "transparent"},
+		{chunkWidth, 0, "#39FF14"},         // neon chunk
+		{0, chunkWidth, DefaultPalette[3]}, // painted over the background
+		{1, chunkWidth, DefaultPalette[6]}, // background
+		{chunkWidth + 5, chunkWidth + 1, DefaultPalette[9]},
+		{2*chunkWidth - 1, 2*chunkWidth - 1, "transparent"},
+	} {
+		if got := hex(tc.x, tc.y); got != tc.want {
+			t.Errorf("Pixel (%d, %d) = %s, want %s", tc.x, tc.y, got, tc.want)
+		}
+	}
+
+	// Explicit bounds are checked
+	r = httptest.NewRequest(http.MethodGet, "/admin/render/full.png?minCx=0&minCy=0&maxCx=99&maxCy=99", nil)
+	w = httptest.NewRecorder()
+	h.GetFullRender(w, r)
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for 100×100 chunks, got %d", w.Code)
+	}
+
+	// Sides whose product overflows, or whose span does
+	for _, query := range []string{
+		"minCx=0&minCy=0&maxCx=4294967296&maxCy=4294967296",
+		"minCx=-9223372036854775808&minCy=0&maxCx=9223372036854775807&maxCy=0",
+	} {
+		w = httptest.NewRecorder()
+		h.GetFullRender(w, httptest.NewRequest(http.MethodGet, "/admin/render/full.png?"+query, nil))
+		if w.Code != 400 || !strings.Contains(w.Body.String(), CodeTooManyChunks) {
+			t.Errorf("%s: expected 400 %s, got %d", query, CodeTooManyChunks, w.Code)
+		}
+	}
+}
+
+func TestSnapshotAndRestoreEndpoints(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, mr := newTestHandler(t, config)
+
+	if w := postPaint(h, bostonPaint(5, 7), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.RequireAdmin(h.GetSnapshot)(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	snapshot := w.Body.Bytes()
+
+	mr.FlushAll()
+
+	restore := func(body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.RequireAdmin(h.PostRestore)(w, r)
+		return w
+	}
+	if w := restore([]byte("not a snapshot")); w.Code != 400 {
+		t.Errorf("Expected 400 for a bad file, got %d", w.Code)
+	}
+	w = restore(snapshot)
+	var resp RestoreResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != 200 || resp.Chunks != 1 {
+		t.Fatalf("Expected one chunk restored, got %d %+v (%v)", w.Code, resp, err)
+	}
+	if resp.Epoch != 1 || h.hub.Epoch() != 1 {
+		t.Errorf("Expected the restore to move the hub to epoch 1, got %d (hub %d)", resp.Epoch, h.hub.Epoch())
+	}
+
+	colors, _ := h.rdb.GetTileColors([]redisclient.TileRef{{O: 5}})
+	if seq, _ := h.rdb.GetChunkSeq(0, 0); colors[0] != 7 || seq != 1 {
+		t.Errorf("Expected the paint back at seq 1, got color %d at seq %d", colors[0], seq)
+	}
+}
+
+func TestGetRoomsListsSubscribedChunks(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	h, _ := newTestHandler(t, config)
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	for _, query := range []string{"cx=1&cy=2", "cx=1&cy=2", "cx=5&cy=0"} {
+		sub, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?"+query, nil)
+		if err != nil {
+			t.Fatalf("WebSocket dial failed: %v", err)
+		}
+		defer sub.Close()
+	}
+	for deadline := time.Now().Add(time.Second); h.hub.TotalSubscribers() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions were never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	getRooms := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/admin/rooms", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.RequireAdmin(h.GetRooms)(w, r)
+		return w
+	}
+	if w := getRooms("wrong"); w.Code != 401 {
+		t.Errorf("Expected 401 with a bad token, got %d", w.Code)
+	}
+
+	w := getRooms("secret")
+	var resp RoomsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); w.Code != 200 || err != nil {
+		t.Fatalf("Expected the rooms, got %d: %s", w.Code, w.Body.String())
+	}
+	want := []ws.RoomStat{{RoomID: "1:2", Subscribers: 2}, {RoomID: "5:0", Subscribers: 1}}
+	if !slices.Equal(resp.Rooms, want) {
+		t.Errorf("Expected %+v, got %+v", want, resp.Rooms)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the address bans, cooldowns and tokens are keyed on.
+// The forwarding headers are only believed from a trusted proxy, since
+// anyone else could rotate them to escape a ban or get someone else's
+// address banned. Of X-Forwarded-For's hops, the right-most one not itself
+// a trusted proxy is the client; the ones left of it are the client's to
+// choose.
+func (h *Handler) clientIP(r *http.Request) string {
+	peer := hostOf(r.RemoteAddr)
+	if !h.trustedProxy(peer) {
+		return peer
+	}
+
+	if ip, ok := parseIP(r.Header.Get("CF-Connecting-IP")); ok {
+		return ip.String()
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		if !h.trustedProxy(ip.String()) || i == 0 {
+			return ip.String()
+		}
+	}
+	return peer
+}
+
+// trustedProxy reports whether addr is in Config.TrustedProxies
+func (h *Handler) trustedProxy(addr string) bool {
+	ip, ok := parseIP(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range h.config.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf strips the port from a host:port address, so each new connection
+// from a client isn't a new subject
+func hostOf(addr string) string {
+	if ip, ok := parseIP(addr); ok {
+		return ip.String()
+	}
+	return addr
+}
+
+// parseIP reads an address with or without a port, as IPv4 when it's an
+// IPv4-mapped IPv6 one
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// paintFrom sends a JSON paint request from the peer at remoteAddr, with
+// the given headers
+func paintFrom(h *Handler, req PaintRequest, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+	return w
+}
+
+func TestPostPaintCooldownIgnoresSpoofedHeaders(t *testing.T) {
+	config := testConfig()
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h, _ := newTestHandler(t, config)
+
+	// A client talking to the server directly can't escape its cooldown by
+	// claiming to be someone else
+	first := map[string]string{"CF-Connecting-IP": "203.0.113.1"}
+	if w := paintFrom(h, bostonPaint(1, 3), "198.51.100.9:5000", first); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+	for _, spoofed := range []map[string]string{
+		{"CF-Connecting-IP": "203.0.113.2"},
+		{"X-Forwarded-For": "203.0.113.3"},
+	} {
+		if w := paintFrom(h, bostonPaint(2, 3), "198.51.100.9:5000", spoofed); w.Code != 429 {
+			t.Errorf("%v: expected 429 while cooling down, got %d: %s", spoofed, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPostPaintCooldownIgnoresThePeerPort(t *testing.T) {
+	config := testConfig()
+	config.TrustedProxies = nil
+	h, _ := newTestHandler(t, config)
+
+	if w := paintFrom(h, bostonPaint(1, 3), "198.51.100.9:5000", nil); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := paintFrom(h, bostonPaint(2, 3), "198.51.100.9:5001", nil); w.Code != 429 {
+		t.Errorf("Expected 429 from a new connection while cooling down, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	config := testConfig()
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := &Handler{config: config}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"peer", "198.51.100.9:5000", nil, "198.51.100.9"},
+		{"ipv6 peer", "[2001:db8::1]:5000", nil, "2001:db8::1"},
+		{"untrusted peer's header", "198.51.100.9:5000", map[string]string{"CF-Connecting-IP": "203.0.113.1"}, "198.51.100.9"},
+		{"trusted proxy's header", "10.0.0.2:5000", map[string]string{"CF-Connecting-IP": "203.0.113.1"}, "203.0.113.1"},
+		{"right-most untrusted hop", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.1, 10.0.0.3"}, "203.0.113.1"},
+		{"only proxies", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"garbage hop", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "203.0.113.1, nonsense"}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := h.clientIP(r); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"splat-boston/internal/geo"
+	"splat-boston/internal/ws"
+)
+
+// DefaultPalette is used for unset Config.Palette entries. Indexes 1-8
+// are the web client's colors; index 0 stands for unpainted tiles, which it
+// draws transparent.
+var DefaultPalette = [16]string{
+	"#000000", "#FF0000", "#FFA500", "#FFFF00",
+	"#00FF00", "#00FFFF", "#0000FF", "#FF00FF",
+	"#FFFFFF", "#800000", "#808000", "#008000",
+	"#008080", "#000080", "#800080", "#FFD700",
+}
+
+// hexColor matches a palette entry
+var hexColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// LoadPalette reads a palette file: a JSON array of 16 "#RRGGBB" colors
+func LoadPalette(r io.Reader) ([16]string, error) {
+	var colors []string
+	if err := json.NewDecoder(r).Decode(&colors); err != nil {
+		return [16]string{}, fmt.Errorf("decode palette: %w", err)
+	}
+	return parsePalette(colors)
+}
+
+// LoadPalettes reads a named palettes file: a JSON object mapping each
+// palette id to 16 "#RRGGBB" colors, as in a palette file
+func LoadPalettes(r io.Reader) (map[string][16]string, error) {
+	var named map[string][]string
+	if err := json.NewDecoder(r).Decode(&named); err != nil {
+		return nil, fmt.Errorf("decode palettes: %w", err)
+	}
+
+	palettes := make(map[string][16]string, len(named))
+	for id, colors := range named {
+		if id == "" {
+			return nil, fmt.Errorf("palette id must not be empty")
+		}
+		palette, err := parsePalette(colors)
+		if err != nil {
+			return nil, fmt.Errorf("palette %q: %w", id, err)
+		}
+		palettes[id] = palette
+	}
+	return palettes, nil
+}
+
+// parsePalette checks a palette's colors
+func parsePalette(colors []string) ([16]string, error) {
+	var palette [16]string
+	if len(colors) != len(palette) {
+		return palette, fmt.Errorf("palette has %d colors, want %d", len(colors), len(palette))
+	}
+	for i, color := range colors {
+		if !hexColor.MatchString(color) {
+			return palette, fmt.Errorf("palette color %d: %q is not #RRGGBB", i, color)
+		}
+		palette[i] = color
+	}
+	return palette, nil
+}
+
+// ParseCooldownByColor reads a comma-separated "color:ms" list, e.g.
+// "15:60000,14:30000", into Config.PaintCooldownByColor
+func ParseCooldownByColor(list string) (map[uint8]int, error) {
+	cooldowns := make(map[uint8]int)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		colorStr, msStr, _ := strings.Cut(entry, ":")
+		color, err := strconv.ParseUint(colorStr, 10, 8)
+		if err != nil || color > 15 {
+			return nil, fmt.Errorf("cooldown %q: color must be 0-15", entry)
+		}
+		ms, err := strconv.Atoi(msStr)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("cooldown %q: ms must be a non-negative integer", entry)
+		}
+		cooldowns[uint8(color)] = ms
+	}
+	return cooldowns, nil
+}
+
+// ParseWorldBounds reads "minCx,minCy,maxCx,maxCy", inclusive, into
+// Config.WorldBounds
+func ParseWorldBounds(s string) (ws.ChunkBounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return ws.ChunkBounds{}, fmt.Errorf("world bounds %q: want minCx,minCy,maxCx,maxCy", s)
+	}
+	var v [4]int64
+	for i, part := range parts {
+		n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return ws.ChunkBounds{}, fmt.Errorf("world bounds %q: %q is not a chunk", s, part)
+		}
+		v[i] = n
+	}
+	b := ws.ChunkBounds{MinCx: v[0], MinCy: v[1], MaxCx: v[2], MaxCy: v[3]}
+	if b.MinCx > b.MaxCx || b.MinCy > b.MaxCy {
+		return ws.ChunkBounds{}, fmt.Errorf("world bounds %q: min is past max", s)
+	}
+	return b, nil
+}
+
+// ParseTrustedProxies reads a comma-separated list of CIDRs, or single
+// addresses, e.g. "173.245.48.0/20,10.0.0.7", into Config.TrustedProxies
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: not a CIDR or address", entry)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// projection returns the tiles paints are projected onto: the default
+// projection, with TileMeters when set
+func (c Config) projection() geo.Projection {
+	proj := geo.DefaultProjection
+	if c.TileMeters > 0 {
+		proj.TileMeters = c.TileMeters
+	}
+	return proj
+}
+
+// CheckMask returns an error if mask's tiles aren't the ones paints are
+// projected onto, since every mask check would then test the wrong tile.
+// A nil mask passes.
+func (c Config) CheckMask(mask geo.TileMask) error {
+	if mask == nil {
+		return nil
+	}
+	if got, want := mask.Projection(), c.projection(); got != want {
+		return fmt.Errorf("mask has %gm tiles in chunks of %d, paints have %gm tiles in chunks of %d",
+			got.TileMeters, got.ChunkSize, want.TileMeters, want.ChunkSize)
+	}
+	return nil
+}
+
+// worldBounds returns WorldBounds, or when unset the chunks covering the
+// geofence box in the configured projection
+func (c Config) worldBounds() ws.ChunkBounds {
+	if c.WorldBounds != nil {
+		return *c.WorldBounds
+	}
+	proj := c.projection()
+	minX, minY, maxX, maxY := fenceTiles(proj)
+	var b ws.ChunkBounds
+	b.MinCx, b.MinCy = proj.ChunkOf(minX, minY)
+	b.MaxCx, b.MaxCy = proj.ChunkOf(maxX, maxY)
+	return b
+}
+
+// ConfigResponse is returned by GET /config, so clients use the server's
+// values rather than their own copies
+type ConfigResponse struct {
+	Palette         []string `json:"palette"`
+	PaintCooldownMs int64    `json:"paintCooldownMs"`
+	GeofenceRadiusM float64  `json:"geofenceRadiusM"`
+
+	// PaintCooldownByColorMs lists the colors with their own cooldown
+	PaintCooldownByColorMs map[uint8]int64 `json:"paintCooldownByColorMs,omitempty"`
+
+	// SpeedMaxKmh is set when the speed limit is enabled
+	SpeedMaxKmh float64 `json:"speedMaxKmh,omitempty"`
+
+	// Palettes are the named palettes chunks may use instead of Palette;
+	// a chunk's X-Palette-Id says which
+	Palettes map[string][]string `json:"palettes,omitempty"`
+}
+
+// configMaxAgeS is how long clients and CDNs may cache GET /config; tuned
+// values reach clients within it
+const configMaxAgeS = 60
+
+// GetConfig handles GET /config
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	response := ConfigResponse{
+		Palette:         h.config.Palette[:],
+		PaintCooldownMs: h.paintCooldown().Milliseconds(),
+		GeofenceRadiusM: h.config.GeofenceRadiusM,
+	}
+	if h.config.EnableSpeedLimit {
+		response.SpeedMaxKmh = h.config.SpeedMaxKmh
+	}
+	if len(h.config.PaintCooldownByColor) > 0 {
+		response.PaintCooldownByColorMs = make(map[uint8]int64, len(h.config.PaintCooldownByColor))
+		for color, ms := range h.config.PaintCooldownByColor {
+			response.PaintCooldownByColorMs[color] = int64(ms)
+		}
+	}
+
+	if len(h.config.Palettes) > 0 {
+		response.Palettes = make(map[string][]string, len(h.config.Palettes))
+		for id, palette := range h.config.Palettes {
+			response.Palettes[id] = palette[:]
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", configMaxAgeS))
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"splat-boston/internal/geo"
+	"splat-boston/internal/ws"
+)
+
+func TestGetConfigReturnsPaletteAndLimits(t *testing.T) {
+	config := testConfig()
+	config.Palette[3] = "#123ABC" // the rest fall back to the defaults
+	h, _ := newTestHandler(t, config)
+
+	w := httptest.NewRecorder()
+	h.GetConfig(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if w.Code != 200 {
+		t.Fatalf("GET /config: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var got ConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	if len(got.Palette) != 16 {
+		t.Fatalf("palette has %d colors, want 16", len(got.Palette))
+	}
+	if got.Palette[3] != "#123ABC" {
+		t.Errorf("palette[3] = %q, want the configured #123ABC", got.Palette[3])
+	}
+	if got.Palette[4] != DefaultPalette[4] {
+		t.Errorf("palette[4] = %q, want default %q", got.Palette[4], DefaultPalette[4])
+	}
+	if got.PaintCooldownMs != int64(config.PaintCooldownMs) {
+		t.Errorf("paintCooldownMs = %d, want %d", got.PaintCooldownMs, config.PaintCooldownMs)
+	}
+	if got.GeofenceRadiusM != config.GeofenceRadiusM {
+		t.Errorf("geofenceRadiusM = %g, want %g", got.GeofenceRadiusM, config.GeofenceRadiusM)
+	}
+}
+
+func TestGetConfigDefaultsPalette(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	w := httptest.NewRecorder()
+	h.GetConfig(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var got ConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	for i, color := range got.Palette {
+		if color != DefaultPalette[i] {
+			t.Errorf("palette[%d] = %q, want default %q", i, color, DefaultPalette[i])
+		}
+	}
+}
+
+func TestLoadPalette(t *testing.T) {
+	colors, _ := json.Marshal(DefaultPalette)
+	palette, err := LoadPalette(strings.NewReader(string(colors)))
+	if err != nil || palette != DefaultPalette {
+		t.Errorf("LoadPalette(default) = %v, %v", palette, err)
+	}
+
+	if _, err := LoadPalette(strings.NewReader(`["#FFFFFF", "#000000"]`)); err == nil {
+		t.Error("Expected an error for a palette of 2 colors")
+	}
+	bad := strings.Replace(string(colors), "#FFA500", "orange", 1)
+	if _, err := LoadPalette(strings.NewReader(bad)); err == nil {
+		t.Error("Expected an error for a color that isn't #RRGGBB")
+	}
+}
+
+func TestLoadPalettes(t *testing.T) {
+	colors, _ := json.Marshal(DefaultPalette)
+	palettes, err := LoadPalettes(strings.NewReader(`{"neon": ` + string(colors) + `}`))
+	if err != nil || len(palettes) != 1 || palettes["neon"] != DefaultPalette {
+		t.Errorf("LoadPalettes = %v, %v", palettes, err)
+	}
+
+	if _, err := LoadPalettes(strings.NewReader(`{"": ` + string(colors) + `}`)); err == nil {
+		t.Error("Expected an error for an empty palette id")
+	}
+	if _, err := LoadPalettes(strings.NewReader(`{"neon": ["#FFFFFF"]}`)); err == nil {
+		t.Error("Expected an error for a palette of 1 color")
+	}
+}
+
+func TestParseWorldBounds(t *testing.T) {
+	b, err := ParseWorldBounds("19000, 24000,19800,24600")
+	if err != nil || b != (ws.ChunkBounds{MinCx: 19000, MinCy: 24000, MaxCx: 19800, MaxCy: 24600}) {
+		t.Errorf("Expected the four chunks parsed, got %+v (%v)", b, err)
+	}
+	for _, bad := range []string{"", "1,2,3", "1,2,3,x", "5,0,4,9", "0,5,9,4"} {
+		if _, err := ParseWorldBounds(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("173.245.48.0/20, 10.0.0.7,2400:cb00::/32,")
+	want := []netip.Prefix{
+		netip.MustParsePrefix("173.245.48.0/20"),
+		netip.MustParsePrefix("10.0.0.7/32"),
+		netip.MustParsePrefix("2400:cb00::/32"),
+	}
+	if err != nil || !reflect.DeepEqual(proxies, want) {
+		t.Errorf("Expected %v, got %v (%v)", want, proxies, err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestWorldBoundsDefaultToGeofence(t *testing.T) {
+	world := Config{}.worldBounds()
+	// Every corner of the fence box, and Boston itself
+	for _, ll := range [][2]float64{
+		{fenceMinLat, fenceMinLon}, {fenceMinLat, fenceMaxLon},
+		{fenceMaxLat, fenceMinLon}, {fenceMaxLat, fenceMaxLon},
+		{42.3601, -71.0589},
+	} {
+		cx, cy := geo.ChunkOf(geo.LatLonToTileXY(ll[0], ll[1]))
+		if !world.Contains(cx, cy) {
+			t.Errorf("Expected (%g, %g), chunk (%d, %d), inside %+v", ll[0], ll[1], cx, cy, world)
+		}
+	}
+	if world.Contains(0, 0) || world.Contains(world.MaxCx+1, world.MinCy) {
+		t.Errorf("Expected chunks off the box outside %+v", world)
+	}
+
+	// A coarser projection has fewer, larger chunks
+	coarse := Config{TileMeters: 20}.worldBounds()
+	if coarse.MaxCx-coarse.MinCx >= world.MaxCx-world.MinCx {
+		t.Errorf("Expected 20m tiles to span fewer chunks than %+v, got %+v", world, coarse)
+	}
+
+	// Set bounds are kept, even the lone chunk (0, 0)
+	origin := Config{WorldBounds: &ws.ChunkBounds{}}.worldBounds()
+	if origin != (ws.ChunkBounds{}) {
+		t.Errorf("Expected just chunk (0, 0), got %+v", origin)
+	}
+}
+
+func TestCheckMaskRejectsAnotherProjection(t *testing.T) {
+	bounds := geo.Bounds{MinX: 0, MinY: 0, MaxX: 7, MaxY: 7}
+	if err := (Config{}).CheckMask(nil); err != nil {
+		t.Errorf("Expected no mask to pass, got %v", err)
+	}
+	if err := (Config{}).CheckMask(geo.NewMaskIn(bounds, geo.DefaultProjection)); err != nil {
+		t.Errorf("Expected a default mask to pass, got %v", err)
+	}
+	coarse := geo.DefaultProjection
+	coarse.TileMeters = 20
+	if err := (Config{TileMeters: 20}).CheckMask(geo.NewMaskIn(bounds, coarse)); err != nil {
+		t.Errorf("Expected a 20m mask to pass with 20m tiles, got %v", err)
+	}
+	if err := (Config{}).CheckMask(geo.NewMaskIn(bounds, coarse)); err == nil {
+		t.Error("Expected a 20m mask to be rejected with default tiles")
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes. Clients should branch on these rather than on messages,
+// which are for people and may change.
+const (
+	CodeBadRequest    = "BAD_REQUEST"
+	CodeMissingParam  = "MISSING_PARAM"
+	CodeInvalidParam  = "INVALID_PARAM"
+	CodeTooManyTiles  = "TOO_MANY_TILES"
+	CodeTooManyChunks = "TOO_MANY_CHUNKS"
+	CodeRateLimited   = "RATE_LIMITED"
+	CodeUnknownField  = "UNKNOWN_FIELD"
+
+	CodeTurnstile        = "TURNSTILE_FAILED"
+	CodeTurnstileExpired = "TURNSTILE_EXPIRED"
+	CodeDuplicate        = "DUPLICATE_IN_PROGRESS"
+	CodeCooldown         = "COOLDOWN"
+	CodeBanned           = "BANNED"
+	CodeSpeedLimit       = "SPEED_LIMIT"
+	CodeGeofence         = "GEOFENCE"
+	CodeCoordsMismatch   = "COORDS_MISMATCH"
+	CodeNotSubscribed    = "NOT_SUBSCRIBED"
+	CodeLocationMismatch = "LOCATION_MISMATCH"
+	CodeOutsideMask      = "OUTSIDE_MASK"
+	CodeInvalidColor     = "INVALID_COLOR"
+	CodeInvalidOffset    = "INVALID_OFFSET"
+	CodeInvalidChunk     = "INVALID_CHUNK"
+	CodeColorNotAllowed  = "COLOR_NOT_ALLOWED"
+	CodeTileOccupied     = "TILE_OCCUPIED"
+	CodeUnknownPalette   = "UNKNOWN_PALETTE"
+	CodePaletteMismatch  = "PALETTE_MISMATCH"
+	CodeUndoUnavailable  = "UNDO_UNAVAILABLE"
+	CodeUndoConflict     = "UNDO_CONFLICT"
+
+	CodeAdminDisabled = "ADMIN_DISABLED"
+	CodeUnauthorized  = "UNAUTHORIZED"
+	CodeNoMask        = "NO_MASK"
+	CodeEmptyCanvas   = "EMPTY_CANVAS"
+	CodeInvalidRect   = "INVALID_RECT"
+
+	CodeWSAuthDisabled     = "WS_AUTH_DISABLED"
+	CodeWSTokenMissing     = "WS_TOKEN_MISSING"
+	CodeWSTokenExpired     = "WS_TOKEN_EXPIRED"
+	CodeWSTokenInvalid     = "WS_TOKEN_INVALID"
+	CodeWSTokenUnavailable = "WS_TOKEN_UNAVAILABLE"
+
+	CodeRedis            = "REDIS_ERROR"
+	CodeRedisUnavailable = "REDIS_UNAVAILABLE"
+	CodeTurnstileDown    = "TURNSTILE_UNAVAILABLE"
+	CodeInternal         = "INTERNAL"
+)
+
+// ErrorResponse is the JSON body of every error response
+type ErrorResponse struct {
+	Ok    bool        `json:"ok"`
+	Error ErrorDetail `json:"error"`
+	// RetryAfterMs is set on rejections the client can retry later
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+	// CooldownMs is the full cooldown a COOLDOWN rejection is waiting out
+	CooldownMs int64 `json:"cooldownMs,omitempty"`
+}
+
+// ErrorDetail says what went wrong
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Param names the offending query parameter, if any
+	Param string `json:"param,omitempty"`
+	// Reasons are an upstream service's own codes, such as Turnstile's
+	// error-codes
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// writeError writes an error response with the given status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
+
+// writeErrorResponse writes a fully built error response
+func writeErrorResponse(w http.ResponseWriter, status int, response ErrorResponse) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
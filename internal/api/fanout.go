@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// DeltaBus is a ws.Bus over Redis pub/sub. Deltas are published on
+// per-chunk channels tagged with the publishing instance, and each instance
+// ignores its own since its hub has already delivered them. Notices go the
+// same way on one channel every instance receives.
+type DeltaBus struct {
+	rdb      *redisclient.Client
+	hub      *ws.Hub
+	instance string
+	timeout  time.Duration
+	sub      *redisclient.DeltaSubscription
+}
+
+// busDelta is a delta as published on the bus. Unlike a delta sent to
+// clients it carries the painter's client ID, so echo suppression works
+// across instances.
+type busDelta struct {
+	Instance string `json:"instance"`
+	Origin   string `json:"origin,omitempty"`
+	Seq      uint64 `json:"seq"`
+	O        int    `json:"o"`
+	Color    uint8  `json:"color"`
+	Ts       int64  `json:"ts"`
+}
+
+// busNotice is a notice as published on the bus
+type busNotice struct {
+	Instance string          `json:"instance"`
+	MinCx    int64           `json:"minCx"`
+	MinCy    int64           `json:"minCy"`
+	MaxCx    int64           `json:"maxCx"`
+	MaxCy    int64           `json:"maxCy"`
+	Fill     bool            `json:"fill,omitempty"`
+	Notice   json.RawMessage `json:"notice"`
+}
+
+// NewDeltaBus connects hub to the other instances sharing rdb and starts
+// receiving their deltas and notices. instance must be unique among them. The caller
+// passes the bus to hub.SetBus before running the hub. Publishing runs on
+// the painter's request, so each publish gives up after timeout, unless it
+// is 0.
+func NewDeltaBus(rdb *redisclient.Client, hub *ws.Hub, instance string, timeout time.Duration) *DeltaBus {
+	b := &DeltaBus{rdb: rdb, hub: hub, instance: instance, timeout: timeout}
+	b.sub = rdb.SubscribeDeltas(b.receive, b.receiveNotice)
+	return b
+}
+
+// Publish sends a local delta to the other instances
+func (b *DeltaBus) Publish(delta ws.Delta) {
+	payload, err := json.Marshal(busDelta{
+		Instance: b.instance,
+		Origin:   delta.Origin,
+		Seq:      delta.Seq,
+		O:        delta.O,
+		Color:    delta.Color,
+		Ts:       delta.Ts,
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := timeoutContext(b.timeout)
+	defer cancel()
+	if err := b.rdb.PublishDeltaContext(ctx, delta.Cx, delta.Cy, payload); err != nil {
+		slog.Error("api: failed to publish delta", "cx", delta.Cx, "cy", delta.Cy, "err", err)
+	}
+}
+
+// PublishNotice sends a local notice to the other instances
+func (b *DeltaBus) PublishNotice(notice ws.BusNotice) {
+	payload, err := json.Marshal(busNotice{
+		Instance: b.instance,
+		MinCx:    notice.MinCx,
+		MinCy:    notice.MinCy,
+		MaxCx:    notice.MaxCx,
+		MaxCy:    notice.MaxCy,
+		Fill:     notice.Fill,
+		Notice:   notice.Payload,
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := timeoutContext(b.timeout)
+	defer cancel()
+	if err := b.rdb.PublishNoticeContext(ctx, payload); err != nil {
+		slog.Error("api: failed to publish notice", "err", err)
+	}
+}
+
+// Subscribe starts receiving other instances' deltas for a chunk
+func (b *DeltaBus) Subscribe(cx, cy int64) {
+	if err := b.sub.Subscribe(cx, cy); err != nil {
+		slog.Error("api: failed to subscribe to deltas", "cx", cx, "cy", cy, "err", err)
+	}
+}
+
+// Unsubscribe stops receiving deltas for a chunk
+func (b *DeltaBus) Unsubscribe(cx, cy int64) {
+	if err := b.sub.Unsubscribe(cx, cy); err != nil {
+		slog.Error("api: failed to unsubscribe from deltas", "cx", cx, "cy", cy, "err", err)
+	}
+}
+
+// Close stops receiving deltas and notices
+func (b *DeltaBus) Close() error {
+	return b.sub.Close()
+}
+
+// timeoutContext bounds a Redis call made outside any request by timeout,
+// or not at all if it is 0
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// receive hands another instance's delta to the hub
+func (b *DeltaBus) receive(cx, cy int64, payload []byte) {
+	var msg busDelta
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Instance == b.instance {
+		return
+	}
+	b.hub.Receive(ws.Delta{
+		Seq:    msg.Seq,
+		O:      msg.O,
+		Color:  msg.Color,
+		Ts:     msg.Ts,
+		Cx:     cx,
+		Cy:     cy,
+		Origin: msg.Origin,
+	})
+}
+
+// receiveNotice hands another instance's notice to the hub
+func (b *DeltaBus) receiveNotice(payload []byte) {
+	var msg busNotice
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Instance == b.instance {
+		return
+	}
+	b.hub.ReceiveNotice(ws.BusNotice{
+		MinCx:   msg.MinCx,
+		MinCy:   msg.MinCy,
+		MaxCx:   msg.MaxCx,
+		MaxCy:   msg.MaxCy,
+		Fill:    msg.Fill,
+		Payload: msg.Notice,
+	})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// newFanoutHandler creates a handler for one of several instances sharing
+// mr, with its hub connected to the others through a DeltaBus
+func newFanoutHandler(t *testing.T, mr *miniredis.Miniredis, instance string) *Handler {
+	t.Helper()
+
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+
+	hub := ws.NewHub()
+	bus := NewDeltaBus(rdb, hub, instance, time.Second)
+	t.Cleanup(func() { bus.Close() })
+	hub.SetBus(bus)
+	go hub.Run()
+
+	h := NewHandler(rdb, hub, testConfig(), nil)
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestDeltaBusFansOutAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newFanoutHandler(t, mr, "a")
+	b := newFanoutHandler(t, mr, "b")
+
+	subscribe := func(h *Handler) *websocket.Conn {
+		t.Helper()
+		server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+		t.Cleanup(server.Close)
+		sub, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=0&cy=0", nil)
+		if err != nil {
+			t.Fatalf("WebSocket dial failed: %v", err)
+		}
+		t.Cleanup(func() { sub.Close() })
+		return sub
+	}
+	local, remote := subscribe(a), subscribe(b)
+
+	// Both hubs listen on the chunk's channel once their rooms exist
+	for deadline := time.Now().Add(time.Second); mr.PubSubNumSub("deltas:0:0")["deltas:0:0"] < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("hubs never subscribed to the chunk's deltas")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := postPaint(a, bostonPaint(9, 4), "10.0.0.2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// The subscriber on each instance gets the delta exactly once
+	for name, sub := range map[string]*websocket.Conn{"local": local, "remote": remote} {
+		sub.SetReadDeadline(time.Now().Add(time.Second))
+		var delta ws.Delta
+		if err := sub.ReadJSON(&delta); err != nil || delta.O != 9 || delta.Color != 4 {
+			t.Fatalf("%s subscriber: expected the delta for tile 9, got %+v (%v)", name, delta, err)
+		}
+		sub.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if err := sub.ReadJSON(&delta); err == nil {
+			t.Errorf("%s subscriber received a second delta %+v", name, delta)
+		}
+	}
+}
+
+func TestDeltaBusSharesFillsAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newFanoutHandler(t, mr, "a")
+	b := newFanoutHandler(t, mr, "b")
+
+	server := httptest.NewServer(http.HandlerFunc(b.HandleWebSocket))
+	defer server.Close()
+	remote, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=0&cy=0", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	// Every instance listens for notices from the start
+	for deadline := time.Now().Add(time.Second); mr.PubSubNumSub("notices")["notices"] < 2 || b.hub.GetSubscriberCount("0:0") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("instances never subscribed to notices")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	body, _ := json.Marshal(FillRequest{MaxX: 3, MaxY: 3, Color: 4})
+	w := httptest.NewRecorder()
+	a.PostFill(w, httptest.NewRequest(http.MethodPost, "/admin/fill", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("fill: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// The subscriber on the other instance gets the fill, not a delta per tile
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := remote.ReadMessage()
+	if err != nil {
+		t.Fatalf("remote subscriber did not receive the fill: %v", err)
+	}
+	var fill ws.RegionFill
+	if err := json.Unmarshal(msg, &fill); err != nil || !strings.Contains(string(msg), `"type":"fill"`) || fill.Seq != 1 || fill.MaxX != 3 || fill.Color != 4 {
+		t.Errorf("Expected the fill at seq 1, got %s", msg)
+	}
+}
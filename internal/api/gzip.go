@@ -0,0 +1,83 @@
+package api
+
+import (
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// chunkGzip compresses chunk responses for clients that accept gzip. Chunk
+// bits are mostly zeros and shrink several-fold, but small bodies such as
+// downsampled chunks aren't worth the CPU, so they go out as-is.
+type chunkGzip struct {
+	level    int
+	minBytes int
+	writers  sync.Pool
+}
+
+// newChunkGzip returns nil, meaning no compression, for level 0. An
+// invalid level falls back to gzip.DefaultCompression.
+func newChunkGzip(level, minBytes int) *chunkGzip {
+	if level == gzip.NoCompression {
+		return nil
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		slog.Warn("api: invalid chunk gzip level, using the default", "level", level)
+		level = gzip.DefaultCompression
+	}
+
+	g := &chunkGzip{level: level, minBytes: minBytes}
+	g.writers.New = func() any {
+		zw, _ := gzip.NewWriterLevel(nil, g.level)
+		return zw
+	}
+	return g
+}
+
+// write sends body with status 200, gzipped when it is large enough and
+// the client accepts it
+func (g *chunkGzip) write(w http.ResponseWriter, r *http.Request, body []byte) {
+	if g == nil {
+		w.WriteHeader(200)
+		w.Write(body)
+		return
+	}
+
+	// Caches must keep compressed and plain copies apart
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < g.minBytes || !acceptsGzip(r) {
+		w.WriteHeader(200)
+		w.Write(body)
+		return
+	}
+
+	zw := g.writers.Get().(*gzip.Writer)
+	defer g.writers.Put(zw)
+	zw.Reset(w)
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(200)
+	zw.Write(body)
+	zw.Close()
+}
+
+// acceptsGzip reports whether Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
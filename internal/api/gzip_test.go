@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"splat-boston/internal/bits"
+)
+
+// sparseChunk returns chunk bits with a few painted blobs over ~5% of the
+// tiles, like most of a live canvas
+func sparseChunk() []byte {
+	rng := rand.New(rand.NewSource(1))
+	buf := make([]byte, 32768)
+	for blob := 0; blob < 8; blob++ {
+		x0, y0 := rng.Intn(240), rng.Intn(240)
+		color := uint8(1 + rng.Intn(15))
+		for y := y0; y < y0+16; y++ {
+			for x := x0; x < x0+16; x++ {
+				if rng.Intn(4) == 0 {
+					color = uint8(1 + rng.Intn(15))
+				}
+				bits.SetNibble(buf, y*256+x, color)
+			}
+		}
+	}
+	return buf
+}
+
+func TestChunkGzipCompressesLargeBodies(t *testing.T) {
+	g := newChunkGzip(gzip.BestSpeed, 1024)
+	body := sparseChunk()
+
+	r := httptest.NewRequest(http.MethodGet, "/state/chunk", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	w := httptest.NewRecorder()
+	g.write(w, r, body)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzipped response, got headers %v", w.Header())
+	}
+	if w.Body.Len() >= len(body)/4 {
+		t.Errorf("Expected a sparse chunk to shrink at least 4x, got %d bytes", w.Body.Len())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); !bytes.Equal(got, body) {
+		t.Error("Decompressed body differs")
+	}
+}
+
+func TestChunkGzipSkipsSmallBodies(t *testing.T) {
+	g := newChunkGzip(gzip.BestSpeed, 1024)
+
+	r := httptest.NewRequest(http.MethodGet, "/state/chunk", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	g.write(w, r, make([]byte, 128)) // a chunk downsampled by 16
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Expected no Content-Encoding below the threshold, got %q", enc)
+	}
+	if w.Body.Len() != 128 {
+		t.Errorf("Expected the 128-byte body as-is, got %d bytes", w.Body.Len())
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP":     true,
+		"gzip;q=0.5, br":    true,
+		"gzip;q=0":          false,
+		"br, gzip ; q=0.0":  false,
+		"identity, deflate": false,
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+// BenchmarkChunkGzip compares levels on a sparse chunk and on random bits,
+// the worst case, reporting the compressed size
+func BenchmarkChunkGzip(b *testing.B) {
+	dense := make([]byte, 32768)
+	rand.New(rand.NewSource(1)).Read(dense)
+	chunks := map[string][]byte{"sparse": sparseChunk(), "dense": dense}
+
+	for _, name := range []string{"sparse", "dense"} {
+		body := chunks[name]
+		for _, level := range []int{gzip.HuffmanOnly, gzip.BestSpeed, 3, gzip.DefaultCompression, gzip.BestCompression} {
+			b.Run(fmt.Sprintf("%s/level=%d", name, level), func(b *testing.B) {
+				zw, _ := gzip.NewWriterLevel(io.Discard, level)
+				var out bytes.Buffer
+				b.SetBytes(int64(len(body)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					out.Reset()
+					zw.Reset(&out)
+					zw.Write(body)
+					zw.Close()
+				}
+				b.ReportMetric(float64(out.Len()), "bytes/op")
+			})
+		}
+	}
+}
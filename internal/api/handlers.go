@@ -2,15 +2,25 @@ package api
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 
+	"splat-boston/internal/bits"
+	"splat-boston/internal/events"
 	"splat-boston/internal/geo"
+	"splat-boston/internal/metrics"
 	"splat-boston/internal/rate"
 	redisclient "splat-boston/internal/redis"
 	"splat-boston/internal/turnstile"
@@ -26,6 +36,14 @@ type PaintRequest struct {
 	O              int     `json:"o"`
 	Color          uint8   `json:"color"`
 	TurnstileToken string  `json:"turnstileToken"`
+	ClientID       string  `json:"clientId,omitempty"`
+	// NetLat/NetLon are an optional network-based (cell/Wi-Fi) location
+	// hint the GPS fix must roughly agree with
+	NetLat *float64 `json:"netLat,omitempty"`
+	NetLon *float64 `json:"netLon,omitempty"`
+	// PaletteID is the palette the client drew the chunk with; it must be
+	// the chunk's, and is empty for the default palette
+	PaletteID string `json:"paletteId,omitempty"`
 }
 
 // PaintResponse represents a paint response
@@ -33,17 +51,299 @@ type PaintResponse struct {
 	Ok  bool   `json:"ok"`
 	Seq uint64 `json:"seq"`
 	Ts  int64  `json:"ts"`
+	// WSToken opens /sub when WSRequireAuth is set
+	WSToken string `json:"wsToken,omitempty"`
 }
 
+// TilesRequest represents a POST /state/tiles request
+type TilesRequest struct {
+	Tiles []TileRef `json:"tiles"`
+}
+
+// TileRef identifies a tile in a TilesRequest
+type TileRef struct {
+	Cx int64 `json:"cx"`
+	Cy int64 `json:"cy"`
+	O  int   `json:"o"`
+}
+
+// TilesResponse holds the colors of the requested tiles, in request order
+type TilesResponse struct {
+	Colors []int `json:"colors"`
+}
+
+// ChunkRLE is a chunk run-length encoded, returned by GET /state/chunk
+// with format=rle
+type ChunkRLE struct {
+	Seq uint64 `json:"seq"`
+	// Width is tiles per row: 256, or less when downsampled
+	Width int `json:"width"`
+	// Runs are [color, count] pairs in row-major tile order
+	Runs [][2]int `json:"runs"`
+	// Background is the color unpainted (0) tiles are drawn in, if set
+	Background uint8 `json:"background,omitempty"`
+	// PaletteID names the palette colors are drawn from, if not the default
+	PaletteID string `json:"paletteId,omitempty"`
+}
+
+// ChunkStats summarizes a chunk's tiles for GET /state/chunk/stats
+type ChunkStats struct {
+	Painted int `json:"painted"`
+	Total   int `json:"total"`
+	// Histogram counts tiles per color; index 0 is unpainted tiles
+	Histogram [16]int `json:"histogram"`
+}
+
+// maxTilesPerRequest bounds how many tiles a single POST /state/tiles reads
+const maxTilesPerRequest = 1024
+
+// maxChunksPerRequest bounds how many chunks a single GET /state/chunks
+// returns (2MB of bits)
+const maxChunksPerRequest = 64
+
 // Config holds the server configuration
 type Config struct {
 	EnableTurnstile bool
 	TurnstileSecret string
 	GeofenceRadiusM float64
 	SpeedMaxKmh     float64
+	// EnableSpeedLimit rejects paints implying travel faster than
+	// SpeedMaxKmh; venues where GPS jitters can turn it off
+	EnableSpeedLimit bool
+	// LimiterTTLS evicts a subject's limiter state after this long idle
+	LimiterTTLS     int
 	PaintCooldownMs int
 	WSWriteBuffer   int
+	// WSPingIntervalS is how often WebSockets are pinged; one silent for
+	// three intervals is closed. Keep it under the proxy's idle timeout.
 	WSPingIntervalS int
+
+	// PaintCooldownByColor gives the listed colors their own cooldown in
+	// ms, e.g. a long one for a rare event color. Each such color cools
+	// down separately; the rest share PaintCooldownMs's.
+	PaintCooldownByColor map[uint8]int
+
+	// Palette is the "#RRGGBB" color of each of the 16 color indexes,
+	// served to clients by GET /config; unset entries are DefaultPalette's
+	Palette [16]string
+
+	// Palettes are named palettes an admin can assign chunks, whose color
+	// indexes are then drawn in that palette's colors. Paints must carry
+	// their chunk's palette id, so clients can't paint with the wrong one.
+	Palettes map[string][16]string
+
+	// TrustClientCoords paints the submitted cx/cy/o as-is instead of
+	// requiring them to match lat/lon; for clients that haven't migrated
+	TrustClientCoords bool
+	// CoordsToleranceTiles is how many tiles, on either axis, the declared
+	// tile may be from the one at lat/lon, allowing for GPS error near tile
+	// edges (0 requires them to match)
+	CoordsToleranceTiles int
+	// RequireSubscription only accepts paints on chunks the subject is
+	// subscribed to over WebSocket
+	RequireSubscription bool
+
+	// TileMeters is the size of the tiles lat/lon are projected onto, 10m
+	// when unset. Clients and the mask must be built for the same size.
+	TileMeters float64
+
+	// NetHintMaxKm is how far a paint's network location hint may be from
+	// its lat/lon (0 disables). A mismatch is only logged and counted unless
+	// NetHintEnforce is set.
+	NetHintMaxKm   float64
+	NetHintEnforce bool
+
+	// ChunkMaxAgeS and ChunkMaxAgeJitterS set GetChunk's max-age to a random
+	// value in base±jitter so clients don't refetch in synchronized waves
+	ChunkMaxAgeS       int
+	ChunkMaxAgeJitterS int
+
+	// StaleChunks keeps the last read of this many recently read chunks in
+	// memory, 32KB each, and serves it from GetChunk while Redis is
+	// unreachable rather than failing the read (0 disables)
+	StaleChunks int
+
+	// ChunkGzipLevel compresses chunk responses of at least
+	// ChunkGzipMinBytes for clients accepting gzip (0 disables). Level 1
+	// gets within ~10% of level 9's size on mostly-blank chunks at a
+	// fraction of the CPU.
+	ChunkGzipLevel    int
+	ChunkGzipMinBytes int
+
+	// EnableWarmupCooldown applies WarmupCooldownMs instead of
+	// PaintCooldownMs to paints on never-painted tiles
+	EnableWarmupCooldown bool
+	WarmupCooldownMs     int
+
+	// EnableStreak rewards painting on consecutive days with a cooldown
+	// reduced by StreakBonusPct per day, up to StreakMaxBonusPct
+	EnableStreak      bool
+	StreakBonusPct    int
+	StreakMaxBonusPct int
+
+	// WSLagRatio is the fraction of lagging subscribers that switches a room
+	// to coalesced delivery (0 disables)
+	WSLagRatio           float64
+	WSCoalesceIntervalMs int
+
+	// WSRegisterBuffer is the capacity of the hub's subscribe queue
+	WSRegisterBuffer int
+
+	// WSRecentDeltas is how many of each chunk's latest deltas the hub keeps
+	// in memory to send clients joining within WSRecentWindowMs of them
+	WSRecentDeltas   int
+	WSRecentWindowMs int
+
+	// WSMaxRoomRate caps the deltas per second a chunk's subscribers are
+	// sent one by one; a busier chunk sends a resync every
+	// WSSnapshotIntervalMs instead (0 disables)
+	WSMaxRoomRate        int
+	WSSnapshotIntervalMs int
+
+	// WSPresenceIntervalMs sends each chunk's subscribers its viewer count
+	// when it changes, at most this often (0 disables)
+	WSPresenceIntervalMs int
+
+	// WSActivityIntervalMs is how often activity subscribers get their
+	// region's per-chunk paint counts (0 disables activity subscriptions)
+	WSActivityIntervalMs int
+
+	// WSMaxLifetimeS closes WebSocket connections after this long, less up
+	// to WSMaxLifetimeJitterS, telling clients to reconnect (0 disables)
+	WSMaxLifetimeS       int
+	WSMaxLifetimeJitterS int
+
+	// WSMaxRoomsPerConn bounds how many chunks one WebSocket may subscribe
+	// to (0 for the default of 64)
+	WSMaxRoomsPerConn int
+
+	// WSDropOldest keeps a WebSocket that falls behind connected, discarding
+	// its oldest queued delta for each new one and resyncing that chunk,
+	// rather than closing it
+	WSDropOldest bool
+
+	// WSCompression offers permessage-deflate to WebSocket clients; frames
+	// under WSCompressMinBytes (0 for the default) are still sent as is
+	WSCompression      bool
+	WSCompressMinBytes int
+
+	// KafkaBrokers (comma-separated) and KafkaTopic enable exporting paint
+	// events to Kafka
+	KafkaBrokers string
+	KafkaTopic   string
+
+	// DuplicateWindowMs treats an identical paint (same subject, tile and
+	// color) repeated within this long as the same action. Zero disables it.
+	DuplicateWindowMs int
+
+	// UndoWindowS is how long a painter may undo their last paint with
+	// POST /paint/undo (0 disables it). Undo reads the chunk history, so
+	// it also needs WS_HISTORY_LEN.
+	UndoWindowS int
+
+	// RedisTimeoutMs bounds the Redis calls made for a chunk read or a
+	// paint, so a hung Redis fails requests instead of piling them up
+	// (0 leaves only the client's own disconnect to end them)
+	RedisTimeoutMs int
+
+	// StrictPaintJSON rejects JSON paints with fields PaintRequest doesn't
+	// have, so a client's typo fails loudly instead of painting color 0
+	StrictPaintJSON bool
+
+	// BanStrikes bans an IP on this many speed limit or geofence rejections
+	// within BanStrikeWindowS (0 disables). Its nth ban lasts
+	// BanPenalties[n-1], or the last penalty once past the end.
+	BanStrikes       int
+	BanStrikeWindowS int
+	BanPenalties     []time.Duration
+
+	// CDNInvalidateURL, when set, receives POSTed "chunk changed, new seq"
+	// notices, at most one per chunk every CDNInvalidateDebounceMs
+	CDNInvalidateURL        string
+	CDNInvalidateDebounceMs int
+
+	// CORSOrigins lists (comma-separated) the origins browsers may call the
+	// API and open WebSockets from; "*" allows any, empty allows none
+	CORSOrigins string
+
+	// AdminToken is the bearer token for /admin endpoints (empty disables)
+	AdminToken string
+
+	// ReadRateLimit caps canvas reads per IP in any ReadRateWindowS
+	// seconds, 60 if unset (0 disables). It is separate from the paint
+	// limits and meant to be generous: a viewport load reads dozens of
+	// chunks at once, though WebSocket snapshots don't count.
+	ReadRateLimit   int
+	ReadRateWindowS int
+	// ReadRateBurst, when set, limits reads with a token bucket of this
+	// size refilled at ReadRateLimit per window, rather than a sliding
+	// window
+	ReadRateBurst int
+
+	// PaintQueueSize buffers up to this many validated paints in memory
+	// while Redis is unreachable, applying them in order once it's back;
+	// each waits up to PaintQueueTimeoutMs before failing with 503. Zero
+	// disables the queue.
+	PaintQueueSize      int
+	PaintQueueTimeoutMs int
+
+	// ObserverKeys lists (comma-separated) "key:scope" API keys for partners.
+	// A read-scoped key sent as X-API-Key skips the read rate limit, may
+	// fetch larger batches, and gets ObserverMaxAgeS private caching.
+	ObserverKeys    string
+	ObserverMaxAgeS int
+
+	// WSRequireAuth refuses /sub without a token minted with WSTokenSecret,
+	// good for WSTokenTTLS seconds (0 for 10 minutes). Successful paints
+	// and POST /sub/token, which checks Turnstile, hand them out.
+	WSRequireAuth bool
+	WSTokenSecret string
+	WSTokenTTLS   int
+
+	// WorldBounds are the chunks paints and subscriptions may name; others
+	// are rejected before they can create Redis keys. Nil uses the chunks
+	// the Boston geofence box covers.
+	WorldBounds *ws.ChunkBounds
+
+	// TrustedProxies are the networks of the proxies in front of the
+	// server, such as Cloudflare's. Only a peer in one may name the client
+	// with CF-Connecting-IP or X-Forwarded-For; otherwise the client is the
+	// peer's address.
+	TrustedProxies []netip.Prefix
+
+	// HTTP server timeouts (0 disables each). ReadHeaderTimeoutMs drops a
+	// client that trickles its headers; ReadTimeoutMs and WriteTimeoutMs
+	// bound a whole request and response; IdleTimeoutMs closes keep-alive
+	// connections between requests. WebSockets are exempt once upgraded.
+	ReadHeaderTimeoutMs int
+	ReadTimeoutMs       int
+	WriteTimeoutMs      int
+	IdleTimeoutMs       int
+}
+
+// HubConfig returns the WebSocket hub tunables derived from the config
+func (c Config) HubConfig() ws.Config {
+	config := ws.Config{
+		LagRatio:         c.WSLagRatio,
+		CoalesceInterval: time.Duration(c.WSCoalesceIntervalMs) * time.Millisecond,
+		RegisterBuffer:   c.WSRegisterBuffer,
+		RecentDeltas:     c.WSRecentDeltas,
+		RecentWindow:     time.Duration(c.WSRecentWindowMs) * time.Millisecond,
+		MaxRoomRate:      c.WSMaxRoomRate,
+		SnapshotInterval: time.Duration(c.WSSnapshotIntervalMs) * time.Millisecond,
+		PresenceInterval: time.Duration(c.WSPresenceIntervalMs) * time.Millisecond,
+		CompressMinBytes: c.WSCompressMinBytes,
+		ActivityInterval: time.Duration(c.WSActivityIntervalMs) * time.Millisecond,
+		MaxLifetime:      time.Duration(c.WSMaxLifetimeS) * time.Second,
+		LifetimeJitter:   time.Duration(c.WSMaxLifetimeJitterS) * time.Second,
+		MaxRoomsPerConn:  c.WSMaxRoomsPerConn,
+		PingInterval:     time.Duration(c.WSPingIntervalS) * time.Second,
+		Bounds:           c.worldBounds(),
+	}
+	if c.WSDropOldest {
+		config.Backpressure = ws.DropOldest
+	}
+	return config
 }
 
 // Handler handles HTTP requests
@@ -54,221 +354,996 @@ type Handler struct {
 	turnstileClient *turnstile.TurnstileClient
 	cooldownLimiter *rate.Limiter
 	speedLimiter    *rate.SpeedLimiter
-	mask            *geo.Mask
+	mask            geo.TileMask
+	proj            geo.Projection
+	events          events.Sink
+	metrics         *metrics.Metrics
+	origins         originAllowlist
+	gzip            *chunkGzip
 	upgrader        websocket.Upgrader
+	hotspots        *rejectionHotspots
+	readLimiter     rate.RequestLimiter
+	paintQueue      *paintQueue
+	observerKeys    [][]byte
+	bans            *rate.BanList
+	stale           *staleChunks
+	wsTokenSecret   []byte
+	logger          *slog.Logger
+	world           ws.ChunkBounds
 }
 
-// NewHandler creates a new API handler
-func NewHandler(rdb *redisclient.Client, hub *ws.Hub, config Config, mask *geo.Mask) *Handler {
+// NewHandler creates a new API handler. mask is a *geo.Mask or
+// *geo.MultiMask, or nil, not a nil pointer, for none. It panics on a mask
+// config.CheckMask rejects.
+func NewHandler(rdb *redisclient.Client, hub *ws.Hub, config Config, mask geo.TileMask) *Handler {
 	h := &Handler{
 		rdb:             rdb,
 		hub:             hub,
 		config:          config,
 		cooldownLimiter: rate.NewLimiter(),
-		speedLimiter:    rate.NewSpeedLimiter(config.SpeedMaxKmh),
 		mask:            mask,
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for now
-			},
-			WriteBufferSize: config.WSWriteBuffer,
+		metrics:         metrics.New(hub),
+		origins:         parseOrigins(config.CORSOrigins),
+		gzip:            newChunkGzip(config.ChunkGzipLevel, config.ChunkGzipMinBytes),
+		stale:           newStaleChunks(config.StaleChunks),
+		hotspots:        newRejectionHotspots(),
+		proj:            config.projection(),
+		observerKeys:    parseObserverKeys(config.ObserverKeys),
+		wsTokenSecret:   wsTokenSecret(config),
+		logger:          slog.Default(),
+		world:           config.worldBounds(),
+	}
+	for i, color := range h.config.Palette {
+		if color == "" {
+			h.config.Palette[i] = DefaultPalette[i]
+		}
+	}
+	if err := config.CheckMask(mask); err != nil {
+		panic("api: " + err.Error())
+	}
+	h.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			// Non-browser clients don't send an Origin
+			origin := r.Header.Get("Origin")
+			return origin == "" || h.origins.allows(origin)
 		},
+		WriteBufferSize:   config.WSWriteBuffer,
+		EnableCompression: config.WSCompression,
 	}
 
 	if config.EnableTurnstile {
 		h.turnstileClient = turnstile.NewTurnstileClient(config.TurnstileSecret)
 	}
 
+	if config.EnableSpeedLimit {
+		h.speedLimiter = rate.NewSpeedLimiter(config.SpeedMaxKmh)
+		if config.LimiterTTLS > 0 {
+			h.speedLimiter.StartJanitor(limiterJanitorInterval, time.Duration(config.LimiterTTLS)*time.Second)
+		}
+	}
+
+	if config.PaintQueueSize > 0 {
+		h.paintQueue = newPaintQueue(config.PaintQueueSize, time.Duration(config.PaintQueueTimeoutMs)*time.Millisecond)
+	}
+
+	if config.ReadRateLimit > 0 {
+		window := time.Minute
+		if config.ReadRateWindowS > 0 {
+			window = time.Duration(config.ReadRateWindowS) * time.Second
+		}
+		if config.ReadRateBurst > 0 {
+			h.readLimiter = rate.NewTokenBucketLimiter(float64(config.ReadRateLimit)/window.Seconds(), config.ReadRateBurst)
+		} else {
+			h.readLimiter = rate.NewRateLimiter(config.ReadRateLimit, window)
+		}
+		h.readLimiter.StartJanitor(limiterJanitorInterval, window)
+	}
+	if config.BanStrikes > 0 {
+		window := time.Duration(config.BanStrikeWindowS) * time.Second
+		h.bans = rate.NewBanList(rdb, config.BanStrikes, window, config.BanPenalties)
+	}
+
+	var sinks []events.Sink
+	if config.KafkaBrokers != "" && config.KafkaTopic != "" {
+		writer := events.NewKafkaWriter(strings.Split(config.KafkaBrokers, ","), config.KafkaTopic)
+		sinks = append(sinks, events.NewKafkaSink(writer, events.KafkaConfig{}))
+	}
+	if config.CDNInvalidateURL != "" {
+		sinks = append(sinks, events.NewInvalidationSink(
+			&events.HTTPNotifier{URL: config.CDNInvalidateURL, Client: &http.Client{Timeout: 5 * time.Second}},
+			events.InvalidationConfig{Debounce: time.Duration(config.CDNInvalidateDebounceMs) * time.Millisecond},
+		))
+	}
+	if len(sinks) > 0 {
+		h.events = events.Multi(sinks...)
+	}
+
 	return h
 }
 
+// limiterJanitorInterval is how often stale limiter entries are swept
+const limiterJanitorInterval = time.Minute
+
+// Close stops the handler's background work
+func (h *Handler) Close() {
+	if h.speedLimiter != nil {
+		h.speedLimiter.Close()
+	}
+	if h.readLimiter != nil {
+		h.readLimiter.Close()
+	}
+	if h.paintQueue != nil {
+		h.paintQueue.close()
+	}
+	if h.events != nil {
+		h.events.Close()
+	}
+}
+
 // GetChunk handles GET /state/chunk?cx=&cy=
 func (h *Handler) GetChunk(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
-	cxStr := r.URL.Query().Get("cx")
-	cyStr := r.URL.Query().Get("cy")
+	query := r.URL.Query()
+	cx, perr := parseInt64Param(query, "cx")
+	if perr != nil {
+		h.rejectParam(w, perr)
+		return
+	}
+	cy, perr := parseInt64Param(query, "cy")
+	if perr != nil {
+		h.rejectParam(w, perr)
+		return
+	}
+
+	// Optional block downsampling for zoomed-out views
+	downsample := 1
+	if s := query.Get("downsample"); s != "" {
+		var err error
+		downsample, err = strconv.Atoi(s)
+		if err != nil || !validDownsample(downsample) {
+			h.rejectParam(w, invalidParam("downsample", "must be 1, 2, 4, ..., 256"))
+			return
+		}
+	}
 
-	if cxStr == "" || cyStr == "" {
-		http.Error(w, "Missing cx or cy parameter", 400)
+	// The raw bits unless the client asked for runs
+	format := query.Get("format")
+	if format != "" && format != "rle" {
+		h.rejectParam(w, invalidParam("format", "must be rle or omitted"))
 		return
 	}
 
-	cx, err := strconv.ParseInt(cxStr, 10, 64)
+	// border=1 appends the neighboring tiles to the raw bits
+	border := false
+	switch query.Get("border") {
+	case "", "0":
+	case "1":
+		if downsample > 1 || format != "" {
+			h.rejectParam(w, invalidParam("border", "only supported on full-resolution raw chunks"))
+			return
+		}
+		border = true
+	default:
+		h.rejectParam(w, invalidParam("border", "must be 0 or 1"))
+		return
+	}
+
+	// from and to pick bytes of the raw bits, inclusive
+	ranged := query.Has("from") || query.Has("to")
+	var from, to int64
+	if ranged {
+		if downsample > 1 || format != "" || border {
+			h.rejectParam(w, invalidParam("from", "only supported on full-resolution raw chunks"))
+			return
+		}
+		if from, perr = parseInt64Param(query, "from"); perr == nil {
+			to, perr = parseInt64Param(query, "to")
+		}
+		if perr == nil && (from < 0 || to >= chunkBytes || from > to) {
+			perr = invalidParam("to", fmt.Sprintf("need 0 <= from <= to <= %d", chunkBytes-1))
+		}
+		if perr != nil {
+			h.rejectParam(w, perr)
+			return
+		}
+	}
+
+	// Bits, seq and background color; unpainted chunks come back blank.
+	// Without Redis the last read may stand in, though not for a border,
+	// which is read from the neighbors.
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	chunk := redisclient.ChunkRef{Cx: cx, Cy: cy}
+	stale := false
+	var snaps []redisclient.ChunkSnapshot
+	var err error
+	if ranged {
+		var snap redisclient.ChunkSnapshot
+		snap, err = h.rdb.GetChunkSnapshotRangeContext(ctx, cx, cy, int(from), int(to))
+		snaps = []redisclient.ChunkSnapshot{snap}
+	} else {
+		snaps, err = h.rdb.GetChunkSnapshotsContext(ctx, []redisclient.ChunkRef{chunk})
+	}
 	if err != nil {
-		http.Error(w, "Invalid cx parameter", 400)
+		h.redisError("chunk", err)
+	}
+	switch {
+	case err == nil && h.stale != nil && !ranged:
+		h.stale.put(snaps[0])
+	case err != nil && h.stale != nil && !border:
+		if snap, ok := h.stale.get(chunk); ok {
+			if ranged {
+				snap.Bits = snap.Bits[from : to+1]
+			}
+			snaps, err, stale = []redisclient.ChunkSnapshot{snap}, nil, true
+		}
+	}
+	if err != nil {
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+	buf, seq, background, paletteID := snaps[0].Bits, snaps[0].Seq, snaps[0].Background, snaps[0].PaletteID
+
+	if border {
+		ring, err := h.rdb.GetChunkBorderContext(ctx, cx, cy)
+		if err != nil {
+			h.redisError("chunk_border", err)
+			writeError(w, 500, CodeRedis, "redis error")
+			return
+		}
+		buf = appendBorder(buf, ring)
+		w.Header().Set("X-Border", "1")
+	}
+
+	if downsample > 1 {
+		buf = bits.Downsample(buf, chunkWidth, downsample)
+		w.Header().Set("X-Downsample", strconv.Itoa(downsample))
+	}
+
+	// Set headers
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Seq", fmt.Sprintf("%d", seq))
+	w.Header().Set("X-Canvas-Epoch", strconv.FormatUint(h.hub.Epoch(), 10))
+	if stale {
+		setStaleCache(w)
+	} else {
+		h.setReadCache(w, r)
+	}
+	if background != 0 {
+		w.Header().Set("X-Background-Color", strconv.Itoa(int(background)))
+	}
+	if paletteID != "" {
+		w.Header().Set("X-Palette-Id", paletteID)
+	}
+
+	if ranged {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, chunkBytes))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(buf)
+		return
+	}
+
+	if format == "rle" {
+		encoded := ChunkRLE{Seq: seq, Width: chunkWidth / downsample, Background: background, PaletteID: paletteID}
+		for _, run := range bits.EncodeRLE(buf) {
+			encoded.Runs = append(encoded.Runs, [2]int{int(run.Color), run.Count})
+		}
+		buf, err = json.Marshal(encoded)
+		if err != nil {
+			writeError(w, 500, CodeInternal, "failed to encode chunk")
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+	}
+	h.gzip.write(w, r, buf)
+}
+
+// chunkBorderBytes is the size of the border appended by GetChunk's
+// border=1: four nibble-packed strips of chunkWidth tiles, then the four
+// corners
+const chunkBorderBytes = 4*chunkWidth/2 + 2
+
+// appendBorder appends a chunk's border to its bits: the north, south, west
+// and east strips, rows west to east and columns north to south, then the
+// NW, NE, SW and SE corners, all packed like the bits
+func appendBorder(buf []byte, border redisclient.ChunkBorder) []byte {
+	out := make([]byte, len(buf)+chunkBorderBytes)
+	copy(out, buf)
+	ring := out[len(buf):]
+	for strip, colors := range [][]uint8{border.North, border.South, border.West, border.East} {
+		for i, color := range colors {
+			bits.SetNibble(ring, strip*chunkWidth+i, color)
+		}
+	}
+	for i, color := range []uint8{border.NW, border.NE, border.SW, border.SE} {
+		bits.SetNibble(ring, 4*chunkWidth+i, color)
+	}
+	return out
+}
+
+// GetChunkStats handles GET /state/chunk/stats, counting a chunk's painted
+// tiles by color
+func (h *Handler) GetChunkStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cx, perr := parseInt64Param(query, "cx")
+	if perr != nil {
+		h.rejectParam(w, perr)
+		return
+	}
+	cy, perr := parseInt64Param(query, "cy")
+	if perr != nil {
+		h.rejectParam(w, perr)
 		return
 	}
 
-	cy, err := strconv.ParseInt(cyStr, 10, 64)
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	buf, seq, err := h.rdb.GetChunkSnapshotContext(ctx, cx, cy)
 	if err != nil {
-		http.Error(w, "Invalid cy parameter", 400)
+		h.redisError("chunk_stats", err)
+		writeError(w, 500, CodeRedis, "redis error")
 		return
 	}
 
-	// Get sequence number
-	seq, err := h.rdb.GetChunkSeq(cx, cy)
-	if err != nil && err != redis.Nil {
-		http.Error(w, "Redis error", 500)
+	stats := ChunkStats{Total: len(buf) * 2, Histogram: bits.ColorHistogram(buf)}
+	stats.Painted = stats.Total - stats.Histogram[0]
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Seq", strconv.FormatUint(seq, 10))
+	h.setReadCache(w, r)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetChunks handles GET /state/chunks?chunks=cx,cy;cx,cy;..., returning
+// several chunks read in one Redis round trip. Each chunk is a record of cx,
+// cy and seq as big-endian 64-bit integers, the bits' length as a big-endian
+// uint32, then the bits, in the order requested.
+func (h *Handler) GetChunks(w http.ResponseWriter, r *http.Request) {
+	chunks, perr := parseChunkList(rawQueryParam(r.URL.RawQuery, "chunks"), h.batchLimit(r, maxChunksPerRequest))
+	if perr != nil {
+		h.rejectParam(w, perr)
 		return
 	}
 
-	// Get chunk bits
-	buf, err := h.rdb.GetChunkBits(cx, cy)
-	if err == redis.Nil || len(buf) == 0 {
-		buf = make([]byte, 32768) // blank chunk
-	} else if err != nil {
-		http.Error(w, "Redis error", 500)
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	snaps, err := h.rdb.GetChunkSnapshotsContext(ctx, chunks)
+	if err != nil {
+		h.redisError("chunks", err)
+		writeError(w, 500, CodeRedis, "redis error")
 		return
 	}
 
-	// Ensure we have 32KB
-	if len(buf) < 32768 {
-		newBuf := make([]byte, 32768)
-		copy(newBuf, buf)
-		buf = newBuf
+	body := make([]byte, 0, len(snaps)*(chunkRecordHeader+32768))
+	var backgrounds, paletteIDs []string
+	for _, snap := range snaps {
+		body = binary.BigEndian.AppendUint64(body, uint64(snap.Cx))
+		body = binary.BigEndian.AppendUint64(body, uint64(snap.Cy))
+		body = binary.BigEndian.AppendUint64(body, snap.Seq)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(snap.Bits)))
+		body = append(body, snap.Bits...)
+
+		if snap.Background != 0 {
+			backgrounds = append(backgrounds, fmt.Sprintf("%d,%d=%d", snap.Cx, snap.Cy, snap.Background))
+		}
+		if snap.PaletteID != "" {
+			paletteIDs = append(paletteIDs, fmt.Sprintf("%d,%d=%s", snap.Cx, snap.Cy, snap.PaletteID))
+		}
 	}
 
-	// Set headers
+	// The record layout predates backgrounds and palettes, so they travel
+	// in headers
+	if len(backgrounds) > 0 {
+		w.Header().Set("X-Background-Colors", strings.Join(backgrounds, ";"))
+	}
+	if len(paletteIDs) > 0 {
+		w.Header().Set("X-Palette-Ids", strings.Join(paletteIDs, ";"))
+	}
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("X-Seq", fmt.Sprintf("%d", seq))
-	w.Header().Set("Cache-Control", "public, max-age=2, stale-while-revalidate=8")
-	w.WriteHeader(200)
-	w.Write(buf)
+	w.Header().Set("X-Canvas-Epoch", strconv.FormatUint(h.hub.Epoch(), 10))
+	h.setReadCache(w, r)
+	h.gzip.write(w, r, body)
 }
 
-// PostPaint handles POST /paint
-func (h *Handler) PostPaint(w http.ResponseWriter, r *http.Request) {
-	var req PaintRequest
+// chunkRecordHeader is the size of a GetChunks record before the bits
+const chunkRecordHeader = 28
+
+// rawQueryParam returns the first value of a query parameter. Unlike
+// url.Values it keeps values with an unescaped ';', which ParseQuery drops.
+func rawQueryParam(rawQuery, name string) string {
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err != nil || k != name {
+			continue
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseChunkList reads "cx,cy;cx,cy;..." into chunk refs, up to limit of
+// them
+func parseChunkList(list string, limit int) ([]redisclient.ChunkRef, *ErrorDetail) {
+	if list == "" {
+		return nil, missingParam("chunks")
+	}
+
+	pairs := strings.Split(list, ";")
+	if len(pairs) > limit {
+		return nil, &ErrorDetail{
+			Code:    CodeTooManyChunks,
+			Message: fmt.Sprintf("too many chunks (max %d)", limit),
+			Param:   "chunks",
+		}
+	}
+
+	chunks := make([]redisclient.ChunkRef, len(pairs))
+	for i, pair := range pairs {
+		cxStr, cyStr, ok := strings.Cut(pair, ",")
+		cx, errX := strconv.ParseInt(cxStr, 10, 64)
+		cy, errY := strconv.ParseInt(cyStr, 10, 64)
+		if !ok || errX != nil || errY != nil {
+			return nil, invalidParam("chunks", fmt.Sprintf("%q is not cx,cy", pair))
+		}
+		chunks[i] = redisclient.ChunkRef{Cx: cx, Cy: cy}
+	}
+	return chunks, nil
+}
+
+// chunkWidth is the number of tiles along each side of a chunk
+const chunkWidth = 256
+
+// chunkBytes is the size of a chunk's raw bits, two tiles to a byte
+const chunkBytes = chunkWidth * chunkWidth / 2
+
+// validOffset reports whether o is a tile offset within a chunk
+func validOffset(o int) bool {
+	return o >= 0 && o < chunkWidth*chunkWidth
+}
+
+// validDownsample reports whether n evenly divides a chunk's width
+func validDownsample(n int) bool {
+	return n >= 1 && n <= chunkWidth && chunkWidth%n == 0
+}
+
+// PostTiles handles POST /state/tiles, returning the colors of a scattered
+// set of tiles in one pipelined Redis read
+func (h *Handler) PostTiles(w http.ResponseWriter, r *http.Request) {
+	var req TilesRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad json", 400)
+		writeError(w, 400, CodeBadRequest, "bad json")
 		return
 	}
 
-	// Verify Turnstile if enabled
-	if h.config.EnableTurnstile {
-		if req.TurnstileToken == "" {
-			http.Error(w, "turnstile", 401)
+	if limit := h.batchLimit(r, maxTilesPerRequest); len(req.Tiles) > limit {
+		writeError(w, 400, CodeTooManyTiles, fmt.Sprintf("too many tiles (max %d)", limit))
+		return
+	}
+
+	refs := make([]redisclient.TileRef, len(req.Tiles))
+	for i, tile := range req.Tiles {
+		if !validOffset(tile.O) {
+			writeError(w, 400, CodeInvalidOffset, "invalid offset")
 			return
 		}
+		refs[i] = redisclient.TileRef{Cx: tile.Cx, Cy: tile.Cy, O: tile.O}
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	colors, err := h.rdb.GetTileColorsContext(ctx, refs)
+	if err != nil {
+		h.redisError("tiles", err)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+
+	// Encode as numbers rather than the base64 a []byte would default to
+	response := TilesResponse{Colors: make([]int, len(colors))}
+	for i, color := range colors {
+		response.Colors[i] = int(color)
+	}
 
-		ip := getIP(r)
-		resp, err := h.turnstileClient.Verify(context.Background(), req.TurnstileToken, ip)
-		if err != nil || !resp.Success {
-			http.Error(w, "turnstile", 401)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PostPaint handles POST /paint
+func (h *Handler) PostPaint(w http.ResponseWriter, r *http.Request) {
+	var req PaintRequest
+	if isProtobufRequest(r) {
+		if err := decodeProtoPaintRequest(r.Body, &req); err != nil {
+			h.metrics.PaintRejected("bad_request")
+			writeError(w, 400, CodeBadRequest, "bad protobuf")
 			return
 		}
+	} else if perr := h.decodePaintJSON(r.Body, &req); perr != nil {
+		h.metrics.PaintRejected("bad_request")
+		writeErrorResponse(w, 400, ErrorResponse{Error: *perr})
+		return
 	}
 
-	// Cooldown and speed limit disabled for development
-	// ip := getIP(r)
-	// cooldownDuration := time.Duration(h.config.PaintCooldownMs) * time.Millisecond
-	// if h.cooldownLimiter.CheckCooldown(ip, cooldownDuration) {
-	// 	http.Error(w, "cooldown", 429)
-	// 	return
-	// }
-	// if !h.speedLimiter.CheckSpeed(ip, req.Lat, req.Lon) {
-	// 	http.Error(w, "speed limit exceeded", 403)
-	// 	return
-	// }
+	// mode=claim only paints unpainted tiles, for event modes where the
+	// first painter owns a tile
+	claimMode := false
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "":
+	case "claim":
+		claimMode = true
+	default:
+		h.rejectParam(w, invalidParam("mode", "must be claim or omitted"))
+		return
+	}
+
+	ip := h.clientIP(r)
+	paintAttrs := []any{"ip", ip, "cx", req.Cx, "cy", req.Cy}
+	logger := h.logger.With(paintAttrs...)
+
+	// Each stretch of Redis calls gets its own RedisTimeoutMs, so a hung
+	// Redis can't hold the handler much past it
+	pre, cancelPre := h.redisContext(r)
+	defer cancelPre()
 
-	// Check geofence (simplified - just check lat/lon bounds for Boston area)
-	if req.Lat < 42.0 || req.Lat > 43.0 || req.Lon < -72.0 || req.Lon > -70.0 {
-		http.Error(w, "geofence", 403)
+	if check := h.checkBan(pre, ip); !check.Pass {
+		h.rejectPaint(w, logger, check)
 		return
 	}
 
-	// Check mask if available
-	if h.mask != nil {
-		x, y := geo.LatLonToTileXY(req.Lat, req.Lon)
-		if !h.mask.IsTileAllowed(x, y) {
-			http.Error(w, "outside mask", 403)
+	// A double-submitted paint (double click, client retry) is answered
+	// with the first one's result instead of painting and cooling down
+	// twice. This runs before Turnstile since the retry reuses a token
+	// that has already been spent.
+	fingerprint := ""
+	if h.config.DuplicateWindowMs > 0 {
+		fp := paintFingerprint(ip, req)
+		claim, err := h.rdb.ClaimPaintContext(pre, fp, h.duplicateWindow())
+		switch {
+		case err != nil:
+			// Fall through and paint without dedupe
+			h.redisError("duplicate", err, paintAttrs...)
+		case !claim.Claimed && claim.Seq == 0:
+			h.metrics.PaintRejected("duplicate")
+			writeError(w, 409, CodeDuplicate, "duplicate paint in progress")
 			return
+		case !claim.Claimed:
+			w.Header().Set("X-Duplicate", "1")
+			writePaintResponse(w, r, PaintResponse{Ok: true, Seq: claim.Seq, Ts: claim.Ts, WSToken: h.mintWSTokenFor(ip)})
+			return
+		default:
+			fingerprint = fp
+		}
+	}
+	painted := false
+	defer func() {
+		if fingerprint != "" && !painted {
+			ctx, cancel := h.detachedRedisContext(r)
+			defer cancel()
+			if err := h.rdb.ReleasePaintContext(ctx, fingerprint); err != nil {
+				h.redisError("duplicate", err, paintAttrs...)
+			}
 		}
+	}()
+
+	if check := h.checkTurnstile(r.Context(), ip, req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkCooldown(ip, req.Color); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+
+	if check := h.checkSpeed(ip, req, true); !check.Pass {
+		h.hotspots.record(check.Name, req.Lat, req.Lon)
+		h.strike(ctx, ip, logger, check)
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkGeofence(req); !check.Pass {
+		h.hotspots.record(check.Name, req.Lat, req.Lon)
+		h.strike(ctx, ip, logger, check)
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkOffset(req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkChunk(req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkCoords(req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkSubscription(ip, req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	check := h.checkNetHint(req)
+	if !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+	if check.Flagged {
+		h.flagPaint(logger, check)
 	}
 
-	// Validate color range
-	if req.Color > 15 {
-		http.Error(w, "invalid color", 400)
+	if check := h.checkMask(req); !check.Pass {
+		h.hotspots.record(check.Name, req.Lat, req.Lon)
+		h.strike(ctx, ip, logger, check)
+		h.rejectPaint(w, logger, check)
 		return
 	}
 
-	// Paint tile
-	seq, ts, _, err := h.rdb.PaintTile(req.Cx, req.Cy, req.O, req.Color)
+	if check := h.checkColor(req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	if check := h.checkPaletteID(ctx, req); !check.Pass {
+		h.rejectPaint(w, logger, check)
+		return
+	}
+
+	// Paint tile. The script checks and starts the cooldown in Redis too,
+	// so a painter can't slip a second paint in through another instance
+	// before this one's cooldown is recorded.
+	coolKey := h.cooldownKey(ip, req.Color)
+	gate := h.cooldownGate(req.Color)
+	var paintTile paintFunc = h.rdb.PaintTileContext
+	if claimMode {
+		paintTile = h.rdb.PaintTileIfEmptyContext
+	}
+	queueKey := ""
+	if gate > 0 {
+		paintTile = h.cooldownPaint(coolKey, gate, claimMode)
+		queueKey = coolKey
+	}
+	seq, ts, prev, err := h.paint(ctx, req, queueKey, paintTile)
+	if err == errPaintQueued {
+		// The queued paint starts a cooldown once it lands
+		check := failed("cooldown", "a paint is already queued", 429, CodeCooldown, "cooling down")
+		check.retryAfter = gate
+		check.cooldown = gate
+		h.rejectPaint(w, logger, check)
+		return
+	}
+	if err == errCoolingDown {
+		remaining, rerr := h.rdb.CooldownRemainingContext(ctx, coolKey)
+		if rerr != nil || remaining <= 0 {
+			remaining = gate
+		}
+		check := failed("cooldown", fmt.Sprintf("%dms remaining in redis", remaining.Milliseconds()), 429, CodeCooldown, "cooling down")
+		check.retryAfter = remaining
+		check.cooldown = gate
+		h.rejectPaint(w, logger, check)
+		return
+	}
+	if err == redisclient.ErrColorNotAllowed {
+		h.metrics.PaintRejected("palette")
+		writeError(w, 403, CodeColorNotAllowed, "color not allowed")
+		return
+	}
+	if err == redisclient.ErrTileOccupied {
+		h.metrics.PaintRejected("occupied")
+		writeError(w, 409, CodeTileOccupied, "tile already painted")
+		return
+	}
+	if err == errPaintUnavailable {
+		h.metrics.PaintRejected("unavailable")
+		writeError(w, 503, CodeRedisUnavailable, "redis unavailable, try again")
+		return
+	}
 	if err != nil {
-		http.Error(w, "redis", 500)
+		h.redisError("paint", err, paintAttrs...)
+		writeError(w, 500, CodeRedis, "redis error")
 		return
 	}
+	h.metrics.PaintAccepted()
+	painted = true
+
+	// The paint has landed, so its bookkeeping finishes even if the client
+	// has gone
+	after, cancelAfter := h.detachedRedisContext(r)
+	defer cancelAfter()
+	if fingerprint != "" {
+		if err := h.rdb.RecordPaintContext(after, fingerprint, seq, ts, h.duplicateWindow()); err != nil {
+			h.redisError("duplicate", err, paintAttrs...)
+		}
+	}
+	if h.config.UndoWindowS > 0 {
+		if err := h.rdb.AllowUndoContext(after, ip, req.Cx, req.Cy, req.O, seq, time.Duration(h.config.UndoWindowS)*time.Second); err != nil {
+			h.redisError("undo", err, paintAttrs...)
+		}
+	}
 
-	// Cooldown disabled for development
-	// h.cooldownLimiter.SetCooldown(ip)
+	// Only successful paints start a cooldown
+	cooldown := h.cooldownFor(prev, req.Color)
+	if h.config.EnableStreak {
+		// Days are counted on the paint's own timestamp
+		if streak, err := h.rdb.TouchStreakContext(after, ip, ts/secondsPerDay); err != nil {
+			h.redisError("streak", err, paintAttrs...)
+		} else {
+			cooldown = h.applyStreak(cooldown, streak)
+			w.Header().Set("X-Streak", strconv.Itoa(streak))
+		}
+	}
+	h.cooldownLimiter.SetCooldownDuration(coolKey, cooldown)
+	if gate > 0 && cooldown != gate {
+		// The script started the longest cooldown the paint could earn
+		if err := h.rdb.SetCooldownContext(after, coolKey, cooldown); err != nil {
+			h.redisError("cooldown", err, paintAttrs...)
+		}
+	}
+	w.Header().Set("X-Cooldown-Ms", strconv.FormatInt(cooldown.Milliseconds(), 10))
 
 	// Broadcast delta
 	h.hub.Publish(req.Cx, req.Cy, ws.Delta{
-		Seq:   seq,
-		O:     uint16(req.O),
-		Color: req.Color,
-		Ts:    ts,
+		Seq:    seq,
+		O:      req.O,
+		Color:  req.Color,
+		Ts:     ts,
+		Origin: req.ClientID,
 	})
 
+	// Export for analytics; sinks never block
+	if h.events != nil {
+		h.events.Emit(events.PaintEvent{
+			Cx:       req.Cx,
+			Cy:       req.Cy,
+			O:        req.O,
+			Color:    req.Color,
+			Prev:     prev,
+			Seq:      seq,
+			Ts:       ts,
+			ClientID: req.ClientID,
+		})
+	}
+
 	// Return response
 	response := PaintResponse{
-		Ok:  true,
-		Seq: seq,
-		Ts:  ts,
+		Ok:      true,
+		Seq:     seq,
+		Ts:      ts,
+		WSToken: h.mintWSTokenFor(ip),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writePaintResponse(w, r, response)
+}
+
+// paintCooldown returns the regular cooldown after overwriting a tile
+func (h *Handler) paintCooldown() time.Duration {
+	return time.Duration(h.config.PaintCooldownMs) * time.Millisecond
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+// applyStreak shortens a cooldown by the streak's bonus
+func (h *Handler) applyStreak(cooldown time.Duration, streak int) time.Duration {
+	return cooldown * time.Duration(100-h.streakBonus(streak)) / 100
+}
+
+// streakBonus is the cooldown reduction in percent for a streak:
+// StreakBonusPct for each consecutive day after the first, capped at
+// StreakMaxBonusPct
+func (h *Handler) streakBonus(streak int) int {
+	return max(min((streak-1)*h.config.StreakBonusPct, h.config.StreakMaxBonusPct), 0)
+}
+
+// chunkMaxAge picks a jittered max-age in seconds for a chunk response
+func (h *Handler) chunkMaxAge() int {
+	maxAge := h.config.ChunkMaxAgeS
+	if jitter := h.config.ChunkMaxAgeJitterS; jitter > 0 {
+		maxAge += rand.Intn(2*jitter+1) - jitter
+	}
+	return max(maxAge, 0)
+}
+
+// cooldownFor returns the cooldown earned by painting color over the tile's
+// previous color. A color with its own cooldown always earns it; otherwise
+// blank tiles earn the warmup cooldown when enabled.
+func (h *Handler) cooldownFor(prev, color uint8) time.Duration {
+	if ms, ok := h.config.PaintCooldownByColor[color]; ok {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if h.config.EnableWarmupCooldown && prev == 0 {
+		return time.Duration(h.config.WarmupCooldownMs) * time.Millisecond
+	}
+	return h.paintCooldown()
+}
+
+// errCoolingDown is returned by a cooldownPaint refused because Redis
+// still has the subject cooling down, as when it painted through another
+// instance moments ago
+var errCoolingDown = errors.New("cooling down")
+
+// cooldownGate returns the longest cooldown a paint in color can earn,
+// which the paint script starts; the handler shortens it once the paint's
+// own is known. Zero means no cooldown applies.
+func (h *Handler) cooldownGate(color uint8) time.Duration {
+	return max(h.cooldownFor(0, color), h.cooldownFor(1, color))
+}
+
+// cooldownPaint returns a paintFunc that paints only if key isn't cooling
+// down in Redis, starting its cooldown in the same script, and fails with
+// errCoolingDown if it is
+func (h *Handler) cooldownPaint(key string, cooldown time.Duration, ifEmpty bool) paintFunc {
+	paint := h.rdb.PaintTileWithCooldownContext
+	if ifEmpty {
+		paint = h.rdb.PaintTileIfEmptyWithCooldownContext
+	}
+	return func(ctx context.Context, cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error) {
+		seq, ts, prev, cooled, err := paint(ctx, cx, cy, offset, color, key, cooldown.Milliseconds())
+		if err == nil && cooled {
+			return 0, ts, 0, errCoolingDown
+		}
+		return seq, ts, prev, err
+	}
+}
+
+// cooldownKey is the limiter key a subject's paints in color cool down
+// under. Colors with their own cooldown each get a key; the rest share the
+// subject's.
+func (h *Handler) cooldownKey(subject string, color uint8) string {
+	if _, ok := h.config.PaintCooldownByColor[color]; ok {
+		return fmt.Sprintf("%s#color%d", subject, color)
+	}
+	return subject
+}
+
+// paintFingerprint identifies a subject's paint for duplicate detection
+func paintFingerprint(subject string, req PaintRequest) string {
+	return fmt.Sprintf("%s:%d:%d:%d:%d", subject, req.Cx, req.Cy, req.O, req.Color)
+}
+
+// redisContext bounds a request's Redis calls by RedisTimeoutMs, and ends
+// them if the client goes away
+func (h *Handler) redisContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return h.redisTimeout(r.Context())
 }
 
-// HandleWebSocket handles WebSocket connections for /sub?cx=&cy=
+// detachedRedisContext is redisContext for calls that must run even if the
+// client has gone, such as releasing a paint's duplicate claim
+func (h *Handler) detachedRedisContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return h.redisTimeout(context.WithoutCancel(r.Context()))
+}
+
+func (h *Handler) redisTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if h.config.RedisTimeoutMs <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, time.Duration(h.config.RedisTimeoutMs)*time.Millisecond)
+}
+
+// redisError counts and logs a failed Redis call. attrs are added to the
+// log line, such as the paint's.
+func (h *Handler) redisError(op string, err error, attrs ...any) {
+	h.metrics.RedisError(op)
+	h.logger.Error("api: redis error", append([]any{"op", op, "err", err}, attrs...)...)
+}
+
+// duplicateWindow returns how long an identical paint counts as a duplicate
+func (h *Handler) duplicateWindow() time.Duration {
+	return time.Duration(h.config.DuplicateWindowMs) * time.Millisecond
+}
+
+// HandleWebSocket handles WebSocket connections for /sub?cx=&cy=. Without
+// cx/cy the connection starts unsubscribed and joins chunks with
+// {"op":"sub","cx":..,"cy":..} messages.
 func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
-	cxStr := r.URL.Query().Get("cx")
-	cyStr := r.URL.Query().Get("cy")
+	// Parse query parameters; cx and cy come as a pair or not at all
+	query := r.URL.Query()
+	initialRoom := query.Get("cx") != "" || query.Get("cy") != ""
 
-	if cxStr == "" || cyStr == "" {
-		http.Error(w, "Missing cx or cy parameter", 400)
-		return
+	var cx, cy int64
+	if initialRoom {
+		var perr *ErrorDetail
+		if cx, perr = parseInt64Param(query, "cx"); perr != nil {
+			h.rejectParam(w, perr)
+			return
+		}
+		if cy, perr = parseInt64Param(query, "cy"); perr != nil {
+			h.rejectParam(w, perr)
+			return
+		}
+		if !h.world.Contains(cx, cy) {
+			writeError(w, 400, CodeInvalidChunk, "chunk out of range")
+			return
+		}
 	}
 
-	cx, err := strconv.ParseInt(cxStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid cx parameter", 400)
+	// Optional echo suppression: a client that already applied its own
+	// edits optimistically can skip the deltas it caused
+	opts := ws.ConnOptions{
+		Subject:  h.clientIP(r),
+		ClientID: query.Get("clientId"),
+	}
+	var err error
+	if s := query.Get("suppressEcho"); s != "" {
+		opts.SuppressEcho, err = strconv.ParseBool(s)
+		if err != nil {
+			h.rejectParam(w, invalidParam("suppressEcho", "must be a boolean"))
+			return
+		}
+	}
+
+	// Optional snapshot on join, so the client needn't race a separate
+	// /state/chunk fetch against incoming deltas
+	if s := query.Get("snapshot"); s != "" {
+		opts.Snapshot, err = strconv.ParseBool(s)
+		if err != nil {
+			h.rejectParam(w, invalidParam("snapshot", "must be a boolean"))
+			return
+		}
+	}
+
+	// Optional binary deltas, under half the size of JSON ones
+	switch enc := query.Get("enc"); enc {
+	case "", "json":
+	case "bin":
+		opts.BinaryDeltas = true
+	default:
+		h.rejectParam(w, invalidParam("enc", fmt.Sprintf("%q is not json or bin", enc)))
 		return
 	}
 
-	cy, err := strconv.ParseInt(cyStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid cy parameter", 400)
+	// Optional catch-up: a reconnecting client replays what it missed on
+	// the initial chunk since the last seq it saw
+	if s := query.Get("sinceSeq"); s != "" {
+		if !initialRoom {
+			h.rejectParam(w, invalidParam("sinceSeq", "requires cx and cy"))
+			return
+		}
+		opts.SinceSeq, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			h.rejectParam(w, invalidParam("sinceSeq", fmt.Sprintf("%q is not a seq", s)))
+			return
+		}
+		opts.Resume = true
+	}
+
+	responseHeader, ok := h.checkWSToken(w, r)
+	if !ok {
 		return
 	}
 
 	// Upgrade connection
-	ws, err := h.upgrader.Upgrade(w, r, nil)
+	wsConn, err := h.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		return
 	}
 
 	// Register connection
-	conn := h.hub.RegisterConn(ws, cx, cy)
+	var conn *ws.Conn
+	if initialRoom {
+		conn = h.hub.RegisterConnWithOptions(wsConn, cx, cy, opts)
+	} else {
+		conn = h.hub.Connect(wsConn, opts)
+	}
 
 	// Start pumps
 	go conn.WritePump()
 	go conn.ReadPump()
 }
 
-func getIP(r *http.Request) string {
-	// Check for Cloudflare headers
-	if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
-		return ip
-	}
-
-	// Check for X-Forwarded-For
-	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-		return ip
-	}
-
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+// GetHubDebug handles GET /debug/hub with per-room delivery health
+func (h *Handler) GetHubDebug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rooms": h.hub.DebugStats(),
+	})
 }
@@ -1,11 +1,38 @@
 package api
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+
+	"splat-boston/internal/bits"
+	"splat-boston/internal/events"
+	"splat-boston/internal/geo"
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/turnstile"
+	"splat-boston/internal/ws"
 )
 
 // Basic API handler tests
-// Note: Full integration tests with Redis are in internal/integration/
+// Handlers run against an in-process miniredis; full integration tests are
+// in internal/integration/
 
 func TestPlaceholder(t *testing.T) {
 	// Placeholder test for API package
@@ -13,5 +40,1585 @@ func TestPlaceholder(t *testing.T) {
 	// and internal/redis/ packages
 }
 
-// Note: Comprehensive handler tests require Redis and are in internal/integration/
-// These basic tests are just placeholders to show the structure
+// testConfig returns a config suitable for handler tests
+func testConfig() Config {
+	return Config{
+		GeofenceRadiusM: 300,
+		SpeedMaxKmh:     150,
+		PaintCooldownMs: 5000,
+		WSWriteBuffer:   4096,
+		WSPingIntervalS: 20,
+		// bostonPaint varies the offset without moving
+		TrustClientCoords: true,
+		// and paints chunk (0, 0), far outside Boston's
+		WorldBounds: &ws.ChunkBounds{MaxCx: geo.DefaultProjection.MaxChunk(), MaxCy: geo.DefaultProjection.MaxChunk()},
+		// httptest requests' and loopback peers, so tests can name the
+		// client with CF-Connecting-IP
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+	}
+}
+
+// newTestHandler creates a handler backed by miniredis and a running hub
+func newTestHandler(t *testing.T, config Config) (*Handler, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+
+	hub := ws.NewHub()
+	go hub.Run()
+
+	h := NewHandler(rdb, hub, config, nil)
+	t.Cleanup(h.Close)
+	return h, mr
+}
+
+// bostonPaint returns a valid paint request inside the Boston geofence
+func bostonPaint(o int, color uint8) PaintRequest {
+	return PaintRequest{
+		Lat:   42.3601,
+		Lon:   -71.0589,
+		Cx:    0,
+		Cy:    0,
+		O:     o,
+		Color: color,
+	}
+}
+
+// paintAt returns a paint at lat/lon that declares the tile there, as a
+// client does
+func paintAt(lat, lon float64, color uint8) PaintRequest {
+	x, y := geo.LatLonToTileXY(lat, lon)
+	cx, cy := geo.ChunkOf(x, y)
+	return PaintRequest{Lat: lat, Lon: lon, Cx: cx, Cy: cy, O: geo.OffsetOf(x, y), Color: color}
+}
+
+// postPaint sends a JSON paint request from the given IP
+func postPaint(h *Handler, req PaintRequest, ip string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("CF-Connecting-IP", ip)
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+	return w
+}
+
+func TestPostPaintJSON(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	w := postPaint(h, bostonPaint(0, 5), "10.0.0.1")
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("Expected JSON response by default, got %q", ct)
+	}
+
+	var resp PaintResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if !resp.Ok || resp.Seq != 1 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestPostPaintStrictJSONRejectsUnknownFields(t *testing.T) {
+	// A typo'd field alongside a well-formed paint
+	body := `{"lat":42.3601,"lon":-71.0589,"cx":0,"cy":0,"o":0,"color":5,"colour":5}`
+	send := func(h *Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/paint", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("CF-Connecting-IP", "10.0.0.1")
+		w := httptest.NewRecorder()
+		h.PostPaint(w, r)
+		return w
+	}
+
+	lenient, _ := newTestHandler(t, testConfig())
+	if w := send(lenient); w.Code != 200 {
+		t.Errorf("Expected lenient mode to ignore the field, got %d: %s", w.Code, w.Body.String())
+	}
+
+	config := testConfig()
+	config.StrictPaintJSON = true
+	strict, _ := newTestHandler(t, config)
+	w := send(strict)
+	if w.Code != 400 {
+		t.Fatalf("Expected strict mode to reject the field, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != CodeUnknownField || resp.Error.Param != "colour" {
+		t.Errorf("Expected %s naming colour, got %+v", CodeUnknownField, resp.Error)
+	}
+
+	// Known fields still paint in strict mode
+	if w := postPaint(strict, bostonPaint(1, 5), "10.0.0.2"); w.Code != 200 {
+		t.Errorf("Expected a well-formed paint to pass strict mode, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintRejectsColorOutsideRegionPalette(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	if err := h.rdb.SetRegionPalette(0, 0, []uint8{2}); err != nil {
+		t.Fatalf("SetRegionPalette failed: %v", err)
+	}
+
+	if w := postPaint(h, bostonPaint(0, 3), "10.0.0.1"); w.Code != 403 {
+		t.Errorf("Expected 403 for disallowed color, got %d", w.Code)
+	}
+	if w := postPaint(h, bostonPaint(0, 2), "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected 200 for allowed color, got %d", w.Code)
+	}
+}
+
+func TestPostPaintAppliesCooldown(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	if w := postPaint(h, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("First paint should succeed, got %d", w.Code)
+	}
+	if w := postPaint(h, bostonPaint(1, 1), "10.0.0.1"); w.Code != 429 {
+		t.Errorf("Second paint should hit cooldown, got %d", w.Code)
+	}
+	if w := postPaint(h, bostonPaint(1, 1), "10.0.0.2"); w.Code != 200 {
+		t.Errorf("Another IP should not be in cooldown, got %d", w.Code)
+	}
+}
+
+func TestPostPaintCooldownHoldsAcrossInstances(t *testing.T) {
+	config := testConfig()
+	config.EnableWarmupCooldown = true
+	config.WarmupCooldownMs = 500
+	a, mr := newTestHandler(t, config)
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	b := NewHandler(rdb, a.hub, config, nil)
+	t.Cleanup(b.Close)
+
+	// A blank tile earns the warmup cooldown, in Redis as well as locally
+	if w := postPaint(a, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("First paint should succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if ttl := mr.TTL("cool:10.0.0.1"); ttl <= 0 || ttl > 500*time.Millisecond {
+		t.Errorf("Expected the Redis cooldown cut to the 500ms warmup, got %v", ttl)
+	}
+
+	// The other instance's own limiter knows nothing of it, but Redis does
+	w := postPaint(b, bostonPaint(1, 1), "10.0.0.1")
+	if w.Code != 429 || !strings.Contains(w.Body.String(), CodeCooldown) || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 %s with Retry-After 1 from the other instance, got %d %q: %s", CodeCooldown, w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if seq, _ := a.rdb.GetChunkSeq(0, 0); seq != 1 {
+		t.Errorf("Expected the refused paint not to land, seq is %d", seq)
+	}
+
+	mr.FastForward(time.Second)
+	if w := postPaint(b, bostonPaint(1, 1), "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected a paint once the cooldown ran out, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintLogsRejections(t *testing.T) {
+	h, mr := newTestHandler(t, testConfig())
+	var buf bytes.Buffer
+	h.logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	postPaint(h, bostonPaint(0, 1), "10.0.0.1")
+	if w := postPaint(h, bostonPaint(1, 1), "10.0.0.1"); w.Code != 429 {
+		t.Fatalf("Second paint should hit cooldown, got %d", w.Code)
+	}
+	line := buf.String()
+	for _, want := range []string{`msg="api: paint rejected"`, "check=cooldown", "code=COOLDOWN", "reason=", "ip=10.0.0.1", "cx=0", "cy=0"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in the log, got %q", want, line)
+		}
+	}
+
+	// At info, rejections and failing health checks stay out of the log
+	buf.Reset()
+	h.logger = slog.New(slog.NewTextHandler(&buf, nil))
+	postPaint(h, bostonPaint(1, 1), "10.0.0.1")
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	for i := 0; i < 3; i++ {
+		h.Healthz(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing logged at info, got %q", buf.String())
+	}
+
+	// Redis errors are logged
+	postPaint(h, bostonPaint(2, 1), "10.0.0.2")
+	if line := buf.String(); !strings.Contains(line, "level=ERROR") || !strings.Contains(line, "op=paint") || !strings.Contains(line, "ip=10.0.0.2") {
+		t.Errorf("Expected the failed paint logged as a Redis error, got %q", line)
+	}
+}
+
+func TestPostPaintWarmupCooldownForBlankTiles(t *testing.T) {
+	config := testConfig()
+	config.PaintCooldownMs = 5000
+	config.EnableWarmupCooldown = true
+	config.WarmupCooldownMs = 500
+	h, _ := newTestHandler(t, config)
+
+	// Painting a blank tile earns the short warmup cooldown
+	w := postPaint(h, bostonPaint(42, 3), "10.0.0.1")
+	if w.Code != 200 {
+		t.Fatalf("Paint on blank tile failed: %d", w.Code)
+	}
+	if got := w.Header().Get("X-Cooldown-Ms"); got != "500" {
+		t.Errorf("Expected X-Cooldown-Ms 500 for blank tile, got %q", got)
+	}
+	blank := h.cooldownLimiter.GetCooldownRemaining("10.0.0.1", h.paintCooldown())
+
+	// Overwriting that tile earns the full cooldown
+	w = postPaint(h, bostonPaint(42, 7), "10.0.0.2")
+	if w.Code != 200 {
+		t.Fatalf("Overwrite failed: %d", w.Code)
+	}
+	if got := w.Header().Get("X-Cooldown-Ms"); got != "5000" {
+		t.Errorf("Expected X-Cooldown-Ms 5000 for overwrite, got %q", got)
+	}
+	overwrite := h.cooldownLimiter.GetCooldownRemaining("10.0.0.2", h.paintCooldown())
+
+	if blank >= overwrite {
+		t.Errorf("Blank tile cooldown %v should be shorter than overwrite cooldown %v", blank, overwrite)
+	}
+	if blank > 500*time.Millisecond {
+		t.Errorf("Blank tile cooldown %v exceeds the warmup cooldown", blank)
+	}
+}
+
+func postTiles(h *Handler, req TilesRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/state/tiles", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.PostTiles(w, r)
+	return w
+}
+
+func TestPostTilesAcrossChunks(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	painted := []struct {
+		cx, cy int64
+		o      int
+		color  uint8
+	}{
+		{0, 0, 10, 3},
+		{0, 0, 11, 12},
+		{1, 2, 500, 7},
+	}
+	for _, p := range painted {
+		if _, _, _, err := h.rdb.PaintTile(p.cx, p.cy, p.o, p.color); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+
+	w := postTiles(h, TilesRequest{Tiles: []TileRef{
+		{Cx: 0, Cy: 0, O: 10},
+		{Cx: 0, Cy: 0, O: 11},
+		{Cx: 0, Cy: 0, O: 12}, // unpainted tile in a painted chunk
+		{Cx: 1, Cy: 2, O: 500},
+		{Cx: 5, Cy: 5, O: 65535}, // unpainted chunk
+	}})
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp TilesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := []int{3, 12, 0, 7, 0}
+	if len(resp.Colors) != len(want) {
+		t.Fatalf("Expected %d colors, got %d", len(want), len(resp.Colors))
+	}
+	for i := range want {
+		if resp.Colors[i] != want[i] {
+			t.Errorf("Tile %d: expected color %d, got %d", i, want[i], resp.Colors[i])
+		}
+	}
+}
+
+func TestPostTilesBoundsListSize(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	tiles := make([]TileRef, maxTilesPerRequest+1)
+	if w := postTiles(h, TilesRequest{Tiles: tiles}); w.Code != 400 {
+		t.Errorf("Expected 400 for oversized list, got %d", w.Code)
+	}
+	if w := postTiles(h, TilesRequest{Tiles: []TileRef{{O: 65536}}}); w.Code != 400 {
+		t.Errorf("Expected 400 for out-of-range offset, got %d", w.Code)
+	}
+}
+
+func TestPostPaintSpeedLimitCanBeDisabled(t *testing.T) {
+	// Boston to Cape Ann (~50km) within milliseconds
+	far := bostonPaint(1, 4)
+	far.Lat, far.Lon = 42.6526, -70.6206
+
+	for _, enabled := range []bool{false, true} {
+		config := testConfig()
+		config.PaintCooldownMs = 0
+		config.EnableSpeedLimit = enabled
+		h, _ := newTestHandler(t, config)
+
+		if enabled == (h.speedLimiter == nil) {
+			t.Errorf("enabled=%v: speed limiter allocated=%v", enabled, h.speedLimiter != nil)
+		}
+
+		if w := postPaint(h, bostonPaint(0, 3), "10.0.0.1"); w.Code != 200 {
+			t.Fatalf("enabled=%v: first paint failed: %d", enabled, w.Code)
+		}
+
+		want := 200
+		if enabled {
+			want = 403
+		}
+		if w := postPaint(h, far, "10.0.0.1"); w.Code != want {
+			t.Errorf("enabled=%v: expected %d for distant paint, got %d", enabled, want, w.Code)
+		}
+	}
+}
+
+func TestPostPaintRequiresSubscription(t *testing.T) {
+	config := testConfig()
+	config.RequireSubscription = true
+	h, _ := newTestHandler(t, config)
+
+	req := bostonPaint(0, 3)
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without a subscription, got %d", w.Code)
+	}
+
+	// Another subject's subscription doesn't count
+	h.hub.RegisterConnWithOptions(nil, req.Cx, req.Cy, ws.ConnOptions{Subject: "10.0.0.2"})
+	h.hub.RegisterConnWithOptions(nil, req.Cx, req.Cy, ws.ConnOptions{Subject: "10.0.0.1"})
+	deadline := time.Now().Add(time.Second)
+	for !h.hub.IsSubscribed("10.0.0.1", req.Cx, req.Cy) {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if h.hub.IsSubscribed("10.0.0.1", req.Cx+1, req.Cy) {
+		t.Error("subscription leaked to a neighbouring chunk")
+	}
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 once subscribed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := postPaint(h, bostonPaint(1, 3), "10.0.0.3"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an unsubscribed subject, got %d", w.Code)
+	}
+}
+
+func TestPostPaintChecksNetHint(t *testing.T) {
+	config := testConfig()
+	config.NetHintMaxKm = 50
+	h, _ := newTestHandler(t, config)
+
+	// Cambridge is a few km from the GPS fix; New York is ~300 km away
+	nearLat, nearLon := 42.3736, -71.1097
+	farLat, farLon := 40.7128, -74.0060
+
+	agreeing := bostonPaint(0, 3)
+	agreeing.NetLat, agreeing.NetLon = &nearLat, &nearLon
+	if w := postPaint(h, agreeing, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for an agreeing hint, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Not enforced: the paint goes through but is flagged
+	disagreeing := bostonPaint(1, 3)
+	disagreeing.NetLat, disagreeing.NetLon = &farLat, &farLon
+	if w := postPaint(h, disagreeing, "10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a flagged paint, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := scrape(t, h); !strings.Contains(got, `splat_paints_flagged_total{check="nethint"} 1`) {
+		t.Errorf("expected one flagged paint, got:\n%s", got)
+	}
+
+	config.NetHintEnforce = true
+	enforcing, _ := newTestHandler(t, config)
+	if w := postPaint(enforcing, disagreeing, "10.0.0.3"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a disagreeing hint, got %d", w.Code)
+	}
+	if w := postPaint(enforcing, bostonPaint(2, 3), "10.0.0.4"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 without a hint, got %d", w.Code)
+	}
+}
+
+func TestPostPaintRejectsSpoofedCoords(t *testing.T) {
+	config := testConfig()
+	config.TrustClientCoords = false
+	h, _ := newTestHandler(t, config)
+
+	// A real Boston location aimed at a chunk on the other side of the world
+	req := bostonPaint(0, 3)
+	x, y := geo.LatLonToTileXY(req.Lat, req.Lon)
+	req.Cx, req.Cy = 1, 1
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for spoofed chunk, got %d", w.Code)
+	}
+
+	// Right chunk, wrong tile
+	req.Cx, req.Cy = geo.ChunkOf(x, y)
+	req.O = (geo.OffsetOf(x, y) + 1) % (chunkWidth * chunkWidth)
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for spoofed offset, got %d", w.Code)
+	}
+
+	req.O = geo.OffsetOf(x, y)
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for matching coords, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintCoordsTolerance(t *testing.T) {
+	config := testConfig()
+	config.TrustClientCoords = false
+	config.CoordsToleranceTiles = 1
+	h, _ := newTestHandler(t, config)
+
+	// aimAt declares the tile dx, dy tiles from the one at the location
+	aimAt := func(dx, dy int64) PaintRequest {
+		req := bostonPaint(0, 3)
+		x, y := geo.LatLonToTileXY(req.Lat, req.Lon)
+		req.Cx, req.Cy = geo.ChunkOf(x+dx, y+dy)
+		req.O = geo.OffsetOf(x+dx, y+dy)
+		return req
+	}
+
+	// A neighboring tile, even across a chunk boundary, is GPS error
+	for i, d := range [][2]int64{{1, 0}, {-1, 1}, {0, -1}} {
+		ip := fmt.Sprintf("10.0.0.%d", i+1)
+		if w := postPaint(h, aimAt(d[0], d[1]), ip); w.Code != http.StatusOK {
+			t.Errorf("expected 200 for a tile (%d, %d) away, got %d: %s", d[0], d[1], w.Code, w.Body.String())
+		}
+	}
+
+	for i, d := range [][2]int64{{2, 0}, {0, 5}, {-5, -5}} {
+		ip := fmt.Sprintf("10.0.1.%d", i+1)
+		w := postPaint(h, aimAt(d[0], d[1]), ip)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodeCoordsMismatch) {
+			t.Errorf("expected 400 %s for a tile (%d, %d) away, got %d: %s", CodeCoordsMismatch, d[0], d[1], w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPostPaintSendsDebouncedInvalidation(t *testing.T) {
+	var mu sync.Mutex
+	var notices [][]events.Invalidation
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []events.Invalidation
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		notices = append(notices, batch)
+		mu.Unlock()
+	}))
+	defer cdn.Close()
+
+	config := testConfig()
+	config.PaintCooldownMs = 0
+	config.CDNInvalidateURL = cdn.URL
+	config.CDNInvalidateDebounceMs = 200
+	h, _ := newTestHandler(t, config)
+
+	var lastSeq uint64
+	for o := 0; o < 3; o++ {
+		w := postPaint(h, bostonPaint(o, 3), "10.0.0.1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("paint %d failed: %d", o, w.Code)
+		}
+		var resp PaintResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		lastSeq = resp.Seq
+	}
+
+	// Close flushes the open window
+	h.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notices) != 1 || len(notices[0]) != 1 {
+		t.Fatalf("expected one notice for one chunk, got %v", notices)
+	}
+	if got := notices[0][0]; got != (events.Invalidation{Cx: 0, Cy: 0, Seq: lastSeq}) {
+		t.Errorf("expected chunk (0, 0) at seq %d, got %+v", lastSeq, got)
+	}
+}
+
+func TestPostPaintDuplicateCountsOnce(t *testing.T) {
+	config := testConfig()
+	config.DuplicateWindowMs = 2000
+	h, _ := newTestHandler(t, config)
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	sub, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=0&cy=0", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer sub.Close()
+	for deadline := time.Now().Add(time.Second); h.hub.GetSubscriberCount("0:0") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A double click: the same paint twice in quick succession
+	first := postPaint(h, bostonPaint(7, 3), "10.0.0.1")
+	second := postPaint(h, bostonPaint(7, 3), "10.0.0.1")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("expected both to succeed, got %d and %d", first.Code, second.Code)
+	}
+	var a, b PaintResponse
+	json.NewDecoder(first.Body).Decode(&a)
+	json.NewDecoder(second.Body).Decode(&b)
+	if a.Seq != b.Seq || second.Header().Get("X-Duplicate") != "1" {
+		t.Errorf("expected the duplicate to echo seq %d, got %d (X-Duplicate %q)", a.Seq, b.Seq, second.Header().Get("X-Duplicate"))
+	}
+	if seq, _ := h.rdb.GetChunkSeq(0, 0); seq != 1 {
+		t.Errorf("expected one paint in Redis, chunk seq is %d", seq)
+	}
+
+	// One delta, not two
+	sub.SetReadDeadline(time.Now().Add(time.Second))
+	var delta ws.Delta
+	if err := sub.ReadJSON(&delta); err != nil || delta.Seq != a.Seq {
+		t.Fatalf("expected delta seq %d, got %+v (%v)", a.Seq, delta, err)
+	}
+	sub.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err := sub.ReadJSON(&delta); err == nil {
+		t.Errorf("expected no second delta, got %+v", delta)
+	}
+
+	// The one cooldown still applies to a different paint
+	if w := postPaint(h, bostonPaint(8, 3), "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for a new paint during the cooldown, got %d", w.Code)
+	}
+	// The cooldown wasn't restarted by the duplicate either
+	if got := second.Header().Get("X-Cooldown-Ms"); got != "" {
+		t.Errorf("expected no cooldown from the duplicate, got X-Cooldown-Ms %s", got)
+	}
+}
+
+func TestGetChunkJittersMaxAge(t *testing.T) {
+	config := testConfig()
+	config.ChunkMaxAgeS = 5
+	config.ChunkMaxAgeJitterS = 2
+	h, _ := newTestHandler(t, config)
+
+	seen := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0", nil)
+		w := httptest.NewRecorder()
+		h.GetChunk(w, r)
+		if w.Code != 200 {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		var maxAge int
+		if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "public, max-age=%d,", &maxAge); err != nil {
+			t.Fatalf("Unexpected Cache-Control %q: %v", w.Header().Get("Cache-Control"), err)
+		}
+		if maxAge < 3 || maxAge > 7 {
+			t.Errorf("max-age %d outside 5±2", maxAge)
+		}
+		seen[maxAge] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("Expected max-age to vary across requests, only saw %v", seen)
+	}
+}
+
+func TestPostPaintStreakReducesCooldown(t *testing.T) {
+	config := testConfig()
+	config.PaintCooldownMs = 10000
+	config.EnableStreak = true
+	config.StreakBonusPct = 10
+	config.StreakMaxBonusPct = 50
+	h, _ := newTestHandler(t, config)
+
+	// 10.0.0.1 painted the two previous days; 10.0.0.2 is new
+	today := time.Now().Unix() / secondsPerDay
+	h.rdb.TouchStreak("10.0.0.1", today-2)
+	h.rdb.TouchStreak("10.0.0.1", today-1)
+
+	w := postPaint(h, bostonPaint(0, 1), "10.0.0.1")
+	if w.Code != 200 {
+		t.Fatalf("Paint failed: %d", w.Code)
+	}
+	if got := w.Header().Get("X-Streak"); got != "3" {
+		t.Errorf("Expected X-Streak 3, got %q", got)
+	}
+	// Two days past the first earn 2 x 10%
+	if got := w.Header().Get("X-Cooldown-Ms"); got != "8000" {
+		t.Errorf("Expected X-Cooldown-Ms 8000, got %q", got)
+	}
+
+	w = postPaint(h, bostonPaint(1, 1), "10.0.0.2")
+	if got := w.Header().Get("X-Streak"); got != "1" {
+		t.Errorf("Expected X-Streak 1 for a new painter, got %q", got)
+	}
+	if got := w.Header().Get("X-Cooldown-Ms"); got != "10000" {
+		t.Errorf("Expected full cooldown for a new painter, got %q", got)
+	}
+
+	// The bonus is capped
+	if got := h.applyStreak(10*time.Second, 30); got != 5*time.Second {
+		t.Errorf("Expected bonus capped at 50%%, got %v", got)
+	}
+}
+
+func TestPostPaintCooldownRetryAfter(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig()) // 5s cooldown
+
+	if w := postPaint(h, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("First paint should succeed, got %d", w.Code)
+	}
+
+	w := postPaint(h, bostonPaint(1, 1), "10.0.0.1")
+	if w.Code != 429 {
+		t.Fatalf("Expected 429, got %d", w.Code)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 5 {
+		t.Errorf("Expected Retry-After within 1-5s, got %q", w.Header().Get("Retry-After"))
+	}
+
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected JSON body: %v", err)
+	}
+	if body.Ok || body.Error.Code != CodeCooldown {
+		t.Errorf("Unexpected body %+v", body)
+	}
+	if body.RetryAfterMs <= 4000 || body.RetryAfterMs > 5000 {
+		t.Errorf("Expected retryAfterMs just under 5000, got %d", body.RetryAfterMs)
+	}
+}
+
+func TestPostPaintPerColorCooldown(t *testing.T) {
+	config := testConfig() // 5s cooldown
+	config.PaintCooldownByColor = map[uint8]int{15: 60000}
+	h, _ := newTestHandler(t, config)
+
+	// A cheap color, then the expensive one straight after
+	if w := postPaint(h, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("Cheap paint should succeed, got %d", w.Code)
+	}
+	w := postPaint(h, bostonPaint(1, 15), "10.0.0.1")
+	if w.Code != 200 {
+		t.Fatalf("Expensive paint should not wait on the cheap color's cooldown, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Cooldown-Ms"); got != "60000" {
+		t.Errorf("Expected X-Cooldown-Ms 60000 for color 15, got %q", got)
+	}
+
+	// The expensive color is now in its longer cooldown
+	w = postPaint(h, bostonPaint(2, 15), "10.0.0.1")
+	if w.Code != 429 {
+		t.Fatalf("Expected 429 repainting color 15, got %d", w.Code)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected JSON body: %v", err)
+	}
+	if body.CooldownMs != 60000 {
+		t.Errorf("Expected cooldownMs 60000 in the 429, got %d", body.CooldownMs)
+	}
+	if body.RetryAfterMs <= 5000 || body.RetryAfterMs > 60000 {
+		t.Errorf("Expected retryAfterMs past the 5s default, got %d", body.RetryAfterMs)
+	}
+
+	// Other colors still share the default cooldown
+	w = postPaint(h, bostonPaint(3, 2), "10.0.0.1")
+	if w.Code != 429 {
+		t.Fatalf("Expected color 2 to share color 1's cooldown, got %d", w.Code)
+	}
+	body = ErrorResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.CooldownMs != 5000 {
+		t.Errorf("Expected cooldownMs 5000 for the shared cooldown, got %d (%v)", body.CooldownMs, err)
+	}
+}
+
+func TestPostPaintValidationFailureDoesNotStartCooldown(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	if w := postPaint(h, bostonPaint(0, 16), "10.0.0.1"); w.Code != 400 {
+		t.Fatalf("Expected 400 for invalid color, got %d", w.Code)
+	}
+
+	outside := bostonPaint(0, 1)
+	outside.Lat = 10
+	if w := postPaint(h, outside, "10.0.0.1"); w.Code != 403 {
+		t.Fatalf("Expected 403 outside the geofence, got %d", w.Code)
+	}
+
+	if w := postPaint(h, bostonPaint(0, 1), "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Rejected paints must not start a cooldown, got %d", w.Code)
+	}
+}
+
+func TestPostPaintGeofenceErrorIsJSON(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	outside := bostonPaint(0, 1)
+	outside.Lat = 10
+	w := postPaint(h, outside, "10.0.0.1")
+	if w.Code != 403 {
+		t.Fatalf("Expected 403 outside the geofence, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("Expected Content-Type %s, got %q", contentTypeJSON, ct)
+	}
+
+	// Exactly {"ok":false,"error":{"code":...,"message":...}}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %q: %v", w.Body.String(), err)
+	}
+	if len(body) != 2 || string(body["ok"]) != "false" {
+		t.Errorf("Unexpected body %s", w.Body.String())
+	}
+	var detail map[string]string
+	if err := json.Unmarshal(body["error"], &detail); err != nil {
+		t.Fatalf("Expected an error object, got %s", body["error"])
+	}
+	if len(detail) != 2 || detail["code"] != CodeGeofence || detail["message"] == "" {
+		t.Errorf("Unexpected error %v", detail)
+	}
+}
+
+func TestPostPaintMaskReplacesGeofenceBox(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	// Providence is south of the built-in box but inside this mask
+	mask, err := geo.NewCircleMask(41.824, -71.4128, 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.mask = mask
+
+	providence := paintAt(41.824, -71.4128, 1)
+	if w := postPaint(h, providence, "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("Expected a paint inside the mask to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Boston is inside the box but not the mask
+	w := postPaint(h, paintAt(42.3601, -71.0589, 1), "10.0.0.2")
+	if w.Code != 403 || !strings.Contains(w.Body.String(), CodeOutsideMask) {
+		t.Errorf("Expected 403 %s outside the mask, got %d: %s", CodeOutsideMask, w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintMultiMaskAllowsEachRegion(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	boston, err := geo.NewCircleMask(42.3601, -71.0589, 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providence, err := geo.NewCircleMask(41.824, -71.4128, 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.mask, err = geo.NewMultiMask(boston, providence)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, place := range []struct {
+		name     string
+		lat, lon float64
+		status   int
+	}{
+		{"Boston", 42.3601, -71.0589, 200},
+		{"Providence", 41.824, -71.4128, 200},
+		{"Worcester", 42.2626, -71.8023, 403},
+	} {
+		paint := paintAt(place.lat, place.lon, 1)
+		if w := postPaint(h, paint, fmt.Sprintf("10.0.0.%d", i+1)); w.Code != place.status {
+			t.Errorf("%s: expected %d, got %d: %s", place.name, place.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGetChunkByteRange(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+	for _, o := range []int{10, 11, 300} {
+		if w := postPaint(h, bostonPaint(o, uint8(o%15+1)), fmt.Sprintf("10.0.0.%d", o%250)); w.Code != 200 {
+			t.Fatalf("paint %d: status %d: %s", o, w.Code, w.Body.String())
+		}
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0&"+query, nil))
+		return w
+	}
+
+	// Bytes 5-150 hold tiles 10 through 301
+	w := get("from=5&to=150")
+	if w.Code != 206 {
+		t.Fatalf("Expected 206, got %d: %s", w.Code, w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 5-150/32768" {
+		t.Errorf("Content-Range = %q", cr)
+	}
+	if seq := w.Header().Get("X-Seq"); seq != "3" {
+		t.Errorf("Expected the whole chunk's seq 3, got %q", seq)
+	}
+	body := w.Body.Bytes()
+	if len(body) != 146 || bits.GetNibble(body, 0) != 11 || bits.GetNibble(body, 1) != 12 || bits.GetNibble(body, 290) != 1 {
+		t.Errorf("Expected tiles 10, 11 and 300 in %d bytes, got % x", len(body), body[:2])
+	}
+
+	for _, query := range []string{"from=0&to=32768", "from=-1&to=4", "from=9&to=8", "from=4", "from=0&to=1&border=1"} {
+		if w := get(query); w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestGetChunkRLE(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	get := func(query string) ChunkRLE {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?"+query, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != contentTypeJSON {
+			t.Errorf("%s: expected JSON, got %q", query, ct)
+		}
+		var body ChunkRLE
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: bad JSON body: %v", query, err)
+		}
+		return body
+	}
+
+	// Never painted: one blank run
+	blank := get("cx=0&cy=0&format=rle")
+	if blank.Width != 256 || len(blank.Runs) != 1 || blank.Runs[0] != [2]int{0, 65536} {
+		t.Errorf("Expected a single blank run, got %+v", blank)
+	}
+
+	for _, o := range []int{10, 11, 300} {
+		if _, _, _, err := h.rdb.PaintTile(0, 0, o, 4); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+	painted := get("cx=0&cy=0&format=rle")
+	want := [][2]int{{0, 10}, {4, 2}, {0, 288}, {4, 1}, {0, 65235}}
+	if painted.Seq != 3 || fmt.Sprint(painted.Runs) != fmt.Sprint(want) {
+		t.Errorf("Expected seq 3 with runs %v, got %+v", want, painted)
+	}
+
+	// Downsampling applies before encoding
+	small := get("cx=0&cy=0&format=rle&downsample=16")
+	if small.Width != 16 || len(small.Runs) != 1 || small.Runs[0] != [2]int{0, 256} {
+		t.Errorf("Expected one blank run over a 16x16 grid, got %+v", small)
+	}
+}
+
+func TestGetChunkBorder(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	const last = chunkWidth - 1
+	paints := []struct {
+		cx, cy int64
+		o      int
+		color  uint8
+	}{
+		{5, 4, last*chunkWidth + 10, 3},     // north neighbor's bottom row
+		{5, 4, (last-1)*chunkWidth + 10, 9}, // a row further out
+		{5, 6, 20, 4},                       // south neighbor's top row
+		{4, 5, 30*chunkWidth + last, 5},     // west neighbor's right column
+		{6, 5, 40 * chunkWidth, 6},          // east neighbor's left column
+		{4, 4, last*chunkWidth + last, 7},
+		{6, 6, 0, 8},
+		{5, 5, 0, 1}, // the chunk itself
+	}
+	for _, p := range paints {
+		if _, _, _, err := h.rdb.PaintTile(p.cx, p.cy, p.o, p.color); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+	if err := h.rdb.SetChunkBackground(6, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?cx=5&cy=5&border=1", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.Bytes()
+	if len(body) != 32768+chunkBorderBytes || w.Header().Get("X-Border") != "1" {
+		t.Fatalf("Expected %d bytes with X-Border, got %d (%q)", 32768+chunkBorderBytes, len(body), w.Header().Get("X-Border"))
+	}
+	if got := bits.GetNibble(body, 0); got != 1 {
+		t.Errorf("Expected the chunk's own tile 0 to be 1, got %d", got)
+	}
+
+	ring := body[32768:]
+	north, south, west, east, corners := 0, chunkWidth, 2*chunkWidth, 3*chunkWidth, 4*chunkWidth
+	checks := []struct {
+		name  string
+		o     int
+		color uint8
+	}{
+		{"north[10]", north + 10, 3},
+		{"north[11]", north + 11, 0},
+		{"south[20]", south + 20, 4},
+		{"west[30]", west + 30, 5},
+		{"east[40]", east + 40, 6},
+		{"NW", corners, 7},
+		{"NE (background)", corners + 1, 2},
+		{"SW", corners + 2, 0},
+		{"SE", corners + 3, 8},
+	}
+	for _, c := range checks {
+		if got := bits.GetNibble(ring, c.o); got != c.color {
+			t.Errorf("%s = %d, want %d", c.name, got, c.color)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?cx=5&cy=5&border=1&downsample=2", nil))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for border with downsample, got %d", w.Code)
+	}
+}
+
+func TestGetChunkStats(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	// Three tiles of color 2, one of 7, and the chunk's last tile in 15
+	for _, p := range []struct {
+		o     int
+		color uint8
+	}{{0, 2}, {1, 2}, {500, 2}, {501, 7}, {65535, 15}} {
+		if _, _, _, err := h.rdb.PaintTile(3, 4, p.o, p.color); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.GetChunkStats(w, httptest.NewRequest(http.MethodGet, "/state/chunk/stats?cx=3&cy=4", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats ChunkStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Bad JSON body: %v", err)
+	}
+	want := ChunkStats{Painted: 5, Total: 65536, Histogram: [16]int{0: 65531, 2: 3, 7: 1, 15: 1}}
+	if stats != want {
+		t.Errorf("Expected %+v, got %+v", want, stats)
+	}
+	if seq := w.Header().Get("X-Seq"); seq != "5" {
+		t.Errorf("Expected X-Seq 5, got %q", seq)
+	}
+
+	// A never-painted chunk is all unpainted
+	w = httptest.NewRecorder()
+	h.GetChunkStats(w, httptest.NewRequest(http.MethodGet, "/state/chunk/stats?cx=9&cy=9", nil))
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if stats.Painted != 0 || stats.Histogram[0] != 65536 {
+		t.Errorf("Expected a blank chunk, got %+v", stats)
+	}
+}
+
+func TestGetChunkParamErrors(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	tests := []struct {
+		query string
+		code  string
+		param string
+	}{
+		{"cy=0", CodeMissingParam, "cx"},
+		{"cx=0&cy=abc", CodeInvalidParam, "cy"},
+		{"cx=1.5&cy=0", CodeInvalidParam, "cx"},
+		{"cx=0&cy=0&format=png", CodeInvalidParam, "format"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?"+tt.query, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", tt.query, w.Code)
+			continue
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: bad JSON body %q: %v", tt.query, w.Body.String(), err)
+			continue
+		}
+		if body.Error.Code != tt.code || body.Error.Param != tt.param {
+			t.Errorf("%s: expected %s for %s, got %+v", tt.query, tt.code, tt.param, body.Error)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.GetChunk(w, httptest.NewRequest(http.MethodGet, "/state/chunk?cx=-3&cy=7", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected 200 for a valid request, got %d: %s", w.Code, w.Body.String())
+	}
+
+	metrics := scrape(t, h)
+	for _, want := range []string{
+		`splat_param_errors_total{code="MISSING_PARAM",param="cx"} 1`,
+		`splat_param_errors_total{code="INVALID_PARAM",param="cx"} 1`,
+		`splat_param_errors_total{code="INVALID_PARAM",param="cy"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected %q in metrics", want)
+		}
+	}
+}
+
+func TestGetChunksReturnsEachChunk(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	// Chunk (1, 0) is painted twice; (0, 1) stays blank
+	h.rdb.PaintTile(1, 0, 5, 3)
+	h.rdb.PaintTile(1, 0, 6, 4)
+
+	r := httptest.NewRequest(http.MethodGet, "/state/chunks?chunks=1,0;0,1", nil)
+	w := httptest.NewRecorder()
+	h.GetChunks(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body := w.Body.Bytes()
+	type record struct {
+		cx, cy int64
+		seq    uint64
+		bits   []byte
+	}
+	var records []record
+	for len(body) > 0 {
+		if len(body) < chunkRecordHeader {
+			t.Fatalf("Truncated record header: %d bytes left", len(body))
+		}
+		n := int(binary.BigEndian.Uint32(body[24:28]))
+		if len(body) < chunkRecordHeader+n {
+			t.Fatalf("Truncated record: want %d bits, %d bytes left", n, len(body)-chunkRecordHeader)
+		}
+		records = append(records, record{
+			cx:   int64(binary.BigEndian.Uint64(body[0:8])),
+			cy:   int64(binary.BigEndian.Uint64(body[8:16])),
+			seq:  binary.BigEndian.Uint64(body[16:24]),
+			bits: body[chunkRecordHeader : chunkRecordHeader+n],
+		})
+		body = body[chunkRecordHeader+n:]
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	painted, blank := records[0], records[1]
+	if painted.cx != 1 || painted.cy != 0 || painted.seq != 2 || len(painted.bits) != 32768 {
+		t.Errorf("Unexpected painted chunk (%d, %d) seq %d with %d bytes", painted.cx, painted.cy, painted.seq, len(painted.bits))
+	}
+	if got := bits.GetNibble(painted.bits, 6); got != 4 {
+		t.Errorf("Expected color 4 at offset 6, got %d", got)
+	}
+	if blank.cx != 0 || blank.cy != 1 || blank.seq != 0 || !bytes.Equal(blank.bits, make([]byte, 32768)) {
+		t.Errorf("Expected a blank chunk (0, 1) at seq 0, got (%d, %d) seq %d", blank.cx, blank.cy, blank.seq)
+	}
+
+	// Clients that escape the separator get the same response
+	escaped := httptest.NewRecorder()
+	h.GetChunks(escaped, httptest.NewRequest(http.MethodGet, "/state/chunks?chunks=1%2C0%3B0%2C1", nil))
+	if escaped.Code != 200 || !bytes.Equal(escaped.Body.Bytes(), w.Body.Bytes()) {
+		t.Errorf("Expected the same chunks for an escaped list, got %d", escaped.Code)
+	}
+}
+
+func TestGetChunksRejectsBadLists(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	tooMany := strings.Repeat("0,0;", maxChunksPerRequest) + "0,0"
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"", CodeMissingParam},
+		{"chunks=1,0;2", CodeInvalidParam},
+		{"chunks=" + tooMany, CodeTooManyChunks},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.GetChunks(w, httptest.NewRequest(http.MethodGet, "/state/chunks?"+tt.query, nil))
+		var body ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != 400 || body.Error.Code != tt.code {
+			t.Errorf("%.30s: expected 400 %s, got %d %+v", tt.query, tt.code, w.Code, body.Error)
+		}
+	}
+}
+
+func TestGetChunkDownsample(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	// Fill the top-left 16x16 block with color 5
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			h.rdb.PaintTile(0, 0, y*256+x, 5)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0&downsample=16", nil)
+	w := httptest.NewRecorder()
+	h.GetChunk(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("X-Downsample"); got != "16" {
+		t.Errorf("Expected X-Downsample 16, got %q", got)
+	}
+
+	// 16x16 blocks packed two per byte; only the first block is painted
+	body := w.Body.Bytes()
+	if len(body) != 128 {
+		t.Fatalf("Expected 128 bytes, got %d", len(body))
+	}
+	if body[0] != 0x50 {
+		t.Errorf("Expected first byte 0x50, got %#x", body[0])
+	}
+	for i, b := range body[1:] {
+		if b != 0 {
+			t.Errorf("Expected byte %d to be blank, got %#x", i+1, b)
+			break
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0&downsample=3", nil)
+	w = httptest.NewRecorder()
+	h.GetChunk(w, r)
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for a factor that doesn't divide 256, got %d", w.Code)
+	}
+}
+
+func TestPostPaintClaimModeOnlyPaintsEmptyTiles(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	claim := func(o int, color uint8, ip, mode string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(bostonPaint(o, color))
+		r := httptest.NewRequest(http.MethodPost, "/paint?mode="+mode, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("CF-Connecting-IP", ip)
+		w := httptest.NewRecorder()
+		h.PostPaint(w, r)
+		return w
+	}
+
+	if w := claim(9, 4, "10.0.0.1", "claim"); w.Code != 200 {
+		t.Fatalf("Expected the first claim to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := claim(9, 6, "10.0.0.2", "claim")
+	if w.Code != 409 {
+		t.Fatalf("Expected 409 for an occupied tile, got %d: %s", w.Code, w.Body.String())
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != CodeTileOccupied {
+		t.Errorf("Expected %s, got %s (%v)", CodeTileOccupied, w.Body.String(), err)
+	}
+	if colors, _ := h.rdb.GetTileColors([]redisclient.TileRef{{Cx: 0, Cy: 0, O: 9}}); colors[0] != 4 {
+		t.Errorf("Expected the tile to keep color 4, got %v", colors)
+	}
+
+	// The loser wasn't cooled down, and an ordinary paint still overwrites
+	if w := postPaint(h, bostonPaint(9, 6), "10.0.0.2"); w.Code != 200 {
+		t.Errorf("Expected a normal paint to overwrite, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := claim(9, 6, "10.0.0.3", "steal"); w.Code != 400 {
+		t.Errorf("Expected 400 for an unknown mode, got %d", w.Code)
+	}
+}
+
+func TestPostPaintRejectsOutOfRangeOffset(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	// 70000 would truncate to 4464 as a uint16
+	for _, o := range []int{10_000_000, 70000, 65536, -1} {
+		w := postPaint(h, bostonPaint(o, 3), "10.0.0.1")
+		if w.Code != 400 {
+			t.Errorf("o=%d: expected 400, got %d: %s", o, w.Code, w.Body.String())
+			continue
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != CodeInvalidOffset {
+			t.Errorf("o=%d: expected %s, got %s", o, CodeInvalidOffset, w.Body.String())
+		}
+	}
+	if seq, err := h.rdb.GetChunkSeq(0, 0); err == nil {
+		t.Errorf("Expected nothing painted, chunk seq is %d", seq)
+	}
+
+	if w := postPaint(h, bostonPaint(65535, 3), "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected the last offset to be paintable, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintRejectsChunkOutsideWorld(t *testing.T) {
+	h, mr := newTestHandler(t, testConfig())
+
+	maxChunk := h.proj.MaxChunk()
+	for _, c := range [][2]int64{{-1, 0}, {0, -1}, {maxChunk + 1, 0}, {0, 1 << 40}} {
+		paint := bostonPaint(0, 3)
+		paint.Cx, paint.Cy = c[0], c[1]
+		w := postPaint(h, paint, "10.0.0.1")
+		if w.Code != 400 || !strings.Contains(w.Body.String(), CodeInvalidChunk) {
+			t.Errorf("chunk %v: expected 400 %s, got %d: %s", c, CodeInvalidChunk, w.Code, w.Body.String())
+		}
+	}
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, "chunk:") {
+			t.Errorf("Expected no chunk written, found %s", key)
+		}
+	}
+
+	paint := bostonPaint(0, 3)
+	paint.Cx, paint.Cy = maxChunk, maxChunk
+	if w := postPaint(h, paint, "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected the last chunk to be paintable, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWorldBoundsRejectPaintsAndSubsOutsideBoston(t *testing.T) {
+	config := testConfig()
+	config.WorldBounds = nil // the geofence's
+	h, _ := newTestHandler(t, config)
+
+	boston := bostonPaint(0, 3)
+	x, y := geo.LatLonToTileXY(boston.Lat, boston.Lon)
+	boston.Cx, boston.Cy = geo.ChunkOf(x, y)
+	if w := postPaint(h, boston, "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected Boston's chunk paintable, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// On the map, but nowhere near Boston
+	far := bostonPaint(0, 3)
+	if w := postPaint(h, far, "10.0.0.2"); w.Code != 400 || !strings.Contains(w.Body.String(), CodeInvalidChunk) {
+		t.Errorf("Expected 400 %s for chunk (0, 0), got %d: %s", CodeInvalidChunk, w.Code, w.Body.String())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=9000000000000000000&cy=0", nil)
+	if err == nil || resp.StatusCode != 400 {
+		t.Errorf("Expected /sub refused for an absurd chunk, got %v", err)
+	}
+	sub, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/sub?cx=%d&cy=%d", server.URL[4:], boston.Cx, boston.Cy), nil)
+	if err != nil {
+		t.Fatalf("Expected /sub to Boston's chunk accepted: %v", err)
+	}
+	sub.Close()
+}
+
+func TestPostPaintProjectsToConfiguredTileSize(t *testing.T) {
+	config := testConfig()
+	config.TrustClientCoords = false
+	config.TileMeters = 5
+	h, _ := newTestHandler(t, config)
+
+	// The 10m tile's coordinates no longer match the location
+	req := bostonPaint(0, 3)
+	x, y := geo.LatLonToTileXY(req.Lat, req.Lon)
+	req.Cx, req.Cy = geo.ChunkOf(x, y)
+	req.O = geo.OffsetOf(x, y)
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for 10m coordinates, got %d", w.Code)
+	}
+
+	fine := geo.Projection{TileMeters: 5, ChunkSize: 256}
+	x, y = fine.LatLonToTileXY(req.Lat, req.Lon)
+	req.Cx, req.Cy = fine.ChunkOf(x, y)
+	req.O = fine.OffsetOf(x, y)
+	if w := postPaint(h, req, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for 5m coordinates, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintBansRepeatOffenders(t *testing.T) {
+	config := testConfig()
+	config.BanStrikes = 2
+	config.BanStrikeWindowS = 600
+	config.BanPenalties = []time.Duration{time.Minute, 10 * time.Minute}
+	h, mr := newTestHandler(t, config)
+	ip := "10.0.0.1"
+
+	outside := bostonPaint(0, 5)
+	outside.Lat = 40.0
+	offend := func() {
+		t.Helper()
+		for i := 0; i < config.BanStrikes; i++ {
+			if w := postPaint(h, outside, ip); w.Code != 403 || !strings.Contains(w.Body.String(), CodeGeofence) {
+				t.Fatalf("Expected 403 %s, got %d: %s", CodeGeofence, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	// The second geofence strike bans for the first penalty
+	offend()
+	if !mr.Exists("ban:" + ip) {
+		t.Fatalf("Expected a ban:%s key", ip)
+	}
+	w := postPaint(h, bostonPaint(0, 5), ip)
+	if w.Code != 403 {
+		t.Fatalf("Expected a banned paint to get 403, got %d: %s", w.Code, w.Body.String())
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != CodeBanned || body.RetryAfterMs <= 50000 || body.RetryAfterMs > 60000 {
+		t.Errorf("Expected %s with about a minute left, got %+v", CodeBanned, body)
+	}
+	if w := postPaint(h, bostonPaint(0, 5), "10.0.0.2"); w.Code != 200 {
+		t.Errorf("Expected another IP to paint, got %d", w.Code)
+	}
+
+	// Once it lapses the IP is judged as usual again, and offending again
+	// bans for longer
+	mr.FastForward(61 * time.Second)
+	offend()
+	if ttl := mr.TTL("ban:" + ip); ttl != 10*time.Minute {
+		t.Errorf("Expected the second ban to last 10m, got %v", ttl)
+	}
+}
+
+func TestPostPaintReportsTurnstileErrors(t *testing.T) {
+	// Answers each token with the error codes it names
+	cloudflare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("response")
+		if token == "garbled" {
+			w.Write([]byte("<html>502 Bad Gateway</html>"))
+			return
+		}
+		resp := turnstile.TurnstileResponse{Success: token == "good"}
+		if !resp.Success {
+			resp.ErrorCodes = []string{token}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer cloudflare.Close()
+
+	config := testConfig()
+	config.EnableTurnstile = true
+	config.TurnstileSecret = "test_secret"
+	h, _ := newTestHandler(t, config)
+	h.turnstileClient.SetBaseURL(cloudflare.URL)
+
+	for i, tc := range []struct {
+		token   string
+		status  int
+		code    string
+		reasons []string
+	}{
+		{"", 401, CodeTurnstile, nil},
+		{"timeout-or-duplicate", 401, CodeTurnstileExpired, []string{"timeout-or-duplicate"}},
+		{"invalid-input-response", 401, CodeTurnstile, []string{"invalid-input-response"}},
+		{"invalid-input-secret", 502, CodeTurnstileDown, []string{"invalid-input-secret"}},
+		{"garbled", 502, CodeTurnstileDown, nil},
+		{"good", 200, "", nil},
+	} {
+		paint := bostonPaint(i, 3)
+		paint.TurnstileToken = tc.token
+		// A fresh IP each time, so cooldown doesn't interfere
+		w := postPaint(h, paint, fmt.Sprintf("10.0.1.%d", i))
+		if w.Code != tc.status {
+			t.Errorf("token %q: expected %d, got %d: %s", tc.token, tc.status, w.Code, w.Body.String())
+			continue
+		}
+		if tc.status == 200 {
+			continue
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("token %q: decode error: %v", tc.token, err)
+		}
+		if body.Error.Code != tc.code || !slices.Equal(body.Error.Reasons, tc.reasons) {
+			t.Errorf("token %q: expected %s %v, got %s %v", tc.token, tc.code, tc.reasons, body.Error.Code, body.Error.Reasons)
+		}
+	}
+}
+
+func TestGetChunkReadRateLimit(t *testing.T) {
+	config := testConfig()
+	config.ReadRateLimit = 3
+	config.ReadRateWindowS = 3600
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+
+	getChunk := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0", nil)
+		r.Header.Set("CF-Connecting-IP", ip)
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := getChunk("10.0.0.1"); w.Code != 200 {
+			t.Fatalf("read %d: status %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	w := getChunk("10.0.0.1")
+	if w.Code != 429 || !strings.Contains(w.Body.String(), CodeRateLimited) {
+		t.Errorf("Expected the fourth read limited, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The limit is per IP, and reads don't spend the paint cooldown
+	if w := getChunk("10.0.0.2"); w.Code != 200 {
+		t.Errorf("Expected another IP's read allowed, got %d", w.Code)
+	}
+	if w := postPaint(h, bostonPaint(0, 5), "10.0.0.1"); w.Code != 200 {
+		t.Errorf("Expected a paint after the reads allowed, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostPaintMaskChecksTheDeclaredTile(t *testing.T) {
+	config := testConfig()
+	config.TrustClientCoords = false
+	config.CoordsToleranceTiles = 2
+	h, _ := newTestHandler(t, config)
+
+	// Only the location's tile is open
+	paint := paintAt(42.3601, -71.0589, 3)
+	x, y := geo.LatLonToTileXY(paint.Lat, paint.Lon)
+	mask := geo.NewMask(geo.Bounds{MinX: x - 5, MinY: y - 5, MaxX: x + 5, MaxY: y + 5}, 10)
+	mask.SetTile(x, y, true)
+	h.mask = mask
+
+	// A tile within the tolerance but masked is still refused
+	stray := paint
+	stray.Cx, stray.Cy = geo.ChunkOf(x+1, y)
+	stray.O = geo.OffsetOf(x+1, y)
+	if w := postPaint(h, stray, "10.0.0.1"); w.Code != 403 || !strings.Contains(w.Body.String(), CodeOutsideMask) {
+		t.Errorf("Expected 403 %s for a masked tile near the location, got %d: %s", CodeOutsideMask, w.Code, w.Body.String())
+	}
+	if w := postPaint(h, paint, "10.0.0.2"); w.Code != 200 {
+		t.Errorf("Expected the open tile to be paintable, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// hangingRedis forwards to miniredis until hang is called, then swallows
+// every command without replying, like a Redis that has stopped responding
+func hangingRedis(t *testing.T, mr *miniredis.Miniredis) (addr string, hang func()) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hung atomic.Bool
+	var mu sync.Mutex
+	var conns []net.Conn
+	track := func(c net.Conn) {
+		mu.Lock()
+		conns = append(conns, c)
+		mu.Unlock()
+	}
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", mr.Addr())
+			if err != nil {
+				client.Close()
+				continue
+			}
+			track(client)
+			track(server)
+			go io.Copy(client, server)
+			go func() {
+				buf := make([]byte, 4096)
+				for {
+					n, err := client.Read(buf)
+					if err != nil {
+						return
+					}
+					if !hung.Load() {
+						server.Write(buf[:n])
+					}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return ln.Addr().String(), func() { hung.Store(true) }
+}
+
+func TestPostPaintGivesUpOnHungRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr, hang := hangingRedis(t, mr)
+	rdb, err := redisclient.NewClient("redis://" + addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	hub := ws.NewHub()
+	go hub.Run()
+
+	config := testConfig()
+	config.RedisTimeoutMs = 100
+	config.DuplicateWindowMs = 5000
+	config.UndoWindowS = 60
+	config.EnableStreak = true
+	config.BanStrikes = 3
+	config.BanStrikeWindowS = 600
+	config.BanPenalties = []time.Duration{time.Minute}
+	h := NewHandler(rdb, hub, config, nil)
+	t.Cleanup(h.Close)
+
+	hang()
+
+	// The ban check, the duplicate claim and its release, the strike and
+	// the paint each give up after REDIS_TIMEOUT_MS
+	outside := bostonPaint(0, 5)
+	outside.Lat = 40.0
+	for _, req := range []PaintRequest{bostonPaint(0, 5), outside} {
+		start := time.Now()
+		w := postPaint(h, req, "10.0.0.1")
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the paint to give up on the hung Redis, took %v (status %d)", elapsed, w.Code)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"time"
+
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// deltaHistory adapts the Redis paint history to the hub's HistorySource
+type deltaHistory struct {
+	rdb     *redisclient.Client
+	timeout time.Duration
+}
+
+// NewDeltaHistory returns a ws.HistorySource backed by the chunk histories
+// PaintTile records when rdb.KeepHistory is set. Each lookup gives up after
+// timeout, unless it is 0.
+func NewDeltaHistory(rdb *redisclient.Client, timeout time.Duration) ws.HistorySource {
+	return deltaHistory{rdb: rdb, timeout: timeout}
+}
+
+// DeltasSince converts the retained paints after seq into deltas
+func (d deltaHistory) DeltasSince(cx, cy int64, seq uint64) ([]ws.Delta, bool, error) {
+	ctx, cancel := timeoutContext(d.timeout)
+	defer cancel()
+	entries, ok, err := d.rdb.DeltasSinceContext(ctx, cx, cy, seq)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	deltas := make([]ws.Delta, len(entries))
+	for i, e := range entries {
+		deltas[i] = ws.Delta{
+			Seq:   e.Seq,
+			O:     e.O,
+			Color: e.Color,
+			Ts:    e.Ts,
+			Cx:    cx,
+			Cy:    cy,
+		}
+	}
+	return deltas, true, nil
+}
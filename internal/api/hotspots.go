@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// hotspotBucketDeg is the size of a rejection hotspot bucket in degrees,
+// about 1.1km north-south and 0.8km east-west in Boston: coarse enough to
+// keep painters' positions vague, fine enough to show one geofence edge
+const hotspotBucketDeg = 0.01
+
+// maxHotspots bounds how many buckets are kept, since rejected paints can
+// claim to come from anywhere. Rejections in new buckets past it are only
+// counted as overflow.
+const maxHotspots = 10000
+
+// Hotspot counts the geographic rejections in one bucket by check. Lat and
+// Lon are the bucket's south-west corner.
+type Hotspot struct {
+	Lat      float64        `json:"lat"`
+	Lon      float64        `json:"lon"`
+	Total    int            `json:"total"`
+	ByReason map[string]int `json:"byReason"`
+}
+
+// HotspotsResponse is returned by GET /stats/rejections/geo
+type HotspotsResponse struct {
+	BucketDeg float64   `json:"bucketDeg"`
+	Overflow  int       `json:"overflow"`
+	Hotspots  []Hotspot `json:"hotspots"`
+}
+
+// hotspotKey identifies a bucket by its index on each axis
+type hotspotKey struct {
+	lat, lon int64
+}
+
+// rejectionHotspots aggregates geofence, mask and speed rejections by
+// coarse location since the process started
+type rejectionHotspots struct {
+	mu       sync.Mutex
+	buckets  map[hotspotKey]map[string]int
+	overflow int
+}
+
+func newRejectionHotspots() *rejectionHotspots {
+	return &rejectionHotspots{buckets: make(map[hotspotKey]map[string]int)}
+}
+
+// record counts a rejection by the named check at lat/lon
+func (s *rejectionHotspots) record(reason string, lat, lon float64) {
+	if math.IsNaN(lat) || math.IsNaN(lon) {
+		return
+	}
+	key := hotspotKey{
+		lat: int64(math.Floor(lat / hotspotBucketDeg)),
+		lon: int64(math.Floor(lon / hotspotBucketDeg)),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxHotspots {
+			s.overflow++
+			return
+		}
+		counts = make(map[string]int)
+		s.buckets[key] = counts
+	}
+	counts[reason]++
+}
+
+// top returns up to limit buckets with the most rejections, most first
+func (s *rejectionHotspots) top(limit int) HotspotsResponse {
+	s.mu.Lock()
+	response := HotspotsResponse{
+		BucketDeg: hotspotBucketDeg,
+		Overflow:  s.overflow,
+		Hotspots:  make([]Hotspot, 0, len(s.buckets)),
+	}
+	for key, counts := range s.buckets {
+		spot := Hotspot{
+			Lat:      bucketEdge(key.lat),
+			Lon:      bucketEdge(key.lon),
+			ByReason: make(map[string]int, len(counts)),
+		}
+		for reason, n := range counts {
+			spot.ByReason[reason] = n
+			spot.Total += n
+		}
+		response.Hotspots = append(response.Hotspots, spot)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(response.Hotspots, func(i, j int) bool {
+		a, b := response.Hotspots[i], response.Hotspots[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Lat != b.Lat {
+			return a.Lat < b.Lat
+		}
+		return a.Lon < b.Lon
+	})
+	if len(response.Hotspots) > limit {
+		response.Hotspots = response.Hotspots[:limit]
+	}
+	return response
+}
+
+// bucketEdge returns the coordinate where bucket i starts, rounded so it
+// prints as e.g. 42.36 rather than 42.360000000000006
+func bucketEdge(i int64) float64 {
+	return math.Round(float64(i)*hotspotBucketDeg*1e6) / 1e6
+}
+
+// defaultHotspotLimit is how many hotspots GET /stats/rejections/geo
+// returns without a limit parameter
+const defaultHotspotLimit = 100
+
+// GetRejectionHotspots handles GET /stats/rejections/geo, listing where
+// this instance's geofence, mask and speed rejections cluster
+func (h *Handler) GetRejectionHotspots(w http.ResponseWriter, r *http.Request) {
+	limit := defaultHotspotLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			h.rejectParam(w, invalidParam("limit", "must be a positive integer"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.hotspots.top(limit))
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectionHotspotsBucketGeofenceRejections(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	paintAt := func(lat, lon float64, ip string) {
+		t.Helper()
+		req := bostonPaint(0, 3)
+		req.Lat, req.Lon = lat, lon
+		if w := postPaint(h, req, ip); w.Code != http.StatusForbidden {
+			t.Fatalf("paint at (%f, %f): expected 403, got %d", lat, lon, w.Code)
+		}
+	}
+	// Two just past the northern edge, in the same bucket, and one south
+	paintAt(43.2051, -71.0632, "10.0.0.1")
+	paintAt(43.2099, -71.0601, "10.0.0.2")
+	paintAt(41.5, -71.05, "10.0.0.3")
+	// An accepted paint isn't counted
+	if w := postPaint(h, bostonPaint(1, 3), "10.0.0.4"); w.Code != 200 {
+		t.Fatalf("paint in Boston: status %d", w.Code)
+	}
+
+	get := func(query string) HotspotsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.GetRejectionHotspots(w, httptest.NewRequest(http.MethodGet, "/stats/rejections/geo"+query, nil))
+		if w.Code != 200 {
+			t.Fatalf("GET hotspots%s: status %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp HotspotsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode hotspots: %v", err)
+		}
+		return resp
+	}
+
+	resp := get("")
+	if len(resp.Hotspots) != 2 {
+		t.Fatalf("Expected 2 hotspots, got %+v", resp.Hotspots)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	north, south := resp.Hotspots[0], resp.Hotspots[1]
+	if !near(north.Lat, 43.20) || !near(north.Lon, -71.07) || north.Total != 2 || north.ByReason["geofence"] != 2 {
+		t.Errorf("Expected 2 geofence rejections in the bucket at (43.20, -71.07), got %+v", north)
+	}
+	if !near(south.Lat, 41.50) || !near(south.Lon, -71.05) || south.Total != 1 {
+		t.Errorf("Expected 1 rejection in the bucket at (41.50, -71.05), got %+v", south)
+	}
+
+	if resp := get("?limit=1"); len(resp.Hotspots) != 1 || resp.Hotspots[0].Total != 2 {
+		t.Errorf("Expected only the busiest hotspot with limit=1, got %+v", resp.Hotspots)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// LimitsResponse reports the limits that apply to the requesting subject
+// and where it stands against them
+type LimitsResponse struct {
+	// CooldownMs is the cooldown a paint starts, before any streak bonus
+	CooldownMs          int64 `json:"cooldownMs"`
+	CooldownRemainingMs int64 `json:"cooldownRemainingMs"`
+
+	// CooldownByColor covers the colors with their own cooldown, keyed by
+	// color; CooldownMs and CooldownRemainingMs are every other color's
+	CooldownByColor map[uint8]ColorCooldown `json:"cooldownByColor,omitempty"`
+
+	// BanRemainingMs is how much longer the subject is banned, if it is
+	BanRemainingMs int64 `json:"banRemainingMs,omitempty"`
+
+	// Streak and the cooldown reduction it earns are set when streaks are
+	// enabled
+	Streak         *int `json:"streak,omitempty"`
+	StreakBonusPct *int `json:"streakBonusPct,omitempty"`
+
+	// SpeedMaxKmh is set when the speed limit is enabled
+	SpeedMaxKmh float64 `json:"speedMaxKmh,omitempty"`
+}
+
+// ColorCooldown is one color's own cooldown and what's left of it
+type ColorCooldown struct {
+	CooldownMs          int64 `json:"cooldownMs"`
+	CooldownRemainingMs int64 `json:"cooldownRemainingMs"`
+}
+
+// GetLimits handles GET /me/limits, gathering what the per-paint headers
+// report into one place so clients can show it before painting
+func (h *Handler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	ip := h.clientIP(r)
+
+	response := LimitsResponse{
+		CooldownMs:          h.paintCooldown().Milliseconds(),
+		CooldownRemainingMs: h.cooldownLimiter.GetCooldownRemaining(ip, h.paintCooldown()).Milliseconds(),
+	}
+
+	// Colors with their own cooldown are tracked under their own key, as in
+	// PostPaint
+	if len(h.config.PaintCooldownByColor) > 0 {
+		response.CooldownByColor = make(map[uint8]ColorCooldown, len(h.config.PaintCooldownByColor))
+		for color, ms := range h.config.PaintCooldownByColor {
+			response.CooldownByColor[color] = ColorCooldown{
+				CooldownMs:          int64(ms),
+				CooldownRemainingMs: h.cooldownLimiter.GetCooldownRemaining(h.cooldownKey(ip, color), h.paintCooldown()).Milliseconds(),
+			}
+		}
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+
+	if h.bans != nil {
+		remaining, err := h.bans.Remaining(ctx, ip)
+		if err != nil {
+			h.redisError("limits", err)
+			writeError(w, 500, CodeRedis, "redis error")
+			return
+		}
+		response.BanRemainingMs = remaining.Milliseconds()
+	}
+
+	if h.config.EnableStreak {
+		streak, err := h.rdb.GetStreakContext(ctx, ip, time.Now().Unix()/secondsPerDay)
+		if err != nil {
+			h.redisError("limits", err)
+			writeError(w, 500, CodeRedis, "redis error")
+			return
+		}
+		bonus := h.streakBonus(streak)
+		response.Streak, response.StreakBonusPct = &streak, &bonus
+	}
+
+	if h.config.EnableSpeedLimit {
+		response.SpeedMaxKmh = h.config.SpeedMaxKmh
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getLimits fetches /me/limits for ip
+func getLimits(t *testing.T, h *Handler, ip string) LimitsResponse {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/me/limits", nil)
+	r.Header.Set("CF-Connecting-IP", ip)
+	w := httptest.NewRecorder()
+	h.GetLimits(w, r)
+	if w.Code != 200 {
+		t.Fatalf("GET /me/limits: status %d: %s", w.Code, w.Body.String())
+	}
+	var limits LimitsResponse
+	if err := json.NewDecoder(w.Body).Decode(&limits); err != nil {
+		t.Fatalf("decode limits: %v", err)
+	}
+	return limits
+}
+
+func TestGetLimitsAfterPaint(t *testing.T) {
+	config := testConfig()
+	config.EnableStreak = true
+	h, _ := newTestHandler(t, config)
+
+	before := getLimits(t, h, "203.0.113.30")
+	if before.CooldownRemainingMs != 0 {
+		t.Errorf("cooldown remaining before painting = %dms, want 0", before.CooldownRemainingMs)
+	}
+	if before.Streak == nil || *before.Streak != 0 {
+		t.Errorf("streak before painting = %v, want 0", before.Streak)
+	}
+
+	if w := postPaint(h, bostonPaint(1, 3), "203.0.113.30"); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+
+	after := getLimits(t, h, "203.0.113.30")
+	if after.CooldownMs != int64(config.PaintCooldownMs) {
+		t.Errorf("cooldown = %dms, want %dms", after.CooldownMs, config.PaintCooldownMs)
+	}
+	if after.CooldownRemainingMs <= 0 || after.CooldownRemainingMs > int64(config.PaintCooldownMs) {
+		t.Errorf("cooldown remaining after painting = %dms, want within (0, %d]", after.CooldownRemainingMs, config.PaintCooldownMs)
+	}
+	if after.Streak == nil || *after.Streak != 1 {
+		t.Errorf("streak after painting = %v, want 1", after.Streak)
+	}
+	if after.StreakBonusPct == nil || *after.StreakBonusPct != 0 {
+		t.Errorf("streak bonus on the first day = %v, want 0", after.StreakBonusPct)
+	}
+}
+
+func TestGetLimitsReportsColorCooldownsAndBans(t *testing.T) {
+	config := testConfig()
+	config.PaintCooldownByColor = map[uint8]int{15: 60000}
+	config.BanStrikes = 1
+	config.BanStrikeWindowS = 600
+	config.BanPenalties = []time.Duration{time.Minute}
+	h, _ := newTestHandler(t, config)
+	ip := "203.0.113.31"
+
+	if w := postPaint(h, bostonPaint(1, 15), ip); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+	limits := getLimits(t, h, ip)
+	gold, ok := limits.CooldownByColor[15]
+	if !ok || gold.CooldownMs != 60000 || gold.CooldownRemainingMs <= 50000 {
+		t.Errorf("Expected color 15 cooling down for about a minute, got %+v", limits.CooldownByColor)
+	}
+	if limits.CooldownRemainingMs != 0 {
+		t.Errorf("Expected the other colors free to paint, got %dms remaining", limits.CooldownRemainingMs)
+	}
+	if limits.BanRemainingMs != 0 {
+		t.Errorf("Expected no ban yet, got %dms", limits.BanRemainingMs)
+	}
+
+	outside := bostonPaint(2, 3)
+	outside.Lat = 40.0
+	if w := postPaint(h, outside, ip); w.Code != 403 {
+		t.Fatalf("Expected a geofence rejection, got %d: %s", w.Code, w.Body.String())
+	}
+	if limits := getLimits(t, h, ip); limits.BanRemainingMs <= 50000 || limits.BanRemainingMs > 60000 {
+		t.Errorf("Expected about a minute of ban left, got %dms", limits.BanRemainingMs)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the handler's metrics in the text exposition format
+func scrape(t *testing.T, h *Handler) string {
+	t.Helper()
+	public, _ := h.Routes(false)
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics returned %d", w.Code)
+	}
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestMetricsCountPaints(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	if got := scrape(t, h); !strings.Contains(got, "splat_paints_accepted_total 0") {
+		t.Fatalf("expected no accepted paints yet, got:\n%s", got)
+	}
+
+	if w := postPaint(h, bostonPaint(0, 3), "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("paint failed: %d", w.Code)
+	}
+	// Still cooling down
+	if w := postPaint(h, bostonPaint(1, 3), "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+
+	got := scrape(t, h)
+	for _, want := range []string{
+		"splat_paints_accepted_total 1",
+		`splat_paints_rejected_total{reason="cooldown"} 1`,
+		"splat_paint_redis_seconds_count 1",
+		"splat_ws_rooms 0",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, got)
+		}
+	}
+}
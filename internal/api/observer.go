@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// observerKeyHeader carries an observer API key
+const observerKeyHeader = "X-API-Key"
+
+// scopeRead lets an observer key read the canvas without the public limits.
+// It is the only scope so far; keys can't paint or reach /admin.
+const scopeRead = "read"
+
+// observerBatchFactor is how many times more chunks or tiles an observer
+// may read per request than an anonymous client
+const observerBatchFactor = 4
+
+// parseObserverKeys reads a comma-separated "key:scope" list, returning the
+// keys granted the read scope. Entries without a known scope are logged and
+// skipped, so a typo can't grant more than intended.
+func parseObserverKeys(list string) [][]byte {
+	var keys [][]byte
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, scope, _ := strings.Cut(entry, ":")
+		if key == "" || scope != scopeRead {
+			slog.Warn("api: ignoring observer key without the read scope", "scope", scope)
+			continue
+		}
+		keys = append(keys, []byte(key))
+	}
+	return keys
+}
+
+// isObserver reports whether the request presents a configured read key.
+// Every key is compared in constant time; an unknown key reads as
+// anonymous rather than failing.
+func (h *Handler) isObserver(r *http.Request) bool {
+	presented := r.Header.Get(observerKeyHeader)
+	if presented == "" {
+		return false
+	}
+	found := 0
+	for _, key := range h.observerKeys {
+		found |= subtle.ConstantTimeCompare([]byte(presented), key)
+	}
+	return found == 1
+}
+
+// readLimited applies the per-IP read rate limit, which observers skip
+func (h *Handler) readLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.readLimiter != nil && !h.isObserver(r) && !h.readLimiter.Allow(h.clientIP(r)) {
+			h.metrics.ReadRateLimited()
+			writeError(w, 429, CodeRateLimited, "too many reads")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setReadCache sets Cache-Control on a canvas read. Observers get
+// ObserverMaxAgeS, kept private so shared caches don't hand the longer
+// lifetime to everyone.
+func (h *Handler) setReadCache(w http.ResponseWriter, r *http.Request) {
+	if h.isObserver(r) {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", h.config.ObserverMaxAgeS))
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=8", h.chunkMaxAge()))
+}
+
+// batchLimit scales a per-request chunk or tile cap for observers
+func (h *Handler) batchLimit(r *http.Request, limit int) int {
+	if h.isObserver(r) {
+		return limit * observerBatchFactor
+	}
+	return limit
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObserverKeyElevatesReads(t *testing.T) {
+	config := testConfig()
+	config.ReadRateLimit = 2
+	config.ObserverKeys = "newsroom-key:read, bad-scope:paint"
+	config.ObserverMaxAgeS = 600
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+
+	getChunk := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0", nil)
+		r.Header.Set("CF-Connecting-IP", "198.51.100.7")
+		if key != "" {
+			r.Header.Set(observerKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, r)
+		return w
+	}
+
+	// The observer's reads don't count against the shared IP
+	for i := 0; i < 5; i++ {
+		w := getChunk("newsroom-key")
+		if w.Code != 200 {
+			t.Fatalf("observer read %d: status %d: %s", i, w.Code, w.Body.String())
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=600" {
+			t.Errorf("observer Cache-Control = %q, want private, max-age=600", cc)
+		}
+	}
+
+	// Wrong and wrongly scoped keys read as anonymous, sharing the limit
+	for _, key := range []string{"", "nope"} {
+		w := getChunk(key)
+		if w.Code != 200 {
+			t.Fatalf("anonymous read with key %q: status %d", key, w.Code)
+		}
+		if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "public,") {
+			t.Errorf("anonymous Cache-Control = %q, want public", cc)
+		}
+	}
+	if w := getChunk("bad-scope"); w.Code != 429 || !strings.Contains(w.Body.String(), CodeRateLimited) {
+		t.Errorf("expected the third anonymous read to be limited, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Observers may fetch larger batches
+	list := strings.TrimSuffix(strings.Repeat("0,0;", maxChunksPerRequest+1), ";")
+	r := httptest.NewRequest(http.MethodGet, "/state/chunks?chunks="+list, nil)
+	r.Header.Set(observerKeyHeader, "newsroom-key")
+	w := httptest.NewRecorder()
+	h.GetChunks(w, r)
+	if w.Code != 200 {
+		t.Errorf("observer batch of %d chunks: status %d: %s", maxChunksPerRequest+1, w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/state/chunks?chunks="+list, nil)
+	r.Header.Set(observerKeyHeader, "nope")
+	w = httptest.NewRecorder()
+	h.GetChunks(w, r)
+	if w.Code != 400 {
+		t.Errorf("anonymous batch of %d chunks: status %d, want 400", maxChunksPerRequest+1, w.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// maxPaintableChunks bounds the viewport one GET /me/paintable covers: 16×16
+// chunks, well past what a map shows at painting zoom
+const maxPaintableChunks = 256
+
+// PaintableChunk says whether the subject may paint a chunk. Reason is the
+// error code a paint there would be rejected with.
+type PaintableChunk struct {
+	Cx        int64  `json:"cx"`
+	Cy        int64  `json:"cy"`
+	Paintable bool   `json:"paintable"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// PaintableResponse is returned by GET /me/paintable, one entry per chunk
+// in row-major order
+type PaintableResponse struct {
+	Chunks []PaintableChunk `json:"chunks"`
+}
+
+// GetPaintable handles GET /me/paintable?minCx=&minCy=&maxCx=&maxCy=,
+// reporting which chunks in the viewport the subject may paint now. It
+// makes PostPaint's location checks at chunk granularity: a chunk is
+// paintable if a paint standing somewhere in it could pass. Cooldown isn't
+// considered, since /me/limits already says when it ends.
+func (h *Handler) GetPaintable(w http.ResponseWriter, r *http.Request) {
+	lo, hi, perr := parseChunkRect(r.URL.Query())
+	if perr != nil {
+		h.rejectParam(w, perr)
+		return
+	}
+	width, height := hi.Cx-lo.Cx+1, hi.Cy-lo.Cy+1
+	if width*height > maxPaintableChunks {
+		writeError(w, 400, CodeTooManyChunks, fmt.Sprintf("%d×%d chunks is too many (max %d)", width, height, maxPaintableChunks))
+		return
+	}
+
+	ip := h.clientIP(r)
+	ctx, cancel := h.redisContext(r)
+	ban := h.checkBan(ctx, ip)
+	cancel()
+
+	response := PaintableResponse{Chunks: make([]PaintableChunk, 0, width*height)}
+	for cy := lo.Cy; cy <= hi.Cy; cy++ {
+		for cx := lo.Cx; cx <= hi.Cx; cx++ {
+			check := ban
+			if check.Pass {
+				check = h.checkChunkFence(cx, cy)
+			}
+			if check.Pass {
+				check = h.checkSubscription(ip, PaintRequest{Cx: cx, Cy: cy})
+			}
+			response.Chunks = append(response.Chunks, PaintableChunk{Cx: cx, Cy: cy, Paintable: check.Pass, Reason: check.code})
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkChunkFence is checkGeofence and checkMask for anywhere in a chunk: it
+// fails when no tile of the chunk is inside the mask, or, without one, the
+// geofence box. With client coordinates trusted the painted chunk needn't be
+// where the painter stands, so any chunk passes.
+func (h *Handler) checkChunkFence(cx, cy int64) PaintCheck {
+	if h.config.TrustClientCoords {
+		return passed("geofence", "client coordinates trusted")
+	}
+
+	size := h.proj.ChunkSize
+	minX, minY := cx*size, cy*size
+	maxX, maxY := minX+size-1, minY+size-1
+	if h.mask != nil {
+		if !h.mask.AnyAllowed(minX, minY, maxX, maxY) {
+			return failed("mask", fmt.Sprintf("chunk (%d, %d) is masked", cx, cy), 403, CodeOutsideMask, "outside mask")
+		}
+		return passed("mask", "")
+	}
+
+	fenceMinX, fenceMinY, fenceMaxX, fenceMaxY := fenceTiles(h.proj)
+	if maxX < fenceMinX || minX > fenceMaxX || maxY < fenceMinY || minY > fenceMaxY {
+		return failed("geofence", fmt.Sprintf("chunk (%d, %d) is outside the allowed area", cx, cy), 403, CodeGeofence, "outside the allowed area")
+	}
+	return passed("geofence", "")
+}
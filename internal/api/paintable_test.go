@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"splat-boston/internal/geo"
+)
+
+func TestGetPaintableFollowsMaskAndGeofence(t *testing.T) {
+	config := testConfig()
+	config.TrustClientCoords = false
+	h, _ := newTestHandler(t, config)
+
+	getPaintable := func(minCx, minCy, maxCx, maxCy int64) *httptest.ResponseRecorder {
+		url := fmt.Sprintf("/me/paintable?minCx=%d&minCy=%d&maxCx=%d&maxCy=%d", minCx, minCy, maxCx, maxCy)
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("CF-Connecting-IP", "203.0.113.40")
+		w := httptest.NewRecorder()
+		h.GetPaintable(w, r)
+		return w
+	}
+	chunks := func(w *httptest.ResponseRecorder) []PaintableChunk {
+		t.Helper()
+		if w.Code != 200 {
+			t.Fatalf("GET /me/paintable: status %d: %s", w.Code, w.Body.String())
+		}
+		var resp PaintableResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode paintable: %v", err)
+		}
+		return resp.Chunks
+	}
+
+	x, y := h.proj.LatLonToTileXY(42.3601, -71.0589)
+	cx, cy := h.proj.ChunkOf(x, y)
+
+	// Without a mask the geofence box decides
+	got := chunks(getPaintable(cx, cy, cx+200, cy))
+	if len(got) != 201 || !got[0].Paintable || got[200].Paintable || got[200].Reason != CodeGeofence {
+		t.Errorf("Expected Boston open and a chunk 200 east outside the geofence, got %+v and %+v", got[0], got[len(got)-1])
+	}
+
+	// A mask over three chunks with one tile open, in the middle one
+	size := h.proj.ChunkSize
+	mask := geo.NewMaskIn(geo.Bounds{MinX: (cx - 1) * size, MinY: cy * size, MaxX: (cx+2)*size - 1, MaxY: (cy+1)*size - 1}, h.proj)
+	mask.SetTile(x, y, true)
+	h.mask = mask
+
+	got = chunks(getPaintable(cx-1, cy, cx+1, cy+1))
+	want := []PaintableChunk{
+		{Cx: cx - 1, Cy: cy, Reason: CodeOutsideMask},
+		{Cx: cx, Cy: cy, Paintable: true},
+		{Cx: cx + 1, Cy: cy, Reason: CodeOutsideMask},
+		{Cx: cx - 1, Cy: cy + 1, Reason: CodeOutsideMask},
+		{Cx: cx, Cy: cy + 1, Reason: CodeOutsideMask},
+		{Cx: cx + 1, Cy: cy + 1, Reason: CodeOutsideMask},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d chunks, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Chunk %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The chunk reported open really is
+	paint := bostonPaint(h.proj.OffsetOf(x, y), 3)
+	paint.Cx, paint.Cy = cx, cy
+	if w := postPaint(h, paint, "203.0.113.40"); w.Code != 200 {
+		t.Errorf("Expected a paint in the open chunk to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The viewport is bounded
+	w := getPaintable(cx, cy, cx+16, cy+15)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), CodeTooManyChunks) {
+		t.Errorf("Expected 400 %s for 17×16 chunks, got %d: %s", CodeTooManyChunks, w.Code, w.Body.String())
+	}
+}
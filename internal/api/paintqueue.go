@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redisclient "splat-boston/internal/redis"
+)
+
+// errPaintUnavailable is returned for a paint that couldn't be queued, or
+// wasn't applied before its timeout, while Redis was unreachable
+var errPaintUnavailable = errors.New("paint queue: redis unavailable")
+
+// errPaintQueued is returned for a paint by a subject that already has one
+// queued. Each paint passed the cooldown check before any of them landed,
+// so letting them all queue would apply them all once Redis is back.
+var errPaintQueued = errors.New("paint queue: subject has a paint queued")
+
+// paintQueueRetry is how long the queue waits between attempts to reach
+// Redis
+const paintQueueRetry = 100 * time.Millisecond
+
+// Queued paint states. A waiting handler may abandon a pending paint when
+// its timeout passes; once the queue is applying it, the handler waits for
+// the outcome instead.
+const (
+	paintPending int32 = iota
+	paintApplying
+	paintAbandoned
+)
+
+// paintFunc paints one tile, as PaintTileContext and
+// PaintTileIfEmptyContext do
+type paintFunc func(ctx context.Context, cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error)
+
+// paintResult is what a paint returned
+type paintResult struct {
+	seq  uint64
+	ts   int64
+	prev uint8
+	err  error
+}
+
+// queuedPaint is a validated paint waiting for Redis to come back. Every
+// attempt runs under writeID, so one that was applied but whose reply was
+// lost isn't applied again by the next.
+type queuedPaint struct {
+	req      PaintRequest
+	subject  string
+	writeID  string
+	paint    paintFunc
+	deadline time.Time
+	state    atomic.Int32
+	result   chan paintResult
+}
+
+// paintQueue holds validated paints while Redis is unreachable and applies
+// them one at a time, in arrival order, once it answers again. While any
+// paint is queued new ones queue behind it rather than going straight to
+// Redis, so a subject's paints land in the order they were made.
+type paintQueue struct {
+	size    int
+	timeout time.Duration
+
+	mu     sync.Mutex
+	paints []*queuedPaint
+	// subjects maps each cooling-down subject with a paint queued to it
+	subjects map[string]*queuedPaint
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newPaintQueue starts a queue of up to size paints, each waiting at most
+// timeout to be applied
+func newPaintQueue(size int, timeout time.Duration) *paintQueue {
+	q := &paintQueue{
+		size:     size,
+		timeout:  timeout,
+		subjects: make(map[string]*queuedPaint),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// busy reports whether paints are waiting
+func (q *paintQueue) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.paints) > 0
+}
+
+// pending returns how many paints are waiting
+func (q *paintQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.paints)
+}
+
+// submit queues a paint, attempted under writeID, and waits for it to be
+// applied, returning errPaintUnavailable if the queue is full or the
+// timeout passes first. A subject, when given, may have one paint queued at
+// a time; a second returns errPaintQueued.
+func (q *paintQueue) submit(req PaintRequest, subject, writeID string, paint paintFunc) paintResult {
+	job := &queuedPaint{
+		req:      req,
+		subject:  subject,
+		writeID:  writeID,
+		paint:    paint,
+		deadline: time.Now().Add(q.timeout),
+		result:   make(chan paintResult, 1),
+	}
+
+	q.mu.Lock()
+	if subject != "" && q.subjects[subject] != nil {
+		q.mu.Unlock()
+		return paintResult{err: errPaintQueued}
+	}
+	if len(q.paints) >= q.size {
+		q.mu.Unlock()
+		return paintResult{err: errPaintUnavailable}
+	}
+	q.paints = append(q.paints, job)
+	if subject != "" {
+		q.subjects[subject] = job
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-job.result:
+		return res
+	case <-timer.C:
+		if job.state.CompareAndSwap(paintPending, paintAbandoned) {
+			q.release(job)
+			return paintResult{err: errPaintUnavailable}
+		}
+		// Being applied right now; its outcome is moments away
+		return <-job.result
+	}
+}
+
+// run applies queued paints until close
+func (q *paintQueue) run() {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		var job *queuedPaint
+		if len(q.paints) > 0 {
+			job = q.paints[0]
+		}
+		q.mu.Unlock()
+
+		if job == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+
+		if !job.state.CompareAndSwap(paintPending, paintApplying) {
+			q.pop() // abandoned
+			continue
+		}
+
+		// The handler's context may be gone by now; the deadline stands in
+		ctx, cancel := context.WithDeadline(redisclient.WithWriteID(context.Background(), job.writeID), job.deadline)
+		seq, ts, prev, err := job.paint(ctx, job.req.Cx, job.req.Cy, job.req.O, job.req.Color)
+		cancel()
+		if isRedisOutage(err) {
+			if time.Now().Before(job.deadline) {
+				// Give the handler its chance to give up while we wait
+				job.state.Store(paintPending)
+				select {
+				case <-time.After(paintQueueRetry):
+					continue
+				case <-q.stop:
+					return
+				}
+			}
+			err = errPaintUnavailable
+		}
+		q.pop()
+		job.result <- paintResult{seq: seq, ts: ts, prev: prev, err: err}
+	}
+}
+
+// pop removes the paint at the front of the queue
+func (q *paintQueue) pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(q.paints[0])
+	q.paints[0] = nil
+	q.paints = q.paints[1:]
+}
+
+// release lets job's subject queue another paint
+func (q *paintQueue) release(job *queuedPaint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(job)
+}
+
+// releaseLocked is release with mu held
+func (q *paintQueue) releaseLocked(job *queuedPaint) {
+	if job.subject != "" && q.subjects[job.subject] == job {
+		delete(q.subjects, job.subject)
+	}
+}
+
+// close stops applying paints. Handlers still waiting give up at their
+// timeout.
+func (q *paintQueue) close() {
+	close(q.stop)
+	<-q.done
+}
+
+// isRedisOutage reports whether a paint failed for want of Redis, which
+// waiting may fix, rather than being refused or getting a reply it
+// couldn't read, which would only fail again
+func isRedisOutage(err error) bool {
+	return redisclient.IsUnavailable(err)
+}
+
+// paint applies a validated paint. With the queue enabled, a paint that
+// finds Redis unreachable, or arrives while earlier ones are still queued,
+// waits in the queue instead of failing outright; one whose client went
+// away isn't queued. A queued paint keeps the write id of its first
+// attempt. subject, the cooldown key when a cooldown applies, limits the
+// queue to one of its paints.
+func (h *Handler) paint(ctx context.Context, req PaintRequest, subject string, paint paintFunc) (uint64, int64, uint8, error) {
+	var writeID string
+	if h.paintQueue != nil {
+		writeID = redisclient.NewWriteID()
+		ctx = redisclient.WithWriteID(ctx, writeID)
+	}
+	if h.paintQueue == nil || !h.paintQueue.busy() {
+		start := time.Now()
+		seq, ts, prev, err := paint(ctx, req.Cx, req.Cy, req.O, req.Color)
+		h.metrics.ObservePaint(time.Since(start))
+		if h.paintQueue == nil || !isRedisOutage(err) || errors.Is(err, context.Canceled) {
+			return seq, ts, prev, err
+		}
+		h.redisError("paint", err)
+	}
+
+	res := h.paintQueue.submit(req, subject, writeID, paint)
+	return res.seq, res.ts, res.prev, res.err
+}
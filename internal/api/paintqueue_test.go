@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"splat-boston/internal/bits"
+)
+
+func TestPaintQueueAppliesPaintAfterBriefOutage(t *testing.T) {
+	config := testConfig()
+	config.PaintQueueSize = 8
+	config.PaintQueueTimeoutMs = 5000
+	h, mr := newTestHandler(t, config)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	done := make(chan int)
+	var body string
+	go func() {
+		w := postPaint(h, bostonPaint(7, 4), "10.0.0.1")
+		body = w.Body.String()
+		done <- w.Code
+	}()
+
+	for deadline := time.Now().Add(2 * time.Second); h.paintQueue.pending() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("paint was never queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mr.SetError("")
+
+	select {
+	case code := <-done:
+		if code != 200 {
+			t.Fatalf("Expected the queued paint to succeed, got %d: %s", code, body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("queued paint was never applied")
+	}
+
+	var resp PaintResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Seq != 1 {
+		t.Errorf("Expected seq 1 from the queued paint, got %s", body)
+	}
+	buf, _, err := h.rdb.GetChunkSnapshot(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color := bits.GetNibble(buf, 7); color != 4 {
+		t.Errorf("Expected tile 7 painted 4 after recovery, got %d", color)
+	}
+}
+
+func TestPaintQueueExtendedOutageReturns503(t *testing.T) {
+	config := testConfig()
+	config.PaintQueueSize = 8
+	config.PaintQueueTimeoutMs = 150
+	h, mr := newTestHandler(t, config)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	w := postPaint(h, bostonPaint(7, 4), "10.0.0.1")
+	if w.Code != 503 || !strings.Contains(w.Body.String(), CodeRedisUnavailable) {
+		t.Fatalf("Expected 503 %s, got %d: %s", CodeRedisUnavailable, w.Code, w.Body.String())
+	}
+	mr.SetError("")
+
+	// The abandoned paint must not land once Redis is back
+	time.Sleep(2 * paintQueueRetry)
+	if n := h.paintQueue.pending(); n != 0 {
+		t.Errorf("Expected the queue to drain, %d paints left", n)
+	}
+	buf, seq, err := h.rdb.GetChunkSnapshot(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 0 || bits.GetNibble(buf, 7) != 0 {
+		t.Errorf("Expected the timed-out paint not to be applied, seq %d", seq)
+	}
+}
+
+func TestPaintQueueDoesNotHoldScriptErrors(t *testing.T) {
+	config := testConfig()
+	config.PaintQueueSize = 8
+	config.PaintQueueTimeoutMs = 5000
+	h, mr := newTestHandler(t, config)
+
+	// A refusal that would recur fails at once instead of waiting out the
+	// queue timeout, and doesn't send other paints into the queue
+	mr.SetError("ERR Error running script: bad argument")
+	start := time.Now()
+	w := postPaint(h, bostonPaint(7, 4), "10.0.0.1")
+	if w.Code != 500 || !strings.Contains(w.Body.String(), CodeRedis) {
+		t.Fatalf("Expected 500 %s, got %d: %s", CodeRedis, w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the script error returned at once, took %v", elapsed)
+	}
+	if n := h.paintQueue.pending(); n != 0 {
+		t.Errorf("Expected nothing queued, %d paints are", n)
+	}
+}
+
+func TestPaintQueueHoldsOnePaintPerSubject(t *testing.T) {
+	config := testConfig()
+	config.PaintQueueSize = 8
+	config.PaintQueueTimeoutMs = 5000
+	h, mr := newTestHandler(t, config)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	done := make(chan int)
+	go func() {
+		done <- postPaint(h, bostonPaint(7, 4), "10.0.0.1").Code
+	}()
+	for deadline := time.Now().Add(2 * time.Second); h.paintQueue.pending() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("paint was never queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Both passed the cooldown check, since neither has landed, but only
+	// the first may wait to
+	w := postPaint(h, bostonPaint(8, 4), "10.0.0.1")
+	if w.Code != 429 || !strings.Contains(w.Body.String(), CodeCooldown) {
+		t.Errorf("Expected 429 %s for a second queued paint, got %d: %s", CodeCooldown, w.Code, w.Body.String())
+	}
+	go func() {
+		done <- postPaint(h, bostonPaint(9, 4), "10.0.0.2").Code
+	}()
+	for deadline := time.Now().Add(2 * time.Second); h.paintQueue.pending() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("another subject's paint was never queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mr.SetError("")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case code := <-done:
+			if code != 200 {
+				t.Errorf("Expected the queued paints to succeed, got %d", code)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("queued paint was never applied")
+		}
+	}
+	if seq, _ := h.rdb.GetChunkSeq(0, 0); seq != 2 {
+		t.Errorf("Expected two paints applied, seq is %d", seq)
+	}
+}
@@ -0,0 +1,6 @@
+// Package pb contains the protobuf wire types for mobile SDKs.
+//
+// paint.pb.go is generated from paint.proto; regenerate with go generate.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative paint.proto
@@ -0,0 +1,372 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.0
+// 	protoc        (unknown)
+// source: paint.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PaintRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Lat            float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon            float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	Cx             int64                  `protobuf:"varint,3,opt,name=cx,proto3" json:"cx,omitempty"`
+	Cy             int64                  `protobuf:"varint,4,opt,name=cy,proto3" json:"cy,omitempty"`
+	O              int32                  `protobuf:"varint,5,opt,name=o,proto3" json:"o,omitempty"`
+	Color          uint32                 `protobuf:"varint,6,opt,name=color,proto3" json:"color,omitempty"`
+	TurnstileToken string                 `protobuf:"bytes,7,opt,name=turnstile_token,json=turnstileToken,proto3" json:"turnstile_token,omitempty"`
+	ClientId       string                 `protobuf:"bytes,8,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	NetLat         *float64               `protobuf:"fixed64,9,opt,name=net_lat,json=netLat,proto3,oneof" json:"net_lat,omitempty"`
+	NetLon         *float64               `protobuf:"fixed64,10,opt,name=net_lon,json=netLon,proto3,oneof" json:"net_lon,omitempty"`
+	PaletteId      string                 `protobuf:"bytes,11,opt,name=palette_id,json=paletteId,proto3" json:"palette_id,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *PaintRequest) Reset() {
+	*x = PaintRequest{}
+	mi := &file_paint_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaintRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaintRequest) ProtoMessage() {}
+
+func (x *PaintRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_paint_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaintRequest.ProtoReflect.Descriptor instead.
+func (*PaintRequest) Descriptor() ([]byte, []int) {
+	return file_paint_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PaintRequest) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetCx() int64 {
+	if x != nil {
+		return x.Cx
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetCy() int64 {
+	if x != nil {
+		return x.Cy
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetO() int32 {
+	if x != nil {
+		return x.O
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetColor() uint32 {
+	if x != nil {
+		return x.Color
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetTurnstileToken() string {
+	if x != nil {
+		return x.TurnstileToken
+	}
+	return ""
+}
+
+func (x *PaintRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *PaintRequest) GetNetLat() float64 {
+	if x != nil && x.NetLat != nil {
+		return *x.NetLat
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetNetLon() float64 {
+	if x != nil && x.NetLon != nil {
+		return *x.NetLon
+	}
+	return 0
+}
+
+func (x *PaintRequest) GetPaletteId() string {
+	if x != nil {
+		return x.PaletteId
+	}
+	return ""
+}
+
+type PaintResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Ts            int64                  `protobuf:"varint,3,opt,name=ts,proto3" json:"ts,omitempty"`
+	WsToken       string                 `protobuf:"bytes,4,opt,name=ws_token,json=wsToken,proto3" json:"ws_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaintResponse) Reset() {
+	*x = PaintResponse{}
+	mi := &file_paint_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaintResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaintResponse) ProtoMessage() {}
+
+func (x *PaintResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_paint_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaintResponse.ProtoReflect.Descriptor instead.
+func (*PaintResponse) Descriptor() ([]byte, []int) {
+	return file_paint_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PaintResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *PaintResponse) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PaintResponse) GetTs() int64 {
+	if x != nil {
+		return x.Ts
+	}
+	return 0
+}
+
+func (x *PaintResponse) GetWsToken() string {
+	if x != nil {
+		return x.WsToken
+	}
+	return ""
+}
+
+type Delta struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	O             uint32                 `protobuf:"varint,2,opt,name=o,proto3" json:"o,omitempty"`
+	Color         uint32                 `protobuf:"varint,3,opt,name=color,proto3" json:"color,omitempty"`
+	Ts            int64                  `protobuf:"varint,4,opt,name=ts,proto3" json:"ts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Delta) Reset() {
+	*x = Delta{}
+	mi := &file_paint_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Delta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Delta) ProtoMessage() {}
+
+func (x *Delta) ProtoReflect() protoreflect.Message {
+	mi := &file_paint_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Delta.ProtoReflect.Descriptor instead.
+func (*Delta) Descriptor() ([]byte, []int) {
+	return file_paint_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Delta) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Delta) GetO() uint32 {
+	if x != nil {
+		return x.O
+	}
+	return 0
+}
+
+func (x *Delta) GetColor() uint32 {
+	if x != nil {
+		return x.Color
+	}
+	return 0
+}
+
+func (x *Delta) GetTs() int64 {
+	if x != nil {
+		return x.Ts
+	}
+	return 0
+}
+
+var File_paint_proto protoreflect.FileDescriptor
+
+var file_paint_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x70, 0x61, 0x69, 0x6e, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x73,
+	0x70, 0x6c, 0x61, 0x74, 0x2e, 0x76, 0x31, 0x22, 0xaf, 0x02, 0x0a, 0x0c, 0x50, 0x61, 0x69, 0x6e,
+	0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x61, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6c, 0x61, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x6f,
+	0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6c, 0x6f, 0x6e, 0x12, 0x0e, 0x0a, 0x02,
+	0x63, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x63, 0x78, 0x12, 0x0e, 0x0a, 0x02,
+	0x63, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x63, 0x79, 0x12, 0x0c, 0x0a, 0x01,
+	0x6f, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x01, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f,
+	0x6c, 0x6f, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x63, 0x6f, 0x6c, 0x6f, 0x72,
+	0x12, 0x27, 0x0a, 0x0f, 0x74, 0x75, 0x72, 0x6e, 0x73, 0x74, 0x69, 0x6c, 0x65, 0x5f, 0x74, 0x6f,
+	0x6b, 0x65, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x74, 0x75, 0x72, 0x6e, 0x73,
+	0x74, 0x69, 0x6c, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69,
+	0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x1c, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x5f, 0x6c, 0x61,
+	0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x06, 0x6e, 0x65, 0x74, 0x4c, 0x61,
+	0x74, 0x88, 0x01, 0x01, 0x12, 0x1c, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x5f, 0x6c, 0x6f, 0x6e, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x01, 0x48, 0x01, 0x52, 0x06, 0x6e, 0x65, 0x74, 0x4c, 0x6f, 0x6e, 0x88,
+	0x01, 0x01, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x61, 0x6c, 0x65, 0x74, 0x74, 0x65, 0x5f, 0x69, 0x64,
+	0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x61, 0x6c, 0x65, 0x74, 0x74, 0x65, 0x49,
+	0x64, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x6e, 0x65, 0x74, 0x5f, 0x6c, 0x61, 0x74, 0x42, 0x0a, 0x0a,
+	0x08, 0x5f, 0x6e, 0x65, 0x74, 0x5f, 0x6c, 0x6f, 0x6e, 0x22, 0x5c, 0x0a, 0x0d, 0x50, 0x61, 0x69,
+	0x6e, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6b,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65,
+	0x71, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x0e, 0x0a, 0x02,
+	0x74, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x74, 0x73, 0x12, 0x19, 0x0a, 0x08,
+	0x77, 0x73, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x77, 0x73, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x4d, 0x0a, 0x05, 0x44, 0x65, 0x6c, 0x74, 0x61,
+	0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73,
+	0x65, 0x71, 0x12, 0x0c, 0x0a, 0x01, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x01, 0x6f,
+	0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x02, 0x74, 0x73, 0x42, 0x1e, 0x5a, 0x1c, 0x73, 0x70, 0x6c, 0x61, 0x74, 0x2d,
+	0x62, 0x6f, 0x73, 0x74, 0x6f, 0x6e, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f,
+	0x61, 0x70, 0x69, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_paint_proto_rawDescOnce sync.Once
+	file_paint_proto_rawDescData = file_paint_proto_rawDesc
+)
+
+func file_paint_proto_rawDescGZIP() []byte {
+	file_paint_proto_rawDescOnce.Do(func() {
+		file_paint_proto_rawDescData = protoimpl.X.CompressGZIP(file_paint_proto_rawDescData)
+	})
+	return file_paint_proto_rawDescData
+}
+
+var file_paint_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_paint_proto_goTypes = []any{
+	(*PaintRequest)(nil),  // 0: splat.v1.PaintRequest
+	(*PaintResponse)(nil), // 1: splat.v1.PaintResponse
+	(*Delta)(nil),         // 2: splat.v1.Delta
+}
+var file_paint_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_paint_proto_init() }
+func file_paint_proto_init() {
+	if File_paint_proto != nil {
+		return
+	}
+	file_paint_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_paint_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_paint_proto_goTypes,
+		DependencyIndexes: file_paint_proto_depIdxs,
+		MessageInfos:      file_paint_proto_msgTypes,
+	}.Build()
+	File_paint_proto = out.File
+	file_paint_proto_rawDesc = nil
+	file_paint_proto_goTypes = nil
+	file_paint_proto_depIdxs = nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"splat-boston/internal/api/pb"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+
+	// maxProtobufBody bounds how much of a protobuf paint body we read
+	maxProtobufBody = 4096
+)
+
+// isProtobufRequest reports whether the request body is a protobuf message
+func isProtobufRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == contentTypeProtobuf
+}
+
+// wantsProtobuf reports whether the client asked for a protobuf response.
+// JSON stays the default unless protobuf is explicitly accepted.
+func wantsProtobuf(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == contentTypeProtobuf {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeProtoPaintRequest reads a protobuf PaintRequest into req
+func decodeProtoPaintRequest(body io.Reader, req *PaintRequest) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxProtobufBody))
+	if err != nil {
+		return err
+	}
+
+	var msg pb.PaintRequest
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	// Reject values the JSON path could never have produced rather than
+	// letting them truncate into range
+	if msg.Color > math.MaxUint8 {
+		return fmt.Errorf("color %d out of range", msg.Color)
+	}
+
+	*req = PaintRequest{
+		Lat:            msg.Lat,
+		Lon:            msg.Lon,
+		Cx:             msg.Cx,
+		Cy:             msg.Cy,
+		O:              int(msg.O),
+		Color:          uint8(msg.Color),
+		TurnstileToken: msg.TurnstileToken,
+		ClientID:       msg.ClientId,
+		NetLat:         msg.NetLat,
+		NetLon:         msg.NetLon,
+		PaletteID:      msg.PaletteId,
+	}
+	return nil
+}
+
+// writePaintResponse encodes the paint response in the negotiated format
+func writePaintResponse(w http.ResponseWriter, r *http.Request, response PaintResponse) {
+	if !wantsProtobuf(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	data, err := proto.Marshal(&pb.PaintResponse{
+		Ok:      response.Ok,
+		Seq:     response.Seq,
+		Ts:      response.Ts,
+		WsToken: response.WSToken,
+	})
+	if err != nil {
+		writeError(w, 500, CodeInternal, "encode")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.Write(data)
+}
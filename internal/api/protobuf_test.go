@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"splat-boston/internal/api/pb"
+	"splat-boston/internal/bits"
+)
+
+func TestPostPaintProtobufRoundTrip(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	body, err := proto.Marshal(&pb.PaintRequest{
+		Lat:   42.3601,
+		Lon:   -71.0589,
+		Cx:    3,
+		Cy:    4,
+		O:     7,
+		Color: 9,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentTypeProtobuf)
+	r.Header.Set("Accept", contentTypeProtobuf)
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != contentTypeProtobuf {
+		t.Fatalf("Expected protobuf response, got %q", ct)
+	}
+
+	var resp pb.PaintResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if !resp.Ok || resp.Seq != 1 || resp.Ts == 0 {
+		t.Errorf("Unexpected response: %+v", &resp)
+	}
+
+	// The decoded request must have painted the right tile
+	buf, err := h.rdb.GetChunkBits(3, 4)
+	if err != nil {
+		t.Fatalf("GetChunkBits failed: %v", err)
+	}
+	if got := bits.GetNibble(buf, 7); got != 9 {
+		t.Errorf("Expected color 9 at offset 7, got %d", got)
+	}
+}
+
+func TestPostPaintProtobufResponseToJSONRequest(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader([]byte(`{"lat":42.36,"lon":-71.05,"o":1,"color":2}`)))
+	r.Header.Set("Content-Type", contentTypeJSON)
+	r.Header.Set("Accept", "application/json;q=0.5, application/x-protobuf")
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp pb.PaintResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Seq != 1 {
+		t.Errorf("Expected seq 1, got %d", resp.Seq)
+	}
+}
+
+func TestPostPaintProtobufRejectsOversizedColor(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+
+	body, _ := proto.Marshal(&pb.PaintRequest{Lat: 42.36, Lon: -71.05, Color: 256 + 3})
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentTypeProtobuf)
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+
+	if w.Code != 400 {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestPostPaintProtobufNamesThePalette(t *testing.T) {
+	config := testConfig()
+	config.Palettes = map[string][16]string{"neon": DefaultPalette}
+	h, _ := newTestHandler(t, config)
+	if err := h.rdb.SetChunkPaletteID(3, 4, "neon"); err != nil {
+		t.Fatal(err)
+	}
+
+	paint := func(paletteID string) int {
+		body, _ := proto.Marshal(&pb.PaintRequest{Lat: 42.3601, Lon: -71.0589, Cx: 3, Cy: 4, O: 7, Color: 9, PaletteId: paletteID})
+		r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentTypeProtobuf)
+		w := httptest.NewRecorder()
+		h.PostPaint(w, r)
+		return w.Code
+	}
+	if code := paint(""); code != 409 {
+		t.Errorf("Expected 409 without the chunk's palette, got %d", code)
+	}
+	if code := paint("neon"); code != 200 {
+		t.Errorf("Expected 200 naming the chunk's palette, got %d", code)
+	}
+}
+
+func TestPostPaintProtobufCarriesWSToken(t *testing.T) {
+	config := testConfig()
+	config.WSRequireAuth = true
+	config.WSTokenSecret = "s3cret"
+	h, _ := newTestHandler(t, config)
+
+	body, _ := proto.Marshal(&pb.PaintRequest{Lat: 42.3601, Lon: -71.0589, O: 7, Color: 9})
+	r := httptest.NewRequest(http.MethodPost, "/paint", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentTypeProtobuf)
+	r.Header.Set("Accept", contentTypeProtobuf)
+	r.Header.Set("CF-Connecting-IP", "10.0.0.1")
+	w := httptest.NewRecorder()
+	h.PostPaint(w, r)
+
+	var resp pb.PaintResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); w.Code != 200 || err != nil {
+		t.Fatalf("Expected a protobuf response, got %d: %v", w.Code, err)
+	}
+	if err := verifyWSToken(h.wsTokenSecret, resp.WsToken, "10.0.0.1", time.Now()); err != nil {
+		t.Errorf("Expected a /sub token for the painter, got %q: %v", resp.WsToken, err)
+	}
+}
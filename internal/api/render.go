@@ -0,0 +1,197 @@
+package api
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"splat-boston/internal/bits"
+	redisclient "splat-boston/internal/redis"
+)
+
+// maxRenderChunks bounds the chunks one full render covers: 32×32 chunks is
+// an 8192×8192 image
+const maxRenderChunks = 1024
+
+// hexToColor converts a palette entry to an opaque color
+func hexToColor(hex string) color.NRGBA {
+	v, _ := strconv.ParseUint(hex[1:], 16, 32)
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}
+}
+
+// renderPalette is the PNG palette of a render: the default palette's 16
+// colors, then 16 for each named palette, in a fixed order. Index 0 of
+// each is transparent, as the web client draws unpainted tiles.
+type renderPalette struct {
+	colors color.Palette
+	// base is where each named palette's colors start
+	base map[string]uint8
+}
+
+// newRenderPalette builds the palette for the handler's palettes. A PNG
+// palette holds 256 colors, so named palettes past the 15th draw in the
+// default palette.
+func (h *Handler) newRenderPalette() renderPalette {
+	p := renderPalette{base: make(map[string]uint8)}
+	add := func(palette [16]string) {
+		p.colors = append(p.colors, color.NRGBA{})
+		for _, hex := range palette[1:] {
+			p.colors = append(p.colors, hexToColor(hex))
+		}
+	}
+	add(h.config.Palette)
+
+	ids := make([]string, 0, len(h.config.Palettes))
+	for id := range h.config.Palettes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if len(p.colors) == 256 {
+			h.logger.Warn("api: render: no room for palette, drawing its chunks in the default", "palette", id)
+			continue
+		}
+		p.base[id] = uint8(len(p.colors))
+		add(h.config.Palettes[id])
+	}
+	return p
+}
+
+// canvasImage renders a rectangle of chunks a row of chunks at a time, so
+// encoding it holds only one row in memory. PNG encoders read rows top to
+// bottom, which is all it supports efficiently.
+type canvasImage struct {
+	rdb     *redisclient.Client
+	palette renderPalette
+
+	minCx, minCy int64
+	width        int // in chunks
+	height       int
+
+	// row is the loaded row of chunks, rowCy its chunk y
+	row    []redisclient.ChunkSnapshot
+	rowCy  int64
+	loaded bool
+	// err is the first read error; later pixels draw transparent
+	err error
+}
+
+func (m *canvasImage) ColorModel() color.Model { return m.palette.colors }
+
+func (m *canvasImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.width*chunkWidth, m.height*chunkWidth)
+}
+
+func (m *canvasImage) At(x, y int) color.Color {
+	return m.palette.colors[m.ColorIndexAt(x, y)]
+}
+
+// ColorIndexAt makes canvasImage an image.PalettedImage, which the PNG
+// encoder writes without converting each pixel's color back to an index
+func (m *canvasImage) ColorIndexAt(x, y int) uint8 {
+	cy := m.minCy + int64(y/chunkWidth)
+	if !m.loaded || cy != m.rowCy {
+		m.loadRow(cy)
+	}
+	if m.err != nil {
+		return 0
+	}
+
+	snap := m.row[x/chunkWidth]
+	o := (y%chunkWidth)*chunkWidth + x%chunkWidth
+	c := bits.GetNibble(snap.Bits, o)
+	if c == 0 {
+		c = snap.Background
+	}
+	if c == 0 {
+		return 0
+	}
+	return m.palette.base[snap.PaletteID] + c
+}
+
+// loadRow reads the chunks of row cy in one round trip
+func (m *canvasImage) loadRow(cy int64) {
+	m.rowCy, m.loaded = cy, true
+	if m.err != nil {
+		return
+	}
+	refs := make([]redisclient.ChunkRef, m.width)
+	for i := range refs {
+		refs[i] = redisclient.ChunkRef{Cx: m.minCx + int64(i), Cy: cy}
+	}
+	m.row, m.err = m.rdb.GetChunkSnapshots(refs)
+}
+
+// renderBounds resolves the chunks GET /admin/render/full.png covers:
+// minCx, minCy, maxCx and maxCy when given, else the mask's chunks when one
+// is loaded, else every painted chunk. ok is false when nothing is painted.
+func (h *Handler) renderBounds(r *http.Request) (lo, hi redisclient.ChunkRef, ok bool, perr *ErrorDetail, err error) {
+	query := r.URL.Query()
+	if query.Has("minCx") || query.Has("minCy") || query.Has("maxCx") || query.Has("maxCy") {
+		lo, hi, perr = parseChunkRect(query)
+		return lo, hi, perr == nil, perr, nil
+	}
+
+	if h.mask != nil {
+		bounds := h.mask.Bounds()
+		lo.Cx, lo.Cy = h.proj.ChunkOf(bounds.MinX, bounds.MinY)
+		hi.Cx, hi.Cy = h.proj.ChunkOf(bounds.MaxX, bounds.MaxY)
+		return lo, hi, true, nil, nil
+	}
+	lo, hi, ok, err = h.rdb.PaintedBounds()
+	return lo, hi, ok, nil, err
+}
+
+// GetFullRender handles GET /admin/render/full.png, one tile per pixel over
+// the whole canvas. The PNG is encoded as chunk rows are read, so the
+// response starts before the canvas has been read; a Redis error partway
+// leaves the rest transparent and is logged.
+func (h *Handler) GetFullRender(w http.ResponseWriter, r *http.Request) {
+	lo, hi, ok, perr, err := h.renderBounds(r)
+	if perr != nil {
+		h.rejectParam(w, perr)
+		return
+	}
+	if err != nil {
+		h.redisError("render", err)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+	if !ok {
+		writeError(w, 404, CodeEmptyCanvas, "nothing painted")
+		return
+	}
+
+	// Each side is checked alone first: far-apart bounds overflow int64, so
+	// the spans are taken unsigned and the product only of small sides
+	spanX, spanY := uint64(hi.Cx-lo.Cx), uint64(hi.Cy-lo.Cy)
+	if spanX >= maxRenderChunks || spanY >= maxRenderChunks || (spanX+1)*(spanY+1) > maxRenderChunks {
+		writeError(w, 400, CodeTooManyChunks, fmt.Sprintf("chunks (%d, %d) to (%d, %d) are too many (max %d)", lo.Cx, lo.Cy, hi.Cx, hi.Cy, maxRenderChunks))
+		return
+	}
+	width, height := int64(spanX)+1, int64(spanY)+1
+
+	liftDeadlines(w)
+	img := &canvasImage{
+		rdb:     h.rdb,
+		palette: h.newRenderPalette(),
+		minCx:   lo.Cx,
+		minCy:   lo.Cy,
+		width:   int(width),
+		height:  int(height),
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Render-Origin", fmt.Sprintf("%d,%d", lo.Cx, lo.Cy))
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(w, img); err != nil {
+		h.logger.Warn("api: render failed", "width", width, "height", height, "err", err)
+		return
+	}
+	if img.err != nil {
+		h.redisError("render", img.err, "width", width, "height", height)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Routes returns the server's muxes. With separateAdmin, operator endpoints
+// (/admin/*, /debug/*, /metrics) go on their own mux for a listener on an internal
+// interface; otherwise everything is on public and admin is nil.
+func (h *Handler) Routes(separateAdmin bool) (public, admin *http.ServeMux) {
+	public = http.NewServeMux()
+	public.HandleFunc("/state/chunk", h.cors(h.readLimited(h.GetChunk)))
+	public.HandleFunc("/state/chunk/stats", h.cors(h.readLimited(h.GetChunkStats)))
+	public.HandleFunc("/state/chunks", h.cors(h.readLimited(h.GetChunks)))
+	public.HandleFunc("/state/tiles", h.cors(h.readLimited(h.PostTiles)))
+	public.HandleFunc("/paint", h.cors(h.PostPaint))
+	public.HandleFunc("/paint/undo", h.cors(h.PostUndo))
+	public.HandleFunc("/me/limits", h.cors(h.GetLimits))
+	public.HandleFunc("/me/paintable", h.cors(h.readLimited(h.GetPaintable)))
+	public.HandleFunc("/config", h.cors(h.GetConfig))
+	public.HandleFunc("/sub", h.cors(h.HandleWebSocket))
+	public.HandleFunc("/sub/token", h.cors(h.PostWSToken))
+	public.HandleFunc("/healthz", h.cors(h.Healthz))
+
+	ops := public
+	if separateAdmin {
+		admin = http.NewServeMux()
+		admin.HandleFunc("/healthz", h.Healthz)
+		ops = admin
+	}
+	ops.HandleFunc("/debug/hub", h.cors(h.RequireAdmin(h.GetHubDebug)))
+	ops.HandleFunc("/stats/rejections/geo", h.cors(h.GetRejectionHotspots))
+	ops.Handle("/metrics", h.metrics.Handler())
+	ops.HandleFunc("/admin/explain", h.cors(h.RequireAdmin(h.PostExplain)))
+	ops.HandleFunc("/admin/mask", h.cors(h.RequireAdmin(h.PostMask)))
+	ops.HandleFunc("/admin/background", h.cors(h.RequireAdmin(h.PostBackground)))
+	ops.HandleFunc("/admin/palette", h.cors(h.RequireAdmin(h.PostPalette)))
+	ops.HandleFunc("/admin/fill", h.cors(h.RequireAdmin(h.PostFill)))
+	ops.HandleFunc("/admin/snapshot", h.cors(h.RequireAdmin(h.GetSnapshot)))
+	ops.HandleFunc("/admin/restore", h.cors(h.RequireAdmin(h.PostRestore)))
+	ops.HandleFunc("/admin/render/full.png", h.cors(h.RequireAdmin(h.GetFullRender)))
+	ops.HandleFunc("/admin/rooms", h.cors(h.RequireAdmin(h.GetRooms)))
+
+	return public, admin
+}
+
+// originAllowlist is the set of origins allowed cross-origin access
+type originAllowlist struct {
+	any     bool
+	origins map[string]bool
+}
+
+// parseOrigins reads a comma-separated origin list; a "*" entry allows any
+func parseOrigins(list string) originAllowlist {
+	allow := originAllowlist{origins: make(map[string]bool)}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			allow.any = true
+		default:
+			allow.origins[origin] = true
+		}
+	}
+	return allow
+}
+
+func (a originAllowlist) allows(origin string) bool {
+	return a.any || a.origins[origin]
+}
+
+// cors adds CORS headers for allowed origins and answers preflight requests.
+// Listed origins are echoed back with credentials allowed; a wildcard
+// allowlist sends "*".
+func (h *Handler) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := h.origins.any
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			// The response depends on Origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if allowed = origin != "" && h.origins.allows(origin); allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		}
+
+		// Handle preflight; without CORS headers the browser blocks the request
+		if r.Method == "OPTIONS" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Healthz handles GET /healthz
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	if err := h.rdb.Ping(); err != nil {
+		// Probes poll this every few seconds; the outage itself is logged
+		// by the requests it fails, so keep this to debug
+		h.logger.Debug("api: healthz: redis ping failed", "err", err)
+		writeError(w, 500, CodeRedis, "redis unhealthy")
+		return
+	}
+	w.WriteHeader(200)
+	w.Write([]byte("OK"))
+}
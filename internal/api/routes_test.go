@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRoutesOnlyOnAdminListener(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "s3cret"
+	h, _ := newTestHandler(t, config)
+
+	publicMux, adminMux := h.Routes(true)
+	public := httptest.NewServer(publicMux)
+	defer public.Close()
+	admin := httptest.NewServer(adminMux)
+	defer admin.Close()
+
+	explain := func(base string) int {
+		body, _ := json.Marshal(ExplainRequest{Subject: "10.0.0.1", Paint: bostonPaint(0, 3)})
+		req, _ := http.NewRequest(http.MethodPost, base+"/admin/explain", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", base, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(url string) int {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := explain(admin.URL); code != http.StatusOK {
+		t.Errorf("expected /admin/explain on the admin listener to return 200, got %d", code)
+	}
+	if code := explain(public.URL); code != http.StatusNotFound {
+		t.Errorf("expected /admin/explain on the public listener to return 404, got %d", code)
+	}
+	if code := get(public.URL + "/debug/hub"); code != http.StatusNotFound {
+		t.Errorf("expected /debug/hub on the public listener to return 404, got %d", code)
+	}
+	if code := get(public.URL + "/state/chunk?cx=0&cy=0"); code != http.StatusOK {
+		t.Errorf("expected the public API on the public listener, got %d", code)
+	}
+	if code := get(admin.URL + "/paint"); code != http.StatusNotFound {
+		t.Errorf("expected no paint API on the admin listener, got %d", code)
+	}
+	if code := get(admin.URL + "/healthz"); code != http.StatusOK {
+		t.Errorf("expected /healthz on the admin listener, got %d", code)
+	}
+}
+
+func TestRoutesKeepAdminOnPublicByDefault(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "s3cret"
+	h, _ := newTestHandler(t, config)
+
+	publicMux, adminMux := h.Routes(false)
+	if adminMux != nil {
+		t.Fatal("expected no admin mux without a separate admin listener")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/debug/hub", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	publicMux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected /debug/hub on the public mux, got %d", w.Code)
+	}
+
+	// Reachable, but only with the admin token
+	w = httptest.NewRecorder()
+	publicMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/hub", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected /debug/hub without a token to return 401, got %d", w.Code)
+	}
+}
+
+// preflight sends a CORS preflight for POST /paint from origin
+func preflight(h *Handler, origin string) *httptest.ResponseRecorder {
+	public, _ := h.Routes(false)
+	r := httptest.NewRequest(http.MethodOptions, "/paint", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSAllowsListedOrigin(t *testing.T) {
+	config := testConfig()
+	config.CORSOrigins = "https://splat.example, https://beta.splat.example"
+	h, _ := newTestHandler(t, config)
+
+	w := preflight(h, "https://beta.splat.example")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for an allowed preflight, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://beta.splat.example" {
+		t.Errorf("expected the origin echoed back, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("expected credentials allowed, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", got)
+	}
+
+	public, _ := h.Routes(false)
+	r := httptest.NewRequest(http.MethodGet, "/state/chunk?cx=0&cy=0", nil)
+	r.Header.Set("Origin", "https://splat.example")
+	get := httptest.NewRecorder()
+	public.ServeHTTP(get, r)
+	if got := get.Header().Get("Access-Control-Allow-Origin"); got != "https://splat.example" {
+		t.Errorf("expected the origin echoed on GET, got %q", got)
+	}
+}
+
+func TestCORSRejectsUnlistedOrigin(t *testing.T) {
+	config := testConfig()
+	config.CORSOrigins = "https://splat.example"
+	h, _ := newTestHandler(t, config)
+
+	w := preflight(h, "https://evil.example")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a disallowed preflight, got %d", w.Code)
+	}
+	for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Credentials"} {
+		if got := w.Header().Get(header); got != "" {
+			t.Errorf("expected no %s, got %q", header, got)
+		}
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", got)
+	}
+
+	// Browsers can't open a WebSocket from it either
+	r := httptest.NewRequest(http.MethodGet, "/sub", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	if h.upgrader.CheckOrigin(r) {
+		t.Error("expected the WebSocket origin check to fail")
+	}
+	r.Header.Del("Origin")
+	if !h.upgrader.CheckOrigin(r) {
+		t.Error("expected clients without an Origin to be allowed")
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	config := testConfig()
+	config.CORSOrigins = "*"
+	h, _ := newTestHandler(t, config)
+
+	w := preflight(h, "https://anywhere.example")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected *, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("expected no credentials with a wildcard, got %q", got)
+	}
+}
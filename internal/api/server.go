@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewServer returns an http.Server for handler on addr with the config's
+// timeouts. They don't reach /sub's connections: the upgrade hijacks the
+// connection and clears its deadlines, and from then on the hub's pings and
+// per-write deadlines decide when a socket is dead. The admin snapshot,
+// restore and full render transfers lift them too, with liftDeadlines.
+func (c Config) NewServer(addr string, handler http.Handler) *http.Server {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: ms(c.ReadHeaderTimeoutMs),
+		ReadTimeout:       ms(c.ReadTimeoutMs),
+		WriteTimeout:      ms(c.WriteTimeoutMs),
+		IdleTimeout:       ms(c.IdleTimeoutMs),
+	}
+}
+
+// liftDeadlines clears the server's read and write deadlines for one
+// request, whose transfer may rightly take longer than the timeouts allow
+// ordinary ones. A ResponseWriter that can't set deadlines has none to lift.
+func liftDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// Serve runs servers until ctx ends or one fails, then shuts them all
+// down: they stop accepting, in-flight requests get up to grace to finish,
+// and the hub tells WebSocket clients to reconnect elsewhere. It returns
+// nil after a shutdown ctx asked for, and the first server's error
+// otherwise.
+func (h *Handler) Serve(ctx context.Context, grace time.Duration, servers ...*http.Server) error {
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			h.shutdown(grace, servers)
+			return err
+		}
+		go func(server *http.Server) {
+			if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("serving %s: %w", server.Addr, err)
+			}
+		}(server)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		h.logger.Info("api: shutting down", "grace", grace)
+	case err = <-failed:
+	}
+	h.shutdown(grace, servers)
+	return err
+}
+
+// shutdown stops servers gracefully, closing any still busy after grace,
+// then drains the hub
+func (h *Handler) shutdown(grace time.Duration, servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			h.logger.Warn("api: requests still running after the grace period", "addr", server.Addr, "err", err)
+			server.Close()
+		}
+	}
+	h.hub.Close()
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"splat-boston/internal/ws"
+)
+
+// serveTimeouts runs the config's server for handler on a free port
+func serveTimeouts(t *testing.T, config Config, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := config.NewServer(ln.Addr().String(), handler)
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+func TestServerDropsSlowHeaders(t *testing.T) {
+	config := testConfig()
+	config.ReadHeaderTimeoutMs = 100
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+	addr := serveTimeouts(t, config, public)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Headers that never finish
+	start := time.Now()
+	io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: splat\r\n")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("Expected the server to close the connection, got %d bytes, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Connection closed after %v, before ReadHeaderTimeout", elapsed)
+	}
+}
+
+func TestServerTimeoutsSpareWebSockets(t *testing.T) {
+	config := testConfig()
+	config.ReadTimeoutMs = 100
+	config.WriteTimeoutMs = 100
+	config.IdleTimeoutMs = 100
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+	addr := serveTimeouts(t, config, public)
+
+	sub, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/sub?cx=0&cy=0", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer sub.Close()
+
+	// Outlive every server timeout, then expect a delta all the same
+	time.Sleep(300 * time.Millisecond)
+	if w := postPaint(h, bostonPaint(0, 3), "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("paint failed: %d %s", w.Code, w.Body.String())
+	}
+	sub.SetReadDeadline(time.Now().Add(time.Second))
+	var delta ws.Delta
+	if err := sub.ReadJSON(&delta); err != nil || delta.Seq != 1 {
+		t.Fatalf("Expected a delta after the timeouts passed, got %+v (%v)", delta, err)
+	}
+}
+
+// freeAddr returns a local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestServeShutsDownCleanly(t *testing.T) {
+	config := testConfig()
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ctx, time.Second, config.NewServer(addr, public)) }()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		resp, err := http.Get("http://" + addr + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("healthz never answered: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sub, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/sub?cx=0&cy=0", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer sub.Close()
+	closed := make(chan error, 1)
+	go func() {
+		sub.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			if _, _, err := sub.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean return, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after ctx ended")
+	}
+	if err := <-closed; !websocket.IsCloseError(err, ws.CloseReconnect) {
+		t.Errorf("Expected the WebSocket told to reconnect, got %v", err)
+	}
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Error("Expected the server to stop accepting")
+	}
+}
+
+func TestServeReturnsListenErrors(t *testing.T) {
+	h, _ := newTestHandler(t, testConfig())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	server := &http.Server{Addr: ln.Addr().String()}
+	if err := h.Serve(context.Background(), time.Second, server); err == nil {
+		t.Error("Expected an error for an address already in use")
+	}
+}
+
+func TestServerTimeoutsSpareAdminTransfers(t *testing.T) {
+	config := testConfig()
+	config.AdminToken = "secret"
+	config.ReadTimeoutMs = 100
+	config.WriteTimeoutMs = 100
+	h, _ := newTestHandler(t, config)
+	public, _ := h.Routes(false)
+	addr := serveTimeouts(t, config, public)
+
+	if w := postPaint(h, bostonPaint(5, 7), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+	snapshot := httptest.NewRecorder()
+	h.GetSnapshot(snapshot, httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil))
+
+	// An upload that trickles in over longer than ReadTimeout
+	body, upload := io.Pipe()
+	go func() {
+		file := snapshot.Body.Bytes()
+		upload.Write(file[:10])
+		time.Sleep(300 * time.Millisecond)
+		upload.Write(file[10:])
+		upload.Close()
+	}()
+	r, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/admin/restore", body)
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Expected the slow restore to finish: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		t.Errorf("Expected 200 for the slow restore, got %d: %s", resp.StatusCode, b)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+
+	redisclient "splat-boston/internal/redis"
+)
+
+// staleMaxAgeS is how long a stale chunk may be cached, kept short so
+// clients pick up the real chunk soon after Redis is back
+const staleMaxAgeS = 1
+
+// staleChunks remembers the last snapshot GetChunk read of recently read
+// chunks, so a read can still be answered while Redis is unreachable. It
+// is bounded to the most recently read chunks; each costs 32KB.
+type staleChunks struct {
+	size int
+
+	mu     sync.Mutex
+	chunks map[redisclient.ChunkRef]*list.Element
+	lru    *list.List // of redisclient.ChunkSnapshot, most recent first
+}
+
+// newStaleChunks returns a store of up to size chunks, or nil for size 0
+func newStaleChunks(size int) *staleChunks {
+	if size <= 0 {
+		return nil
+	}
+	return &staleChunks{
+		size:   size,
+		chunks: make(map[redisclient.ChunkRef]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// put remembers a snapshot just read from Redis
+func (s *staleChunks) put(snap redisclient.ChunkSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.chunks[snap.ChunkRef]; ok {
+		elem.Value = snap
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.chunks[snap.ChunkRef] = s.lru.PushFront(snap)
+	if s.lru.Len() > s.size {
+		oldest := s.lru.Remove(s.lru.Back()).(redisclient.ChunkSnapshot)
+		delete(s.chunks, oldest.ChunkRef)
+	}
+}
+
+// get returns the last snapshot read of a chunk, if it is still held
+func (s *staleChunks) get(chunk redisclient.ChunkRef) (redisclient.ChunkSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.chunks[chunk]
+	if !ok {
+		return redisclient.ChunkSnapshot{}, false
+	}
+	return elem.Value.(redisclient.ChunkSnapshot), true
+}
+
+// setStaleCache marks a read answered from staleChunks, replacing
+// setReadCache's lifetime with a short one
+func setStaleCache(w http.ResponseWriter) {
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staleMaxAgeS))
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"splat-boston/internal/bits"
+	redisclient "splat-boston/internal/redis"
+)
+
+func TestGetChunkServesStaleWhileRedisDown(t *testing.T) {
+	config := testConfig()
+	config.StaleChunks = 8
+	h, mr := newTestHandler(t, config)
+
+	getChunk := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/state/chunk?"+query, nil)
+		w := httptest.NewRecorder()
+		h.GetChunk(w, r)
+		return w
+	}
+
+	if w := postPaint(h, bostonPaint(7, 4), "10.0.0.1"); w.Code != 200 {
+		t.Fatalf("paint: status %d: %s", w.Code, w.Body.String())
+	}
+	fresh := getChunk("cx=0&cy=0")
+	if fresh.Code != 200 || fresh.Header().Get("Warning") != "" {
+		t.Fatalf("Expected a fresh read, got %d with Warning %q", fresh.Code, fresh.Header().Get("Warning"))
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	w := getChunk("cx=0&cy=0")
+	if w.Code != 200 {
+		t.Fatalf("Expected the last read served while Redis is down, got %d: %s", w.Code, w.Body.String())
+	}
+	if warning := w.Header().Get("Warning"); warning != `110 - "Response is Stale"` {
+		t.Errorf("Warning = %q, want 110", warning)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=1" {
+		t.Errorf("Cache-Control = %q, want a short max-age", cc)
+	}
+	if w.Header().Get("X-Seq") != "1" || !bytes.Equal(w.Body.Bytes(), fresh.Body.Bytes()) || bits.GetNibble(w.Body.Bytes(), 7) != 4 {
+		t.Errorf("Expected the stale read to match the fresh one at seq 1, got seq %s", w.Header().Get("X-Seq"))
+	}
+
+	// Chunks never read, and borders, still fail
+	if w := getChunk("cx=1&cy=1"); w.Code != 500 {
+		t.Errorf("Expected 500 for a chunk never read, got %d", w.Code)
+	}
+	if w := getChunk("cx=0&cy=0&border=1"); w.Code != 500 {
+		t.Errorf("Expected 500 for a border read, got %d", w.Code)
+	}
+
+	// Paints aren't helped
+	if w := postPaint(h, bostonPaint(8, 4), "10.0.0.2"); w.Code == 200 {
+		t.Error("Expected a paint to fail while Redis is down")
+	}
+}
+
+func TestStaleChunksKeepsMostRecentlyRead(t *testing.T) {
+	s := newStaleChunks(2)
+	for cx := int64(0); cx < 3; cx++ {
+		s.put(redisclient.ChunkSnapshot{ChunkRef: redisclient.ChunkRef{Cx: cx}, Seq: uint64(cx)})
+	}
+	if _, ok := s.get(redisclient.ChunkRef{Cx: 0}); ok {
+		t.Error("Expected the oldest chunk evicted")
+	}
+	if snap, ok := s.get(redisclient.ChunkRef{Cx: 2}); !ok || snap.Seq != 2 {
+		t.Errorf("Expected the newest chunk held, got %+v", snap)
+	}
+	if newStaleChunks(0) != nil {
+		t.Error("Expected no store when disabled")
+	}
+}
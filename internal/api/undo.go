@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"splat-boston/internal/events"
+	redisclient "splat-boston/internal/redis"
+	"splat-boston/internal/ws"
+)
+
+// UndoRequest names the paint to undo by its tile and the seq POST /paint
+// returned for it
+type UndoRequest struct {
+	Cx  int64  `json:"cx"`
+	Cy  int64  `json:"cy"`
+	O   int    `json:"o"`
+	Seq uint64 `json:"seq"`
+}
+
+// PostUndo handles POST /paint/undo, putting back the color a tile had
+// before the caller's last paint. It fails with 409 if anyone has painted
+// the tile since, so an undo never erases someone else's paint. The revert
+// is a paint of its own, with a new seq, and is broadcast as a delta.
+func (h *Handler) PostUndo(w http.ResponseWriter, r *http.Request) {
+	if h.config.UndoWindowS <= 0 {
+		writeError(w, 404, CodeUndoUnavailable, "undo is disabled")
+		return
+	}
+
+	var req UndoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+	if !validOffset(req.O) {
+		writeError(w, 400, CodeInvalidOffset, "offset out of range")
+		return
+	}
+
+	ctx, cancel := h.redisContext(r)
+	defer cancel()
+	seq, ts, restored, undone, err := h.rdb.UndoPaintContext(ctx, h.clientIP(r), req.Cx, req.Cy, req.O, req.Seq)
+	switch {
+	case err == redisclient.ErrUndoUnavailable:
+		writeError(w, 404, CodeUndoUnavailable, "no paint to undo")
+		return
+	case err == redisclient.ErrUndoConflict:
+		writeError(w, 409, CodeUndoConflict, "tile painted since")
+		return
+	case err != nil:
+		h.redisError("undo", err)
+		writeError(w, 500, CodeRedis, "redis error")
+		return
+	}
+
+	h.hub.Publish(req.Cx, req.Cy, ws.Delta{
+		Seq:   seq,
+		O:     req.O,
+		Color: restored,
+		Ts:    ts,
+	})
+	if h.events != nil {
+		h.events.Emit(events.PaintEvent{
+			Cx:    req.Cx,
+			Cy:    req.Cy,
+			O:     req.O,
+			Color: restored,
+			Prev:  undone,
+			Seq:   seq,
+			Ts:    ts,
+		})
+	}
+
+	writePaintResponse(w, r, PaintResponse{Ok: true, Seq: seq, Ts: ts})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"splat-boston/internal/bits"
+)
+
+// postUndo sends an undo request from the given IP
+func postUndo(h *Handler, req UndoRequest, ip string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/paint/undo", bytes.NewReader(body))
+	r.Header.Set("CF-Connecting-IP", ip)
+	w := httptest.NewRecorder()
+	h.PostUndo(w, r)
+	return w
+}
+
+// paintSeq paints and returns the paint's seq
+func paintSeq(t *testing.T, h *Handler, req PaintRequest, ip string) uint64 {
+	t.Helper()
+	w := postPaint(h, req, ip)
+	var resp PaintResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("paint from %s failed: %d %s", ip, w.Code, w.Body.String())
+	}
+	return resp.Seq
+}
+
+func TestPostUndoRestoresPreviousColor(t *testing.T) {
+	config := testConfig()
+	config.UndoWindowS = 30
+	h, _ := newTestHandler(t, config)
+	h.rdb.KeepHistory(16)
+
+	paintSeq(t, h, bostonPaint(7, 4), "10.0.0.1")
+	seq := paintSeq(t, h, bostonPaint(7, 9), "10.0.0.2")
+
+	// Only the painter may undo it
+	if w := postUndo(h, UndoRequest{O: 7, Seq: seq}, "10.0.0.1"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 undoing someone else's paint, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := postUndo(h, UndoRequest{O: 7, Seq: seq}, "10.0.0.2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the undo to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp PaintResponse
+	if json.Unmarshal(w.Body.Bytes(), &resp); resp.Seq != seq+1 {
+		t.Errorf("expected the undo to be seq %d, got %s", seq+1, w.Body.String())
+	}
+	buf, _, err := h.rdb.GetChunkSnapshot(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color := bits.GetNibble(buf, 7); color != 4 {
+		t.Errorf("expected tile 7 back to 4, got %d", color)
+	}
+
+	// A paint is undone once
+	if w := postUndo(h, UndoRequest{O: 7, Seq: seq}, "10.0.0.2"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 undoing twice, got %d", w.Code)
+	}
+}
+
+func TestPostUndoConflictsWithLaterPaint(t *testing.T) {
+	config := testConfig()
+	config.UndoWindowS = 30
+	h, _ := newTestHandler(t, config)
+	h.rdb.KeepHistory(16)
+
+	seq := paintSeq(t, h, bostonPaint(7, 4), "10.0.0.1")
+	// Other tiles in the chunk don't matter; a repaint of this one does
+	paintSeq(t, h, bostonPaint(8, 5), "10.0.0.2")
+	paintSeq(t, h, bostonPaint(7, 6), "10.0.0.3")
+
+	w := postUndo(h, UndoRequest{O: 7, Seq: seq}, "10.0.0.1")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeUndoConflict) {
+		t.Fatalf("expected 409 %s, got %d: %s", CodeUndoConflict, w.Code, w.Body.String())
+	}
+	buf, _, err := h.rdb.GetChunkSnapshot(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color := bits.GetNibble(buf, 7); color != 6 {
+		t.Errorf("expected the later paint to stand, got %d", color)
+	}
+}
@@ -0,0 +1,415 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"splat-boston/internal/geo"
+	redisclient "splat-boston/internal/redis"
+)
+
+// PaintCheck is the outcome of one paint validation step. A failed check
+// carries the status and message PostPaint rejects the paint with.
+type PaintCheck struct {
+	Name   string `json:"name"`
+	Pass   bool   `json:"pass"`
+	Detail string `json:"detail,omitempty"`
+	// Flagged marks a passing check that found something suspicious
+	Flagged bool `json:"flagged,omitempty"`
+
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+	// cooldown is the length of the cooldown a 429 is waiting out
+	cooldown time.Duration
+	// reasons are passed on from an upstream check, as ErrorDetail.Reasons
+	reasons []string
+}
+
+func missingParam(name string) *ErrorDetail {
+	return &ErrorDetail{Code: CodeMissingParam, Message: "missing " + name + " parameter", Param: name}
+}
+
+func invalidParam(name, reason string) *ErrorDetail {
+	return &ErrorDetail{Code: CodeInvalidParam, Message: "invalid " + name + " parameter: " + reason, Param: name}
+}
+
+// parseInt64Param reads a required integer query parameter
+func parseInt64Param(query url.Values, name string) (int64, *ErrorDetail) {
+	s := query.Get(name)
+	if s == "" {
+		return 0, missingParam(name)
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, invalidParam(name, fmt.Sprintf("%q is not an integer", s))
+	}
+	return v, nil
+}
+
+// parseChunkRect reads the minCx, minCy, maxCx and maxCy query params as a
+// chunk rectangle, lo to hi inclusive
+func parseChunkRect(query url.Values) (lo, hi redisclient.ChunkRef, perr *ErrorDetail) {
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"minCx", &lo.Cx}, {"minCy", &lo.Cy}, {"maxCx", &hi.Cx}, {"maxCy", &hi.Cy}} {
+		if *p.dst, perr = parseInt64Param(query, p.name); perr != nil {
+			return lo, hi, perr
+		}
+	}
+	if lo.Cx > hi.Cx || lo.Cy > hi.Cy {
+		return lo, hi, invalidParam("maxCx", "bounds are empty")
+	}
+	return lo, hi, nil
+}
+
+// rejectParam counts a bad query parameter and writes its 400
+func (h *Handler) rejectParam(w http.ResponseWriter, perr *ErrorDetail) {
+	h.metrics.ParamError(perr.Code, perr.Param)
+	writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{Error: *perr})
+}
+
+// decodePaintJSON decodes a JSON paint. With StrictPaintJSON, a field
+// PaintRequest doesn't have is an UNKNOWN_FIELD error naming it.
+func (h *Handler) decodePaintJSON(body io.Reader, req *PaintRequest) *ErrorDetail {
+	dec := json.NewDecoder(body)
+	if h.config.StrictPaintJSON {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(req); err != nil {
+		// encoding/json has no error type for this, only the message
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field, _ = strconv.Unquote(field)
+			return &ErrorDetail{Code: CodeUnknownField, Message: fmt.Sprintf("unknown field %q", field), Param: field}
+		}
+		return &ErrorDetail{Code: CodeBadRequest, Message: "bad json"}
+	}
+	return nil
+}
+
+// reject writes the check's failure response. Retryable failures also get
+// a Retry-After header and retryAfterMs the client can count down from.
+func (c PaintCheck) reject(w http.ResponseWriter) {
+	if c.retryAfter > 0 {
+		// Retry-After is in whole seconds; round up so clients don't retry early
+		seconds := int64((c.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	writeErrorResponse(w, c.status, ErrorResponse{
+		Error:        ErrorDetail{Code: c.code, Message: c.message, Reasons: c.reasons},
+		RetryAfterMs: c.retryAfter.Milliseconds(),
+		CooldownMs:   c.cooldown.Milliseconds(),
+	})
+}
+
+// rejectPaint counts a failed check by name, logs it at debug level, and
+// writes its response. Rejections are routine, so info stays quiet.
+func (h *Handler) rejectPaint(w http.ResponseWriter, logger *slog.Logger, check PaintCheck) {
+	h.metrics.PaintRejected(check.Name)
+	reason := check.Detail
+	if reason == "" {
+		reason = check.message
+	}
+	logger.Debug("api: paint rejected", "check", check.Name, "code", check.code, "reason", reason)
+	check.reject(w)
+}
+
+// flagPaint counts and logs a suspicious paint that was let through
+func (h *Handler) flagPaint(logger *slog.Logger, check PaintCheck) {
+	h.metrics.PaintFlagged(check.Name)
+	logger.Info("api: paint flagged", "check", check.Name, "reason", check.Detail)
+}
+
+func passed(name, detail string) PaintCheck {
+	return PaintCheck{Name: name, Pass: true, Detail: detail}
+}
+
+// flagged passes a suspicious paint but records it, for signals too soft to
+// reject on
+func flagged(name, detail string) PaintCheck {
+	return PaintCheck{Name: name, Pass: true, Flagged: true, Detail: detail}
+}
+
+func failed(name, detail string, status int, code, message string) PaintCheck {
+	return PaintCheck{Name: name, Detail: detail, status: status, code: code, message: message}
+}
+
+// checkBan fails while the subject is banned. Bans are a second line of
+// defence, so a Redis error lets the paint through to the other checks.
+func (h *Handler) checkBan(ctx context.Context, subject string) PaintCheck {
+	if h.bans == nil {
+		return passed("ban", "bans disabled")
+	}
+	remaining, err := h.bans.Remaining(ctx, subject)
+	if err != nil {
+		h.redisError("ban", err)
+		return passed("ban", fmt.Sprintf("redis: %v", err))
+	}
+	if remaining > 0 {
+		check := failed("ban", fmt.Sprintf("%dms remaining", remaining.Milliseconds()), 403, CodeBanned, "banned")
+		check.retryAfter = remaining
+		return check
+	}
+	return passed("ban", "")
+}
+
+// strike counts a failed check against the subject's ban threshold
+func (h *Handler) strike(ctx context.Context, subject string, logger *slog.Logger, check PaintCheck) {
+	if h.bans == nil {
+		return
+	}
+	d, err := h.bans.Strike(ctx, subject)
+	if err != nil {
+		h.redisError("ban", err)
+		return
+	}
+	if d > 0 {
+		h.metrics.BanIssued()
+		logger.Warn("api: banned after repeated rejections", "check", check.Name, "duration", d)
+	}
+}
+
+// turnstileServerErrors are Turnstile error codes that mean the fault is
+// ours or Cloudflare's, not the client's token
+var turnstileServerErrors = []string{"missing-input-secret", "invalid-input-secret", "internal-error"}
+
+// checkTurnstile verifies the paint's Turnstile token, when enabled. An
+// expired or already used token is TURNSTILE_EXPIRED, so the client can
+// rerun the challenge and retry; other rejected tokens are
+// TURNSTILE_FAILED. When Cloudflare can't be reached, or can't check the
+// token, the paint fails with a 502 instead, since the token may be fine.
+func (h *Handler) checkTurnstile(ctx context.Context, subject string, req PaintRequest) PaintCheck {
+	if !h.config.EnableTurnstile {
+		return passed("turnstile", "disabled")
+	}
+	if req.TurnstileToken == "" {
+		return failed("turnstile", "no token", 401, CodeTurnstile, "turnstile token missing")
+	}
+
+	resp, err := h.turnstileClient.Verify(ctx, req.TurnstileToken, subject)
+	if err != nil {
+		return failed("turnstile", fmt.Sprintf("verify: %v", err), 502, CodeTurnstileDown, "turnstile verification unavailable")
+	}
+	if resp.Success {
+		return passed("turnstile", "")
+	}
+
+	detail := strings.Join(resp.ErrorCodes, ", ")
+	var check PaintCheck
+	switch {
+	case slices.Contains(resp.ErrorCodes, "timeout-or-duplicate"):
+		check = failed("turnstile", detail, 401, CodeTurnstileExpired, "turnstile token expired or already used")
+	case slices.ContainsFunc(resp.ErrorCodes, func(code string) bool { return slices.Contains(turnstileServerErrors, code) }):
+		check = failed("turnstile", detail, 502, CodeTurnstileDown, "turnstile verification unavailable")
+	default:
+		check = failed("turnstile", detail, 401, CodeTurnstile, "turnstile verification failed")
+	}
+	check.reasons = resp.ErrorCodes
+	return check
+}
+
+// checkCooldown fails while the subject is still cooling down from a paint
+// in the same cooldown group as color
+func (h *Handler) checkCooldown(subject string, color uint8) PaintCheck {
+	key := h.cooldownKey(subject, color)
+	remaining := h.cooldownLimiter.GetCooldownRemaining(key, h.paintCooldown())
+	if remaining > 0 {
+		check := failed("cooldown", fmt.Sprintf("%dms remaining", remaining.Milliseconds()), 429, CodeCooldown, "cooling down")
+		check.retryAfter = remaining
+		check.cooldown = h.cooldownLimiter.GetCooldownDuration(key, h.paintCooldown())
+		return check
+	}
+	return passed("cooldown", "")
+}
+
+// checkSpeed fails when reaching the paint location implies travelling
+// faster than allowed. record stores the position for the next check;
+// read-only callers pass false.
+func (h *Handler) checkSpeed(subject string, req PaintRequest, record bool) PaintCheck {
+	if h.speedLimiter == nil {
+		return passed("speed", "speed limit disabled")
+	}
+
+	if record {
+		if !h.speedLimiter.CheckSpeed(subject, req.Lat, req.Lon) {
+			return failed("speed", "", 403, CodeSpeedLimit, "speed limit exceeded")
+		}
+		return passed("speed", "")
+	}
+
+	kmh, ok := h.speedLimiter.PeekSpeed(subject, req.Lat, req.Lon)
+	detail := fmt.Sprintf("%.0f km/h (max %.0f)", kmh, h.config.SpeedMaxKmh)
+	if !ok {
+		return failed("speed", detail, 403, CodeSpeedLimit, "speed limit exceeded")
+	}
+	return passed("speed", detail)
+}
+
+// The Boston area checkGeofence allows (simplified lat/lon bounds)
+const (
+	fenceMinLat, fenceMaxLat = 42.0, 43.0
+	fenceMinLon, fenceMaxLon = -72.0, -70.0
+)
+
+// fenceTiles returns the tiles the geofence box covers in proj
+func fenceTiles(proj geo.Projection) (minX, minY, maxX, maxY int64) {
+	return proj.TileRect(fenceMinLat, fenceMinLon, fenceMaxLat, fenceMaxLon)
+}
+
+// checkGeofence fails outside the Boston area.
+// A loaded mask is the more exact fence, so with one the box is skipped and
+// checkMask decides.
+func (h *Handler) checkGeofence(req PaintRequest) PaintCheck {
+	if h.mask != nil {
+		return passed("geofence", "mask loaded")
+	}
+	if req.Lat < fenceMinLat || req.Lat > fenceMaxLat || req.Lon < fenceMinLon || req.Lon > fenceMaxLon {
+		return failed("geofence", fmt.Sprintf("(%f, %f) is outside the allowed area", req.Lat, req.Lon), 403, CodeGeofence, "outside the allowed area")
+	}
+	return passed("geofence", "")
+}
+
+// checkCoords fails, unless TrustClientCoords is set, when cx/cy/o aren't
+// within CoordsToleranceTiles of the tile at the submitted lat/lon; otherwise
+// a client could pass the geofence with a real location and paint anywhere
+func (h *Handler) checkCoords(req PaintRequest) PaintCheck {
+	if h.config.TrustClientCoords {
+		return passed("coords", "client coordinates trusted")
+	}
+
+	x, y := h.proj.LatLonToTileXY(req.Lat, req.Lon)
+	tx, ty := h.declaredTile(req)
+	dx, dy := tx-x, ty-y
+	off := max(dx, -dx, dy, -dy)
+	if off > int64(h.config.CoordsToleranceTiles) {
+		cx, cy := h.proj.ChunkOf(x, y)
+		o := h.proj.OffsetOf(x, y)
+		return failed("coords", fmt.Sprintf("(%d, %d, %d) is %d tiles from the tile at the location, expected (%d, %d, %d)", req.Cx, req.Cy, req.O, off, cx, cy, o), 400, CodeCoordsMismatch, "coordinates do not match location")
+	}
+	if off > 0 {
+		return passed("coords", fmt.Sprintf("%d tiles from the location, within tolerance", off))
+	}
+	return passed("coords", "")
+}
+
+// declaredTile returns the tile cx/cy/o name, the one the paint writes
+func (h *Handler) declaredTile(req PaintRequest) (x, y int64) {
+	size := h.proj.ChunkSize
+	return req.Cx*size + int64(req.O)%size, req.Cy*size + int64(req.O)/size
+}
+
+// checkOffset fails for offsets outside a chunk. With client coordinates
+// trusted, nothing else stops one being written past the chunk's bits.
+func (h *Handler) checkOffset(req PaintRequest) PaintCheck {
+	if !validOffset(req.O) {
+		return failed("offset", fmt.Sprintf("offset %d out of range 0-%d", req.O, chunkWidth*chunkWidth-1), 400, CodeInvalidOffset, "offset out of range")
+	}
+	return passed("offset", "")
+}
+
+// checkChunk fails for chunks off the map or outside WorldBounds. Like
+// checkOffset it only matters with client coordinates trusted; otherwise
+// checkCoords ties the chunk to a real location.
+func (h *Handler) checkChunk(req PaintRequest) PaintCheck {
+	maxChunk := h.proj.MaxChunk()
+	if req.Cx < 0 || req.Cx > maxChunk || req.Cy < 0 || req.Cy > maxChunk {
+		return failed("chunk", fmt.Sprintf("chunk (%d, %d) out of range 0-%d", req.Cx, req.Cy, maxChunk), 400, CodeInvalidChunk, "chunk out of range")
+	}
+	if w := h.world; !w.Contains(req.Cx, req.Cy) {
+		return failed("chunk", fmt.Sprintf("chunk (%d, %d) outside the world, (%d, %d) to (%d, %d)", req.Cx, req.Cy, w.MinCx, w.MinCy, w.MaxCx, w.MaxCy), 400, CodeInvalidChunk, "chunk out of range")
+	}
+	return passed("chunk", "")
+}
+
+// checkSubscription fails, when RequireSubscription is set, if the subject
+// has no WebSocket subscribed to the chunk being painted
+func (h *Handler) checkSubscription(subject string, req PaintRequest) PaintCheck {
+	if !h.config.RequireSubscription {
+		return passed("subscription", "not required")
+	}
+	if !h.hub.IsSubscribed(subject, req.Cx, req.Cy) {
+		return failed("subscription", fmt.Sprintf("not subscribed to chunk (%d, %d)", req.Cx, req.Cy), 403, CodeNotSubscribed, "not subscribed to the chunk")
+	}
+	return passed("subscription", "")
+}
+
+// checkNetHint compares the GPS fix with the client's network location hint,
+// when it sent one. Spoofing GPS is easy but moving the network location
+// isn't, so a large disagreement suggests a faked position. Cell and Wi-Fi
+// positioning are coarse, so only a gap beyond NetHintMaxKm counts.
+func (h *Handler) checkNetHint(req PaintRequest) PaintCheck {
+	if h.config.NetHintMaxKm <= 0 {
+		return passed("nethint", "disabled")
+	}
+	if req.NetLat == nil || req.NetLon == nil {
+		return passed("nethint", "no hint")
+	}
+
+	km := geo.HaversineDistance(req.Lat, req.Lon, *req.NetLat, *req.NetLon) / 1000
+	detail := fmt.Sprintf("%.1f km from network location (max %.0f)", km, h.config.NetHintMaxKm)
+	if km <= h.config.NetHintMaxKm {
+		return passed("nethint", detail)
+	}
+	if !h.config.NetHintEnforce {
+		return flagged("nethint", detail)
+	}
+	return failed("nethint", detail, 403, CodeLocationMismatch, "location does not match network location")
+}
+
+// checkMask fails when the declared tile is masked out. That is the tile
+// written, which CoordsToleranceTiles lets stray from the location's.
+func (h *Handler) checkMask(req PaintRequest) PaintCheck {
+	if h.mask == nil {
+		return passed("mask", "no mask loaded")
+	}
+
+	x, y := h.declaredTile(req)
+	if !h.mask.IsTileAllowed(x, y) {
+		return failed("mask", fmt.Sprintf("tile (%d, %d) is masked", x, y), 403, CodeOutsideMask, "outside mask")
+	}
+	return passed("mask", "")
+}
+
+// checkColor fails for colors outside the 16-color palette
+func (h *Handler) checkColor(req PaintRequest) PaintCheck {
+	if req.Color > 15 {
+		return failed("color", fmt.Sprintf("color %d out of range 0-15", req.Color), 400, CodeInvalidColor, "invalid color")
+	}
+	return passed("color", "")
+}
+
+// checkPaletteID fails a paint whose palette id isn't its chunk's, since
+// the client would have picked the color index from the wrong palette. With
+// no named palettes configured every chunk uses the default and Redis isn't
+// asked.
+func (h *Handler) checkPaletteID(ctx context.Context, req PaintRequest) PaintCheck {
+	if req.PaletteID != "" {
+		if _, ok := h.config.Palettes[req.PaletteID]; !ok {
+			return failed("palette_id", fmt.Sprintf("unknown palette %q", req.PaletteID), 400, CodeUnknownPalette, "unknown palette")
+		}
+	}
+	if len(h.config.Palettes) == 0 {
+		return passed("palette_id", "no named palettes")
+	}
+
+	chunkID, err := h.rdb.GetChunkPaletteIDContext(ctx, req.Cx, req.Cy)
+	if err != nil {
+		h.redisError("palette_id", err)
+		return failed("palette_id", fmt.Sprintf("redis: %v", err), 500, CodeRedis, "redis error")
+	}
+	if chunkID != req.PaletteID {
+		return failed("palette_id", fmt.Sprintf("chunk (%d, %d) uses palette %q, paint used %q", req.Cx, req.Cy, chunkID, req.PaletteID), 409, CodePaletteMismatch, "chunk uses another palette")
+	}
+	return passed("palette_id", "")
+}
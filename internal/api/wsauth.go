@@ -0,0 +1,200 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A WebSocket token is "<expiry>.<mac>": the Unix second it expires, and
+// the base64url HMAC-SHA256 of the client IP and expiry under the server's
+// secret. It is tied to the IP it was minted for, so a token lifted from
+// one client is no use to a bot elsewhere.
+
+// defaultWSTokenTTL is how long a token is good for when WSTokenTTLS is
+// unset
+const defaultWSTokenTTL = 10 * time.Minute
+
+// wsTokenProtocolPrefix marks a Sec-WebSocket-Protocol entry carrying a
+// token, for browsers, which can't set other headers on a WebSocket
+const wsTokenProtocolPrefix = "token."
+
+var (
+	errWSTokenMalformed = errors.New("malformed token")
+	errWSTokenExpired   = errors.New("token expired")
+	errWSTokenInvalid   = errors.New("token not valid for this client")
+)
+
+// wsTokenSecret returns the key tokens are signed with, or nil when /sub
+// doesn't require one. Without WSTokenSecret a random key is used, which
+// only suits a single instance: tokens don't verify on the others.
+func wsTokenSecret(config Config) []byte {
+	if !config.WSRequireAuth {
+		return nil
+	}
+	if config.WSTokenSecret != "" {
+		return []byte(config.WSTokenSecret)
+	}
+	slog.Warn("api: WS_REQUIRE_AUTH is set without WS_TOKEN_SECRET; tokens will only verify on this instance")
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// wsTokenMAC signs an IP and expiry. A port, as getIP leaves on a
+// RemoteAddr, is dropped: it changes with every connection.
+func wsTokenMAC(secret []byte, ip string, expiry int64) []byte {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expiry, 10)))
+	return mac.Sum(nil)
+}
+
+// mintWSToken returns a token for ip good until expires
+func mintWSToken(secret []byte, ip string, expires time.Time) string {
+	expiry := expires.Unix()
+	return strconv.FormatInt(expiry, 10) + "." + base64.RawURLEncoding.EncodeToString(wsTokenMAC(secret, ip, expiry))
+}
+
+// verifyWSToken checks a token was minted for ip and hasn't expired by now
+func verifyWSToken(secret []byte, token, ip string, now time.Time) error {
+	expiryPart, macPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return errWSTokenMalformed
+	}
+	expiry, err := strconv.ParseInt(expiryPart, 10, 64)
+	if err != nil {
+		return errWSTokenMalformed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(macPart)
+	if err != nil {
+		return errWSTokenMalformed
+	}
+	// The MAC first, so a forged expiry isn't reported as merely expired
+	if !hmac.Equal(mac, wsTokenMAC(secret, ip, expiry)) {
+		return errWSTokenInvalid
+	}
+	if now.Unix() >= expiry {
+		return errWSTokenExpired
+	}
+	return nil
+}
+
+// wsTokenTTL returns how long minted tokens are good for
+func (h *Handler) wsTokenTTL() time.Duration {
+	if h.config.WSTokenTTLS > 0 {
+		return time.Duration(h.config.WSTokenTTLS) * time.Second
+	}
+	return defaultWSTokenTTL
+}
+
+// mintWSTokenFor returns a token for ip, or "" when /sub needs none
+func (h *Handler) mintWSTokenFor(ip string) string {
+	if h.wsTokenSecret == nil {
+		return ""
+	}
+	return mintWSToken(h.wsTokenSecret, ip, time.Now().Add(h.wsTokenTTL()))
+}
+
+// wsTokenFromRequest finds a token in the token query parameter or a
+// "token."-prefixed Sec-WebSocket-Protocol entry. protocol is that entry,
+// which the upgrade must select for browsers to accept the connection.
+func wsTokenFromRequest(r *http.Request) (token, protocol string) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, ""
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, entry := range strings.Split(header, ",") {
+			entry = strings.TrimSpace(entry)
+			if token, ok := strings.CutPrefix(entry, wsTokenProtocolPrefix); ok {
+				return token, entry
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkWSToken authorizes a /sub request when tokens are required, writing
+// a 401 and returning false if it has none or a bad one. The response
+// header selects the token's subprotocol, if it came as one.
+func (h *Handler) checkWSToken(w http.ResponseWriter, r *http.Request) (http.Header, bool) {
+	if h.wsTokenSecret == nil {
+		return nil, true
+	}
+	token, protocol := wsTokenFromRequest(r)
+	if token == "" {
+		writeError(w, 401, CodeWSTokenMissing, "websocket token required")
+		return nil, false
+	}
+	switch err := verifyWSToken(h.wsTokenSecret, token, h.clientIP(r), time.Now()); err {
+	case nil:
+	case errWSTokenExpired:
+		writeError(w, 401, CodeWSTokenExpired, "websocket token expired")
+		return nil, false
+	default:
+		writeError(w, 401, CodeWSTokenInvalid, "websocket token invalid")
+		return nil, false
+	}
+	if protocol == "" {
+		return nil, true
+	}
+	return http.Header{"Sec-WebSocket-Protocol": {protocol}}, true
+}
+
+// WSTokenRequest is the body of POST /sub/token
+type WSTokenRequest struct {
+	TurnstileToken string `json:"turnstileToken"`
+}
+
+// WSTokenResponse carries a token for /sub
+type WSTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// PostWSToken handles POST /sub/token, minting a /sub token for a client
+// that passes Turnstile, for viewers who haven't painted. Without Turnstile
+// it would mint one for anybody, so it refuses and only paints hand tokens
+// out.
+func (h *Handler) PostWSToken(w http.ResponseWriter, r *http.Request) {
+	if h.wsTokenSecret == nil {
+		writeError(w, 404, CodeWSAuthDisabled, "websocket tokens are not required")
+		return
+	}
+	if !h.config.EnableTurnstile {
+		writeError(w, 403, CodeWSTokenUnavailable, "websocket tokens come only from paints")
+		return
+	}
+	var req WSTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, CodeBadRequest, "bad json")
+		return
+	}
+
+	ip := h.clientIP(r)
+	if check := h.checkTurnstile(r.Context(), ip, PaintRequest{TurnstileToken: req.TurnstileToken}); !check.Pass {
+		check.reject(w)
+		return
+	}
+
+	expires := time.Now().Add(h.wsTokenTTL())
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(WSTokenResponse{
+		Token:     mintWSToken(h.wsTokenSecret, ip, expires),
+		ExpiresAt: expires.Unix(),
+	})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"splat-boston/internal/turnstile"
+)
+
+func TestVerifyWSToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Unix(1_700_000_000, 0)
+	token := mintWSToken(secret, "10.0.0.1", now.Add(time.Minute))
+
+	if err := verifyWSToken(secret, token, "10.0.0.1", now); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+	if err := verifyWSToken(secret, token, "10.0.0.1:51234", now); err != nil {
+		t.Errorf("Expected the port ignored, got %v", err)
+	}
+	if err := verifyWSToken(secret, token, "10.0.0.1", now.Add(time.Minute)); err != errWSTokenExpired {
+		t.Errorf("expired token: got %v", err)
+	}
+	if err := verifyWSToken(secret, token, "10.0.0.2", now); err != errWSTokenInvalid {
+		t.Errorf("another IP's token: got %v", err)
+	}
+	if err := verifyWSToken([]byte("other-secret"), token, "10.0.0.1", now); err != errWSTokenInvalid {
+		t.Errorf("another secret's token: got %v", err)
+	}
+
+	// Pushing the expiry out breaks the MAC
+	expiry, mac, _ := strings.Cut(token, ".")
+	if err := verifyWSToken(secret, expiry+"0."+mac, "10.0.0.1", now); err != errWSTokenInvalid {
+		t.Errorf("tampered expiry: got %v", err)
+	}
+	flipped := []byte(mac)
+	flipped[0] ^= 'A' ^ 'B'
+	if err := verifyWSToken(secret, expiry+"."+string(flipped), "10.0.0.1", now); err != errWSTokenInvalid {
+		t.Errorf("tampered MAC: got %v", err)
+	}
+
+	for _, bad := range []string{"", "nodot", "abc." + mac, expiry + ".!!"} {
+		if err := verifyWSToken(secret, bad, "10.0.0.1", now); err != errWSTokenMalformed {
+			t.Errorf("%q: got %v, want malformed", bad, err)
+		}
+	}
+}
+
+func TestHandleWebSocketRequiresToken(t *testing.T) {
+	config := testConfig()
+	config.WSRequireAuth = true
+	config.WSTokenSecret = "test-secret"
+	h, _ := newTestHandler(t, config)
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+	dial := func(query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("CF-Connecting-IP", "10.0.0.1")
+		return websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/sub?cx=0&cy=0"+query, header)
+	}
+	expectRefused := func(name, query string, code string) {
+		t.Helper()
+		conn, resp, err := dial(query, nil)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("%s: expected the upgrade refused", name)
+		}
+		defer resp.Body.Close()
+		var body ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != 401 || body.Error.Code != code {
+			t.Errorf("%s: got %d %s, want 401 %s", name, resp.StatusCode, body.Error.Code, code)
+		}
+	}
+
+	expectRefused("no token", "", CodeWSTokenMissing)
+	expectRefused("expired", "&token="+mintWSToken(h.wsTokenSecret, "10.0.0.1", time.Now().Add(-time.Second)), CodeWSTokenExpired)
+	expectRefused("another IP's", "&token="+mintWSToken(h.wsTokenSecret, "10.0.0.2", time.Now().Add(time.Minute)), CodeWSTokenInvalid)
+
+	// A successful paint hands out a token that opens /sub
+	w := postPaint(h, bostonPaint(0, 5), "10.0.0.1")
+	var paint PaintResponse
+	if err := json.NewDecoder(w.Body).Decode(&paint); err != nil || paint.WSToken == "" {
+		t.Fatalf("Expected a token with the paint, got %+v (%v)", paint, err)
+	}
+	conn, _, err := dial("&token="+paint.WSToken, nil)
+	if err != nil {
+		t.Fatalf("Expected the paint's token accepted: %v", err)
+	}
+	conn.Close()
+
+	// As a subprotocol, which the server must select
+	header := http.Header{"Sec-WebSocket-Protocol": {"splat, " + wsTokenProtocolPrefix + paint.WSToken}}
+	conn, resp, err := dial("", header)
+	if err != nil {
+		t.Fatalf("Expected the subprotocol token accepted: %v", err)
+	}
+	conn.Close()
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != wsTokenProtocolPrefix+paint.WSToken {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want the token's", got)
+	}
+}
+
+func TestPostWSTokenChecksTurnstile(t *testing.T) {
+	post := func(h *Handler, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(WSTokenRequest{TurnstileToken: token})
+		r := httptest.NewRequest(http.MethodPost, "/sub/token", bytes.NewReader(body))
+		r.Header.Set("CF-Connecting-IP", "10.0.0.1")
+		w := httptest.NewRecorder()
+		h.PostWSToken(w, r)
+		return w
+	}
+
+	h, _ := newTestHandler(t, testConfig())
+	if w := post(h, ""); w.Code != 404 {
+		t.Errorf("Expected 404 without WSRequireAuth, got %d", w.Code)
+	}
+
+	config := testConfig()
+	config.WSRequireAuth = true
+	config.EnableTurnstile = true
+	config.TurnstileSecret = "1x0000000000000000000000000000000AA"
+	h, _ = newTestHandler(t, config)
+	if w := post(h, ""); w.Code != 401 {
+		t.Errorf("Expected 401 without a Turnstile token, got %d", w.Code)
+	}
+
+	cloudflare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(turnstile.TurnstileResponse{Success: r.FormValue("response") == "good"})
+	}))
+	defer cloudflare.Close()
+	h.turnstileClient.SetBaseURL(cloudflare.URL)
+	w := post(h, "good")
+	var resp WSTokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); w.Code != 200 || err != nil {
+		t.Fatalf("Expected a token, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := verifyWSToken(h.wsTokenSecret, resp.Token, "10.0.0.1", time.Now()); err != nil {
+		t.Errorf("Expected the token to verify: %v", err)
+	}
+	if resp.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("ExpiresAt %d is not in the future", resp.ExpiresAt)
+	}
+
+	// Without Turnstile there is nothing to check, so nothing is minted
+	config.EnableTurnstile = false
+	h, _ = newTestHandler(t, config)
+	if w := post(h, ""); w.Code != 403 || !strings.Contains(w.Body.String(), CodeWSTokenUnavailable) {
+		t.Errorf("Expected 403 %s without Turnstile, got %d: %s", CodeWSTokenUnavailable, w.Code, w.Body.String())
+	}
+}
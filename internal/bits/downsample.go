@@ -0,0 +1,32 @@
+package bits
+
+// Downsample reduces a square nibble-packed grid of width x width tiles to
+// (width/factor) x (width/factor) by taking the most common color of each
+// factor x factor block; ties go to the lower color. The result uses the
+// same row-major nibble packing. factor must divide width.
+func Downsample(data []byte, width, factor int) []byte {
+	outWidth := width / factor
+	out := make([]byte, (outWidth*outWidth+1)/2)
+
+	var counts [16]int
+	for by := 0; by < outWidth; by++ {
+		for bx := 0; bx < outWidth; bx++ {
+			counts = [16]int{}
+			for y := by * factor; y < (by+1)*factor; y++ {
+				for x := bx * factor; x < (bx+1)*factor; x++ {
+					counts[GetNibble(data, y*width+x)]++
+				}
+			}
+
+			var mode uint8
+			for color := 1; color < 16; color++ {
+				if counts[color] > counts[mode] {
+					mode = uint8(color)
+				}
+			}
+			SetNibble(out, by*outWidth+bx, mode)
+		}
+	}
+
+	return out
+}
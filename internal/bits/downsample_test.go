@@ -0,0 +1,51 @@
+package bits
+
+import "testing"
+
+func TestDownsampleBlockMode(t *testing.T) {
+	const width = 256
+	data := make([]byte, width*width/2)
+
+	// Quadrants of 128x128 filled with colors 1-4, plus a minority of 9s in
+	// the top-left quadrant that must lose to the majority
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			color := uint8(1 + (y/128)*2 + x/128)
+			if x < 128 && y < 128 && x%4 == 0 {
+				color = 9
+			}
+			SetNibble(data, y*width+x, color)
+		}
+	}
+
+	out := Downsample(data, width, 128)
+	if len(out) != 2 {
+		t.Fatalf("Expected 2 bytes for a 2x2 grid, got %d", len(out))
+	}
+	for i, want := range []uint8{1, 2, 3, 4} {
+		if got := GetNibble(out, i); got != want {
+			t.Errorf("Block %d: expected color %d, got %d", i, want, got)
+		}
+	}
+}
+
+func TestDownsampleTiesGoToLowerColor(t *testing.T) {
+	// 2x2 grid: two 7s and two 3s
+	data := []byte{0x77, 0x33}
+
+	out := Downsample(data, 2, 2)
+	if got := GetNibble(out, 0); got != 3 {
+		t.Errorf("Expected tie to resolve to color 3, got %d", got)
+	}
+}
+
+func TestDownsampleFactorOne(t *testing.T) {
+	data := []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0}
+
+	out := Downsample(data, 4, 1)
+	for i := range data {
+		if out[i] != data[i] {
+			t.Fatalf("Factor 1 should be the identity, got %x", out)
+		}
+	}
+}
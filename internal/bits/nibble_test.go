@@ -99,6 +99,27 @@ func TestNibbleOverwrite(t *testing.T) {
 	}
 }
 
+func TestSetNibbleIfEmpty(t *testing.T) {
+	data := make([]byte, 2)
+
+	// The first painter claims the tile
+	if written, prev := SetNibbleIfEmpty(data, 1, 6); !written || prev != 0 {
+		t.Errorf("Expected to claim an empty tile, got written=%v prev=%d", written, prev)
+	}
+
+	// Later painters see who got there first and change nothing
+	if written, prev := SetNibbleIfEmpty(data, 1, 2); written || prev != 6 {
+		t.Errorf("Expected an occupied tile to report color 6, got written=%v prev=%d", written, prev)
+	}
+	if GetNibble(data, 1) != 6 || GetNibble(data, 0) != 0 {
+		t.Errorf("Expected only tile 1 painted, got byte %#x", data[0])
+	}
+
+	if written, _ := SetNibbleIfEmpty(data, 4, 3); written {
+		t.Error("Expected an out-of-bounds offset not to be written")
+	}
+}
+
 func TestNibbleBounds(t *testing.T) {
 	// Test bounds checking
 	data := make([]byte, 2) // 4 tiles worth of data
@@ -240,6 +261,42 @@ func TestNibbleConcurrency(t *testing.T) {
 	}
 }
 
+func TestSetNibblesMatchesSetNibbleLoop(t *testing.T) {
+	writes := []NibbleWrite{
+		{Offset: 0, Color: 1},  // high nibble of byte 0...
+		{Offset: 1, Color: 2},  // ...and its low nibble, sharing the byte
+		{Offset: 1, Color: 3},  // the same tile again sees color 2
+		{Offset: -1, Color: 4}, // out of bounds
+		{Offset: 5, Color: 5},  // low before high in byte 2
+		{Offset: 4, Color: 6},
+		{Offset: 8, Color: 7}, // past the end
+		{Offset: 0, Color: 8}, // back to byte 0 later on
+	}
+
+	want := make([]byte, 4)
+	want[3] = 0xAB
+	var wantPrevs []uint8
+	for _, w := range writes {
+		wantPrevs = append(wantPrevs, SetNibble(want, w.Offset, w.Color))
+	}
+
+	got := make([]byte, 4)
+	got[3] = 0xAB
+	prevs := SetNibbles(got, writes)
+
+	if string(got) != string(want) {
+		t.Errorf("Expected bytes %x, got %x", want, got)
+	}
+	for i := range wantPrevs {
+		if prevs[i] != wantPrevs[i] {
+			t.Errorf("Write %d: expected prev %d, got %d", i, wantPrevs[i], prevs[i])
+		}
+	}
+	if prevs[2] != 2 || prevs[7] != 1 {
+		t.Errorf("Expected repeated tiles to see the earlier writes, got prevs %v", prevs)
+	}
+}
+
 func BenchmarkNibbleOperations(b *testing.B) {
 	data := make([]byte, chunkSizeBytes)
 
@@ -266,3 +323,29 @@ func BenchmarkNibbleOperations(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkSetNibbles(b *testing.B) {
+	// A 16x16 block painted row by row, as a batch paint would send it
+	var writes []NibbleWrite
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			writes = append(writes, NibbleWrite{Offset: y*256 + x, Color: uint8(1 + (x+y)%8)})
+		}
+	}
+	data := make([]byte, chunkSizeBytes)
+
+	b.Run("Loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			prevs := make([]uint8, len(writes))
+			for j, w := range writes {
+				prevs[j] = SetNibble(data, w.Offset, w.Color)
+			}
+		}
+	})
+
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			SetNibbles(data, writes)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package bits
+
+// Run is a stretch of consecutive tiles of one color
+type Run struct {
+	Color uint8
+	Count int
+}
+
+// EncodeRLE run-length encodes a nibble array, two tiles per byte, in tile
+// order. A blank 32KB chunk is a single run of 65536 zeros.
+func EncodeRLE(data []byte) []Run {
+	var runs []Run
+	for offset := 0; offset < len(data)*2; offset++ {
+		color := GetNibble(data, offset)
+		if n := len(runs); n > 0 && runs[n-1].Color == color {
+			runs[n-1].Count++
+			continue
+		}
+		runs = append(runs, Run{Color: color, Count: 1})
+	}
+	return runs
+}
+
+// DecodeRLE expands runs back into a nibble array. An odd total leaves the
+// last byte's low nibble zero.
+func DecodeRLE(runs []Run) []byte {
+	total := 0
+	for _, run := range runs {
+		total += run.Count
+	}
+
+	data := make([]byte, (total+1)/2)
+	offset := 0
+	for _, run := range runs {
+		// Zeros are already in place
+		if run.Color == 0 {
+			offset += run.Count
+			continue
+		}
+		for i := 0; i < run.Count; i++ {
+			SetNibble(data, offset, run.Color)
+			offset++
+		}
+	}
+	return data
+}
@@ -0,0 +1,51 @@
+package bits
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeRLEBlankChunkIsOneRun(t *testing.T) {
+	runs := EncodeRLE(make([]byte, 32768))
+	if len(runs) != 1 || runs[0] != (Run{Color: 0, Count: 65536}) {
+		t.Fatalf("Expected a single run of 65536 zeros, got %d runs starting %+v", len(runs), runs[0])
+	}
+	if !bytes.Equal(DecodeRLE(runs), make([]byte, 32768)) {
+		t.Error("Expected the blank chunk to decode to 32KB of zeros")
+	}
+}
+
+func TestEncodeRLESplitsOnColorChange(t *testing.T) {
+	data := make([]byte, 4)
+	SetNibble(data, 2, 5)
+	SetNibble(data, 3, 5)
+	SetNibble(data, 4, 1)
+
+	want := []Run{{0, 2}, {5, 2}, {1, 1}, {0, 3}}
+	runs := EncodeRLE(data)
+	if len(runs) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, runs)
+	}
+	for i := range want {
+		if runs[i] != want[i] {
+			t.Errorf("Run %d: expected %+v, got %+v", i, want[i], runs[i])
+		}
+	}
+}
+
+func TestRLERoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, density := range []float64{0, 0.001, 0.1, 1} {
+		data := make([]byte, 32768)
+		for offset := 0; offset < 65536; offset++ {
+			if rng.Float64() < density {
+				SetNibble(data, offset, uint8(1+rng.Intn(8)))
+			}
+		}
+
+		if got := DecodeRLE(EncodeRLE(data)); !bytes.Equal(got, data) {
+			t.Errorf("Density %v: round trip changed the chunk", density)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package bits
+
+// CountPainted returns how many tiles in a nibble array are painted
+// (non-zero)
+func CountPainted(data []byte) int {
+	painted := 0
+	for _, b := range data {
+		if b&0xF0 != 0 {
+			painted++
+		}
+		if b&0x0F != 0 {
+			painted++
+		}
+	}
+	return painted
+}
+
+// ColorHistogram counts the tiles of each color in a nibble array. Index 0
+// is the unpainted count.
+func ColorHistogram(data []byte) [16]int {
+	// Tally whole bytes first, two tiles per increment, then split them.
+	// Mostly blank chunks hit one counter over and over, so four tables
+	// interleaved keep the increments from waiting on each other.
+	var counts [4][256]int
+	i := 0
+	for ; i+4 <= len(data); i += 4 {
+		counts[0][data[i]]++
+		counts[1][data[i+1]]++
+		counts[2][data[i+2]]++
+		counts[3][data[i+3]]++
+	}
+	for ; i < len(data); i++ {
+		counts[0][data[i]]++
+	}
+
+	var hist [16]int
+	for b := 0; b < 256; b++ {
+		n := counts[0][b] + counts[1][b] + counts[2][b] + counts[3][b]
+		hist[b>>4] += n
+		hist[b&0x0F] += n
+	}
+	return hist
+}
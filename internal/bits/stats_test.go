@@ -0,0 +1,82 @@
+package bits
+
+import "testing"
+
+// mixedChunk paints color c on every 10c-th tile for colors 1-8, later
+// colors overwriting earlier ones, plus color 15 on the last tile
+func mixedChunk() ([]byte, [16]int) {
+	data := make([]byte, chunkSizeBytes)
+	colors := make([]uint8, tilesPerChunk)
+	for c := uint8(1); c <= 8; c++ {
+		for o := 0; o < tilesPerChunk; o += 10 * int(c) {
+			colors[o] = c
+		}
+	}
+	colors[tilesPerChunk-1] = 15
+
+	var want [16]int
+	for o, c := range colors {
+		SetNibble(data, o, c)
+		want[c]++
+	}
+	return data, want
+}
+
+func TestColorHistogramAndCountPainted(t *testing.T) {
+	data, want := mixedChunk()
+
+	hist := ColorHistogram(data)
+	if hist != want {
+		t.Errorf("Expected histogram %v, got %v", want, hist)
+	}
+	if hist[15] != 1 {
+		t.Errorf("Expected the last tile (offset 65535) counted as color 15, got %d", hist[15])
+	}
+	if got := CountPainted(data); got != tilesPerChunk-want[0] {
+		t.Errorf("Expected %d painted, got %d", tilesPerChunk-want[0], got)
+	}
+
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	if total != tilesPerChunk {
+		t.Errorf("Expected the histogram to cover %d tiles, got %d", tilesPerChunk, total)
+	}
+}
+
+func TestCountPaintedBlankAndLastTile(t *testing.T) {
+	data := make([]byte, chunkSizeBytes)
+	if n := CountPainted(data); n != 0 {
+		t.Errorf("Expected a blank chunk to have 0 painted, got %d", n)
+	}
+	SetNibble(data, tilesPerChunk-1, 1)
+	if n := CountPainted(data); n != 1 {
+		t.Errorf("Expected only the last tile painted, got %d", n)
+	}
+}
+
+func TestColorHistogramOddLength(t *testing.T) {
+	// Five bytes leave one past the four-at-a-time loop
+	data := []byte{0x12, 0x00, 0x00, 0x00, 0x3F}
+	want := [16]int{0: 6, 1: 1, 2: 1, 3: 1, 15: 1}
+	if hist := ColorHistogram(data); hist != want {
+		t.Errorf("Expected %v, got %v", want, hist)
+	}
+}
+
+func BenchmarkColorHistogram(b *testing.B) {
+	data, _ := mixedChunk()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		ColorHistogram(data)
+	}
+}
+
+func BenchmarkCountPainted(b *testing.B) {
+	data, _ := mixedChunk()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		CountPainted(data)
+	}
+}
@@ -0,0 +1,139 @@
+package canvas
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Store is the shared state resets go through, implemented by the Redis
+// client
+type Store interface {
+	// ClaimReset reports whether this instance won the reset scheduled at
+	// the given moment; exactly one instance per moment succeeds, and
+	// succeeds again on reclaiming it
+	ClaimReset(at time.Time, instance string) (bool, error)
+	// ReleaseReset gives up this instance's claim on the reset scheduled at
+	// the given moment
+	ReleaseReset(at time.Time, instance string) error
+	// ResetCanvas archives and clears the canvas, returning the new epoch
+	// and how many chunks were archived
+	ResetCanvas() (epoch uint64, archived int, err error)
+	CanvasEpoch() (uint64, error)
+}
+
+// Scheduler resets the canvas at each moment of its schedule and watches
+// the epoch so every instance learns of resets, whoever performed them.
+// Every instance runs one; they race to claim each reset and the winner
+// does it.
+type Scheduler struct {
+	store    Store
+	schedule Schedule
+	instance string
+	onEpoch  func(epoch uint64)
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewScheduler creates a scheduler. instance identifies this server in
+// reset claims. onEpoch is called with the current epoch on start and
+// whenever it changes.
+func NewScheduler(store Store, schedule Schedule, instance string, onEpoch func(epoch uint64)) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		schedule: schedule,
+		instance: instance,
+		onEpoch:  onEpoch,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// ResetAt performs the reset scheduled at the given moment if this instance
+// wins the claim for it, reporting whether it did. A reset that fails
+// partway releases the claim; calling ResetAt again picks it up where it
+// stopped.
+func (s *Scheduler) ResetAt(at time.Time) (bool, error) {
+	won, err := s.store.ClaimReset(at, s.instance)
+	if err != nil || !won {
+		return false, err
+	}
+
+	epoch, archived, err := s.store.ResetCanvas()
+	if err != nil {
+		if rerr := s.store.ReleaseReset(at, s.instance); rerr != nil {
+			slog.Error("canvas: failed to release reset claim", "at", at.Format(time.RFC3339), "err", rerr)
+		}
+		return false, err
+	}
+	slog.Info("canvas: scheduled reset done", "at", at.Format(time.RFC3339), "archived", archived, "epoch", epoch)
+	s.onEpoch(epoch)
+	return true, nil
+}
+
+// Start runs resets on schedule and polls the epoch every interval until
+// Close
+func (s *Scheduler) Start(pollInterval time.Duration) {
+	s.poll()
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		// A nil timer channel never fires, for an empty schedule
+		var next time.Time
+		var due <-chan time.Time
+		// A reset that failed is retried on every poll until it's done,
+		// since a half-done one leaves the canvas half cleared
+		var failed time.Time
+		arm := func() {
+			if next = s.schedule.Next(time.Now()); !next.IsZero() {
+				timer := time.NewTimer(time.Until(next))
+				due = timer.C
+			}
+		}
+		arm()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !failed.IsZero() {
+					if _, err := s.ResetAt(failed); err != nil {
+						slog.Error("canvas: scheduled reset retry failed", "at", failed.Format(time.RFC3339), "err", err)
+					} else {
+						failed = time.Time{}
+					}
+				}
+				s.poll()
+			case <-due:
+				if _, err := s.ResetAt(next); err != nil {
+					slog.Error("canvas: scheduled reset failed", "at", next.Format(time.RFC3339), "err", err)
+					failed = next
+				}
+				arm()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops a started scheduler and waits for it to exit
+func (s *Scheduler) Close() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// poll passes the current epoch to onEpoch
+func (s *Scheduler) poll() {
+	epoch, err := s.store.CanvasEpoch()
+	if err != nil {
+		slog.Error("canvas: failed to read epoch", "err", err)
+		return
+	}
+	s.onEpoch(epoch)
+}
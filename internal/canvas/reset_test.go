@@ -0,0 +1,195 @@
+package canvas
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	redisclient "splat-boston/internal/redis"
+)
+
+func TestScheduledResetArchivesThenClears(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer rdb.Close()
+
+	rdb.KeepHistory(16)
+	for o := 0; o < 3; o++ {
+		if _, _, _, err := rdb.PaintTile(5, 7, o, 2); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+	painted, _, _ := rdb.GetChunkSnapshot(5, 7)
+
+	// Two instances wake up for the same scheduled moment
+	var seen []uint64
+	onEpoch := func(epoch uint64) { seen = append(seen, epoch) }
+	a := NewScheduler(rdb, Schedule{}, "a", onEpoch)
+	b := NewScheduler(rdb, Schedule{}, "b", onEpoch)
+	at := time.Date(2024, 3, 10, 22, 0, 0, 0, time.UTC)
+
+	wonA, err := a.ResetAt(at)
+	if err != nil {
+		t.Fatalf("ResetAt failed: %v", err)
+	}
+	wonB, err := b.ResetAt(at)
+	if err != nil {
+		t.Fatalf("ResetAt failed: %v", err)
+	}
+	if wonA == wonB {
+		t.Fatalf("Expected exactly one instance to reset, got a=%v b=%v", wonA, wonB)
+	}
+
+	// The old canvas is archived under epoch 0...
+	archived, archivedSeq, err := rdb.GetArchivedChunk(0, 5, 7)
+	if err != nil {
+		t.Fatalf("GetArchivedChunk failed: %v", err)
+	}
+	if !bytes.Equal(archived, painted) || archivedSeq != 3 {
+		t.Errorf("Expected the painted chunk archived at seq 3, got seq %d", archivedSeq)
+	}
+
+	// ...and the live one is blank, with a seq past any the clients hold
+	bits, seq, err := rdb.GetChunkSnapshot(5, 7)
+	if err != nil {
+		t.Fatalf("GetChunkSnapshot failed: %v", err)
+	}
+	if !bytes.Equal(bits, make([]byte, len(bits))) || seq != 4 {
+		t.Errorf("Expected a blank chunk at seq 4, got seq %d", seq)
+	}
+	if _, ok, _ := rdb.DeltasSince(5, 7, 1); ok {
+		t.Error("Expected the pre-reset history to be gone")
+	}
+
+	epoch, err := rdb.CanvasEpoch()
+	if err != nil || epoch != 1 {
+		t.Fatalf("Expected epoch 1, got %d (%v)", epoch, err)
+	}
+	if len(seen) != 1 || seen[0] != 1 {
+		t.Errorf("Expected the winner to announce epoch 1, got %v", seen)
+	}
+
+	// The next scheduled moment is a separate election
+	if won, err := b.ResetAt(at.Add(7 * 24 * time.Hour)); err != nil || !won {
+		t.Fatalf("Expected the next reset to be claimable, got %v (%v)", won, err)
+	}
+	if epoch, _ := rdb.CanvasEpoch(); epoch != 2 {
+		t.Errorf("Expected epoch 2, got %d", epoch)
+	}
+}
+
+// flakyStore fails the first resets, as a Redis blip partway through the
+// SCAN would
+type flakyStore struct {
+	*redisclient.Client
+	fails int
+}
+
+func (s *flakyStore) ResetCanvas() (uint64, int, error) {
+	if s.fails > 0 {
+		s.fails--
+		return 0, 0, errors.New("connection reset")
+	}
+	return s.Client.ResetCanvas()
+}
+
+func TestFailedResetReleasesItsClaim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer rdb.Close()
+
+	if _, _, _, err := rdb.PaintTile(5, 7, 0, 2); err != nil {
+		t.Fatalf("PaintTile failed: %v", err)
+	}
+
+	var seen []uint64
+	onEpoch := func(epoch uint64) { seen = append(seen, epoch) }
+	a := NewScheduler(&flakyStore{Client: rdb, fails: 1}, Schedule{}, "a", onEpoch)
+	at := time.Date(2024, 3, 10, 22, 0, 0, 0, time.UTC)
+	claim := "canvas:reset:1710108000"
+
+	if won, err := a.ResetAt(at); err == nil || won {
+		t.Fatalf("Expected the failed reset to report an error, got %v (%v)", won, err)
+	}
+	if mr.Exists(claim) {
+		t.Errorf("Expected the failed reset to release %s", claim)
+	}
+	if epoch, _ := rdb.CanvasEpoch(); epoch != 0 || len(seen) != 0 {
+		t.Errorf("Expected no epoch bump, got epoch %d, announced %v", epoch, seen)
+	}
+
+	// The retry claims the moment again and finishes the reset
+	if won, err := a.ResetAt(at); err != nil || !won {
+		t.Fatalf("Expected the retry to reset, got %v (%v)", won, err)
+	}
+	if epoch, _ := rdb.CanvasEpoch(); epoch != 1 || len(seen) != 1 || seen[0] != 1 {
+		t.Errorf("Expected epoch 1 announced once, got epoch %d, announced %v", epoch, seen)
+	}
+	if archived, _, _ := rdb.GetArchivedChunk(0, 5, 7); archived == nil {
+		t.Error("Expected the painted chunk to be archived")
+	}
+
+	// A claim still held by its winner, whose release failed, can be
+	// reclaimed by it but by no one else
+	later := at.Add(7 * 24 * time.Hour)
+	if won, err := rdb.ClaimReset(later, "a"); err != nil || !won {
+		t.Fatalf("Expected a to claim, got %v (%v)", won, err)
+	}
+	if won, _ := rdb.ClaimReset(later, "b"); won {
+		t.Error("Expected b not to claim a's reset")
+	}
+	if won, _ := rdb.ClaimReset(later, "a"); !won {
+		t.Error("Expected a to reclaim its own reset")
+	}
+	if err := rdb.ReleaseReset(later, "b"); err != nil || !mr.Exists("canvas:reset:1710712800") {
+		t.Errorf("Expected b's release to leave a's claim, got %v", err)
+	}
+}
+
+func TestSchedulerPollsEpochChanges(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb, err := redisclient.NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer rdb.Close()
+
+	seen := make(chan uint64, 16)
+	s := NewScheduler(rdb, Schedule{}, "a", func(epoch uint64) {
+		select {
+		case seen <- epoch:
+		default:
+		}
+	})
+	s.Start(10 * time.Millisecond)
+	defer s.Close()
+
+	if epoch := <-seen; epoch != 0 {
+		t.Fatalf("Expected epoch 0 on start, got %d", epoch)
+	}
+
+	// Another instance resets the canvas
+	if _, _, err := rdb.ResetCanvas(); err != nil {
+		t.Fatalf("ResetCanvas failed: %v", err)
+	}
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case epoch := <-seen:
+			if epoch == 1 {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Expected the poll to observe epoch 1")
+		}
+	}
+}
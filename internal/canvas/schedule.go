@@ -0,0 +1,102 @@
+package canvas
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of weekly moments, such as Sunday at 18:00, in one
+// location. The zero value never fires.
+type Schedule struct {
+	moments []weeklyMoment
+	loc     *time.Location
+}
+
+// weeklyMoment is a wall-clock time on one day of the week
+type weeklyMoment struct {
+	day          time.Weekday
+	hour, minute int
+}
+
+// dayNames maps the accepted day spellings to weekdays. "daily" expands to
+// all seven.
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses a comma-separated list of "<day> HH:MM" entries,
+// e.g. "Sun 18:00, Wed 06:30", where day is a three-letter weekday or
+// "daily". Times are wall-clock times in loc. An empty spec gives an empty
+// schedule.
+func ParseSchedule(spec string, loc *time.Location) (Schedule, error) {
+	s := Schedule{loc: loc}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		day, clock, ok := strings.Cut(entry, " ")
+		if !ok {
+			return Schedule{}, fmt.Errorf("schedule entry %q: want \"<day> HH:MM\"", entry)
+		}
+		at, err := time.Parse("15:04", strings.TrimSpace(clock))
+		if err != nil {
+			return Schedule{}, fmt.Errorf("schedule entry %q: bad time: %w", entry, err)
+		}
+
+		day = strings.ToLower(day)
+		if day == "daily" {
+			for d := time.Sunday; d <= time.Saturday; d++ {
+				s.moments = append(s.moments, weeklyMoment{d, at.Hour(), at.Minute()})
+			}
+			continue
+		}
+		weekday, ok := dayNames[day]
+		if !ok {
+			return Schedule{}, fmt.Errorf("schedule entry %q: unknown day %q", entry, day)
+		}
+		s.moments = append(s.moments, weeklyMoment{weekday, at.Hour(), at.Minute()})
+	}
+	return s, nil
+}
+
+// Empty reports whether the schedule never fires
+func (s Schedule) Empty() bool {
+	return len(s.moments) == 0
+}
+
+// Next returns the first scheduled moment strictly after t, or the zero
+// time for an empty schedule
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.Empty() {
+		return time.Time{}
+	}
+
+	local := t.In(s.loc)
+	var next time.Time
+	// A week and a day covers every moment, including today's that passed
+	for days := 0; days <= 7; days++ {
+		date := local.AddDate(0, 0, days)
+		for _, m := range s.moments {
+			if m.day != date.Weekday() {
+				continue
+			}
+			candidate := time.Date(date.Year(), date.Month(), date.Day(), m.hour, m.minute, 0, 0, s.loc)
+			if candidate.After(t) && (next.IsZero() || candidate.Before(next)) {
+				next = candidate
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
@@ -0,0 +1,60 @@
+package canvas
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNextFindsEarliestMoment(t *testing.T) {
+	boston, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	schedule, err := ParseSchedule("Sun 18:00, wed 06:30", boston)
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+
+	tests := []struct {
+		after string
+		want  string
+	}{
+		// Monday -> Wednesday morning
+		{"2024-03-04T12:00:00-05:00", "2024-03-06T06:30:00-05:00"},
+		// Exactly at a moment -> the next one
+		{"2024-03-06T06:30:00-05:00", "2024-03-10T18:00:00-04:00"},
+		// Sunday after 18:00 -> next Wednesday
+		{"2024-03-10T19:00:00-04:00", "2024-03-13T06:30:00-04:00"},
+	}
+	for _, tt := range tests {
+		after, _ := time.Parse(time.RFC3339, tt.after)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if got := schedule.Next(after); !got.Equal(want) {
+			t.Errorf("Next(%s) = %s, want %s", tt.after, got.Format(time.RFC3339), tt.want)
+		}
+	}
+}
+
+func TestScheduleDailyAndEmpty(t *testing.T) {
+	schedule, err := ParseSchedule("daily 04:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+	after := time.Date(2024, 3, 4, 5, 0, 0, 0, time.UTC)
+	if got, want := schedule.Next(after), time.Date(2024, 3, 5, 4, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	empty, err := ParseSchedule(" ", time.UTC)
+	if err != nil || !empty.Empty() || !empty.Next(after).IsZero() {
+		t.Errorf("Expected an empty schedule that never fires, got %+v (%v)", empty, err)
+	}
+}
+
+func TestParseScheduleRejectsBadEntries(t *testing.T) {
+	for _, spec := range []string{"Sunday 18:00", "Sun", "Sun 25:00", "Sun 6pm"} {
+		if _, err := ParseSchedule(spec, time.UTC); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package events exports accepted paints to optional downstream sinks such
+// as Kafka. Sinks are interchangeable and must never block the paint path.
+package events
+
+import "fmt"
+
+// PaintEvent describes a single accepted paint. It is the one event type
+// every sink receives.
+type PaintEvent struct {
+	Cx       int64  `json:"cx"`
+	Cy       int64  `json:"cy"`
+	O        int    `json:"o"`
+	Color    uint8  `json:"color"`
+	Prev     uint8  `json:"prev"`
+	Seq      uint64 `json:"seq"`
+	Ts       int64  `json:"ts"`
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// ChunkKey returns the "cx:cy" key used to keep a chunk's events in order
+func (e PaintEvent) ChunkKey() string {
+	return fmt.Sprintf("%d:%d", e.Cx, e.Cy)
+}
+
+// Sink receives paint events. Emit must return immediately; a sink that
+// cannot keep up drops events rather than stalling the caller.
+type Sink interface {
+	Emit(event PaintEvent)
+	Close() error
+}
+
+// multiSink fans events out to several sinks
+type multiSink []Sink
+
+// Multi combines sinks into one; with a single sink it returns it unchanged
+func Multi(sinks ...Sink) Sink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return multiSink(sinks)
+}
+
+func (m multiSink) Emit(event PaintEvent) {
+	for _, s := range m {
+		s.Emit(event)
+	}
+}
+
+// Close closes every sink and returns the first error
+func (m multiSink) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
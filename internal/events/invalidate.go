@@ -0,0 +1,189 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Invalidation tells a CDN origin that a chunk changed and its newest seq,
+// so cached /state/chunk responses older than Seq can be purged or refetched
+type Invalidation struct {
+	Cx  int64  `json:"cx"`
+	Cy  int64  `json:"cy"`
+	Seq uint64 `json:"seq"`
+}
+
+// Notifier delivers invalidations, so tests can substitute a fake
+type Notifier interface {
+	Notify(ctx context.Context, invalidations []Invalidation) error
+}
+
+// HTTPNotifier POSTs each batch of invalidations to URL as a JSON array
+type HTTPNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify sends one request for the batch; non-2xx responses are errors
+func (n *HTTPNotifier) Notify(ctx context.Context, invalidations []Invalidation) error {
+	body, err := json.Marshal(invalidations)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalidation endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// InvalidationConfig holds the sink's tunables. Zero values use defaults.
+type InvalidationConfig struct {
+	// Debounce is the window over which a chunk's paints collapse into one
+	// invalidation carrying the latest seq
+	Debounce time.Duration
+	// BufferSize bounds how many events wait for the debouncer before Emit
+	// drops
+	BufferSize int
+	// Timeout bounds a single Notify call
+	Timeout time.Duration
+}
+
+const (
+	defaultInvalidationDebounce   = time.Second
+	defaultInvalidationBufferSize = 10000
+	defaultInvalidationTimeout    = 5 * time.Second
+)
+
+// InvalidationSink turns paint events into at most one invalidation per
+// chunk per debounce window
+type InvalidationSink struct {
+	notifier Notifier
+	config   InvalidationConfig
+
+	events  chan PaintEvent
+	quit    chan struct{}
+	done    chan struct{}
+	closeMu sync.Once
+
+	dropped atomic.Uint64
+}
+
+// NewInvalidationSink starts a sink that sends invalidations to notifier
+func NewInvalidationSink(notifier Notifier, config InvalidationConfig) *InvalidationSink {
+	if config.Debounce <= 0 {
+		config.Debounce = defaultInvalidationDebounce
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultInvalidationBufferSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultInvalidationTimeout
+	}
+
+	s := &InvalidationSink{
+		notifier: notifier,
+		config:   config,
+		events:   make(chan PaintEvent, config.BufferSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Emit records a chunk change, dropping it if the buffer is full
+func (s *InvalidationSink) Emit(event PaintEvent) {
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many events were discarded under backpressure
+func (s *InvalidationSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close sends invalidations still pending and stops the sink
+func (s *InvalidationSink) Close() error {
+	s.closeMu.Do(func() { close(s.quit) })
+	<-s.done
+	return nil
+}
+
+// run collects each chunk's latest seq and notifies once per window
+func (s *InvalidationSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Debounce)
+	defer ticker.Stop()
+
+	pending := make(map[string]Invalidation)
+	add := func(event PaintEvent) {
+		key := event.ChunkKey()
+		if inv, ok := pending[key]; ok && inv.Seq >= event.Seq {
+			return
+		}
+		pending[key] = Invalidation{Cx: event.Cx, Cy: event.Cy, Seq: event.Seq}
+	}
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		batch := make([]Invalidation, 0, len(pending))
+		for _, inv := range pending {
+			batch = append(batch, inv)
+		}
+		clear(pending)
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		if err := s.notifier.Notify(ctx, batch); err != nil {
+			slog.Warn("events: invalidation failed", "chunks", len(batch), "err", err)
+		}
+		cancel()
+	}
+
+	for {
+		select {
+		case event := <-s.events:
+			add(event)
+		case <-ticker.C:
+			flush()
+		case <-s.quit:
+			for {
+				select {
+				case event := <-s.events:
+					add(event)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
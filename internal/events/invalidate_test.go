@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records each Notify call as one batch
+type fakeNotifier struct {
+	mu      sync.Mutex
+	batches [][]Invalidation
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, invalidations []Invalidation) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.batches = append(n.batches, invalidations)
+	return nil
+}
+
+func (n *fakeNotifier) sent() [][]Invalidation {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([][]Invalidation(nil), n.batches...)
+}
+
+func TestInvalidationSinkDebouncesPerChunk(t *testing.T) {
+	notifier := &fakeNotifier{}
+	sink := NewInvalidationSink(notifier, InvalidationConfig{Debounce: 100 * time.Millisecond})
+	defer sink.Close()
+
+	// A burst on two chunks inside one window
+	for seq := uint64(1); seq <= 5; seq++ {
+		sink.Emit(PaintEvent{Cx: 1, Cy: 2, O: int(seq), Seq: seq})
+	}
+	sink.Emit(PaintEvent{Cx: 3, Cy: 4, Seq: 9})
+	sink.Emit(PaintEvent{Cx: 3, Cy: 4, Seq: 8}) // late arrival of an older seq
+
+	deadline := time.Now().Add(time.Second)
+	for len(notifier.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Nothing else happens in later windows
+	time.Sleep(250 * time.Millisecond)
+	batches := notifier.sent()
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 notification, got %d: %v", len(batches), batches)
+	}
+
+	got := batches[0]
+	sort.Slice(got, func(i, j int) bool { return got[i].Cx < got[j].Cx })
+	want := []Invalidation{{Cx: 1, Cy: 2, Seq: 5}, {Cx: 3, Cy: 4, Seq: 9}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestInvalidationSinkFlushesOnClose(t *testing.T) {
+	notifier := &fakeNotifier{}
+	sink := NewInvalidationSink(notifier, InvalidationConfig{Debounce: time.Hour})
+
+	sink.Emit(PaintEvent{Cx: 1, Cy: 2, Seq: 7})
+	sink.Close()
+
+	batches := notifier.sent()
+	if len(batches) != 1 || len(batches[0]) != 1 || batches[0][0] != (Invalidation{Cx: 1, Cy: 2, Seq: 7}) {
+		t.Errorf("expected the pending invalidation on Close, got %v", batches)
+	}
+}
+
+func TestHTTPNotifierPostsJSON(t *testing.T) {
+	var got []Invalidation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	notifier := &HTTPNotifier{URL: server.URL}
+	if err := notifier.Notify(context.Background(), []Invalidation{{Cx: 1, Cy: 2, Seq: 3}}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != (Invalidation{Cx: 1, Cy: 2, Seq: 3}) {
+		t.Errorf("unexpected body %v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	notifier.URL = failing.URL
+	if err := notifier.Notify(context.Background(), nil); err == nil {
+		t.Error("expected an error for a 502")
+	}
+}
@@ -0,0 +1,160 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Producer is the subset of *kafka.Writer the sink needs, so tests can
+// substitute a fake
+type Producer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaConfig holds the sink's batching tunables. Zero values use defaults.
+type KafkaConfig struct {
+	// BufferSize bounds how many events wait for delivery before Emit drops
+	BufferSize int
+	// BatchSize is the most events written in one WriteMessages call
+	BatchSize int
+	// FlushInterval is how long a partial batch may wait before it is sent
+	FlushInterval time.Duration
+	// WriteTimeout bounds a single WriteMessages call
+	WriteTimeout time.Duration
+}
+
+const (
+	defaultKafkaBufferSize    = 10000
+	defaultKafkaBatchSize     = 100
+	defaultKafkaFlushInterval = 100 * time.Millisecond
+	defaultKafkaWriteTimeout  = 5 * time.Second
+)
+
+// KafkaSink delivers paint events to a Kafka topic in batches from a
+// background goroutine
+type KafkaSink struct {
+	producer Producer
+	config   KafkaConfig
+
+	events  chan PaintEvent
+	quit    chan struct{}
+	done    chan struct{}
+	closeMu sync.Once
+
+	dropped atomic.Uint64
+}
+
+// NewKafkaWriter creates a producer for the given brokers and topic. Events
+// are keyed by chunk so each chunk's paints stay ordered within a partition.
+func NewKafkaWriter(brokers []string, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	}
+}
+
+// NewKafkaSink starts a sink that writes to producer
+func NewKafkaSink(producer Producer, config KafkaConfig) *KafkaSink {
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultKafkaBufferSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultKafkaBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultKafkaFlushInterval
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultKafkaWriteTimeout
+	}
+
+	s := &KafkaSink{
+		producer: producer,
+		config:   config,
+		events:   make(chan PaintEvent, config.BufferSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Emit queues an event for delivery, dropping it if the buffer is full
+func (s *KafkaSink) Emit(event PaintEvent) {
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many events were discarded under backpressure
+func (s *KafkaSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close flushes queued events and closes the producer
+func (s *KafkaSink) Close() error {
+	s.closeMu.Do(func() { close(s.quit) })
+	<-s.done
+	return s.producer.Close()
+}
+
+// run batches queued events and writes them until Close
+func (s *KafkaSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]kafka.Message, 0, s.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.WriteTimeout)
+		if err := s.producer.WriteMessages(ctx, batch...); err != nil {
+			slog.Warn("events: kafka write failed", "events", len(batch), "err", err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+	add := func(event PaintEvent) {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		batch = append(batch, kafka.Message{Key: []byte(event.ChunkKey()), Value: value})
+		if len(batch) >= s.config.BatchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.events:
+			add(event)
+		case <-ticker.C:
+			flush()
+		case <-s.quit:
+			// Deliver whatever was already queued before shutting down
+			for {
+				select {
+				case event := <-s.events:
+					add(event)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
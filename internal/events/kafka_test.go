@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeProducer records written messages. When gate is set, each write
+// signals started and then waits for the gate to open.
+type fakeProducer struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	started  chan struct{}
+	gate     chan struct{}
+}
+
+func (p *fakeProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.gate != nil {
+		p.started <- struct{}{}
+		<-p.gate
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *fakeProducer) Close() error { return nil }
+
+func (p *fakeProducer) written() []kafka.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]kafka.Message(nil), p.messages...)
+}
+
+func TestKafkaSinkEnqueuesEvents(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := NewKafkaSink(producer, KafkaConfig{BatchSize: 2, FlushInterval: 10 * time.Millisecond})
+
+	for i := 0; i < 3; i++ {
+		sink.Emit(PaintEvent{Cx: 1, Cy: 2, O: i, Color: 5, Seq: uint64(i + 1)})
+	}
+
+	// Two events fill a batch; the third goes out on the flush interval
+	deadline := time.Now().Add(time.Second)
+	for len(producer.written()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	messages := producer.written()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	for i, msg := range messages {
+		if string(msg.Key) != "1:2" {
+			t.Errorf("Message %d: expected key 1:2, got %q", i, msg.Key)
+		}
+		var event PaintEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			t.Fatalf("Message %d: bad payload: %v", i, err)
+		}
+		if event.Seq != uint64(i+1) {
+			t.Errorf("Message %d: expected seq %d, got %d", i, i+1, event.Seq)
+		}
+	}
+	if sink.Dropped() != 0 {
+		t.Errorf("Expected no drops, got %d", sink.Dropped())
+	}
+}
+
+func TestKafkaSinkDropsUnderBackpressure(t *testing.T) {
+	producer := &fakeProducer{
+		started: make(chan struct{}, 1),
+		gate:    make(chan struct{}),
+	}
+	sink := NewKafkaSink(producer, KafkaConfig{BufferSize: 2, BatchSize: 1})
+
+	// The first event is picked up and stalls in the producer
+	sink.Emit(PaintEvent{Seq: 1})
+	<-producer.started
+
+	// Two more fill the buffer, the rest must be dropped without blocking
+	for i := 2; i <= 6; i++ {
+		sink.Emit(PaintEvent{Seq: uint64(i)})
+	}
+	if got := sink.Dropped(); got != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", got)
+	}
+
+	// Let the stalled write and the buffered events drain
+	close(producer.gate)
+	go func() {
+		for range producer.started {
+		}
+	}()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	close(producer.started)
+	if got := len(producer.written()); got != 3 {
+		t.Errorf("Expected 3 delivered events, got %d", got)
+	}
+}
@@ -1,31 +1,202 @@
 package geo
 
-import "math"
+import (
+	"errors"
+	"fmt"
+	"math"
+)
 
 const (
 	earthRadius = 6378137.0
 	originShift = math.Pi * earthRadius
-	tileMeters  = 10.0
+
+	// maxLat is the Web Mercator latitude limit
+	maxLat = 85.05112878
 )
 
+// Projection maps WGS84 lat/lon onto a grid of square Web Mercator tiles,
+// TileMeters on a side at the equator, grouped into chunks of ChunkSize ×
+// ChunkSize tiles
+type Projection struct {
+	TileMeters float64
+	ChunkSize  int64
+}
+
+// DefaultProjection is the 10m tiles in 256-tile chunks the package-level
+// functions use
+var DefaultProjection = Projection{TileMeters: 10, ChunkSize: 256}
+
+// NewProjection returns a projection with the given tile size in meters and
+// tiles per chunk side
+func NewProjection(tileMeters float64, chunkSize int64) (Projection, error) {
+	if !(tileMeters > 0) || math.IsInf(tileMeters, 1) {
+		return Projection{}, fmt.Errorf("tile size %v must be a positive number of meters", tileMeters)
+	}
+	if chunkSize <= 0 {
+		return Projection{}, fmt.Errorf("chunk size %d must be positive", chunkSize)
+	}
+	return Projection{TileMeters: tileMeters, ChunkSize: chunkSize}, nil
+}
+
+// maxTile is the largest tile index on either axis; the last tile on each
+// axis is partial since the world isn't a whole number of tiles wide
+var maxTile = DefaultProjection.maxTile()
+
+// maxTile returns the largest tile index on either axis
+func (p Projection) maxTile() int64 {
+	return int64(math.Floor(2 * originShift / p.TileMeters))
+}
+
+// MaxChunk returns the largest chunk index on either axis; chunks run from
+// 0 to it, as tiles do
+func (p Projection) MaxChunk() int64 {
+	return floorDiv(p.maxTile(), p.ChunkSize)
+}
+
 // LatLonToTileXY converts WGS84 lat/lon to tile coordinates (x, y)
-func LatLonToTileXY(lat, lon float64) (x, y int64) {
+func (p Projection) LatLonToTileXY(lat, lon float64) (x, y int64) {
 	// Clamp latitude to Mercator
-	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	lat = math.Max(math.Min(lat, maxLat), -maxLat)
 	mx := lon * originShift / 180.0
 	my := math.Log(math.Tan((90.0+lat)*math.Pi/360.0)) * earthRadius
-	// Shift to [0, 2*originShift], then quantize to 10m tiles
-	tx := int64(math.Floor((mx + originShift) / tileMeters))
-	ty := int64(math.Floor((originShift - my) / tileMeters)) // top-down
+	// Shift to [0, 2*originShift], then quantize to tiles
+	tx := int64(math.Floor((mx + originShift) / p.TileMeters))
+	ty := int64(math.Floor((originShift - my) / p.TileMeters)) // top-down
 	return tx, ty
 }
 
+// TileXYToLatLon converts tile coordinates to the WGS84 lat/lon of the tile's
+// center. Out-of-range tiles are clamped to the world's edges.
+func (p Projection) TileXYToLatLon(x, y int64) (lat, lon float64) {
+	maxTile := p.maxTile()
+	x = min(max(x, 0), maxTile)
+	y = min(max(y, 0), maxTile)
+
+	// Center of the tile, kept inside the world for the partial edge tiles
+	mx := math.Min((float64(x)+0.5)*p.TileMeters, 2*originShift) - originShift
+	my := originShift - math.Min((float64(y)+0.5)*p.TileMeters, 2*originShift)
+
+	lon = mx * 180.0 / originShift
+	lat = (2.0*math.Atan(math.Exp(my/earthRadius)) - math.Pi/2.0) * 180.0 / math.Pi
+	lat = math.Max(math.Min(lat, maxLat), -maxLat)
+	return lat, lon
+}
+
 // ChunkOf returns the chunk coordinates for a given tile coordinate
-func ChunkOf(x, y int64) (cx, cy int64) {
-	return x >> 8, y >> 8
+func (p Projection) ChunkOf(x, y int64) (cx, cy int64) {
+	return floorDiv(x, p.ChunkSize), floorDiv(y, p.ChunkSize)
 }
 
 // OffsetOf returns the offset within a chunk for a given tile coordinate
+func (p Projection) OffsetOf(x, y int64) int {
+	return int((y-floorDiv(y, p.ChunkSize)*p.ChunkSize)*p.ChunkSize + x - floorDiv(x, p.ChunkSize)*p.ChunkSize)
+}
+
+// TileRect returns the tiles covering a lat/lon rectangle, given with its
+// corners in either order. Tile y runs south from the top, so the
+// north-west corner has the least x and y and the south-east corner the
+// greatest.
+func (p Projection) TileRect(minLat, minLon, maxLat, maxLon float64) (minX, minY, maxX, maxY int64) {
+	if minLat > maxLat {
+		minLat, maxLat = maxLat, minLat
+	}
+	if minLon > maxLon {
+		minLon, maxLon = maxLon, minLon
+	}
+	minX, minY = p.LatLonToTileXY(maxLat, minLon)
+	maxX, maxY = p.LatLonToTileXY(minLat, maxLon)
+	return minX, minY, maxX, maxY
+}
+
+// ErrTooManyChunks is returned by ChunksInBounds for a rectangle covering
+// more chunks than the caller allows
+var ErrTooManyChunks = errors.New("too many chunks")
+
+// ChunksInBounds returns the chunks covering a lat/lon rectangle, row by
+// row from the north-west, clipped to the world. The corners may be given
+// in either order. A rectangle covering more than maxChunks chunks returns
+// ErrTooManyChunks, before anything is allocated.
+func (p Projection) ChunksInBounds(minLat, minLon, maxLat, maxLon float64, maxChunks int) ([][2]int64, error) {
+	minX, minY, maxX, maxY := p.TileRect(minLat, minLon, maxLat, maxLon)
+	maxChunk := p.MaxChunk()
+	minCx, minCy := p.ChunkOf(minX, minY)
+	maxCx, maxCy := p.ChunkOf(maxX, maxY)
+	minCx, minCy = max(minCx, 0), max(minCy, 0)
+	maxCx, maxCy = min(maxCx, maxChunk), min(maxCy, maxChunk)
+
+	// Both sides are clipped to the world, so the product can't overflow
+	count := max(0, (maxCx-minCx+1)*(maxCy-minCy+1))
+	if count > int64(maxChunks) {
+		return nil, fmt.Errorf("%w: %d×%d, max %d", ErrTooManyChunks, maxCx-minCx+1, maxCy-minCy+1, maxChunks)
+	}
+
+	chunks := make([][2]int64, 0, count)
+	for cy := minCy; cy <= maxCy; cy++ {
+		for cx := minCx; cx <= maxCx; cx++ {
+			chunks = append(chunks, [2]int64{cx, cy})
+		}
+	}
+	return chunks, nil
+}
+
+// NeighborChunks returns the eight chunks around (cx, cy), row by row from
+// the north-west. Chunks past the world's edge are included as they are;
+// nothing wraps.
+func NeighborChunks(cx, cy int64) [8][2]int64 {
+	var neighbors [8][2]int64
+	i := 0
+	for dy := int64(-1); dy <= 1; dy++ {
+		for dx := int64(-1); dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			neighbors[i] = [2]int64{cx + dx, cy + dy}
+			i++
+		}
+	}
+	return neighbors
+}
+
+// lonToTileX returns the fractional tile x of a longitude
+func (p Projection) lonToTileX(lon float64) float64 {
+	return (lon*originShift/180.0 + originShift) / p.TileMeters
+}
+
+// floorDiv divides rounding toward negative infinity, as a shift would
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// LatLonToTileXY converts WGS84 lat/lon to tile coordinates (x, y) in the
+// default projection
+func LatLonToTileXY(lat, lon float64) (x, y int64) {
+	return DefaultProjection.LatLonToTileXY(lat, lon)
+}
+
+// TileXYToLatLon converts tile coordinates in the default projection to the
+// WGS84 lat/lon of the tile's center
+func TileXYToLatLon(x, y int64) (lat, lon float64) {
+	return DefaultProjection.TileXYToLatLon(x, y)
+}
+
+// ChunkOf returns the chunk coordinates for a given tile coordinate in the
+// default projection
+func ChunkOf(x, y int64) (cx, cy int64) {
+	return DefaultProjection.ChunkOf(x, y)
+}
+
+// OffsetOf returns the offset within a chunk for a given tile coordinate in
+// the default projection
 func OffsetOf(x, y int64) int {
-	return int(((y & 255) << 8) | (x & 255))
+	return DefaultProjection.OffsetOf(x, y)
+}
+
+// ChunksInBounds returns the chunks in the default projection covering a
+// lat/lon rectangle, up to maxChunks of them
+func ChunksInBounds(minLat, minLon, maxLat, maxLon float64, maxChunks int) ([][2]int64, error) {
+	return DefaultProjection.ChunksInBounds(minLat, minLon, maxLat, maxLon, maxChunks)
 }
@@ -1,6 +1,7 @@
 package geo
 
 import (
+	"errors"
 	"math"
 	"testing"
 )
@@ -167,17 +168,7 @@ func TestCoordinateRoundTrip(t *testing.T) {
 	originalLon := -71.0589
 
 	x, y := LatLonToTileXY(originalLat, originalLon)
-
-	// Convert back to approximate lat/lon
-	// This is a simplified reverse conversion for testing
-	const earthRadius = 6378137.0
-	const originShift = math.Pi * earthRadius
-	const tileMeters = 10.0
-	mx := float64(x)*tileMeters - originShift
-	my := originShift - float64(y)*tileMeters
-
-	approxLon := mx * 180.0 / originShift
-	approxLat := 2.0*math.Atan(math.Exp(my/earthRadius))*180.0/math.Pi - 90.0
+	approxLat, approxLon := TileXYToLatLon(x, y)
 
 	// Allow for reasonable precision loss (within ~10 meters)
 	latDiff := math.Abs(approxLat - originalLat)
@@ -192,6 +183,38 @@ func TestCoordinateRoundTrip(t *testing.T) {
 	}
 }
 
+func TestTileXYToLatLonRoundTrip(t *testing.T) {
+	bx, by := LatLonToTileXY(42.3601, -71.0589)
+	tiles := [][2]int64{
+		{0, 0},
+		{maxTile, maxTile},
+		{0, maxTile},
+		{maxTile / 2, maxTile / 2},
+		{bx, by},
+		{bx + 1, by + 1},
+		{1234567, 3456789},
+	}
+	for _, tile := range tiles {
+		lat, lon := TileXYToLatLon(tile[0], tile[1])
+		x, y := LatLonToTileXY(lat, lon)
+		if x != tile[0] || y != tile[1] {
+			t.Errorf("Tile (%d, %d) -> (%f, %f) -> tile (%d, %d)", tile[0], tile[1], lat, lon, x, y)
+		}
+	}
+}
+
+func TestTileXYToLatLonClampsAtPoles(t *testing.T) {
+	lat, lon := TileXYToLatLon(-5, -5)
+	if lat > 85.05112878 || lat < 85.0 || lon < -180 {
+		t.Errorf("Top-left tile center out of range: (%f, %f)", lat, lon)
+	}
+
+	lat, lon = TileXYToLatLon(maxTile+100, maxTile+100)
+	if lat < -85.05112878 || lat > -85.0 || lon > 180 {
+		t.Errorf("Bottom-right tile center out of range: (%f, %f)", lat, lon)
+	}
+}
+
 func TestLatitudeClamping(t *testing.T) {
 	// Test that extreme latitudes are properly clamped and don't panic
 	extremeLat := 90.0
@@ -209,3 +232,104 @@ func TestLatitudeClamping(t *testing.T) {
 	_ = x2
 	_ = y2
 }
+
+func TestProjectionFiveMeterTilesAreHalfSize(t *testing.T) {
+	fine, err := NewProjection(5, 256)
+	if err != nil {
+		t.Fatalf("NewProjection failed: %v", err)
+	}
+
+	const lat, lon = 42.3601, -71.0589
+	size := func(p Projection) (dLat, dLon float64) {
+		x, y := p.LatLonToTileXY(lat, lon)
+		lat0, lon0 := p.TileXYToLatLon(x, y)
+		lat1, lon1 := p.TileXYToLatLon(x+1, y+1)
+		return lat0 - lat1, lon1 - lon0
+	}
+	coarseLat, coarseLon := size(DefaultProjection)
+	fineLat, fineLon := size(fine)
+	if math.Abs(fineLat/coarseLat-0.5) > 1e-6 || math.Abs(fineLon/coarseLon-0.5) > 1e-6 {
+		t.Errorf("5m tile is %g x %g degrees, 10m is %g x %g; want half on each side", fineLat, fineLon, coarseLat, coarseLon)
+	}
+
+	// Each 10m tile splits into 2x2 of the 5m ones
+	x10, y10 := DefaultProjection.LatLonToTileXY(lat, lon)
+	x5, y5 := fine.LatLonToTileXY(lat, lon)
+	if x5/2 != x10 || y5/2 != y10 {
+		t.Errorf("5m tile (%d, %d) isn't inside 10m tile (%d, %d)", x5, y5, x10, y10)
+	}
+}
+
+func TestProjectionChunksOfOtherSizes(t *testing.T) {
+	p := Projection{TileMeters: 10, ChunkSize: 100}
+	if cx, cy := p.ChunkOf(250, 99); cx != 2 || cy != 0 {
+		t.Errorf("ChunkOf(250, 99) = (%d, %d), want (2, 0)", cx, cy)
+	}
+	if o := p.OffsetOf(250, 99); o != 99*100+50 {
+		t.Errorf("OffsetOf(250, 99) = %d, want %d", o, 99*100+50)
+	}
+
+	// The default projection matches the shift-based layout, negative tiles
+	// included
+	for _, tile := range [][2]int64{{0, 0}, {257, 511}, {-1, -257}} {
+		cx, cy := ChunkOf(tile[0], tile[1])
+		if cx != tile[0]>>8 || cy != tile[1]>>8 || OffsetOf(tile[0], tile[1]) != int((tile[1]&255)<<8|tile[0]&255) {
+			t.Errorf("tile %v: chunk (%d, %d) offset %d", tile, cx, cy, OffsetOf(tile[0], tile[1]))
+		}
+	}
+
+	// The world's last tile is in the last chunk
+	if cx, _ := ChunkOf(maxTile, 0); DefaultProjection.MaxChunk() != cx || cx != 15654 {
+		t.Errorf("MaxChunk() = %d, want the last tile's chunk %d", DefaultProjection.MaxChunk(), cx)
+	}
+
+	if _, err := NewProjection(0, 256); err == nil {
+		t.Errorf("Expected an error for 0m tiles")
+	}
+	if _, err := NewProjection(5, 0); err == nil {
+		t.Errorf("Expected an error for empty chunks")
+	}
+}
+
+func TestChunksInBounds(t *testing.T) {
+	cx, cy := ChunkOf(LatLonToTileXY(42.3601, -71.0589))
+	x0, y0 := cx*256, cy*256
+
+	// Inside one chunk
+	northLat, westLon := TileXYToLatLon(x0+10, y0+10)
+	southLat, eastLon := TileXYToLatLon(x0+200, y0+200)
+	got, err := ChunksInBounds(southLat, westLon, northLat, eastLon, 1)
+	if err != nil || len(got) != 1 || got[0] != [2]int64{cx, cy} {
+		t.Errorf("Expected the single chunk (%d, %d), got %v (%v)", cx, cy, got, err)
+	}
+
+	// From the last tile of one chunk to the first of the next diagonally,
+	// with the corners swapped
+	northLat, westLon = TileXYToLatLon(x0+255, y0+255)
+	southLat, eastLon = TileXYToLatLon(x0+256, y0+256)
+	got, err = ChunksInBounds(northLat, eastLon, southLat, westLon, 4)
+	want := [][2]int64{{cx, cy}, {cx + 1, cy}, {cx, cy + 1}, {cx + 1, cy + 1}}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("Expected a 2x2 grid %v, got %v (%v)", want, got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Chunk %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// One more chunk than allowed, or the whole world, is refused
+	if got, err := ChunksInBounds(northLat, eastLon, southLat, westLon, 3); !errors.Is(err, ErrTooManyChunks) || got != nil {
+		t.Errorf("Expected ErrTooManyChunks for 4 chunks with a max of 3, got %v (%v)", got, err)
+	}
+	if _, err := ChunksInBounds(-90, -180, 90, 180, 1<<20); !errors.Is(err, ErrTooManyChunks) {
+		t.Errorf("Expected ErrTooManyChunks for the whole world, got %v", err)
+	}
+}
+
+func TestNeighborChunks(t *testing.T) {
+	want := [8][2]int64{{4, 6}, {5, 6}, {6, 6}, {4, 7}, {6, 7}, {4, 8}, {5, 8}, {6, 8}}
+	if got := NeighborChunks(5, 7); got != want {
+		t.Errorf("NeighborChunks(5, 7) = %v, want %v", got, want)
+	}
+}
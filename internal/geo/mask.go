@@ -1,12 +1,20 @@
 package geo
 
-import "math"
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
 
-// Mask represents a geofence mask for tile allowances
+// Mask represents a geofence mask for tile allowances. It is safe for
+// concurrent use, so operators can open and close areas while paints are
+// being checked.
 type Mask struct {
-	data     []byte
-	bounds   Bounds
-	tileSize float64
+	mu     sync.RWMutex
+	data   []byte
+	bounds Bounds
+	proj   Projection
 }
 
 // Bounds represents the bounding box for the mask
@@ -14,22 +22,146 @@ type Bounds struct {
 	MinX, MinY, MaxX, MaxY int64
 }
 
-// NewMask creates a new mask with the given bounds and tile size
+// NewMask creates a new mask with the given bounds and tile size, in
+// otherwise default chunks
 func NewMask(bounds Bounds, tileSize float64) *Mask {
+	return NewMaskIn(bounds, Projection{TileMeters: tileSize, ChunkSize: DefaultProjection.ChunkSize})
+}
+
+// NewMaskIn creates a new mask with bounds given in proj's tiles
+func NewMaskIn(bounds Bounds, proj Projection) *Mask {
 	width := int(bounds.MaxX - bounds.MinX + 1)
 	height := int(bounds.MaxY - bounds.MinY + 1)
 	totalTiles := width * height
 	bytesNeeded := (totalTiles + 7) / 8 // Round up to nearest byte
 
 	return &Mask{
-		data:     make([]byte, bytesNeeded),
-		bounds:   bounds,
-		tileSize: tileSize,
+		data:   make([]byte, bytesNeeded),
+		bounds: bounds,
+		proj:   proj,
+	}
+}
+
+// LatLon is a WGS84 point
+type LatLon struct {
+	Lat, Lon float64
+}
+
+// metersPerDegree is the length of a degree of latitude, near enough
+const metersPerDegree = 111320.0
+
+// NewCircleMask creates a mask allowing the tiles whose centers are within
+// radiusM meters of the center, bounded to the circle's tiles
+func NewCircleMask(centerLat, centerLon, radiusM, tileSize float64) (*Mask, error) {
+	if !(radiusM > 0) {
+		return nil, fmt.Errorf("circle radius must be positive, got %g", radiusM)
+	}
+	proj := Projection{TileMeters: tileSize, ChunkSize: DefaultProjection.ChunkSize}
+
+	// Pad the box a little so rounding never clips the circle's edge tiles
+	dLat := radiusM/metersPerDegree + 2*tileSize/metersPerDegree
+	dLon := dLat / math.Cos(centerLat*math.Pi/180)
+	minX, minY := proj.LatLonToTileXY(centerLat+dLat, centerLon-dLon)
+	maxX, maxY := proj.LatLonToTileXY(centerLat-dLat, centerLon+dLon)
+
+	m := NewMaskIn(Bounds{MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY}, proj)
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			lat, lon := proj.TileXYToLatLon(x, y)
+			if HaversineDistance(centerLat, centerLon, lat, lon) <= radiusM {
+				m.setTile(x, y, true)
+			}
+		}
 	}
+	return m, nil
+}
+
+// NewPolygonMask creates a mask allowing the tiles whose centers are inside
+// the polygon, bounded to its bounding box. The ring is closed implicitly.
+func NewPolygonMask(points []LatLon, tileSize float64) (*Mask, error) {
+	if len(points) < 3 {
+		return nil, fmt.Errorf("polygon needs at least 3 points, got %d", len(points))
+	}
+	proj := Projection{TileMeters: tileSize, ChunkSize: DefaultProjection.ChunkSize}
+
+	ring := make([][2]float64, len(points))
+	for i, pt := range points {
+		ring[i] = [2]float64{pt.Lon, pt.Lat}
+	}
+	p := &Polygon{rings: [][][2]float64{ring}}
+
+	bounds := p.TileBoundsIn(proj)
+	m := NewMaskIn(bounds, proj)
+	// rowSpans casts a ray along each row once, rather than per tile
+	for y := bounds.MinY; y <= bounds.MaxY; y++ {
+		for _, span := range p.rowSpans(proj, y) {
+			for x := span[0]; x < span[1]; x++ {
+				m.setTile(x, y, true)
+			}
+		}
+	}
+	return m, nil
+}
+
+// LoadMask reads a mask file: the tile bits over bounds, row-major and packed
+// MSB first, with no header. The bounds must match the ones it was built with.
+func LoadMask(r io.Reader, bounds Bounds, tileSize float64) (*Mask, error) {
+	return LoadMaskIn(r, bounds, Projection{TileMeters: tileSize, ChunkSize: DefaultProjection.ChunkSize})
+}
+
+// LoadMaskIn reads a mask file like LoadMask, for a mask built in proj
+func LoadMaskIn(r io.Reader, bounds Bounds, proj Projection) (*Mask, error) {
+	m := NewMaskIn(bounds, proj)
+	if _, err := io.ReadFull(r, m.data); err != nil {
+		return nil, fmt.Errorf("read mask: %w", err)
+	}
+
+	// Trailing bytes mean the bounds don't match the file
+	var extra [1]byte
+	if _, err := io.ReadFull(r, extra[:]); err == nil {
+		return nil, fmt.Errorf("mask is larger than %d bytes expected for bounds", len(m.data))
+	}
+	return m, nil
+}
+
+// Bounds returns the tile range the mask covers
+func (m *Mask) Bounds() Bounds {
+	return m.bounds
+}
+
+// Projection returns the projection the mask's tiles are in
+func (m *Mask) Projection() Projection {
+	return m.proj
 }
 
 // SetTile sets a tile as allowed (true) or forbidden (false)
 func (m *Mask) SetTile(x, y int64, allowed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setTile(x, y, allowed)
+}
+
+// SetRect sets every tile in the rectangle, inclusive, as allowed or
+// forbidden under one lock, and returns how many tiles were inside the
+// mask's bounds
+func (m *Mask) SetRect(minX, minY, maxX, maxY int64, allowed bool) int {
+	minX, minY = max(minX, m.bounds.MinX), max(minY, m.bounds.MinY)
+	maxX, maxY = min(maxX, m.bounds.MaxX), min(maxY, m.bounds.MaxY)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			m.setTile(x, y, allowed)
+			n++
+		}
+	}
+	return n
+}
+
+// setTile sets a tile; callers must hold mu
+func (m *Mask) setTile(x, y int64, allowed bool) {
 	if x < m.bounds.MinX || x > m.bounds.MaxX || y < m.bounds.MinY || y > m.bounds.MaxY {
 		return // Out of bounds
 	}
@@ -57,6 +189,9 @@ func (m *Mask) SetTile(x, y int64, allowed bool) {
 
 // IsTileAllowed checks if a tile is allowed
 func (m *Mask) IsTileAllowed(x, y int64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if x < m.bounds.MinX || x > m.bounds.MaxX || y < m.bounds.MinY || y > m.bounds.MaxY {
 		return false // Out of bounds
 	}
@@ -78,6 +213,41 @@ func (m *Mask) IsTileAllowed(x, y int64) bool {
 	return (m.data[byteIndex] & (1 << (7 - bitOffset))) != 0
 }
 
+// AnyAllowed reports whether any tile in the rectangle, inclusive, is
+// allowed. Rows are scanned a byte at a time where they can be, so a whole
+// chunk is cheap to check.
+func (m *Mask) AnyAllowed(minX, minY, maxX, maxY int64) bool {
+	minX, minY = max(minX, m.bounds.MinX), max(minY, m.bounds.MinY)
+	maxX, maxY = min(maxX, m.bounds.MaxX), min(maxY, m.bounds.MaxY)
+	if minX > maxX || minY > maxY {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	width := m.bounds.MaxX - m.bounds.MinX + 1
+	for y := minY; y <= maxY; y++ {
+		// A row's tiles are consecutive bits
+		row := (y - m.bounds.MinY) * width
+		bit := row + minX - m.bounds.MinX
+		end := row + maxX - m.bounds.MinX + 1
+		for bit < end {
+			if bit%8 == 0 && end-bit >= 8 {
+				if m.data[bit/8] != 0 {
+					return true
+				}
+				bit += 8
+				continue
+			}
+			if m.data[bit/8]&(1<<(7-bit%8)) != 0 {
+				return true
+			}
+			bit++
+		}
+	}
+	return false
+}
+
 // HaversineDistance calculates the distance between two points in meters
 func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	const earthRadius = 6371000 // Earth radius in meters
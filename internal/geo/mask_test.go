@@ -2,6 +2,7 @@ package geo
 
 import (
 	"math"
+	"sync"
 	"testing"
 )
 
@@ -72,6 +73,186 @@ func TestMaskBounds(t *testing.T) {
 	}
 }
 
+func TestMaskSetRect(t *testing.T) {
+	mask := NewMask(Bounds{MinX: 10, MinY: 10, MaxX: 19, MaxY: 19}, 10.0)
+
+	// Clamped to the mask: only the 3x3 corner inside the bounds is set
+	if n := mask.SetRect(17, 17, 25, 25, true); n != 9 {
+		t.Errorf("Expected 9 tiles set, got %d", n)
+	}
+	if !mask.IsTileAllowed(17, 17) || !mask.IsTileAllowed(19, 19) || mask.IsTileAllowed(16, 17) {
+		t.Error("Expected exactly the corner to be opened")
+	}
+
+	mask.SetRect(18, 18, 18, 18, false)
+	if mask.IsTileAllowed(18, 18) || !mask.IsTileAllowed(19, 18) {
+		t.Error("Expected only (18, 18) to be closed again")
+	}
+
+	if n := mask.SetRect(30, 30, 40, 40, true); n != 0 {
+		t.Errorf("Expected a rectangle outside the bounds to set nothing, got %d", n)
+	}
+}
+
+func TestMaskAnyAllowed(t *testing.T) {
+	mask := NewMask(Bounds{MinX: 0, MinY: 0, MaxX: 99, MaxY: 99}, 10.0)
+	if mask.AnyAllowed(0, 0, 99, 99) {
+		t.Error("Expected an empty mask to allow nothing")
+	}
+
+	// One tile, off byte boundaries
+	mask.SetTile(37, 61, true)
+	for _, tc := range []struct {
+		minX, minY, maxX, maxY int64
+		want                   bool
+	}{
+		{0, 0, 99, 99, true},
+		{37, 61, 37, 61, true},
+		{32, 56, 47, 63, true},
+		{38, 0, 99, 99, false},
+		{0, 62, 99, 99, false},
+		{0, 0, 36, 99, false},
+		{-50, -50, 200, 200, true},
+		{100, 0, 200, 99, false},
+	} {
+		if got := mask.AnyAllowed(tc.minX, tc.minY, tc.maxX, tc.maxY); got != tc.want {
+			t.Errorf("AnyAllowed(%d, %d, %d, %d) = %v, want %v", tc.minX, tc.minY, tc.maxX, tc.maxY, got, tc.want)
+		}
+	}
+}
+
+// Run with -race: checks read the mask while an operator edits it
+func TestMaskConcurrentEdits(t *testing.T) {
+	mask := NewMask(Bounds{MinX: 0, MinY: 0, MaxX: 63, MaxY: 63}, 10.0)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for x := int64(0); x < 64; x++ {
+					mask.IsTileAllowed(x, x)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		mask.SetRect(0, 0, 63, 63, i%2 == 0)
+		mask.SetTile(int64(i%64), 5, true)
+	}
+	close(stop)
+	wg.Wait()
+
+	// The last full pass closed everything but the tiles set after it
+	if mask.IsTileAllowed(0, 0) || !mask.IsTileAllowed(7, 5) {
+		t.Error("Expected the final edits to win")
+	}
+}
+
+// Run with -race: a writer toggling single tiles while readers check their
+// neighbours, which share the same bytes
+func TestMaskSetTileWithConcurrentReaders(t *testing.T) {
+	mask := NewMask(Bounds{MinX: 0, MinY: 0, MaxX: 7, MaxY: 0}, 10.0)
+	mask.SetTile(0, 0, true)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Tile 0 is never written after setup
+				if !mask.IsTileAllowed(0, 0) {
+					t.Error("Expected tile 0 to stay allowed")
+					return
+				}
+				mask.IsTileAllowed(1, 0)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		mask.SetTile(int64(1+i%7), 0, i%2 == 0)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestCircleMaskFollowsRadius(t *testing.T) {
+	const lat, lon, radius = 42.3601, -71.0589, 500.0
+	mask, err := NewCircleMask(lat, lon, radius, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walk east from the center to the last tile inside the radius
+	x, y := LatLonToTileXY(lat, lon)
+	for {
+		tileLat, tileLon := TileXYToLatLon(x+1, y)
+		if HaversineDistance(lat, lon, tileLat, tileLon) > radius {
+			break
+		}
+		x++
+	}
+
+	if !mask.IsTileAllowed(x, y) {
+		t.Errorf("Tile (%d, %d) just inside the radius should be allowed", x, y)
+	}
+	if mask.IsTileAllowed(x+1, y) {
+		t.Errorf("Tile (%d, %d) just outside the radius should not be allowed", x+1, y)
+	}
+
+	if _, err := NewCircleMask(lat, lon, 0, 10); err == nil {
+		t.Error("Expected an error for a zero radius")
+	}
+}
+
+func TestPolygonMaskFollowsOutline(t *testing.T) {
+	// A triangle with its right angle at the south-west
+	mask, err := NewPolygonMask([]LatLon{
+		{Lat: 42.35, Lon: -71.07},
+		{Lat: 42.37, Lon: -71.07},
+		{Lat: 42.35, Lon: -71.05},
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		allowed  bool
+	}{
+		{"inside near the right angle", 42.352, -71.068, true},
+		{"outside past the hypotenuse", 42.368, -71.052, false},
+		{"outside the bounding box", 42.40, -71.06, false},
+	}
+	for _, tt := range tests {
+		x, y := LatLonToTileXY(tt.lat, tt.lon)
+		if got := mask.IsTileAllowed(x, y); got != tt.allowed {
+			t.Errorf("%s: tile (%d, %d) allowed = %v, want %v", tt.name, x, y, got, tt.allowed)
+		}
+	}
+
+	if _, err := NewPolygonMask([]LatLon{{Lat: 42.35, Lon: -71.07}, {Lat: 42.37, Lon: -71.07}}, 10); err == nil {
+		t.Error("Expected an error for a polygon with 2 points")
+	}
+}
+
 func TestHaversineDistance(t *testing.T) {
 	// Test Haversine distance calculation
 	tests := []struct {
@@ -0,0 +1,58 @@
+package geo
+
+// MaskReport lists where a mask and a geofence polygon disagree. A tile is in
+// the fence when its center is inside the polygon.
+type MaskReport struct {
+	// Checked is the number of tiles compared: the union of both bounds
+	Checked int64
+	// OutsideFence counts tiles the mask allows but the fence excludes
+	OutsideFence int64
+	// Uncovered counts tiles inside the fence the mask doesn't allow
+	Uncovered int64
+
+	OutsideFenceSample [][2]int64
+	UncoveredSample    [][2]int64
+}
+
+// Ok reports whether the mask matches the fence exactly
+func (r MaskReport) Ok() bool {
+	return r.OutsideFence == 0 && r.Uncovered == 0
+}
+
+// CompareMask checks every tile in the mask's or fence's bounds, in the
+// mask's projection, and reports discrepancies, keeping up to sampleSize
+// example tiles of each kind
+func CompareMask(mask *Mask, fence *Polygon, sampleSize int) MaskReport {
+	proj := mask.Projection()
+	b := mask.Bounds()
+	fb := fence.TileBoundsIn(proj)
+	b.MinX, b.MinY = min(b.MinX, fb.MinX), min(b.MinY, fb.MinY)
+	b.MaxX, b.MaxY = max(b.MaxX, fb.MaxX), max(b.MaxY, fb.MaxY)
+
+	var report MaskReport
+	for y := b.MinY; y <= b.MaxY; y++ {
+		spans := fence.rowSpans(proj, y)
+		for x := b.MinX; x <= b.MaxX; x++ {
+			for len(spans) > 0 && spans[0][1] <= x {
+				spans = spans[1:]
+			}
+			inFence := len(spans) > 0 && spans[0][0] <= x
+			allowed := mask.IsTileAllowed(x, y)
+			report.Checked++
+
+			switch {
+			case allowed && !inFence:
+				report.OutsideFence++
+				if len(report.OutsideFenceSample) < sampleSize {
+					report.OutsideFenceSample = append(report.OutsideFenceSample, [2]int64{x, y})
+				}
+			case inFence && !allowed:
+				report.Uncovered++
+				if len(report.UncoveredSample) < sampleSize {
+					report.UncoveredSample = append(report.UncoveredSample, [2]int64{x, y})
+				}
+			}
+		}
+	}
+	return report
+}
@@ -0,0 +1,103 @@
+package geo
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// squareFence returns a GeoJSON polygon whose edges fall between tiles, so
+// exactly the tiles in [x0, x1] × [y0, y1] have their centers inside it
+func squareFence(x0, y0, x1, y1 int64) string {
+	edge := func(x, y int64) (lat, lon float64) {
+		latA, lonA := TileXYToLatLon(x-1, y-1)
+		latB, lonB := TileXYToLatLon(x, y)
+		return (latA + latB) / 2, (lonA + lonB) / 2
+	}
+	north, west := edge(x0, y0)
+	south, east := edge(x1+1, y1+1)
+	return fmt.Sprintf(`{"type": "FeatureCollection", "features": [{"type": "Feature",
+		"geometry": {"type": "MultiPolygon", "coordinates": [[[[%f, %f], [%f, %f], [%f, %f], [%f, %f], [%f, %f]]]]}}]}`,
+		west, north, east, north, east, south, west, south, west, north)
+}
+
+func TestCompareMaskReportsOverCoverage(t *testing.T) {
+	// 10×10 tile fence in Boston
+	x0, y0 := LatLonToTileXY(42.3601, -71.0589)
+	fence, err := LoadGeoJSON(strings.NewReader(squareFence(x0, y0, x0+9, y0+9)))
+	if err != nil {
+		t.Fatalf("LoadGeoJSON: %v", err)
+	}
+
+	// Mask allows a 2-tile border around the fence (14×14) but misses one
+	// tile inside it
+	mask := NewMask(Bounds{MinX: x0 - 2, MinY: y0 - 2, MaxX: x0 + 11, MaxY: y0 + 11}, 10.0)
+	for y := y0 - 2; y <= y0+11; y++ {
+		for x := x0 - 2; x <= x0+11; x++ {
+			mask.SetTile(x, y, true)
+		}
+	}
+	mask.SetTile(x0+4, y0+5, false)
+
+	report := CompareMask(mask, fence, 5)
+	if report.Checked != 14*14 {
+		t.Errorf("expected 196 tiles checked, got %d", report.Checked)
+	}
+	if report.OutsideFence != 14*14-10*10 {
+		t.Errorf("expected 96 tiles outside the fence, got %d", report.OutsideFence)
+	}
+	if len(report.OutsideFenceSample) != 5 {
+		t.Errorf("expected a 5-tile sample, got %d", len(report.OutsideFenceSample))
+	}
+	for _, tile := range report.OutsideFenceSample {
+		lat, lon := TileXYToLatLon(tile[0], tile[1])
+		if fence.Contains(lat, lon) {
+			t.Errorf("sampled tile %v is inside the fence", tile)
+		}
+	}
+	if report.Uncovered != 1 || report.UncoveredSample[0] != [2]int64{x0 + 4, y0 + 5} {
+		t.Errorf("expected only (%d, %d) uncovered, got %d: %v", x0+4, y0+5, report.Uncovered, report.UncoveredSample)
+	}
+	if report.Ok() {
+		t.Error("report should not be ok")
+	}
+}
+
+func TestCompareMaskExactMatch(t *testing.T) {
+	x0, y0 := LatLonToTileXY(42.3601, -71.0589)
+	fence, err := LoadGeoJSON(strings.NewReader(squareFence(x0, y0, x0+3, y0+3)))
+	if err != nil {
+		t.Fatalf("LoadGeoJSON: %v", err)
+	}
+
+	mask := NewMask(Bounds{MinX: x0, MinY: y0, MaxX: x0 + 3, MaxY: y0 + 3}, 10.0)
+	for y := y0; y <= y0+3; y++ {
+		for x := x0; x <= x0+3; x++ {
+			mask.SetTile(x, y, true)
+		}
+	}
+
+	if report := CompareMask(mask, fence, 5); !report.Ok() {
+		t.Errorf("expected a match, got %+v", report)
+	}
+}
+
+func TestLoadMaskChecksSize(t *testing.T) {
+	bounds := Bounds{MinX: 0, MinY: 0, MaxX: 15, MaxY: 0} // 16 tiles, 2 bytes
+
+	mask, err := LoadMask(bytes.NewReader([]byte{0x80, 0x01}), bounds, 10.0)
+	if err != nil {
+		t.Fatalf("LoadMask: %v", err)
+	}
+	if !mask.IsTileAllowed(0, 0) || !mask.IsTileAllowed(15, 0) || mask.IsTileAllowed(1, 0) {
+		t.Error("mask bits not read MSB first")
+	}
+
+	if _, err := LoadMask(bytes.NewReader([]byte{0x80}), bounds, 10.0); err == nil {
+		t.Error("expected an error for a short file")
+	}
+	if _, err := LoadMask(bytes.NewReader([]byte{0x80, 0x01, 0x00}), bounds, 10.0); err == nil {
+		t.Error("expected an error for a long file")
+	}
+}
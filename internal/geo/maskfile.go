@@ -0,0 +1,103 @@
+package geo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Mask files written by WriteTo start with maskMagic and a big-endian
+// uint16 version. Then come the bounds as four int64s, MinX, MinY, MaxX
+// and MaxY; the projection's tile size as a float64 and chunk size as an
+// int64; and the length of the tile bits as a uint32, followed by the bits
+// as LoadMask reads them. Unlike LoadMask's headerless files, a mask file
+// carries everything needed to load it.
+const (
+	maskMagic   = "SPMK"
+	maskVersion = 1
+
+	// maskHeader is the size of a version 1 header
+	maskHeader = len(maskMagic) + 2 + 4*8 + 8 + 8 + 4
+
+	// maxMaskBytes bounds the bits a header may claim, so a corrupt file
+	// can't make the reader allocate without limit. 1GB covers a 300km
+	// square of 10m tiles several times over.
+	maxMaskBytes = 1 << 30
+)
+
+// ErrMaskFormat is returned by ReadMask for a file that isn't a mask file,
+// is cut short, or disagrees with itself
+var ErrMaskFormat = errors.New("not a supported mask file")
+
+// WriteTo writes the mask as a mask file, for ReadMask to load
+func (m *Mask) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	header := make([]byte, 0, maskHeader)
+	header = append(header, maskMagic...)
+	header = binary.BigEndian.AppendUint16(header, maskVersion)
+	for _, v := range []int64{m.bounds.MinX, m.bounds.MinY, m.bounds.MaxX, m.bounds.MaxY} {
+		header = binary.BigEndian.AppendUint64(header, uint64(v))
+	}
+	header = binary.BigEndian.AppendUint64(header, math.Float64bits(m.proj.TileMeters))
+	header = binary.BigEndian.AppendUint64(header, uint64(m.proj.ChunkSize))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(m.data)))
+
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	nd, err := w.Write(m.data)
+	return int64(n + nd), err
+}
+
+// ReadMask loads a mask file written by WriteTo
+func ReadMask(r io.Reader) (*Mask, error) {
+	header := make([]byte, maskHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMaskFormat, err)
+	}
+	if string(header[:len(maskMagic)]) != maskMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrMaskFormat)
+	}
+	header = header[len(maskMagic):]
+	if version := binary.BigEndian.Uint16(header); version != maskVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrMaskFormat, version)
+	}
+	header = header[2:]
+
+	next := func() int64 {
+		v := int64(binary.BigEndian.Uint64(header))
+		header = header[8:]
+		return v
+	}
+	bounds := Bounds{MinX: next(), MinY: next(), MaxX: next(), MaxY: next()}
+	tileMeters := math.Float64frombits(uint64(next()))
+	proj, err := NewProjection(tileMeters, next())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMaskFormat, err)
+	}
+	size := binary.BigEndian.Uint32(header)
+
+	// The bits must be exactly what the bounds need
+	width, height := bounds.MaxX-bounds.MinX+1, bounds.MaxY-bounds.MinY+1
+	if width <= 0 || height <= 0 || width > maxMaskBytes*8/height {
+		return nil, fmt.Errorf("%w: bounds %+v", ErrMaskFormat, bounds)
+	}
+	if want := (width*height + 7) / 8; want > maxMaskBytes || int64(size) != want {
+		return nil, fmt.Errorf("%w: %d bytes of tiles for bounds needing %d", ErrMaskFormat, size, want)
+	}
+
+	m := NewMaskIn(bounds, proj)
+	if _, err := io.ReadFull(r, m.data); err != nil {
+		return nil, fmt.Errorf("%w: tiles: %v", ErrMaskFormat, err)
+	}
+	var extra [1]byte
+	if _, err := io.ReadFull(r, extra[:]); err == nil {
+		return nil, fmt.Errorf("%w: trailing data", ErrMaskFormat)
+	}
+	return m, nil
+}
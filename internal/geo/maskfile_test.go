@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestMaskFileRoundTrip(t *testing.T) {
+	// Boston, in 5m tiles, so the projection has to survive the trip too
+	proj, _ := NewProjection(5, 128)
+	bounds := Bounds{MinX: 1000, MinY: 2000, MaxX: 1036, MaxY: 2020} // 37 wide: rows straddle bytes
+	mask := NewMaskIn(bounds, proj)
+	mask.SetRect(1003, 2001, 1020, 2011, true)
+	mask.SetTile(1036, 2020, true)
+	mask.SetTile(1010, 2005, false)
+
+	var buf bytes.Buffer
+	n, err := mask.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo wrote %d of %d bytes: %v", n, buf.Len(), err)
+	}
+
+	loaded, err := ReadMask(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMask failed: %v", err)
+	}
+	if loaded.Bounds() != bounds || loaded.Projection() != proj {
+		t.Fatalf("Expected bounds %+v in %+v, got %+v in %+v", bounds, proj, loaded.Bounds(), loaded.Projection())
+	}
+	for y := bounds.MinY; y <= bounds.MaxY; y++ {
+		for x := bounds.MinX; x <= bounds.MaxX; x++ {
+			if loaded.IsTileAllowed(x, y) != mask.IsTileAllowed(x, y) {
+				t.Errorf("Tile (%d, %d): loaded %v, want %v", x, y, loaded.IsTileAllowed(x, y), mask.IsTileAllowed(x, y))
+			}
+		}
+	}
+}
+
+func TestReadMaskRejectsCorruptFiles(t *testing.T) {
+	mask := NewMask(Bounds{MinX: 0, MinY: 0, MaxX: 15, MaxY: 15}, 10)
+	var buf bytes.Buffer
+	mask.WriteTo(&buf)
+	good := buf.Bytes()
+
+	// field returns a copy of the file with the header field at offset
+	// overwritten
+	const fields = len(maskMagic) + 2
+	field := func(offset int, v uint64) []byte {
+		out := bytes.Clone(good)
+		binary.BigEndian.PutUint64(out[fields+offset:], v)
+		return out
+	}
+	badMagic := bytes.Clone(good)
+	badMagic[0] = 'X'
+	newer := bytes.Clone(good)
+	binary.BigEndian.PutUint16(newer[len(maskMagic):], maskVersion+1)
+	wrongLength := bytes.Clone(good)
+	binary.BigEndian.PutUint32(wrongLength[maskHeader-4:], 31)
+
+	for name, file := range map[string][]byte{
+		"empty":         nil,
+		"truncated":     good[:len(good)-1],
+		"trailing data": append(bytes.Clone(good), 0),
+		"bad magic":     badMagic,
+		"newer version": newer,
+		"wrong length":  wrongLength,
+		"empty bounds":  field(16, uint64(0xffffffffffffffff)), // MaxX -1
+		"huge bounds":   field(16, 1<<40),
+		"zero tiles":    field(32, 0),
+	} {
+		if _, err := ReadMask(bytes.NewReader(file)); !errors.Is(err, ErrMaskFormat) {
+			t.Errorf("%s: expected ErrMaskFormat, got %v", name, err)
+		}
+	}
+}
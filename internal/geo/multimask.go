@@ -0,0 +1,111 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TileMask is what paint checks need of a mask. A Mask covers one
+// rectangle of tiles; a MultiMask covers several.
+type TileMask interface {
+	Bounds() Bounds
+	Projection() Projection
+	IsTileAllowed(x, y int64) bool
+	AnyAllowed(minX, minY, maxX, maxY int64) bool
+	SetRect(minX, minY, maxX, maxY int64, allowed bool) int
+}
+
+var (
+	_ TileMask = (*Mask)(nil)
+	_ TileMask = (*MultiMask)(nil)
+)
+
+// MultiMask allows a tile when any of its regions does, for areas that
+// aren't one rectangle, like a city and a campus some way off. Its Bounds
+// are the union of the regions'. Regions are indexed by the chunks they
+// overlap, so a lookup only asks the regions over the tile's chunk.
+type MultiMask struct {
+	regions []*Mask
+	bounds  Bounds
+	proj    Projection
+	chunks  map[[2]int64][]*Mask
+}
+
+// NewMultiMask combines regions built in the same projection. They may
+// overlap.
+func NewMultiMask(regions ...*Mask) (*MultiMask, error) {
+	if len(regions) == 0 {
+		return nil, errors.New("multimask needs at least one region")
+	}
+	m := &MultiMask{
+		regions: regions,
+		bounds:  regions[0].Bounds(),
+		proj:    regions[0].Projection(),
+		chunks:  make(map[[2]int64][]*Mask),
+	}
+	for i, region := range regions {
+		if region.Projection() != m.proj {
+			return nil, fmt.Errorf("region %d is in %gm tiles of %d, want %gm tiles of %d",
+				i, region.Projection().TileMeters, region.Projection().ChunkSize, m.proj.TileMeters, m.proj.ChunkSize)
+		}
+
+		b := region.Bounds()
+		m.bounds.MinX, m.bounds.MinY = min(m.bounds.MinX, b.MinX), min(m.bounds.MinY, b.MinY)
+		m.bounds.MaxX, m.bounds.MaxY = max(m.bounds.MaxX, b.MaxX), max(m.bounds.MaxY, b.MaxY)
+
+		minCx, minCy := m.proj.ChunkOf(b.MinX, b.MinY)
+		maxCx, maxCy := m.proj.ChunkOf(b.MaxX, b.MaxY)
+		for cy := minCy; cy <= maxCy; cy++ {
+			for cx := minCx; cx <= maxCx; cx++ {
+				m.chunks[[2]int64{cx, cy}] = append(m.chunks[[2]int64{cx, cy}], region)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Bounds returns the smallest tile range covering every region
+func (m *MultiMask) Bounds() Bounds {
+	return m.bounds
+}
+
+// Projection returns the projection the regions' tiles are in
+func (m *MultiMask) Projection() Projection {
+	return m.proj
+}
+
+// IsTileAllowed checks if any region allows a tile
+func (m *MultiMask) IsTileAllowed(x, y int64) bool {
+	if x < m.bounds.MinX || x > m.bounds.MaxX || y < m.bounds.MinY || y > m.bounds.MaxY {
+		return false
+	}
+	cx, cy := m.proj.ChunkOf(x, y)
+	for _, region := range m.chunks[[2]int64{cx, cy}] {
+		if region.IsTileAllowed(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyAllowed reports whether any region allows a tile in the rectangle,
+// inclusive
+func (m *MultiMask) AnyAllowed(minX, minY, maxX, maxY int64) bool {
+	for _, region := range m.regions {
+		if region.AnyAllowed(minX, minY, maxX, maxY) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRect sets the rectangle, inclusive, in every region it overlaps, and
+// returns how many tiles were inside a region's bounds. A tile where regions
+// overlap is counted once for each.
+func (m *MultiMask) SetRect(minX, minY, maxX, maxY int64, allowed bool) int {
+	n := 0
+	for _, region := range m.regions {
+		n += region.SetRect(minX, minY, maxX, maxY, allowed)
+	}
+	return n
+}
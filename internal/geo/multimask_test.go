@@ -0,0 +1,61 @@
+package geo
+
+import "testing"
+
+func TestMultiMaskAllowsAnyRegion(t *testing.T) {
+	proj := DefaultProjection
+
+	// Two squares a few chunks apart, each with its own tiles open
+	west := NewMaskIn(Bounds{MinX: 0, MinY: 0, MaxX: 99, MaxY: 99}, proj)
+	west.SetRect(10, 10, 20, 20, true)
+	east := NewMaskIn(Bounds{MinX: 1000, MinY: 500, MaxX: 1099, MaxY: 599}, proj)
+	east.SetRect(1050, 550, 1060, 560, true)
+
+	m, err := NewMultiMask(west, east)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Bounds{MinX: 0, MinY: 0, MaxX: 1099, MaxY: 599}); m.Bounds() != want {
+		t.Errorf("Bounds = %+v, want %+v", m.Bounds(), want)
+	}
+
+	cases := []struct {
+		name    string
+		x, y    int64
+		allowed bool
+	}{
+		{"west", 15, 15, true},
+		{"east", 1055, 555, true},
+		{"closed tile in west", 50, 50, false},
+		{"between the squares", 500, 300, false},
+		{"outside both", 2000, 2000, false},
+	}
+	for _, tc := range cases {
+		if got := m.IsTileAllowed(tc.x, tc.y); got != tc.allowed {
+			t.Errorf("%s: IsTileAllowed(%d, %d) = %v, want %v", tc.name, tc.x, tc.y, got, tc.allowed)
+		}
+	}
+
+	if !m.AnyAllowed(1000, 500, 1099, 599) || m.AnyAllowed(200, 0, 999, 499) {
+		t.Error("Expected AnyAllowed to see the east square's tiles and none between")
+	}
+
+	// Edits reach whichever region holds the tiles
+	if n := m.SetRect(1050, 550, 1060, 560, false); n != 121 {
+		t.Errorf("SetRect changed %d tiles, want 121", n)
+	}
+	if m.IsTileAllowed(1055, 555) || !m.IsTileAllowed(15, 15) {
+		t.Error("Expected only the east square closed")
+	}
+}
+
+func TestNewMultiMaskChecksProjection(t *testing.T) {
+	if _, err := NewMultiMask(); err == nil {
+		t.Error("Expected an error for no regions")
+	}
+	a := NewMask(Bounds{MaxX: 9, MaxY: 9}, 10)
+	b := NewMask(Bounds{MaxX: 9, MaxY: 9}, 5)
+	if _, err := NewMultiMask(a, b); err == nil {
+		t.Error("Expected an error for regions in different tile sizes")
+	}
+}
@@ -0,0 +1,138 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Polygon is a geofence outline made of one or more rings of lon/lat points.
+// Rings are combined even-odd, so holes in a MultiPolygon stay excluded.
+type Polygon struct {
+	rings [][][2]float64
+}
+
+// geoJSON covers the parts of FeatureCollection, Feature and bare geometry
+// objects needed to find Polygon and MultiPolygon coordinates
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Features    []geoJSON       `json:"features"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// LoadGeoJSON reads every Polygon and MultiPolygon in a GeoJSON document
+func LoadGeoJSON(r io.Reader) (*Polygon, error) {
+	var doc geoJSON
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode geojson: %w", err)
+	}
+
+	p := &Polygon{}
+	if err := p.add(doc); err != nil {
+		return nil, err
+	}
+	if len(p.rings) == 0 {
+		return nil, fmt.Errorf("geojson has no polygons")
+	}
+	return p, nil
+}
+
+func (p *Polygon) add(g geoJSON) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for _, f := range g.Features {
+			if err := p.add(f); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return p.add(*g.Geometry)
+		}
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return fmt.Errorf("decode polygon: %w", err)
+		}
+		p.rings = append(p.rings, rings...)
+	case "MultiPolygon":
+		var polys [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			return fmt.Errorf("decode multipolygon: %w", err)
+		}
+		for _, rings := range polys {
+			p.rings = append(p.rings, rings...)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether a point is inside the polygon
+func (p *Polygon) Contains(lat, lon float64) bool {
+	inside := false
+	for _, ring := range p.rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a[1] > lat) != (b[1] > lat) &&
+				lon < a[0]+(lat-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// TileBounds returns the tiles covering the polygon's bounding box in the
+// default projection
+func (p *Polygon) TileBounds() Bounds {
+	return p.TileBoundsIn(DefaultProjection)
+}
+
+// TileBoundsIn returns the tiles covering the polygon's bounding box in proj
+func (p *Polygon) TileBoundsIn(proj Projection) Bounds {
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	for _, ring := range p.rings {
+		for _, pt := range ring {
+			minLon, maxLon = math.Min(minLon, pt[0]), math.Max(maxLon, pt[0])
+			minLat, maxLat = math.Min(minLat, pt[1]), math.Max(maxLat, pt[1])
+		}
+	}
+
+	// Tile y grows southward
+	minX, minY := proj.LatLonToTileXY(maxLat, minLon)
+	maxX, maxY := proj.LatLonToTileXY(minLat, maxLon)
+	return Bounds{MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY}
+}
+
+// rowSpans returns the sorted, half-open [x0, x1) runs of tiles in row y whose
+// centers are inside the polygon. Scanning a row at once keeps whole-region
+// comparisons linear in the number of edges rather than tiles × edges.
+func (p *Polygon) rowSpans(proj Projection, y int64) [][2]int64 {
+	lat, _ := proj.TileXYToLatLon(0, y)
+
+	var xs []float64
+	for _, ring := range p.rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a[1] > lat) != (b[1] > lat) {
+				xs = append(xs, a[0]+(lat-a[1])*(b[0]-a[0])/(b[1]-a[1]))
+			}
+		}
+	}
+	sort.Float64s(xs)
+
+	spans := make([][2]int64, 0, len(xs)/2)
+	for i := 0; i+1 < len(xs); i += 2 {
+		// First and last tile whose center lies in [xs[i], xs[i+1])
+		x0 := int64(math.Ceil(proj.lonToTileX(xs[i]) - 0.5))
+		x1 := int64(math.Ceil(proj.lonToTileX(xs[i+1]) - 0.5))
+		if x1 > x0 {
+			spans = append(spans, [2]int64{x0, x1})
+		}
+	}
+	return spans
+}
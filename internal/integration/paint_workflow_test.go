@@ -2,7 +2,6 @@ package integration
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -57,7 +56,7 @@ type MockConnection struct {
 
 type Delta struct {
 	Seq   uint64 `json:"seq"`
-	O     uint16 `json:"o"`
+	O     int    `json:"o"`
 	Color uint8  `json:"color"`
 	Ts    int64  `json:"ts"`
 }
@@ -78,6 +77,12 @@ type PaintResponse struct {
 	Ts  int64  `json:"ts"`
 }
 
+// Paint ranges the real handler enforces: 256×256-tile chunks of 10m tiles
+const (
+	maxOffset = 256*256 - 1
+	maxChunk  = 15654
+)
+
 func NewIntegrationTest() *IntegrationTest {
 	redis := &MockRedisClient{
 		chunks:    make(map[string][]byte),
@@ -183,6 +188,20 @@ func (it *IntegrationTest) handlePostPaint(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Ranges are checked before anything touches Redis
+	if req.Color > 15 {
+		http.Error(w, "invalid color", 400)
+		return
+	}
+	if req.O < 0 || req.O > maxOffset {
+		http.Error(w, "offset out of range", 400)
+		return
+	}
+	if req.Cx < 0 || req.Cx > maxChunk || req.Cy < 0 || req.Cy > maxChunk {
+		http.Error(w, "chunk out of range", 400)
+		return
+	}
+
 	// Mock Turnstile verification
 	if it.config.EnableTurnstile {
 		if req.TurnstileToken == "" || req.TurnstileToken == "invalid" {
@@ -237,7 +256,7 @@ func (it *IntegrationTest) handlePostPaint(w http.ResponseWriter, r *http.Reques
 	// Publish delta
 	delta := Delta{
 		Seq:   seq,
-		O:     uint16(req.O),
+		O:     req.O,
 		Color: req.Color,
 		Ts:    ts,
 	}
@@ -276,6 +295,15 @@ func (it *IntegrationTest) handleWebSocket(w http.ResponseWriter, r *http.Reques
 	// Register with hub
 	it.hub.Register(conn, 0, 0) // Default to chunk 0,0
 
+	// Forward published deltas to the socket
+	go func() {
+		for delta := range conn.send {
+			if err := ws.WriteJSON(delta); err != nil {
+				return
+			}
+		}
+	}()
+
 	// Handle messages
 	for {
 		_, _, err := ws.ReadMessage()
@@ -284,8 +312,9 @@ func (it *IntegrationTest) handleWebSocket(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	// Unregister
+	// Unregister; Publish holds the hub lock, so nothing sends after this
 	it.hub.Unregister(conn, 0, 0)
+	close(conn.send)
 }
 
 func (it *IntegrationTest) getIP(r *http.Request) string {
@@ -549,6 +578,9 @@ func TestPaintWorkflowSequence(t *testing.T) {
 	// Paint multiple tiles and verify sequence increments
 	expectedSeq := uint64(1)
 	for i := 0; i < 5; i++ {
+		// Sequencing is under test here, not the cooldown
+		it.ClearCooldown("192.168.1.1")
+
 		reqBody := PaintRequest{
 			Lat:   42.3601,
 			Lon:   -71.0589,
@@ -744,6 +776,25 @@ func TestPaintWorkflowErrorHandling(t *testing.T) {
 	if w.Code != 200 {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
+
+	// Out-of-range offsets and chunks are refused without a write
+	for _, bad := range []PaintRequest{
+		{Lat: 42.3601, Lon: -71.0589, O: 10_000_000},
+		{Lat: 42.3601, Lon: -71.0589, O: -1},
+		{Lat: 42.3601, Lon: -71.0589, Cx: maxChunk + 1},
+		{Lat: 42.3601, Lon: -71.0589, Cy: -1},
+	} {
+		jsonBody, _ := json.Marshal(bad)
+		req = httptest.NewRequest("POST", "/paint", bytes.NewReader(jsonBody))
+		w = httptest.NewRecorder()
+		it.handlePostPaint(w, req)
+		if w.Code != 400 {
+			t.Errorf("Expected status 400 for %+v, got %d", bad, w.Code)
+		}
+	}
+	if len(it.GetPublishedDeltas()) != 1 {
+		t.Errorf("Expected only the valid paint published, got %d", len(it.GetPublishedDeltas()))
+	}
 }
 
 func BenchmarkPaintWorkflow(b *testing.B) {
@@ -0,0 +1,136 @@
+// Package metrics exposes the server's Prometheus metrics. Each Metrics has
+// its own registry so tests and multiple handlers don't collide.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// HubStats is the subset of the WebSocket hub the gauges read at scrape time
+type HubStats interface {
+	GetRoomCount() int
+	TotalSubscribers() int
+}
+
+// Metrics holds the paint path's instruments
+type Metrics struct {
+	registry *prometheus.Registry
+
+	paintsAccepted prometheus.Counter
+	paintsRejected *prometheus.CounterVec
+	paintsFlagged  *prometheus.CounterVec
+	paintLatency   prometheus.Histogram
+	redisErrors    *prometheus.CounterVec
+	paramErrors    *prometheus.CounterVec
+	readsLimited   prometheus.Counter
+	bansIssued     prometheus.Counter
+}
+
+// New creates the instruments and registers gauges backed by hub
+func New(hub HubStats) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		paintsAccepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "splat_paints_accepted_total",
+			Help: "Paints written to the canvas.",
+		}),
+		paintsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "splat_paints_rejected_total",
+			Help: "Paints refused, by reason.",
+		}, []string{"reason"}),
+		paintsFlagged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "splat_paints_flagged_total",
+			Help: "Paints accepted despite a soft anti-spoof signal, by check.",
+		}, []string{"check"}),
+		paintLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "splat_paint_redis_seconds",
+			Help:    "Latency of the Redis paint script.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12), // 0.5ms to ~1s
+		}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "splat_redis_errors_total",
+			Help: "Redis calls that failed, by operation.",
+		}, []string{"op"}),
+		paramErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "splat_param_errors_total",
+			Help: "Requests rejected for a missing or malformed query parameter.",
+		}, []string{"code", "param"}),
+		readsLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "splat_reads_rate_limited_total",
+			Help: "Canvas reads refused by the per-IP read rate limit.",
+		}),
+		bansIssued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "splat_bans_issued_total",
+			Help: "IPs banned for repeated speed limit or geofence rejections.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.paintsAccepted,
+		m.paintsRejected,
+		m.paintsFlagged,
+		m.paintLatency,
+		m.redisErrors,
+		m.paramErrors,
+		m.readsLimited,
+		m.bansIssued,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "splat_ws_rooms",
+			Help: "Chunks with at least one WebSocket subscriber.",
+		}, func() float64 { return float64(hub.GetRoomCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "splat_ws_subscribers",
+			Help: "WebSocket chunk subscriptions across all rooms.",
+		}, func() float64 { return float64(hub.TotalSubscribers()) }),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// PaintAccepted counts a successful paint
+func (m *Metrics) PaintAccepted() {
+	m.paintsAccepted.Inc()
+}
+
+// PaintRejected counts a refused paint
+func (m *Metrics) PaintRejected(reason string) {
+	m.paintsRejected.WithLabelValues(reason).Inc()
+}
+
+// PaintFlagged counts a paint let through with a suspicious check
+func (m *Metrics) PaintFlagged(check string) {
+	m.paintsFlagged.WithLabelValues(check).Inc()
+}
+
+// ObservePaint records how long the Redis paint script took
+func (m *Metrics) ObservePaint(d time.Duration) {
+	m.paintLatency.Observe(d.Seconds())
+}
+
+// RedisError counts a failed Redis call
+func (m *Metrics) RedisError(op string) {
+	m.redisErrors.WithLabelValues(op).Inc()
+}
+
+// ParamError counts a request rejected for a bad query parameter
+func (m *Metrics) ParamError(code, param string) {
+	m.paramErrors.WithLabelValues(code, param).Inc()
+}
+
+// ReadRateLimited counts a read refused by the read rate limit
+func (m *Metrics) ReadRateLimited() {
+	m.readsLimited.Inc()
+}
+
+// BanIssued counts an IP banned by the ban list
+func (m *Metrics) BanIssued() {
+	m.bansIssued.Inc()
+}
@@ -0,0 +1,96 @@
+package rate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// banOffenseTTL is how long a ban counts toward the next one's length. An
+// IP that stays out of trouble this long starts again at the first penalty.
+const banOffenseTTL = 24 * time.Hour
+
+// BanStore keeps strikes and bans where every server instance sees them.
+// Each call gives up when ctx is done.
+type BanStore interface {
+	// AddStrikeContext counts a strike against ip, forgotten ttl after the
+	// latest, and returns its strikes so far
+	AddStrikeContext(ctx context.Context, ip string, ttl time.Duration) (int64, error)
+	// AddOffenseContext counts a ban against ip, forgotten ttl after the
+	// latest, and returns its bans so far
+	AddOffenseContext(ctx context.Context, ip string, ttl time.Duration) (int64, error)
+	// SetBanContext bans ip for d and clears its strikes
+	SetBanContext(ctx context.Context, ip string, d time.Duration) error
+	// BanRemainingContext returns how much of ip's ban is left, or 0
+	BanRemainingContext(ctx context.Context, ip string) (time.Duration, error)
+}
+
+// BanList bans IPs that keep tripping abuse checks. Each trip is a strike;
+// the threshold-th strike within the strike window bans the IP, for longer
+// on each repeat offense.
+type BanList struct {
+	store     BanStore
+	threshold int64
+	window    time.Duration
+	penalties []time.Duration
+}
+
+// NewBanList creates a ban list banning on the threshold-th strike within
+// window. The nth ban lasts penalties[n-1], or the last penalty past the
+// end of the list.
+func NewBanList(store BanStore, threshold int, window time.Duration, penalties []time.Duration) *BanList {
+	return &BanList{
+		store:     store,
+		threshold: int64(threshold),
+		window:    window,
+		penalties: penalties,
+	}
+}
+
+// Strike records a strike against ip and returns the ban it triggered, or
+// 0 if it didn't
+func (b *BanList) Strike(ctx context.Context, ip string) (time.Duration, error) {
+	strikes, err := b.store.AddStrikeContext(ctx, ip, b.window)
+	if err != nil {
+		return 0, err
+	}
+	// Only the strike reaching the threshold bans, so instances striking
+	// the same IP at once don't each escalate it
+	if strikes != b.threshold || len(b.penalties) == 0 {
+		return 0, nil
+	}
+
+	offenses, err := b.store.AddOffenseContext(ctx, ip, banOffenseTTL)
+	if err != nil {
+		return 0, err
+	}
+	d := b.penalties[min(int(offenses), len(b.penalties))-1]
+	if err := b.store.SetBanContext(ctx, ip, d); err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+// Remaining returns how much longer ip is banned, or 0 if it isn't
+func (b *BanList) Remaining(ctx context.Context, ip string) (time.Duration, error) {
+	return b.store.BanRemainingContext(ctx, ip)
+}
+
+// ParsePenalties reads a comma-separated list of ban lengths, e.g.
+// "1m,10m,1h"
+func ParsePenalties(list string) ([]time.Duration, error) {
+	var penalties []time.Duration
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		d, err := time.ParseDuration(entry)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("penalty %q: must be a positive duration", entry)
+		}
+		penalties = append(penalties, d)
+	}
+	return penalties, nil
+}
@@ -0,0 +1,69 @@
+package rate
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucketLimiter is a token bucket rate limiter. Each IP holds up to
+// burst tokens, refilled continuously at rate per second; a request spends
+// one. Unlike RateLimiter it keeps two numbers per IP rather than a
+// timestamp per request, so Allow is constant time whatever the limit.
+type TokenBucketLimiter struct {
+	buckets map[string]*bucket
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	janitor *janitor
+
+	// now is time.Now, replaceable in tests
+	now func() time.Time
+}
+
+// bucket is one IP's tokens as of its last refill
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter creates a limiter allowing bursts of up to burst
+// requests per IP and rate requests per second sustained
+func NewTokenBucketLimiter(rate float64, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		buckets: make(map[string]*bucket),
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+	}
+}
+
+// Allow returns true if the IP has a token to spend, spending it
+func (l *TokenBucketLimiter) Allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, exists := l.buckets[ip]
+	if !exists {
+		// New IPs start with a full bucket
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*l.rate, l.burst)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// fullAfter is how long an empty bucket takes to refill completely
+func (l *TokenBucketLimiter) fullAfter() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(l.burst / l.rate * float64(time.Second))
+}
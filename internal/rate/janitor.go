@@ -0,0 +1,168 @@
+package rate
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor runs a sweep function on an interval until closed
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// startJanitor calls sweep with the current time every interval
+func startJanitor(interval time.Duration, sweep func(now time.Time)) *janitor {
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sweep(now)
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+
+	return j
+}
+
+// close stops the janitor and waits for it to exit; safe on nil
+func (j *janitor) close() {
+	if j == nil {
+		return
+	}
+	j.once.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+// StartJanitor evicts positions older than ttl every interval, so IPs that
+// stop painting don't stay in memory forever. Stop it with Close.
+func (s *SpeedLimiter) StartJanitor(interval, ttl time.Duration) {
+	j := startJanitor(interval, func(now time.Time) {
+		s.evictBefore(now.Add(-ttl))
+	})
+
+	s.mu.Lock()
+	prev := s.janitor
+	s.janitor = j
+	s.mu.Unlock()
+
+	// Outside the lock: a running sweep may be waiting on it
+	prev.close()
+}
+
+// Close stops the janitor, if any
+func (s *SpeedLimiter) Close() {
+	s.mu.Lock()
+	j := s.janitor
+	s.janitor = nil
+	s.mu.Unlock()
+
+	j.close()
+}
+
+// evictBefore drops positions last seen before cutoff
+func (s *SpeedLimiter) evictBefore(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ip, pos := range s.lastPositions {
+		if pos.Time.Before(cutoff) {
+			delete(s.lastPositions, ip)
+		}
+	}
+}
+
+// StartJanitor evicts IPs with no requests in the last ttl every interval.
+// Requests older than the window no longer count, so ttl is raised to at
+// least the window. Stop it with Close.
+func (r *RateLimiter) StartJanitor(interval, ttl time.Duration) {
+	ttl = max(ttl, r.window)
+
+	j := startJanitor(interval, func(now time.Time) {
+		r.evictBefore(now.Add(-ttl))
+	})
+
+	r.mu.Lock()
+	prev := r.janitor
+	r.janitor = j
+	r.mu.Unlock()
+
+	// Outside the lock: a running sweep may be waiting on it
+	prev.close()
+}
+
+// Close stops the janitor, if any
+func (r *RateLimiter) Close() {
+	r.mu.Lock()
+	j := r.janitor
+	r.janitor = nil
+	r.mu.Unlock()
+
+	j.close()
+}
+
+// evictBefore drops IPs whose latest request was before cutoff
+func (r *RateLimiter) evictBefore(cutoff time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for ip, requests := range r.requests {
+		if len(requests) == 0 || requests[len(requests)-1].Before(cutoff) {
+			delete(r.requests, ip)
+		}
+	}
+}
+
+// StartJanitor evicts IPs idle for ttl every interval. An evicted IP starts
+// again with a full bucket, so ttl is raised to at least the time an empty
+// bucket takes to refill. Stop it with Close.
+func (l *TokenBucketLimiter) StartJanitor(interval, ttl time.Duration) {
+	ttl = max(ttl, l.fullAfter())
+
+	j := startJanitor(interval, func(now time.Time) {
+		l.evictBefore(now.Add(-ttl))
+	})
+
+	l.mu.Lock()
+	prev := l.janitor
+	l.janitor = j
+	l.mu.Unlock()
+
+	// Outside the lock: a running sweep may be waiting on it
+	prev.close()
+}
+
+// Close stops the janitor, if any
+func (l *TokenBucketLimiter) Close() {
+	l.mu.Lock()
+	j := l.janitor
+	l.janitor = nil
+	l.mu.Unlock()
+
+	j.close()
+}
+
+// evictBefore drops IPs whose bucket was last touched before cutoff
+func (l *TokenBucketLimiter) evictBefore(cutoff time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, b := range l.buckets {
+		if b.last.Before(cutoff) {
+			delete(l.buckets, ip)
+		}
+	}
+}
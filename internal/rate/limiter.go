@@ -8,14 +8,30 @@ import (
 
 // Limiter handles cooldown tracking
 type Limiter struct {
-	cooldowns map[string]time.Time
+	cooldowns map[string]cooldown
 	mu        sync.RWMutex
 }
 
+// cooldown records when an IP last painted and, optionally, the cooldown
+// that paint earned
+type cooldown struct {
+	start    time.Time
+	duration time.Duration
+	fixed    bool // duration overrides the caller's duration
+}
+
+// effective returns the cooldown's own duration or the fallback
+func (c cooldown) effective(fallback time.Duration) time.Duration {
+	if c.fixed {
+		return c.duration
+	}
+	return fallback
+}
+
 // NewLimiter creates a new rate limiter
 func NewLimiter() *Limiter {
 	return &Limiter{
-		cooldowns: make(map[string]time.Time),
+		cooldowns: make(map[string]cooldown),
 	}
 }
 
@@ -24,13 +40,13 @@ func (l *Limiter) CheckCooldown(ip string, cooldownDuration time.Duration) bool
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	lastPaint, exists := l.cooldowns[ip]
+	cd, exists := l.cooldowns[ip]
 	if !exists {
 		return false // No cooldown
 	}
 
 	// Check if cooldown has expired
-	if time.Now().After(lastPaint.Add(cooldownDuration)) {
+	if time.Now().After(cd.start.Add(cd.effective(cooldownDuration))) {
 		delete(l.cooldowns, ip)
 		return false // Cooldown expired
 	}
@@ -42,7 +58,28 @@ func (l *Limiter) CheckCooldown(ip string, cooldownDuration time.Duration) bool
 func (l *Limiter) SetCooldown(ip string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.cooldowns[ip] = time.Now()
+	l.cooldowns[ip] = cooldown{start: time.Now()}
+}
+
+// SetCooldownDuration sets a cooldown of a specific length for the given IP,
+// overriding the duration later passed to CheckCooldown
+func (l *Limiter) SetCooldownDuration(ip string, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cooldowns[ip] = cooldown{start: time.Now(), duration: duration, fixed: true}
+}
+
+// GetCooldownDuration returns the full length of the IP's current
+// cooldown, or 0 if it has none
+func (l *Limiter) GetCooldownDuration(ip string, cooldownDuration time.Duration) time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	cd, exists := l.cooldowns[ip]
+	if !exists {
+		return 0
+	}
+	return cd.effective(cooldownDuration)
 }
 
 // GetCooldownRemaining returns the remaining cooldown duration
@@ -50,12 +87,12 @@ func (l *Limiter) GetCooldownRemaining(ip string, cooldownDuration time.Duration
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	lastPaint, exists := l.cooldowns[ip]
+	cd, exists := l.cooldowns[ip]
 	if !exists {
 		return 0
 	}
 
-	remaining := lastPaint.Add(cooldownDuration).Sub(time.Now())
+	remaining := cd.start.Add(cd.effective(cooldownDuration)).Sub(time.Now())
 	if remaining < 0 {
 		return 0
 	}
@@ -68,6 +105,7 @@ type SpeedLimiter struct {
 	lastPositions map[string]Position
 	mu            sync.RWMutex
 	maxSpeedMs    float64
+	janitor       *janitor
 }
 
 // Position represents a GPS position with timestamp
@@ -91,13 +129,32 @@ func (s *SpeedLimiter) CheckSpeed(ip string, lat, lon float64) bool {
 	defer s.mu.Unlock()
 
 	now := time.Now()
+	_, ok := s.speedFrom(ip, lat, lon, now)
+
+	// Update position
+	s.lastPositions[ip] = Position{Lat: lat, Lon: lon, Time: now}
 
+	return ok
+}
+
+// PeekSpeed returns the speed in km/h implied by moving to lat/lon now and
+// whether it is within limits, without recording the position
+func (s *SpeedLimiter) PeekSpeed(ip string, lat, lon float64) (kmh float64, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	speedMs, ok := s.speedFrom(ip, lat, lon, time.Now())
+	return speedMs * 3600.0 / 1000.0, ok
+}
+
+// speedFrom computes the speed in m/s from the last recorded position;
+// callers must hold mu
+func (s *SpeedLimiter) speedFrom(ip string, lat, lon float64, now time.Time) (float64, bool) {
 	// Get last position
 	lastPos, exists := s.lastPositions[ip]
 	if !exists {
 		// First position for this IP
-		s.lastPositions[ip] = Position{Lat: lat, Lon: lon, Time: now}
-		return true
+		return 0, true
 	}
 
 	// Calculate distance and time
@@ -105,15 +162,11 @@ func (s *SpeedLimiter) CheckSpeed(ip string, lat, lon float64) bool {
 	timeDiff := now.Sub(lastPos.Time).Seconds()
 
 	if timeDiff <= 0 {
-		return true // Same time or invalid
+		return 0, true // Same time or invalid
 	}
 
 	speed := distance / timeDiff
-
-	// Update position
-	s.lastPositions[ip] = Position{Lat: lat, Lon: lon, Time: now}
-
-	return speed <= s.maxSpeedMs
+	return speed, speed <= s.maxSpeedMs
 }
 
 func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
@@ -135,12 +188,24 @@ func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return earthRadius * c
 }
 
+// RequestLimiter admits or refuses requests per IP. RateLimiter and
+// TokenBucketLimiter both implement it, so callers can swap one for the
+// other.
+type RequestLimiter interface {
+	// Allow reports whether a request from ip may proceed, counting it if so
+	Allow(ip string) bool
+	// StartJanitor evicts idle IPs every interval; Close stops it
+	StartJanitor(interval, ttl time.Duration)
+	Close()
+}
+
 // RateLimiter implements a sliding window rate limiter
 type RateLimiter struct {
 	requests map[string][]time.Time
 	mu       sync.RWMutex
 	limit    int
 	window   time.Duration
+	janitor  *janitor
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -1,6 +1,7 @@
 package rate
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -81,8 +82,8 @@ func TestSpeedLimiter(t *testing.T) {
 		t.Errorf("First position should be allowed")
 	}
 
-	// Wait a tiny bit to ensure time difference
-	time.Sleep(10 * time.Millisecond)
+	// Wait long enough that ~11m of travel stays under the limit (~100 km/h)
+	time.Sleep(400 * time.Millisecond)
 
 	// Short distance should be allowed (within speed limit)
 	if !limiter.CheckSpeed(ip, 42.3602, -71.0589) {
@@ -183,6 +184,124 @@ func TestRateLimiterMultipleIPs(t *testing.T) {
 	}
 }
 
+// fakeClock stands in for time.Now so refill timing is exact
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTokenBucketBurstThenRefill(t *testing.T) {
+	// 2 tokens per second, bursts of 5
+	limiter := NewTokenBucketLimiter(2, 5)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	limiter.now = clock.now
+	ip := "192.168.1.1"
+
+	// The whole burst is available immediately
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow(ip) {
+			t.Errorf("Burst request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow(ip) {
+		t.Errorf("Request past the burst should be denied")
+	}
+
+	// Half a token isn't enough
+	clock.advance(250 * time.Millisecond)
+	if limiter.Allow(ip) {
+		t.Errorf("Request after 250ms should be denied")
+	}
+
+	// Denied requests don't reset the refill: 500ms in, one token is back
+	clock.advance(250 * time.Millisecond)
+	if !limiter.Allow(ip) {
+		t.Errorf("Request after 500ms should be allowed")
+	}
+	if limiter.Allow(ip) {
+		t.Errorf("Only one token should have refilled after 500ms")
+	}
+
+	// A long idle period refills to the burst, no further
+	clock.advance(time.Hour)
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow(ip) {
+			t.Errorf("Refilled burst request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow(ip) {
+		t.Errorf("Tokens should be capped at the burst")
+	}
+
+	// Other IPs have their own bucket
+	if !limiter.Allow("192.168.1.2") {
+		t.Errorf("A new IP should start with a full bucket")
+	}
+}
+
+func TestTokenBucketRefillsInRealTime(t *testing.T) {
+	limiter := NewTokenBucketLimiter(20, 1)
+	ip := "192.168.1.1"
+
+	if !limiter.Allow(ip) {
+		t.Fatalf("First request should be allowed")
+	}
+	if limiter.Allow(ip) {
+		t.Fatalf("Second request should be denied")
+	}
+
+	// One token takes 50ms
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow(ip) {
+		t.Errorf("Request after refill should be allowed")
+	}
+}
+
+func TestTokenBucketConcurrency(t *testing.T) {
+	// No refill to speak of, so exactly the burst gets through
+	limiter := NewTokenBucketLimiter(0.001, 50)
+	ip := "192.168.1.1"
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if limiter.Allow(ip) {
+					mu.Lock()
+					count++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count != 50 {
+		t.Errorf("Expected exactly 50 of 200 concurrent requests allowed, got %d", count)
+	}
+}
+
+func TestLimitersShareInterface(t *testing.T) {
+	for name, limiter := range map[string]RequestLimiter{
+		"window": NewRateLimiter(3, time.Minute),
+		"bucket": NewTokenBucketLimiter(3.0/60, 3),
+	} {
+		for i := 0; i < 3; i++ {
+			if !limiter.Allow("10.0.0.1") {
+				t.Errorf("%s: request %d should be allowed", name, i+1)
+			}
+		}
+		if limiter.Allow("10.0.0.1") {
+			t.Errorf("%s: 4th request should be denied", name)
+		}
+		limiter.Close()
+	}
+}
+
 func TestCombinedLimiters(t *testing.T) {
 	// Test combining cooldown and rate limiting
 	cooldownLimiter := NewLimiter()
@@ -297,6 +416,41 @@ func BenchmarkRateLimiter(b *testing.B) {
 	}
 }
 
+func BenchmarkTokenBucketLimiter(b *testing.B) {
+	limiter := NewTokenBucketLimiter(100.0/60, 100)
+	ip := "192.168.1.1"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		limiter.Allow(ip)
+	}
+}
+
+// The parallel benchmarks spread requests over many IPs, as under load
+func benchmarkLimiterParallel(b *testing.B, limiter RequestLimiter) {
+	ips := make([]string, 1024)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			limiter.Allow(ips[i%len(ips)])
+			i++
+		}
+	})
+}
+
+func BenchmarkRateLimiterParallel(b *testing.B) {
+	benchmarkLimiterParallel(b, NewRateLimiter(1000, time.Minute))
+}
+
+func BenchmarkTokenBucketLimiterParallel(b *testing.B) {
+	benchmarkLimiterParallel(b, NewTokenBucketLimiter(1000.0/60, 1000))
+}
+
 func BenchmarkSpeedLimiter(b *testing.B) {
 	limiter := NewSpeedLimiter(150.0)
 	ip := "192.168.1.1"
@@ -309,3 +463,162 @@ func BenchmarkSpeedLimiter(b *testing.B) {
 		limiter.CheckSpeed(ip, lat, lon)
 	}
 }
+
+func TestCooldownWithExplicitDuration(t *testing.T) {
+	limiter := NewLimiter()
+	fallback := 5 * time.Second
+
+	limiter.SetCooldownDuration("short", 50*time.Millisecond)
+	limiter.SetCooldownDuration("none", 0)
+	limiter.SetCooldown("default")
+
+	if remaining := limiter.GetCooldownRemaining("short", fallback); remaining > 50*time.Millisecond {
+		t.Errorf("Explicit duration should override fallback, got %v remaining", remaining)
+	}
+	if limiter.CheckCooldown("none", fallback) {
+		t.Errorf("Zero explicit duration should not cool down")
+	}
+	if remaining := limiter.GetCooldownRemaining("default", fallback); remaining <= 50*time.Millisecond {
+		t.Errorf("SetCooldown should use the fallback duration, got %v remaining", remaining)
+	}
+	if d := limiter.GetCooldownDuration("short", fallback); d != 50*time.Millisecond {
+		t.Errorf("Expected the explicit duration, got %v", d)
+	}
+	if d := limiter.GetCooldownDuration("default", fallback); d != fallback {
+		t.Errorf("Expected the fallback duration, got %v", d)
+	}
+	if d := limiter.GetCooldownDuration("unknown", fallback); d != 0 {
+		t.Errorf("Expected no duration without a cooldown, got %v", d)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if limiter.CheckCooldown("short", fallback) {
+		t.Errorf("Explicit cooldown should have expired")
+	}
+	if !limiter.CheckCooldown("default", fallback) {
+		t.Errorf("Fallback cooldown should still be active")
+	}
+}
+
+func TestJanitorEvictsStaleEntries(t *testing.T) {
+	speed := NewSpeedLimiter(150.0)
+	requests := NewRateLimiter(5, 10*time.Millisecond)
+	buckets := NewTokenBucketLimiter(1000, 5)
+	defer speed.Close()
+	defer requests.Close()
+	defer buckets.Close()
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		speed.CheckSpeed(ip, 42.3601, -71.0589)
+		requests.Allow(ip)
+		buckets.Allow(ip)
+	}
+
+	// A sweep at the current time keeps fresh entries
+	speed.evictBefore(time.Now().Add(-time.Minute))
+	requests.evictBefore(time.Now().Add(-time.Minute))
+	buckets.evictBefore(time.Now().Add(-time.Minute))
+	if len(speed.lastPositions) != 3 || len(requests.requests) != 3 || len(buckets.buckets) != 3 {
+		t.Fatalf("Fresh entries should survive a sweep")
+	}
+
+	speed.StartJanitor(5*time.Millisecond, 20*time.Millisecond)
+	requests.StartJanitor(5*time.Millisecond, 20*time.Millisecond)
+	buckets.StartJanitor(5*time.Millisecond, 20*time.Millisecond)
+
+	// Once past the TTL the janitors empty both maps
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		speed.mu.RLock()
+		positions := len(speed.lastPositions)
+		speed.mu.RUnlock()
+		requests.mu.RLock()
+		windows := len(requests.requests)
+		requests.mu.RUnlock()
+		buckets.mu.Lock()
+		idle := len(buckets.buckets)
+		buckets.mu.Unlock()
+
+		if positions == 0 && windows == 0 && idle == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Janitors did not evict stale entries")
+}
+
+// memoryBanStore is a BanStore without expiry, for driving a BanList
+type memoryBanStore struct {
+	strikes, offenses map[string]int64
+	bans              map[string]time.Duration
+}
+
+func newMemoryBanStore() *memoryBanStore {
+	return &memoryBanStore{
+		strikes:  make(map[string]int64),
+		offenses: make(map[string]int64),
+		bans:     make(map[string]time.Duration),
+	}
+}
+
+func (s *memoryBanStore) AddStrikeContext(ctx context.Context, ip string, ttl time.Duration) (int64, error) {
+	s.strikes[ip]++
+	return s.strikes[ip], nil
+}
+
+func (s *memoryBanStore) AddOffenseContext(ctx context.Context, ip string, ttl time.Duration) (int64, error) {
+	s.offenses[ip]++
+	return s.offenses[ip], nil
+}
+
+func (s *memoryBanStore) SetBanContext(ctx context.Context, ip string, d time.Duration) error {
+	s.bans[ip] = d
+	delete(s.strikes, ip)
+	return nil
+}
+
+func (s *memoryBanStore) BanRemainingContext(ctx context.Context, ip string) (time.Duration, error) {
+	return s.bans[ip], nil
+}
+
+func TestBanListEscalates(t *testing.T) {
+	store := newMemoryBanStore()
+	bans := NewBanList(store, 3, time.Minute, []time.Duration{time.Minute, 10 * time.Minute, time.Hour})
+	ip := "192.168.1.1"
+
+	// Each round of 3 strikes bans for the next penalty, the last repeating
+	for _, want := range []time.Duration{time.Minute, 10 * time.Minute, time.Hour, time.Hour} {
+		for i := 1; i <= 3; i++ {
+			d, err := bans.Strike(context.Background(), ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i < 3 && d != 0 {
+				t.Fatalf("Strike %d should not ban, got %v", i, d)
+			}
+			if i == 3 && d != want {
+				t.Fatalf("Strike %d should ban for %v, got %v", i, want, d)
+			}
+		}
+		if remaining, _ := bans.Remaining(context.Background(), ip); remaining != want {
+			t.Errorf("Expected %v remaining, got %v", want, remaining)
+		}
+	}
+
+	if remaining, _ := bans.Remaining(context.Background(), "192.168.1.2"); remaining != 0 {
+		t.Errorf("Expected an untouched IP not to be banned, got %v", remaining)
+	}
+}
+
+func TestParsePenalties(t *testing.T) {
+	penalties, err := ParsePenalties("1m, 10m,1h")
+	if err != nil || fmt.Sprint(penalties) != "[1m0s 10m0s 1h0m0s]" {
+		t.Errorf("ParsePenalties = %v, %v", penalties, err)
+	}
+	for _, bad := range []string{"1m,soon", "0s", "-1m"} {
+		if _, err := ParsePenalties(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// backgroundKey returns the Redis key holding a chunk's background color
+func backgroundKey(cx, cy int64) string {
+	return fmt.Sprintf("background:%d:%d", cx, cy)
+}
+
+// SetChunkBackground sets the color a chunk's unpainted tiles read as.
+// Color 0 removes it, so they read as unpainted again. Painted tiles are
+// unaffected either way.
+func (c *Client) SetChunkBackground(cx, cy int64, color uint8) error {
+	if color == 0 {
+		return c.client.Del(c.ctx, backgroundKey(cx, cy)).Err()
+	}
+	return c.client.Set(c.ctx, backgroundKey(cx, cy), color, 0).Err()
+}
+
+// GetChunkBackground returns a chunk's background color, or 0 if it has
+// none
+func (c *Client) GetChunkBackground(cx, cy int64) (uint8, error) {
+	return backgroundVal(c.client.Get(c.ctx, backgroundKey(cx, cy)))
+}
+
+// backgroundVal reads a background color from a GET, treating a missing key
+// as no background
+func backgroundVal(cmd *redis.StringCmd) (uint8, error) {
+	color, err := cmd.Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint8(color), nil
+}
@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// banKey returns the Redis key marking an IP as banned until it expires
+func banKey(ip string) string {
+	return fmt.Sprintf("ban:%s", ip)
+}
+
+// strikesKey returns the Redis key counting an IP's recent strikes
+func strikesKey(ip string) string {
+	return fmt.Sprintf("strikes:%s", ip)
+}
+
+// offensesKey returns the Redis key counting an IP's recent bans
+func offensesKey(ip string) string {
+	return fmt.Sprintf("offenses:%s", ip)
+}
+
+// AddStrike counts a strike against ip, expiring ttl after the latest one,
+// and returns its strikes so far
+func (c *Client) AddStrike(ip string, ttl time.Duration) (int64, error) {
+	return c.AddStrikeContext(c.ctx, ip, ttl)
+}
+
+// AddStrikeContext is AddStrike bounded by ctx
+func (c *Client) AddStrikeContext(ctx context.Context, ip string, ttl time.Duration) (int64, error) {
+	return c.incrExpire(ctx, strikesKey(ip), ttl)
+}
+
+// AddOffense counts a ban against ip, expiring ttl after the latest one,
+// and returns its bans so far
+func (c *Client) AddOffense(ip string, ttl time.Duration) (int64, error) {
+	return c.AddOffenseContext(c.ctx, ip, ttl)
+}
+
+// AddOffenseContext is AddOffense bounded by ctx
+func (c *Client) AddOffenseContext(ctx context.Context, ip string, ttl time.Duration) (int64, error) {
+	return c.incrExpire(ctx, offensesKey(ip), ttl)
+}
+
+// incrExpire increments a counter and pushes back its expiry in one round
+// trip
+func (c *Client) incrExpire(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := c.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return incr.Val(), nil
+}
+
+// SetBan bans ip for d and clears its strikes, so the next ban takes a full
+// threshold of new ones
+func (c *Client) SetBan(ip string, d time.Duration) error {
+	return c.SetBanContext(c.ctx, ip, d)
+}
+
+// SetBanContext is SetBan bounded by ctx
+func (c *Client) SetBanContext(ctx context.Context, ip string, d time.Duration) error {
+	pipe := c.client.TxPipeline()
+	pipe.Set(ctx, banKey(ip), 1, d)
+	pipe.Del(ctx, strikesKey(ip))
+	_, err := pipe.Exec(ctx)
+	return ctxErr(ctx, err)
+}
+
+// BanRemaining returns how much of ip's ban is left, or 0 if it has none
+func (c *Client) BanRemaining(ip string) (time.Duration, error) {
+	return c.BanRemainingContext(c.ctx, ip)
+}
+
+// BanRemainingContext is BanRemaining bounded by ctx
+func (c *Client) BanRemainingContext(ctx context.Context, ip string) (time.Duration, error) {
+	ttl, err := c.client.PTTL(ctx, banKey(ip)).Result()
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	// PTTL is negative for a missing key (or one without an expiry)
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"splat-boston/internal/bits"
+)
+
+// chunkWidth is the number of tiles along each side of a chunk
+const chunkWidth = 256
+
+// ChunkBorder is the ring of tiles just outside a chunk, read from its eight
+// neighbors. Colors are one per tile, with unpainted tiles read as their
+// own chunk's background, as GetTileColors does.
+type ChunkBorder struct {
+	// North and South are the neighboring rows, west to east
+	North, South []uint8
+	// West and East are the neighboring columns, north to south
+	West, East []uint8
+	// The diagonal neighbors' nearest corner tiles
+	NW, NE, SW, SE uint8
+}
+
+// GetChunkBorder reads the tiles bordering a chunk in one pipelined round
+// trip. Each neighboring row is one range of its chunk's bits; columns and
+// corners are read a byte per tile.
+func (c *Client) GetChunkBorder(cx, cy int64) (ChunkBorder, error) {
+	return c.GetChunkBorderContext(c.ctx, cx, cy)
+}
+
+// GetChunkBorderContext is GetChunkBorder bounded by ctx
+func (c *Client) GetChunkBorderContext(ctx context.Context, cx, cy int64) (ChunkBorder, error) {
+	bitsKey := func(dx, dy int64) string {
+		return fmt.Sprintf("chunk:%d:%d:bits", cx+dx, cy+dy)
+	}
+	// Tile y grows southward, so the north neighbor's last row borders ours
+	const lastRow = (chunkWidth - 1) * chunkWidth
+
+	pipe := c.client.Pipeline()
+	tileCmd := func(dx, dy int64, o int) *redis.StringCmd {
+		return pipe.GetRange(ctx, bitsKey(dx, dy), int64(o/2), int64(o/2))
+	}
+
+	north := pipe.GetRange(ctx, bitsKey(0, -1), lastRow/2, chunkBytes-1)
+	south := pipe.GetRange(ctx, bitsKey(0, 1), 0, chunkWidth/2-1)
+	west := make([]*redis.StringCmd, chunkWidth)
+	east := make([]*redis.StringCmd, chunkWidth)
+	for y := 0; y < chunkWidth; y++ {
+		west[y] = tileCmd(-1, 0, y*chunkWidth+chunkWidth-1)
+		east[y] = tileCmd(1, 0, y*chunkWidth)
+	}
+	nw := tileCmd(-1, -1, lastRow+chunkWidth-1)
+	ne := tileCmd(1, -1, lastRow)
+	sw := tileCmd(-1, 1, chunkWidth-1)
+	se := tileCmd(1, 1, 0)
+
+	backgroundCmds := make(map[[2]int64]*redis.StringCmd, 8)
+	for dy := int64(-1); dy <= 1; dy++ {
+		for dx := int64(-1); dx <= 1; dx++ {
+			if dx != 0 || dy != 0 {
+				backgroundCmds[[2]int64{dx, dy}] = pipe.Get(ctx, backgroundKey(cx+dx, cy+dy))
+			}
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return ChunkBorder{}, ctxErr(ctx, err)
+	}
+
+	backgrounds := make(map[[2]int64]uint8, len(backgroundCmds))
+	for d, cmd := range backgroundCmds {
+		background, err := backgroundVal(cmd)
+		if err != nil {
+			return ChunkBorder{}, err
+		}
+		backgrounds[d] = background
+	}
+
+	// color reads tile o of what cmd returned, falling back to the
+	// neighbor's background; a missing chunk reads as empty
+	var err error
+	color := func(cmd *redis.StringCmd, o int, dx, dy int64) uint8 {
+		b, cmdErr := cmd.Bytes()
+		if cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+		}
+		if v := bits.GetNibble(b, o); v != 0 {
+			return v
+		}
+		return backgrounds[[2]int64{dx, dy}]
+	}
+
+	border := ChunkBorder{
+		North: make([]uint8, chunkWidth),
+		South: make([]uint8, chunkWidth),
+		West:  make([]uint8, chunkWidth),
+		East:  make([]uint8, chunkWidth),
+	}
+	for i := 0; i < chunkWidth; i++ {
+		border.North[i] = color(north, i, 0, -1)
+		border.South[i] = color(south, i, 0, 1)
+		// Single bytes read back at index 0, so only the parity matters
+		border.West[i] = color(west[i], (chunkWidth-1)%2, -1, 0)
+		border.East[i] = color(east[i], 0, 1, 0)
+	}
+	border.NW = color(nw, (chunkWidth-1)%2, -1, -1)
+	border.NE = color(ne, 0, 1, -1)
+	border.SW = color(sw, (chunkWidth-1)%2, -1, 1)
+	border.SE = color(se, 0, 1, 1)
+	if err != nil {
+		return ChunkBorder{}, err
+	}
+	return border, nil
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PaintClaim is the outcome of ClaimPaint. When Claimed is false the same
+// paint was already submitted within the window; Seq and Ts are its result,
+// or zero while it is still in flight.
+type PaintClaim struct {
+	Claimed bool
+	Seq     uint64
+	Ts      int64
+}
+
+// dedupeKey returns the Redis key for a paint's fingerprint
+func dedupeKey(fingerprint string) string {
+	return "dup:" + fingerprint
+}
+
+// ClaimPaint marks a paint fingerprint as seen for ttl. The first caller
+// claims it; later callers get the first paint's recorded result.
+func (c *Client) ClaimPaint(fingerprint string, ttl time.Duration) (PaintClaim, error) {
+	return c.ClaimPaintContext(c.ctx, fingerprint, ttl)
+}
+
+// ClaimPaintContext is ClaimPaint bounded by ctx
+func (c *Client) ClaimPaintContext(ctx context.Context, fingerprint string, ttl time.Duration) (PaintClaim, error) {
+	key := dedupeKey(fingerprint)
+	ok, err := c.client.SetNX(ctx, key, "", ttl).Result()
+	if err != nil {
+		return PaintClaim{}, ctxErr(ctx, err)
+	}
+	if ok {
+		return PaintClaim{Claimed: true}, nil
+	}
+
+	val, err := c.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// Released or expired between the two calls; the retry decides
+		return c.ClaimPaintContext(ctx, fingerprint, ttl)
+	}
+	if err != nil {
+		return PaintClaim{}, ctxErr(ctx, err)
+	}
+
+	var claim PaintClaim
+	if val != "" {
+		if _, err := fmt.Sscanf(val, "%d,%d", &claim.Seq, &claim.Ts); err != nil {
+			return PaintClaim{}, fmt.Errorf("bad dedupe entry %q: %w", val, err)
+		}
+	}
+	return claim, nil
+}
+
+// RecordPaint stores a claimed paint's result for duplicates to return
+func (c *Client) RecordPaint(fingerprint string, seq uint64, ts int64, ttl time.Duration) error {
+	return c.RecordPaintContext(c.ctx, fingerprint, seq, ts, ttl)
+}
+
+// RecordPaintContext is RecordPaint bounded by ctx
+func (c *Client) RecordPaintContext(ctx context.Context, fingerprint string, seq uint64, ts int64, ttl time.Duration) error {
+	return ctxErr(ctx, c.client.SetXX(ctx, dedupeKey(fingerprint), fmt.Sprintf("%d,%d", seq, ts), ttl).Err())
+}
+
+// ReleasePaint forgets a claim whose paint was rejected, so a corrected
+// retry isn't mistaken for a duplicate
+func (c *Client) ReleasePaint(fingerprint string) error {
+	return c.ReleasePaintContext(c.ctx, fingerprint)
+}
+
+// ReleasePaintContext is ReleasePaint bounded by ctx
+func (c *Client) ReleasePaintContext(ctx context.Context, fingerprint string) error {
+	return ctxErr(ctx, c.client.Del(ctx, dedupeKey(fingerprint)).Err())
+}
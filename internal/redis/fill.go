@@ -0,0 +1,131 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const fillScript = `
+-- KEYS[1]=k_bits, KEYS[2]=k_seq, KEYS[3]=k_palette, KEYS[4]=k_log,
+-- KEYS[5]=k_write
+-- ARGV[1]=minX, ARGV[2]=minY, ARGV[3]=maxX, ARGV[4]=maxY, ARGV[5]=color,
+-- ARGV[6]=nowTs, ARGV[7]=useRedisTime, ARGV[8]=writeTtlMs, ARGV[9]=chunkTtlMs
+
+-- a retry of a fill that was applied gets its result again, as in the
+-- paint script
+local writeTtl = tonumber(ARGV[8])
+if writeTtl > 0 then
+  local done = redis.call('GET', KEYS[5])
+  if done then
+    local seq, ts = string.match(done, '^(%d+),(%d+)$')
+    return { tonumber(seq), tonumber(ts) }
+  end
+end
+
+local minX, minY = tonumber(ARGV[1]), tonumber(ARGV[2])
+local maxX, maxY = tonumber(ARGV[3]), tonumber(ARGV[4])
+local color = tonumber(ARGV[5])
+local now = tonumber(ARGV[6])
+if ARGV[7] == '1' then
+  now = tonumber(redis.call('TIME')[1])
+end
+
+if redis.call('EXISTS', KEYS[3]) == 1 and redis.call('SISMEMBER', KEYS[3], color) == 0 then
+  return redis.error_reply('COLOR_NOT_ALLOWED')
+end
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  redis.call('SETRANGE', KEYS[1], 32767, string.char(0))
+end
+
+-- each row is one run of bytes; only a byte at either end may hold a tile
+-- outside the rectangle, whose nibble is kept
+local both = string.char(color * 16 + color)
+for y = minY, maxY do
+  local first = math.floor((y * 256 + minX) / 2)
+  local last = math.floor((y * 256 + maxX) / 2)
+  local row = string.rep(both, last - first + 1)
+  if minX % 2 == 1 then
+    local b = string.byte(redis.call('GETRANGE', KEYS[1], first, first))
+    row = string.char(math.floor(b / 16) * 16 + color) .. string.sub(row, 2)
+  end
+  if maxX % 2 == 0 then
+    local b = string.byte(redis.call('GETRANGE', KEYS[1], last, last))
+    row = string.sub(row, 1, -2) .. string.char(color * 16 + b % 16)
+  end
+  redis.call('SETRANGE', KEYS[1], first, row)
+end
+
+local seq = redis.call('INCR', KEYS[2])
+
+-- the history can't express a fill, so it starts over: clients resuming
+-- from before it are sent the whole chunk instead
+redis.call('DEL', KEYS[4])
+
+local chunkTtl = tonumber(ARGV[9])
+if chunkTtl > 0 then
+  redis.call('PEXPIRE', KEYS[1], chunkTtl)
+  redis.call('PEXPIRE', KEYS[2], chunkTtl)
+end
+
+if writeTtl > 0 then
+  redis.call('SET', KEYS[5], seq .. ',' .. now, 'PX', writeTtl)
+end
+
+return { seq, now }
+`
+
+// FillRect paints every tile in a rectangle of a chunk one color, in
+// tile coordinates within the chunk, inclusive, as a single write with a
+// single seq. It is all or nothing, and the chunk's history is cleared, so
+// clients resuming from an earlier seq are sent the whole chunk.
+func (c *Client) FillRect(cx, cy int64, minX, minY, maxX, maxY int, color uint8) (seq uint64, ts int64, err error) {
+	return c.FillRectContext(c.ctx, cx, cy, minX, minY, maxX, maxY, color)
+}
+
+// FillRectContext is FillRect bounded by ctx
+func (c *Client) FillRectContext(ctx context.Context, cx, cy int64, minX, minY, maxX, maxY int, color uint8) (seq uint64, ts int64, err error) {
+	if minX < 0 || minY < 0 || maxX > 255 || maxY > 255 || minX > maxX || minY > maxY {
+		return 0, 0, fmt.Errorf("fill: rectangle (%d,%d)-(%d,%d) is not inside a chunk", minX, minY, maxX, maxY)
+	}
+	if color > 15 {
+		return 0, 0, fmt.Errorf("fill: invalid color %d", color)
+	}
+
+	id := c.writeID(ctx)
+	var writeTtlMs int64
+	if id != "" {
+		writeTtlMs = writeIDLifetime(ctx).Milliseconds()
+	}
+	keys := []string{
+		fmt.Sprintf("chunk:%d:%d:bits", cx, cy),
+		fmt.Sprintf("chunk:%d:%d:seq", cx, cy),
+		paletteKey(cx, cy),
+		historyKey(cx, cy),
+		writeIDKey(id),
+	}
+	useRedisTime := "0"
+	if c.useRedisTime {
+		useRedisTime = "1"
+	}
+
+	now := time.Now().Unix()
+	var result interface{}
+	err = c.retryWrite(ctx, func() (err error) {
+		result, err = c.fillScript.Run(ctx, c.writes, keys, minX, minY, maxX, maxY, color, now, useRedisTime, writeTtlMs, c.chunkTTL.Milliseconds()).Result()
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "COLOR_NOT_ALLOWED") {
+			return 0, 0, ErrColorNotAllowed
+		}
+		return 0, 0, ctxErr(ctx, err)
+	}
+	var out [2]int64
+	if err := scriptInts(result, out[:]); err != nil {
+		return 0, 0, fmt.Errorf("fill: %w", err)
+	}
+	return uint64(out[0]), out[1], nil
+}
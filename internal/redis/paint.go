@@ -2,20 +2,58 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+
+	"splat-boston/internal/bits"
 )
 
 const paintScript = `
--- KEYS[1]=k_bits, KEYS[2]=k_seq
--- ARGV[1]=o, ARGV[2]=color, ARGV[3]=nowTs
+-- KEYS[1]=k_bits, KEYS[2]=k_seq, KEYS[3]=k_palette, KEYS[4]=k_log,
+-- KEYS[5]=k_write, KEYS[6]=k_cool (optional)
+-- ARGV[1]=o, ARGV[2]=color, ARGV[3]=nowTs, ARGV[4]=useRedisTime,
+-- ARGV[5]=historyLen, ARGV[6]=ifEmpty, ARGV[7]=cooldownMs, ARGV[8]=writeTtlMs,
+-- ARGV[9]=chunkTtlMs
+
+-- a retry of a paint that was applied gets its result again rather than
+-- painting, and bumping the seq, twice
+local writeTtl = tonumber(ARGV[8])
+if writeTtl > 0 then
+  local done = redis.call('GET', KEYS[5])
+  if done then
+    local seq, ts, prev = string.match(done, '^(%d+),(%d+),(%d+)$')
+    return { tonumber(seq), tonumber(ts), tonumber(prev) }
+  end
+end
 
 local o = tonumber(ARGV[1])
 local color = tonumber(ARGV[2])
 local now = tonumber(ARGV[3])
 
+-- Redis's own clock is the single source of truth across server instances
+if ARGV[4] == '1' then
+  now = tonumber(redis.call('TIME')[1])
+end
+
+-- a painter still cooling down is refused before anything is written;
+-- seq -1 tells the caller
+local cooldownMs = tonumber(ARGV[7])
+if cooldownMs and cooldownMs > 0 and redis.call('EXISTS', KEYS[6]) == 1 then
+  return { -1, now, 0 }
+end
+
+-- a region palette, when present, restricts the colors that may be written;
+-- checking it here keeps the check atomic with the write
+if redis.call('EXISTS', KEYS[3]) == 1 and redis.call('SISMEMBER', KEYS[3], color) == 0 then
+  return redis.error_reply('COLOR_NOT_ALLOWED')
+end
+
 local byteIdx = math.floor((o * 4) / 8)
 local nibbleIsHigh = (o % 2) == 0
 
@@ -26,27 +64,87 @@ if cur == false or #cur == 0 then
   cur = string.char(0)
 end
 
+-- plain arithmetic instead of the bit library so the script also runs on
+-- Lua engines without it (e.g. miniredis in tests)
 local b = string.byte(cur)
+local hi = math.floor(b / 16)
+local lo = b % 16
 local prev
 if nibbleIsHigh then
-  prev = bit.rshift(bit.band(b, 0xF0), 4)
-  b = bit.bor(bit.band(b, 0x0F), bit.lshift(color, 4))
+  prev = hi
+  b = color * 16 + lo
 else
-  prev = bit.band(b, 0x0F)
-  b = bit.bor(bit.band(b, 0xF0), color)
+  prev = lo
+  b = hi * 16 + color
+end
+
+-- claim mode only writes unpainted tiles; seq 0 tells the caller it lost
+if ARGV[6] == '1' and prev ~= 0 then
+  return { 0, now, prev }
 end
 
 redis.call('SETRANGE', KEYS[1], byteIdx, string.char(b))
 local seq = redis.call('INCR', KEYS[2])
 
+-- recent deltas, oldest first, for clients resuming from a seq; the
+-- previous color lets the painter undo it
+local historyLen = tonumber(ARGV[5])
+if historyLen and historyLen > 0 then
+  redis.call('RPUSH', KEYS[4], seq .. ',' .. o .. ',' .. color .. ',' .. now .. ',' .. prev)
+  redis.call('LTRIM', KEYS[4], -historyLen, -1)
+end
+
+-- an abandoned chunk expires, and each paint pushes that back. The bits,
+-- seq and history share one deadline, so a seq never restarts from 0
+-- while older bits or deltas remain.
+local chunkTtl = tonumber(ARGV[9])
+if chunkTtl > 0 then
+  redis.call('PEXPIRE', KEYS[1], chunkTtl)
+  redis.call('PEXPIRE', KEYS[2], chunkTtl)
+  redis.call('PEXPIRE', KEYS[4], chunkTtl)
+end
+
+if cooldownMs and cooldownMs > 0 then
+  redis.call('SET', KEYS[6], now, 'PX', cooldownMs)
+end
+
+if writeTtl > 0 then
+  redis.call('SET', KEYS[5], seq .. ',' .. now .. ',' .. prev, 'PX', writeTtl)
+end
+
 return { seq, now, prev }
 `
 
+// ErrColorNotAllowed is returned when a region palette forbids the color
+var ErrColorNotAllowed = errors.New("color not allowed in region")
+
+// ErrTileOccupied is returned by PaintTileIfEmpty when the tile is already
+// painted
+var ErrTileOccupied = errors.New("tile already painted")
+
+// ErrUnexpectedReply is returned when a script's reply isn't the shape it
+// returns, as from a proxy or a Redis that converts replies differently
+var ErrUnexpectedReply = errors.New("unexpected script reply")
+
 // Client wraps a Redis client with paint-specific methods
 type Client struct {
 	client      *redis.Client
 	ctx         context.Context
 	paintScript *redis.Script
+	undoScript  *redis.Script
+	fillScript  *redis.Script
+
+	// writes runs the paint, undo and fill scripts. go-redis's own retries
+	// are off on it: they would re-run a script whose reply was lost,
+	// painting twice. retryWrite retries instead, and the scripts spot
+	// replays.
+	writes       *redis.Client
+	writeRetries int
+	writeBackoff time.Duration
+
+	useRedisTime bool
+	historyLen   int
+	chunkTTL     time.Duration
 }
 
 // NewClient creates a new Redis client
@@ -56,70 +154,581 @@ func NewClient(redisURL string) (*Client, error) {
 		return nil, err
 	}
 
-	client := redis.NewClient(opts)
+	c := newClient(opts)
 
 	// Test connection
-	if err := client.Ping(context.Background()).Err(); err != nil {
+	if err := c.client.Ping(c.ctx).Err(); err != nil {
+		c.Close()
 		return nil, err
 	}
+	return c, nil
+}
 
-	script := redis.NewScript(paintScript)
+// newClient builds a Client without checking that Redis answers
+func newClient(opts *redis.Options) *Client {
+	writeOpts := *opts
+	writeOpts.MaxRetries = -1
 
 	return &Client{
-		client:      client,
-		ctx:         context.Background(),
-		paintScript: script,
-	}, nil
+		client:       redis.NewClient(opts),
+		ctx:          context.Background(),
+		paintScript:  redis.NewScript(paintScript),
+		undoScript:   redis.NewScript(undoScript),
+		fillScript:   redis.NewScript(fillScript),
+		writes:       redis.NewClient(&writeOpts),
+		writeRetries: defaultWriteRetries,
+		writeBackoff: defaultWriteBackoff,
+	}
+}
+
+// UseRedisTime makes PaintTile timestamp paints with Redis's TIME instead
+// of this server's clock, so instances with skewed clocks agree
+func (c *Client) UseRedisTime(enabled bool) {
+	c.useRedisTime = enabled
 }
 
-// Close closes the Redis connection
+// KeepHistory makes PaintTile retain each chunk's last n deltas for
+// DeltasSince. Zero, the default, keeps none.
+func (c *Client) KeepHistory(n int) {
+	c.historyLen = n
+}
+
+// ExpireChunks makes paints set each chunk's bits, seq and history to
+// expire after ttl, so chunks nobody paints for that long are dropped.
+// Zero, the default, keeps chunks forever.
+func (c *Client) ExpireChunks(ttl time.Duration) {
+	c.chunkTTL = ttl
+}
+
+// ClockSkew returns how far Redis's clock is ahead of this server's,
+// measured against the midpoint of the TIME round trip
+func (c *Client) ClockSkew() (time.Duration, error) {
+	before := time.Now()
+	redisNow, err := c.client.Time(c.ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	after := time.Now()
+
+	local := before.Add(after.Sub(before) / 2)
+	return redisNow.Sub(local), nil
+}
+
+// Close closes the Redis connections
 func (c *Client) Close() error {
+	c.writes.Close()
 	return c.client.Close()
 }
 
 // PaintTile atomically paints a tile and returns the new sequence number, timestamp, and previous color
 func (c *Client) PaintTile(cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error) {
+	return c.PaintTileContext(c.ctx, cx, cy, offset, color)
+}
+
+// PaintTileContext is PaintTile bounded by ctx. A paint whose context ends
+// mid-flight may or may not have been applied.
+func (c *Client) PaintTileContext(ctx context.Context, cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error) {
+	return c.paintTile(ctx, cx, cy, offset, color, false)
+}
+
+// PaintTileIfEmpty paints a tile only if it is unpainted, so the first
+// painter claims it. An occupied tile returns ErrTileOccupied along with
+// its current color.
+func (c *Client) PaintTileIfEmpty(cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error) {
+	return c.PaintTileIfEmptyContext(c.ctx, cx, cy, offset, color)
+}
+
+// PaintTileIfEmptyContext is PaintTileIfEmpty bounded by ctx
+func (c *Client) PaintTileIfEmptyContext(ctx context.Context, cx, cy int64, offset int, color uint8) (uint64, int64, uint8, error) {
+	seq, ts, prev, err := c.paintTile(ctx, cx, cy, offset, color, true)
+	if err == nil && seq == 0 {
+		return 0, ts, prev, ErrTileOccupied
+	}
+	return seq, ts, prev, err
+}
+
+// PaintTileWithCooldown paints a tile and starts ip's cooldown of
+// cooldownMs in the same script, so instances sharing Redis can't both let
+// one painter through. If ip is still cooling down nothing is painted and
+// cooled is true.
+func (c *Client) PaintTileWithCooldown(cx, cy int64, offset int, color uint8, ip string, cooldownMs int64) (seq uint64, ts int64, prev uint8, cooled bool, err error) {
+	return c.PaintTileWithCooldownContext(c.ctx, cx, cy, offset, color, ip, cooldownMs)
+}
+
+// PaintTileWithCooldownContext is PaintTileWithCooldown bounded by ctx
+func (c *Client) PaintTileWithCooldownContext(ctx context.Context, cx, cy int64, offset int, color uint8, ip string, cooldownMs int64) (seq uint64, ts int64, prev uint8, cooled bool, err error) {
+	return c.paintTileWithCooldown(ctx, cx, cy, offset, color, false, ip, cooldownMs)
+}
+
+// PaintTileIfEmptyWithCooldownContext is PaintTileIfEmptyContext with ip's
+// cooldown checked and started as in PaintTileWithCooldown. Losing the
+// claim starts no cooldown.
+func (c *Client) PaintTileIfEmptyWithCooldownContext(ctx context.Context, cx, cy int64, offset int, color uint8, ip string, cooldownMs int64) (seq uint64, ts int64, prev uint8, cooled bool, err error) {
+	seq, ts, prev, cooled, err = c.paintTileWithCooldown(ctx, cx, cy, offset, color, true, ip, cooldownMs)
+	if err == nil && !cooled && seq == 0 {
+		return 0, ts, prev, false, ErrTileOccupied
+	}
+	return seq, ts, prev, cooled, err
+}
+
+// paintTileWithCooldown runs the paint script with ip's cooldown, in claim
+// mode when ifEmpty is set
+func (c *Client) paintTileWithCooldown(ctx context.Context, cx, cy int64, offset int, color uint8, ifEmpty bool, ip string, cooldownMs int64) (uint64, int64, uint8, bool, error) {
+	result, err := c.runPaintScript(ctx, cx, cy, offset, color, ifEmpty, cooldownKey(ip), cooldownMs)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	if result[0] == -1 {
+		return 0, result[1], 0, true, nil
+	}
+	return uint64(result[0]), result[1], uint8(result[2]), false, nil
+}
+
+// cooldownKey returns the Redis key marking an IP as cooling down
+func cooldownKey(ip string) string {
+	return fmt.Sprintf("cool:%s", ip)
+}
+
+// paintTile runs the paint script, in claim mode when ifEmpty is set
+func (c *Client) paintTile(ctx context.Context, cx, cy int64, offset int, color uint8, ifEmpty bool) (uint64, int64, uint8, error) {
+	result, err := c.runPaintScript(ctx, cx, cy, offset, color, ifEmpty, "", 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return uint64(result[0]), result[1], uint8(result[2]), nil
+}
+
+// runPaintScript runs the paint script and returns its three results. A
+// cooldown applies when cooldownMs is positive.
+func (c *Client) runPaintScript(ctx context.Context, cx, cy int64, offset int, color uint8, ifEmpty bool, kCool string, cooldownMs int64) ([3]int64, error) {
 	kBits := fmt.Sprintf("chunk:%d:%d:bits", cx, cy)
 	kSeq := fmt.Sprintf("chunk:%d:%d:seq", cx, cy)
+	kPalette := paletteKey(cx, cy)
+	kLog := historyKey(cx, cy)
+	id := c.writeID(ctx)
+	keys := []string{kBits, kSeq, kPalette, kLog, writeIDKey(id)}
+	if cooldownMs > 0 {
+		keys = append(keys, kCool)
+	}
+
+	useRedisTime := "0"
+	if c.useRedisTime {
+		useRedisTime = "1"
+	}
 
-	result, err := c.paintScript.Run(c.ctx, c.client, []string{kBits, kSeq}, offset, color, time.Now().Unix()).Result()
+	claim := "0"
+	if ifEmpty {
+		claim = "1"
+	}
+
+	var writeTtlMs int64
+	if id != "" {
+		writeTtlMs = writeIDLifetime(ctx).Milliseconds()
+	}
+
+	// The timestamp is taken once, so a replayed paint has the same one
+	now := time.Now().Unix()
+	var result interface{}
+	err := c.retryWrite(ctx, func() (err error) {
+		result, err = c.paintScript.Run(ctx, c.writes, keys, offset, color, now, useRedisTime, c.historyLen, claim, cooldownMs, writeTtlMs, c.chunkTTL.Milliseconds()).Result()
+		return err
+	})
 	if err != nil {
-		return 0, 0, 0, err
+		if strings.Contains(err.Error(), "COLOR_NOT_ALLOWED") {
+			return [3]int64{}, ErrColorNotAllowed
+		}
+		return [3]int64{}, ctxErr(ctx, err)
 	}
 
-	arr := result.([]interface{})
-	seq := uint64(arr[0].(int64))
-	ts := arr[1].(int64)
-	prev := uint8(arr[2].(int64))
+	var out [3]int64
+	if err := scriptInts(result, out[:]); err != nil {
+		return [3]int64{}, fmt.Errorf("paint: %w", err)
+	}
+	return out, nil
+}
 
-	return seq, ts, prev, nil
+// scriptInts reads a script's array reply of len(out) integers into out
+func scriptInts(result interface{}, out []int64) error {
+	arr, ok := result.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %T, want an array", ErrUnexpectedReply, result)
+	}
+	if len(arr) != len(out) {
+		return fmt.Errorf("%w: %d values, want %d", ErrUnexpectedReply, len(arr), len(out))
+	}
+	for i, v := range arr {
+		n, err := replyInt(v)
+		if err != nil {
+			return fmt.Errorf("%w: value %d: %v", ErrUnexpectedReply, i, err)
+		}
+		out[i] = n
+	}
+	return nil
+}
+
+// replyInt converts one value of a script reply to an integer. Lua numbers
+// reach go-redis as int64, but a proxy or RESP3 can turn them into floats
+// or strings.
+func replyInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("%T is not a number", v)
+}
+
+// historyKey returns the Redis key holding a chunk's recent deltas
+func historyKey(cx, cy int64) string {
+	return fmt.Sprintf("chunk:%d:%d:log", cx, cy)
+}
+
+// HistoryEntry is one retained paint in a chunk's history
+type HistoryEntry struct {
+	Seq   uint64
+	O     int
+	Color uint8
+	Ts    int64
+}
+
+// DeltasSince returns a chunk's paints after seq, oldest first. ok is false
+// when some of them have aged out of the history (or were never kept), in
+// which case the caller must fall back to the full chunk.
+func (c *Client) DeltasSince(cx, cy int64, seq uint64) ([]HistoryEntry, bool, error) {
+	return c.DeltasSinceContext(c.ctx, cx, cy, seq)
+}
+
+// DeltasSinceContext is DeltasSince bounded by ctx
+func (c *Client) DeltasSinceContext(ctx context.Context, cx, cy int64, seq uint64) ([]HistoryEntry, bool, error) {
+	var logCmd *redis.StringSliceCmd
+	var seqCmd *redis.StringCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		logCmd = pipe.LRange(ctx, historyKey(cx, cy), 0, -1)
+		seqCmd = pipe.Get(ctx, fmt.Sprintf("chunk:%d:%d:seq", cx, cy))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, false, ctxErr(ctx, err)
+	}
+
+	current, err := seqCmd.Uint64()
+	if err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+	if seq == current {
+		return nil, true, nil
+	}
+	if seq > current {
+		// The client is ahead of this chunk, e.g. after a Redis reset
+		return nil, false, nil
+	}
+
+	var entries []HistoryEntry
+	for _, raw := range logCmd.Val() {
+		var e HistoryEntry
+		if _, err := fmt.Sscanf(raw, "%d,%d,%d,%d", &e.Seq, &e.O, &e.Color, &e.Ts); err != nil {
+			return nil, false, fmt.Errorf("bad history entry %q: %w", raw, err)
+		}
+		if e.Seq > seq {
+			entries = append(entries, e)
+		}
+	}
+
+	// Every seq after the client's must still be retained, with no gaps from
+	// paints made while history was off
+	if uint64(len(entries)) != current-seq || entries[0].Seq != seq+1 {
+		return nil, false, nil
+	}
+	return entries, true, nil
+}
+
+// paletteKey returns the Redis key holding a region's allowed colors
+func paletteKey(cx, cy int64) string {
+	return fmt.Sprintf("palette:%d:%d", cx, cy)
+}
+
+// SetRegionPalette restricts painting in a chunk to the given colors.
+// Passing no colors removes the restriction.
+func (c *Client) SetRegionPalette(cx, cy int64, colors []uint8) error {
+	key := paletteKey(cx, cy)
+	members := make([]interface{}, len(colors))
+	for i, color := range colors {
+		members[i] = color
+	}
+
+	pipe := c.client.TxPipeline()
+	pipe.Del(c.ctx, key)
+	if len(members) > 0 {
+		pipe.SAdd(c.ctx, key, members...)
+	}
+	_, err := pipe.Exec(c.ctx)
+	return err
+}
+
+// ClearRegionPalette removes a chunk's palette restriction
+func (c *Client) ClearRegionPalette(cx, cy int64) error {
+	return c.client.Del(c.ctx, paletteKey(cx, cy)).Err()
+}
+
+// IsColorAllowed reports whether a chunk's palette, if it has one, permits
+// the color. It mirrors the check the paint script makes.
+func (c *Client) IsColorAllowed(cx, cy int64, color uint8) (bool, error) {
+	key := paletteKey(cx, cy)
+	exists, err := c.client.Exists(c.ctx, key).Result()
+	if err != nil || exists == 0 {
+		return true, err
+	}
+	return c.client.SIsMember(c.ctx, key, color).Result()
 }
 
 // GetChunkBits retrieves the full 32KB chunk bitstring
 func (c *Client) GetChunkBits(cx, cy int64) ([]byte, error) {
+	return c.GetChunkBitsContext(c.ctx, cx, cy)
+}
+
+// GetChunkBitsContext is GetChunkBits bounded by ctx
+func (c *Client) GetChunkBitsContext(ctx context.Context, cx, cy int64) ([]byte, error) {
 	kBits := fmt.Sprintf("chunk:%d:%d:bits", cx, cy)
-	return c.client.GetRange(c.ctx, kBits, 0, 32767).Bytes()
+	b, err := c.client.GetRange(ctx, kBits, 0, 32767).Bytes()
+	return b, ctxErr(ctx, err)
+}
+
+// chunkBytes is the size of a chunk's bitstring: 256×256 tiles at 4 bits
+const chunkBytes = 32768
+
+// GetChunkBitsRange retrieves bytes startByte through endByte, inclusive, of
+// a chunk's bitstring, zero-filled where the chunk is unpainted
+func (c *Client) GetChunkBitsRange(cx, cy int64, startByte, endByte int) ([]byte, error) {
+	return c.GetChunkBitsRangeContext(c.ctx, cx, cy, startByte, endByte)
+}
+
+// GetChunkBitsRangeContext is GetChunkBitsRange bounded by ctx
+func (c *Client) GetChunkBitsRangeContext(ctx context.Context, cx, cy int64, startByte, endByte int) ([]byte, error) {
+	if err := checkByteRange(startByte, endByte); err != nil {
+		return nil, err
+	}
+	kBits := fmt.Sprintf("chunk:%d:%d:bits", cx, cy)
+	b, err := c.client.GetRange(ctx, kBits, int64(startByte), int64(endByte)).Bytes()
+	if err != nil && err != redis.Nil {
+		return nil, ctxErr(ctx, err)
+	}
+	buf := make([]byte, endByte-startByte+1)
+	copy(buf, b)
+	return buf, nil
+}
+
+// checkByteRange checks an inclusive byte range lies within a chunk
+func checkByteRange(startByte, endByte int) error {
+	if startByte < 0 || endByte >= chunkBytes || startByte > endByte {
+		return fmt.Errorf("byte range %d-%d is not within a %d-byte chunk", startByte, endByte, chunkBytes)
+	}
+	return nil
+}
+
+// GetChunkSnapshot reads a chunk's bits and seq in one transaction so the
+// seq matches the bits exactly. Unpainted chunks read as blank with seq 0.
+func (c *Client) GetChunkSnapshot(cx, cy int64) ([]byte, uint64, error) {
+	return c.GetChunkSnapshotContext(c.ctx, cx, cy)
+}
+
+// GetChunkSnapshotContext is GetChunkSnapshot bounded by ctx
+func (c *Client) GetChunkSnapshotContext(ctx context.Context, cx, cy int64) ([]byte, uint64, error) {
+	snaps, err := c.GetChunkSnapshotsContext(ctx, []ChunkRef{{Cx: cx, Cy: cy}})
+	if err != nil {
+		return nil, 0, err
+	}
+	return snaps[0].Bits, snaps[0].Seq, nil
+}
+
+// ChunkRef identifies a chunk
+type ChunkRef struct {
+	Cx int64
+	Cy int64
+}
+
+// ChunkSnapshot is a chunk's 32KB bits and the seq they are current as of.
+// Background is the color its unpainted tiles read as, or 0 for none; the
+// bits keep 0 for them either way. PaletteID names the palette its colors
+// are drawn from, or is empty for the default palette.
+type ChunkSnapshot struct {
+	ChunkRef
+	Seq        uint64
+	Bits       []byte
+	Background uint8
+	PaletteID  string
+}
+
+// GetChunkSnapshots reads several chunks in one MULTI round trip, in the
+// order given. Unpainted chunks come back blank with seq 0.
+func (c *Client) GetChunkSnapshots(chunks []ChunkRef) ([]ChunkSnapshot, error) {
+	return c.GetChunkSnapshotsContext(c.ctx, chunks)
+}
+
+// GetChunkSnapshotsContext is GetChunkSnapshots bounded by ctx
+func (c *Client) GetChunkSnapshotsContext(ctx context.Context, chunks []ChunkRef) ([]ChunkSnapshot, error) {
+	return c.chunkSnapshots(ctx, chunks, 0, chunkBytes-1)
+}
+
+// GetChunkSnapshotRangeContext reads a chunk like GetChunkSnapshotsContext,
+// but only bytes startByte through endByte, inclusive, of its bits. Seq is
+// still the whole chunk's.
+func (c *Client) GetChunkSnapshotRangeContext(ctx context.Context, cx, cy int64, startByte, endByte int) (ChunkSnapshot, error) {
+	if err := checkByteRange(startByte, endByte); err != nil {
+		return ChunkSnapshot{}, err
+	}
+	snaps, err := c.chunkSnapshots(ctx, []ChunkRef{{Cx: cx, Cy: cy}}, startByte, endByte)
+	if err != nil {
+		return ChunkSnapshot{}, err
+	}
+	return snaps[0], nil
+}
+
+// chunkSnapshots reads chunks' seqs and bytes startByte through endByte of
+// their bits in one transaction
+func (c *Client) chunkSnapshots(ctx context.Context, chunks []ChunkRef, startByte, endByte int) ([]ChunkSnapshot, error) {
+	bitsCmds := make([]*redis.StringCmd, len(chunks))
+	seqCmds := make([]*redis.StringCmd, len(chunks))
+	backgroundCmds := make([]*redis.StringCmd, len(chunks))
+	paletteIDCmds := make([]*redis.StringCmd, len(chunks))
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, chunk := range chunks {
+			bitsCmds[i] = pipe.GetRange(ctx, fmt.Sprintf("chunk:%d:%d:bits", chunk.Cx, chunk.Cy), int64(startByte), int64(endByte))
+			seqCmds[i] = pipe.Get(ctx, fmt.Sprintf("chunk:%d:%d:seq", chunk.Cx, chunk.Cy))
+			backgroundCmds[i] = pipe.Get(ctx, backgroundKey(chunk.Cx, chunk.Cy))
+			paletteIDCmds[i] = pipe.Get(ctx, paletteIDKey(chunk.Cx, chunk.Cy))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	snaps := make([]ChunkSnapshot, len(chunks))
+	for i, chunk := range chunks {
+		buf := make([]byte, endByte-startByte+1)
+		b, err := bitsCmds[i].Bytes()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		copy(buf, b)
+
+		seq, err := seqCmds[i].Uint64()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		background, err := backgroundVal(backgroundCmds[i])
+		if err != nil {
+			return nil, err
+		}
+		paletteID, err := paletteIDVal(paletteIDCmds[i])
+		if err != nil {
+			return nil, err
+		}
+		snaps[i] = ChunkSnapshot{ChunkRef: chunk, Seq: seq, Bits: buf, Background: background, PaletteID: paletteID}
+	}
+	return snaps, nil
+}
+
+// TileRef identifies a single tile by chunk and offset
+type TileRef struct {
+	Cx int64
+	Cy int64
+	O  int
+}
+
+// GetTileColors reads the colors of the given tiles in a single pipelined
+// round trip. Unpainted tiles read as their chunk's background color, or 0
+// if it has none.
+func (c *Client) GetTileColors(tiles []TileRef) ([]uint8, error) {
+	return c.GetTileColorsContext(c.ctx, tiles)
+}
+
+// GetTileColorsContext is GetTileColors bounded by ctx
+func (c *Client) GetTileColorsContext(ctx context.Context, tiles []TileRef) ([]uint8, error) {
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(tiles))
+	backgroundCmds := make(map[ChunkRef]*redis.StringCmd)
+	for i, tile := range tiles {
+		kBits := fmt.Sprintf("chunk:%d:%d:bits", tile.Cx, tile.Cy)
+		byteIdx := int64(tile.O / 2)
+		cmds[i] = pipe.GetRange(ctx, kBits, byteIdx, byteIdx)
+
+		chunk := ChunkRef{Cx: tile.Cx, Cy: tile.Cy}
+		if _, ok := backgroundCmds[chunk]; !ok {
+			backgroundCmds[chunk] = pipe.Get(ctx, backgroundKey(tile.Cx, tile.Cy))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	colors := make([]uint8, len(tiles))
+	for i, cmd := range cmds {
+		b, err := cmd.Bytes()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		// Reuse the nibble layout: the byte read back sits at index 0
+		if colors[i] = bits.GetNibble(b, tiles[i].O%2); colors[i] != 0 {
+			continue
+		}
+		background, err := backgroundVal(backgroundCmds[ChunkRef{Cx: tiles[i].Cx, Cy: tiles[i].Cy}])
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = background
+	}
+	return colors, nil
 }
 
 // GetChunkSeq retrieves the current sequence number for a chunk
 func (c *Client) GetChunkSeq(cx, cy int64) (uint64, error) {
+	return c.GetChunkSeqContext(c.ctx, cx, cy)
+}
+
+// GetChunkSeqContext is GetChunkSeq bounded by ctx
+func (c *Client) GetChunkSeqContext(ctx context.Context, cx, cy int64) (uint64, error) {
 	kSeq := fmt.Sprintf("chunk:%d:%d:seq", cx, cy)
-	return c.client.Get(c.ctx, kSeq).Uint64()
+	seq, err := c.client.Get(ctx, kSeq).Uint64()
+	return seq, ctxErr(ctx, err)
 }
 
 // SetCooldown sets a cooldown for an IP address
 func (c *Client) SetCooldown(ip string, duration time.Duration) error {
-	key := fmt.Sprintf("cool:%s", ip)
-	return c.client.Set(c.ctx, key, time.Now().Unix(), duration).Err()
+	return c.SetCooldownContext(c.ctx, ip, duration)
+}
+
+// SetCooldownContext is SetCooldown bounded by ctx. A duration of zero or
+// less ends the cooldown.
+func (c *Client) SetCooldownContext(ctx context.Context, ip string, duration time.Duration) error {
+	if duration <= 0 {
+		return ctxErr(ctx, c.client.Del(ctx, cooldownKey(ip)).Err())
+	}
+	return ctxErr(ctx, c.client.Set(ctx, cooldownKey(ip), time.Now().Unix(), duration).Err())
 }
 
 // CheckCooldown checks if an IP address is in cooldown
 func (c *Client) CheckCooldown(ip string) (bool, error) {
-	key := fmt.Sprintf("cool:%s", ip)
-	exists, err := c.client.Exists(c.ctx, key).Result()
+	exists, err := c.client.Exists(c.ctx, cooldownKey(ip)).Result()
 	return exists > 0, err
 }
 
+// CooldownRemainingContext returns how long an IP address has left to cool
+// down, zero if it isn't
+func (c *Client) CooldownRemainingContext(ctx context.Context, ip string) (time.Duration, error) {
+	ttl, err := c.client.PTTL(ctx, cooldownKey(ip)).Result()
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return max(ttl, 0), nil
+}
+
 // FlushDB flushes the database (for testing only)
 func (c *Client) FlushDB() error {
 	return c.client.FlushDB(c.ctx).Err()
@@ -1,50 +1,24 @@
 package redis
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+
+	"splat-boston/internal/bits"
 )
 
 // Test Redis operations and Lua scripts for the paint system
 
-const paintScript = `
--- KEYS[1]=k_bits, KEYS[2]=k_seq
--- ARGV[1]=o, ARGV[2]=color, ARGV[3]=nowTs
-
-local o = tonumber(ARGV[1])
-local color = tonumber(ARGV[2])
-local now = tonumber(ARGV[3])
-
-local byteIdx = math.floor((o * 4) / 8)
-local nibbleIsHigh = (o % 2) == 0
-
-local cur = redis.call('GETRANGE', KEYS[1], byteIdx, byteIdx)
-if cur == false or #cur == 0 then
-  -- initialize 32 KiB if absent
-  redis.call('SETRANGE', KEYS[1], 32767, string.char(0))
-  cur = string.char(0)
-end
-
-local b = string.byte(cur)
-local prev
-if nibbleIsHigh then
-  prev = bit.rshift(bit.band(b, 0xF0), 4)
-  b = bit.bor(bit.band(b, 0x0F), bit.lshift(color, 4))
-else
-  prev = bit.band(b, 0x0F)
-  b = bit.bor(bit.band(b, 0xF0), color)
-end
-
-redis.call('SETRANGE', KEYS[1], byteIdx, string.char(b))
-local seq = redis.call('INCR', KEYS[2])
-
-return { seq, now, prev }
-`
-
 type RedisClient struct {
 	client *redis.Client
 	ctx    context.Context
@@ -161,6 +135,11 @@ func TestRedisPaintScript(t *testing.T) {
 		t.Errorf("Expected sequence %d, got %d", seq+1, seq2)
 	}
 
+	// Verify timestamp did not go backwards
+	if ts2 < ts {
+		t.Errorf("Timestamp went backwards: %d after %d", ts2, ts)
+	}
+
 	// Verify previous color for new tile
 	if prev2 != 0 {
 		t.Errorf("Expected previous color 0 for new tile, got %d", prev2)
@@ -455,12 +434,12 @@ func TestRedisConcurrentPaints(t *testing.T) {
 	}
 }
 
-func BenchmarkRedisPaint(t *testing.B) {
+func BenchmarkRedisPaint(b *testing.B) {
 	client := NewRedisClient()
 	defer client.Close()
 
 	if err := client.client.Ping(client.ctx).Err(); err != nil {
-		t.Skip("Redis not available, skipping benchmark")
+		b.Skip("Redis not available, skipping benchmark")
 	}
 
 	client.FlushDB()
@@ -474,3 +453,943 @@ func BenchmarkRedisPaint(t *testing.B) {
 		client.PaintTile(cx, cy, offset, color)
 	}
 }
+
+// newMiniClient returns a Client backed by an in-process miniredis
+func newMiniClient(t *testing.T) *Client {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client, err := NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestPaintScriptRejectsColorOutsideRegionPalette(t *testing.T) {
+	client := newMiniClient(t)
+
+	if err := client.SetRegionPalette(2, 3, []uint8{1, 4}); err != nil {
+		t.Fatalf("SetRegionPalette failed: %v", err)
+	}
+
+	// Disallowed color is rejected without touching the chunk
+	if _, _, _, err := client.PaintTile(2, 3, 10, 5); err != ErrColorNotAllowed {
+		t.Fatalf("Expected ErrColorNotAllowed, got %v", err)
+	}
+	if seq, err := client.GetChunkSeq(2, 3); err != redis.Nil {
+		t.Errorf("Expected no seq after rejected paint, got %d (err %v)", seq, err)
+	}
+
+	// Allowed color goes through
+	seq, _, _, err := client.PaintTile(2, 3, 10, 4)
+	if err != nil {
+		t.Fatalf("PaintTile with allowed color failed: %v", err)
+	}
+	if seq != 1 {
+		t.Errorf("Expected seq 1, got %d", seq)
+	}
+
+	// Other chunks are unaffected
+	if _, _, _, err := client.PaintTile(0, 0, 10, 5); err != nil {
+		t.Errorf("Unrestricted chunk rejected paint: %v", err)
+	}
+
+	// Clearing the palette lifts the restriction
+	if err := client.ClearRegionPalette(2, 3); err != nil {
+		t.Fatalf("ClearRegionPalette failed: %v", err)
+	}
+	if _, _, _, err := client.PaintTile(2, 3, 10, 5); err != nil {
+		t.Errorf("Paint after clearing palette failed: %v", err)
+	}
+}
+
+func TestPaintUsesRedisTimeAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	// Two server instances sharing one Redis
+	instances := make([]*Client, 2)
+	for i := range instances {
+		client, err := NewClient("redis://" + mr.Addr())
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		client.UseRedisTime(true)
+		instances[i] = client
+	}
+
+	// A Redis clock far from the local one proves where ts comes from
+	redisNow := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	var lastTs int64
+	for i := 0; i < 10; i++ {
+		mr.SetTime(redisNow.Add(time.Duration(i) * 500 * time.Millisecond))
+
+		_, ts, _, err := instances[i%2].PaintTile(0, 0, i, 1)
+		if err != nil {
+			t.Fatalf("PaintTile %d failed: %v", i, err)
+		}
+		if want := redisNow.Unix() + int64(i)/2; ts != want {
+			t.Errorf("Paint %d: expected Redis timestamp %d, got %d", i, want, ts)
+		}
+		if ts < lastTs {
+			t.Errorf("Paint %d: timestamp went backwards (%d after %d)", i, ts, lastTs)
+		}
+		lastTs = ts
+	}
+
+	skew, err := instances[0].ClockSkew()
+	if err != nil {
+		t.Fatalf("ClockSkew failed: %v", err)
+	}
+	if skew > -time.Hour {
+		t.Errorf("Expected a large negative skew against a 2001 Redis clock, got %v", skew)
+	}
+}
+
+func TestStreakIncrementsAcrossDays(t *testing.T) {
+	client := newMiniClient(t)
+
+	steps := []struct {
+		day  int64
+		want int
+	}{
+		{100, 1},
+		{100, 1}, // second paint the same day doesn't count twice
+		{101, 2},
+		{102, 3},
+	}
+	for _, step := range steps {
+		got, err := client.TouchStreak("10.0.0.1", step.day)
+		if err != nil {
+			t.Fatalf("TouchStreak failed: %v", err)
+		}
+		if got != step.want {
+			t.Errorf("Day %d: expected streak %d, got %d", step.day, step.want, got)
+		}
+	}
+
+	if got, _ := client.GetStreak("10.0.0.1", 103); got != 3 {
+		t.Errorf("Streak should still stand the next day, got %d", got)
+	}
+}
+
+func TestStreakResetsAfterGap(t *testing.T) {
+	client := newMiniClient(t)
+
+	client.TouchStreak("10.0.0.1", 100)
+	client.TouchStreak("10.0.0.1", 101)
+
+	// Day 102 was missed
+	if got, _ := client.GetStreak("10.0.0.1", 103); got != 0 {
+		t.Errorf("Expected lapsed streak to read 0, got %d", got)
+	}
+	if got, err := client.TouchStreak("10.0.0.1", 103); err != nil || got != 1 {
+		t.Errorf("Expected streak to restart at 1, got %d (err %v)", got, err)
+	}
+}
+
+func TestGetChunkSnapshotReadsBitsWithSeq(t *testing.T) {
+	client := newMiniClient(t)
+
+	// Unpainted chunks are blank at seq 0
+	buf, seq, err := client.GetChunkSnapshot(7, 8)
+	if err != nil || seq != 0 || len(buf) != 32768 || buf[0] != 0 {
+		t.Fatalf("Expected a blank snapshot at seq 0, got %d bytes at seq %d (err %v)", len(buf), seq, err)
+	}
+
+	client.PaintTile(7, 8, 0, 5)
+	client.PaintTile(7, 8, 3, 9)
+
+	buf, seq, err = client.GetChunkSnapshot(7, 8)
+	if err != nil {
+		t.Fatalf("GetChunkSnapshot failed: %v", err)
+	}
+	if seq != 2 || len(buf) != 32768 || buf[0] != 0x50 || buf[1] != 0x09 {
+		t.Errorf("Expected seq 2 with both paints, got seq %d, bytes % x", seq, buf[:2])
+	}
+}
+
+func TestExpireChunksSetsAndRefreshesTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+	client.KeepHistory(8)
+
+	// Off by default
+	client.PaintTile(1, 2, 0, 3)
+	if ttl := mr.TTL("chunk:1:2:bits"); ttl != 0 {
+		t.Errorf("Expected no TTL by default, got %v", ttl)
+	}
+
+	client.ExpireChunks(30 * 24 * time.Hour)
+	client.PaintTile(1, 2, 1, 4)
+	for _, key := range []string{"chunk:1:2:bits", "chunk:1:2:seq", "chunk:1:2:log"} {
+		if ttl := mr.TTL(key); ttl != 30*24*time.Hour {
+			t.Errorf("%s: expected a 30 day TTL, got %v", key, ttl)
+		}
+	}
+
+	// A repaint pushes the deadline back
+	mr.FastForward(20 * 24 * time.Hour)
+	client.PaintTile(1, 2, 2, 5)
+	if ttl := mr.TTL("chunk:1:2:bits"); ttl != 30*24*time.Hour {
+		t.Errorf("Expected the TTL refreshed on repaint, got %v", ttl)
+	}
+
+	// Left alone, the bits and seq go together
+	mr.FastForward(31 * 24 * time.Hour)
+	buf, seq, err := client.GetChunkSnapshot(1, 2)
+	if err != nil || seq != 0 || buf[0] != 0 || buf[1] != 0 {
+		t.Errorf("Expected the expired chunk blank at seq 0, got seq %d, bytes % x (err %v)", seq, buf[:2], err)
+	}
+}
+
+func TestGetChunkBitsRange(t *testing.T) {
+	client := newMiniClient(t)
+	client.PaintTile(7, 8, 3, 9)     // byte 1
+	client.PaintTile(7, 8, 200, 4)   // byte 100
+	client.PaintTile(7, 8, 1000, 12) // byte 500, past the range below
+
+	buf, err := client.GetChunkBitsRange(7, 8, 1, 100)
+	if err != nil {
+		t.Fatalf("GetChunkBitsRange failed: %v", err)
+	}
+	if len(buf) != 100 || buf[0] != 0x09 || buf[99] != 0x40 {
+		t.Errorf("Expected bytes 1-100 with both paints, got %d bytes, first %#x last %#x", len(buf), buf[0], buf[len(buf)-1])
+	}
+
+	// Past the painted end of the string, and in an unpainted chunk, reads
+	// as blank
+	if buf, err := client.GetChunkBitsRange(7, 8, 32000, 32767); err != nil || len(buf) != 768 {
+		t.Errorf("Expected 768 blank bytes, got %d (err %v)", len(buf), err)
+	}
+	snap, err := client.GetChunkSnapshotRangeContext(context.Background(), 7, 8, 500, 500)
+	if err != nil || snap.Seq != 3 || len(snap.Bits) != 1 || snap.Bits[0] != 0xc0 {
+		t.Errorf("Expected byte 500 at the chunk's seq 3, got %+v (err %v)", snap, err)
+	}
+
+	for _, r := range [][2]int{{-1, 10}, {0, 32768}, {10, 9}} {
+		if _, err := client.GetChunkBitsRange(7, 8, r[0], r[1]); err == nil {
+			t.Errorf("Expected an error for bytes %d-%d", r[0], r[1])
+		}
+	}
+}
+
+func TestDeltasSinceWithinAndOutsideHistory(t *testing.T) {
+	client := newMiniClient(t)
+	client.KeepHistory(3)
+
+	for o := 0; o < 5; o++ {
+		if _, _, _, err := client.PaintTile(1, 1, o, uint8(o+1)); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+
+	// Seqs 3-5 are retained
+	entries, ok, err := client.DeltasSince(1, 1, 2)
+	if err != nil || !ok {
+		t.Fatalf("Expected seqs after 2 to be retained, got ok=%v err=%v", ok, err)
+	}
+	if len(entries) != 3 || entries[0] != (HistoryEntry{Seq: 3, O: 2, Color: 3, Ts: entries[0].Ts}) || entries[2].Seq != 5 {
+		t.Errorf("Expected seqs 3-5 in order, got %+v", entries)
+	}
+
+	// Seq 2 aged out
+	if _, ok, _ := client.DeltasSince(1, 1, 1); ok {
+		t.Error("Expected seqs after 1 to be outside the window")
+	}
+
+	// Up to date, and ahead of the chunk
+	if entries, ok, _ := client.DeltasSince(1, 1, 5); !ok || len(entries) != 0 {
+		t.Errorf("Expected nothing to replay at the current seq, got ok=%v %+v", ok, entries)
+	}
+	if _, ok, _ := client.DeltasSince(1, 1, 9); ok {
+		t.Error("Expected a seq beyond the chunk's to need a refetch")
+	}
+}
+
+func TestDeltasSinceWithoutHistory(t *testing.T) {
+	client := newMiniClient(t)
+
+	client.PaintTile(1, 1, 0, 1)
+	if _, ok, _ := client.DeltasSince(1, 1, 0); ok {
+		t.Error("Expected no replay when history is off")
+	}
+}
+
+func TestClaimPaintDedupesWithinWindow(t *testing.T) {
+	client := newMiniClient(t)
+
+	if claim, err := client.ClaimPaint("10.0.0.1:0:0:7:3", time.Second); err != nil || !claim.Claimed {
+		t.Fatalf("Expected the first claim to win, got %+v (%v)", claim, err)
+	}
+
+	// In flight: no result yet
+	if claim, _ := client.ClaimPaint("10.0.0.1:0:0:7:3", time.Second); claim.Claimed || claim.Seq != 0 {
+		t.Errorf("Expected an in-flight duplicate, got %+v", claim)
+	}
+
+	client.RecordPaint("10.0.0.1:0:0:7:3", 42, 1700000000, time.Second)
+	if claim, _ := client.ClaimPaint("10.0.0.1:0:0:7:3", time.Second); claim != (PaintClaim{Seq: 42, Ts: 1700000000}) {
+		t.Errorf("Expected the recorded result, got %+v", claim)
+	}
+
+	// A rejected paint releases its claim
+	client.ReleasePaint("10.0.0.1:0:0:7:3")
+	if claim, _ := client.ClaimPaint("10.0.0.1:0:0:7:3", time.Second); !claim.Claimed {
+		t.Error("Expected a released fingerprint to be claimable again")
+	}
+}
+
+func TestPaintTileIfEmptyClaimsOnce(t *testing.T) {
+	client := newMiniClient(t)
+
+	seq, _, prev, err := client.PaintTileIfEmpty(1, 1, 7, 3)
+	if err != nil || seq != 1 || prev != 0 {
+		t.Fatalf("Expected to claim the empty tile at seq 1, got seq %d prev %d (%v)", seq, prev, err)
+	}
+
+	// The second painter loses and learns the claimed color
+	seq, _, prev, err = client.PaintTileIfEmpty(1, 1, 7, 5)
+	if err != ErrTileOccupied || seq != 0 || prev != 3 {
+		t.Fatalf("Expected ErrTileOccupied with prev 3, got seq %d prev %d (%v)", seq, prev, err)
+	}
+	if current, _ := client.GetChunkSeq(1, 1); current != 1 {
+		t.Errorf("Expected the lost claim not to bump the seq, got %d", current)
+	}
+	colors, err := client.GetTileColors([]TileRef{{Cx: 1, Cy: 1, O: 7}})
+	if err != nil || colors[0] != 3 {
+		t.Errorf("Expected the tile to keep color 3, got %v (%v)", colors, err)
+	}
+
+	// Its neighbour in the same byte is still claimable
+	if _, _, _, err := client.PaintTileIfEmpty(1, 1, 6, 5); err != nil {
+		t.Errorf("Expected the neighbouring tile to be claimable, got %v", err)
+	}
+}
+
+// checkPaintTileWithCooldown covers a cooldown being hit and then expiring;
+// wait lets the cooldown elapse
+func checkPaintTileWithCooldown(t *testing.T, client *Client, wait func(time.Duration)) {
+	t.Helper()
+	const ip = "198.51.100.4"
+
+	seq, _, _, cooled, err := client.PaintTileWithCooldown(0, 0, 1, 3, ip, 300)
+	if err != nil || cooled || seq != 1 {
+		t.Fatalf("Expected the first paint to land at seq 1, got seq %d cooled %v (%v)", seq, cooled, err)
+	}
+	if cooling, _ := client.CheckCooldown(ip); !cooling {
+		t.Error("Expected the paint to start a cooldown")
+	}
+
+	// Cooldown hit: refused, nothing written
+	seq, _, _, cooled, err = client.PaintTileWithCooldown(0, 0, 2, 4, ip, 300)
+	if err != nil || !cooled || seq != 0 {
+		t.Fatalf("Expected the second paint to be refused, got seq %d cooled %v (%v)", seq, cooled, err)
+	}
+	if current, _ := client.GetChunkSeq(0, 0); current != 1 {
+		t.Errorf("Expected the refused paint not to bump the seq, got %d", current)
+	}
+
+	// Other painters aren't affected
+	if _, _, _, cooled, _ := client.PaintTileWithCooldown(0, 0, 2, 4, "198.51.100.5", 300); cooled {
+		t.Error("Expected a different IP to paint")
+	}
+
+	// Cooldown expired: paints again, seeing the other painter's color
+	wait(400 * time.Millisecond)
+	seq, _, prev, cooled, err := client.PaintTileWithCooldown(0, 0, 2, 6, ip, 300)
+	if err != nil || cooled || seq != 3 || prev != 4 {
+		t.Errorf("Expected a paint at seq 3 over color 4, got seq %d prev %d cooled %v (%v)", seq, prev, cooled, err)
+	}
+}
+
+func TestPaintTileWithCooldown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := NewClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	checkPaintTileWithCooldown(t, client, mr.FastForward)
+}
+
+func TestPaintTileIfEmptyWithCooldownContext(t *testing.T) {
+	client := newMiniClient(t)
+	ctx := context.Background()
+	const ip = "198.51.100.6"
+
+	if _, _, _, err := client.PaintTile(0, 0, 1, 3); err != nil {
+		t.Fatalf("PaintTile failed: %v", err)
+	}
+
+	// Losing the claim starts no cooldown
+	if _, _, prev, cooled, err := client.PaintTileIfEmptyWithCooldownContext(ctx, 0, 0, 1, 5, ip, 300); err != ErrTileOccupied || cooled || prev != 3 {
+		t.Fatalf("Expected ErrTileOccupied over color 3, got prev %d cooled %v (%v)", prev, cooled, err)
+	}
+	if cooling, _ := client.CheckCooldown(ip); cooling {
+		t.Error("Expected a lost claim not to start a cooldown")
+	}
+
+	seq, _, _, cooled, err := client.PaintTileIfEmptyWithCooldownContext(ctx, 0, 0, 2, 5, ip, 300)
+	if err != nil || cooled || seq != 2 {
+		t.Fatalf("Expected the claim to land at seq 2, got seq %d cooled %v (%v)", seq, cooled, err)
+	}
+	if remaining, err := client.CooldownRemainingContext(ctx, ip); err != nil || remaining <= 0 || remaining > 300*time.Millisecond {
+		t.Errorf("Expected up to 300ms of cooldown left, got %v (%v)", remaining, err)
+	}
+	if _, _, _, cooled, err := client.PaintTileIfEmptyWithCooldownContext(ctx, 0, 0, 3, 5, ip, 300); err != nil || !cooled {
+		t.Errorf("Expected the next claim refused while cooling down, got cooled %v (%v)", cooled, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, _, _, err := client.PaintTileWithCooldownContext(canceled, 0, 0, 4, 5, "198.51.100.7", 300); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// Setting a cooldown of zero ends it
+	if err := client.SetCooldownContext(ctx, ip, 0); err != nil {
+		t.Fatalf("SetCooldownContext failed: %v", err)
+	}
+	if remaining, _ := client.CooldownRemainingContext(ctx, ip); remaining != 0 {
+		t.Errorf("Expected the cooldown ended, %v left", remaining)
+	}
+}
+
+func TestRedisPaintTileWithCooldown(t *testing.T) {
+	// Skip if Redis is not available
+	client, err := NewClient("redis://localhost:6379/1")
+	if err != nil {
+		t.Skip("Redis not available, skipping test")
+	}
+	defer client.Close()
+	client.FlushDB()
+
+	checkPaintTileWithCooldown(t, client, time.Sleep)
+}
+
+func TestDeltaSubscriptionReceivesSubscribedChunks(t *testing.T) {
+	client := newMiniClient(t)
+
+	received := make(chan string, 4)
+	sub := client.SubscribeDeltas(func(cx, cy int64, payload []byte) {
+		received <- fmt.Sprintf("%d,%d:%s", cx, cy, payload)
+	}, nil)
+	defer sub.Close()
+
+	// Subscribing and unsubscribing don't wait for Redis to confirm
+	waitSubscribers := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			n, err := client.client.PubSubNumSub(client.ctx, deltaChannel(1, -2)).Result()
+			if err != nil {
+				t.Fatalf("PUBSUB NUMSUB failed: %v", err)
+			}
+			if n[deltaChannel(1, -2)] == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("channel has %d subscribers, want %d", n[deltaChannel(1, -2)], want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := sub.Subscribe(1, -2); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	waitSubscribers(1)
+
+	// Unsubscribed chunks aren't delivered
+	if err := client.PublishDelta(5, 5, []byte("other")); err != nil {
+		t.Fatalf("PublishDelta failed: %v", err)
+	}
+	if err := client.PublishDelta(1, -2, []byte("mine")); err != nil {
+		t.Fatalf("PublishDelta failed: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got != "1,-2:mine" {
+			t.Errorf("received %q, want %q", got, "1,-2:mine")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscribed delta was not received")
+	}
+
+	if err := sub.Unsubscribe(1, -2); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	waitSubscribers(0)
+	if err := client.PublishDelta(1, -2, []byte("late")); err != nil {
+		t.Fatalf("PublishDelta failed: %v", err)
+	}
+	select {
+	case got := <-received:
+		t.Errorf("received %q after unsubscribing", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSnapshotRoundTripsAfterFlush(t *testing.T) {
+	client := newMiniClient(t)
+
+	client.PaintTile(7, 8, 0, 5)
+	client.PaintTile(7, 8, 3, 9)
+	client.PaintTile(-1, 2, 65535, 4)
+	client.SetChunkBackground(-1, 2, 6)
+	client.SetChunkPaletteID(7, 8, "neon")
+	want := map[ChunkRef]ChunkSnapshot{}
+	for _, chunk := range []ChunkRef{{7, 8}, {-1, 2}} {
+		snaps, _ := client.GetChunkSnapshots([]ChunkRef{chunk})
+		want[chunk] = snaps[0]
+	}
+
+	var file bytes.Buffer
+	n, err := client.SnapshotAllChunks(&file)
+	if err != nil || n != 2 {
+		t.Fatalf("SnapshotAllChunks wrote %d chunks (err %v), want 2", n, err)
+	}
+
+	if err := client.client.FlushAll(client.ctx).Err(); err != nil {
+		t.Fatalf("FLUSHALL failed: %v", err)
+	}
+	n, epoch, err := client.RestoreSnapshot(bytes.NewReader(file.Bytes()))
+	if err != nil || n != 2 {
+		t.Fatalf("RestoreSnapshot restored %d chunks (err %v), want 2", n, err)
+	}
+	if current, _ := client.CanvasEpoch(); epoch != 1 || current != 1 {
+		t.Errorf("Expected the restore to bump the epoch to 1, got %d (stored %d)", epoch, current)
+	}
+
+	for chunk, before := range want {
+		snaps, err := client.GetChunkSnapshots([]ChunkRef{chunk})
+		if err != nil {
+			t.Fatalf("GetChunkSnapshots failed: %v", err)
+		}
+		got := snaps[0]
+		if got.Seq != before.Seq || got.Background != before.Background || got.PaletteID != before.PaletteID || !bytes.Equal(got.Bits, before.Bits) {
+			t.Errorf("chunk %v: restored seq %d background %d palette %q, want seq %d background %d palette %q (bits equal: %v)",
+				chunk, got.Seq, got.Background, got.PaletteID, before.Seq, before.Background, before.PaletteID, bytes.Equal(got.Bits, before.Bits))
+		}
+	}
+
+	// Paints carry on from the restored seq
+	if seq, _, _, err := client.PaintTile(7, 8, 1, 2); err != nil || seq != 3 {
+		t.Errorf("Expected the next paint to get seq 3, got %d (err %v)", seq, err)
+	}
+}
+
+func TestRestoreSnapshotVersions(t *testing.T) {
+	client := newMiniClient(t)
+
+	// A record as a later version might write it, with a field after the
+	// palette id
+	record := binary.BigEndian.AppendUint64(nil, 3)
+	record = binary.BigEndian.AppendUint64(record, 4)
+	record = binary.BigEndian.AppendUint64(record, 12)
+	record = append(record, 0)
+	record = binary.BigEndian.AppendUint32(record, chunkBytes)
+	record = append(record, make([]byte, chunkBytes)...)
+	record[snapshotRecordHeader] = 0x70
+	bitsEnd := len(record)
+	record = binary.BigEndian.AppendUint16(record, 4)
+	record = append(record, "neon"...)
+	record = append(record, "extra"...)
+	snapshotOf := func(record []byte) []byte {
+		file := append([]byte(snapshotMagic), 0, snapshotVersion)
+		file = binary.BigEndian.AppendUint32(file, uint32(len(record)))
+		file = append(file, record...)
+		return append(file, 0, 0, 0, 0)
+	}
+	file := snapshotOf(record)
+
+	if n, _, err := client.RestoreSnapshot(bytes.NewReader(file)); err != nil || n != 1 {
+		t.Fatalf("RestoreSnapshot restored %d chunks (err %v), want 1", n, err)
+	}
+	buf, seq, _ := client.GetChunkSnapshot(3, 4)
+	if seq != 12 || buf[0] != 0x70 {
+		t.Errorf("Expected seq 12 and the painted byte, got seq %d byte %#x", seq, buf[0])
+	}
+	if id, _ := client.GetChunkPaletteID(3, 4); id != "neon" {
+		t.Errorf("Expected palette neon, got %q", id)
+	}
+
+	// A record ending at the bits, from before palette ids, has the default
+	// palette
+	if n, _, err := client.RestoreSnapshot(bytes.NewReader(snapshotOf(record[:bitsEnd]))); err != nil || n != 1 {
+		t.Fatalf("RestoreSnapshot restored %d chunks (err %v), want 1", n, err)
+	}
+	if id, _ := client.GetChunkPaletteID(3, 4); id != "" {
+		t.Errorf("Expected the default palette, got %q", id)
+	}
+
+	// A palette id running past its record is refused
+	if _, _, err := client.RestoreSnapshot(bytes.NewReader(snapshotOf(record[:bitsEnd+4]))); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("Expected ErrSnapshotFormat for a cut-off palette id, got %v", err)
+	}
+
+	// A file cut short is refused rather than partly restored
+	if _, _, err := client.RestoreSnapshot(bytes.NewReader(file[:len(file)-4])); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("Expected ErrSnapshotFormat for a snapshot missing its end marker, got %v", err)
+	}
+
+	// An incompatible version, or not a snapshot at all, is refused
+	newer := append([]byte(snapshotMagic), 0, snapshotVersion+1)
+	if _, _, err := client.RestoreSnapshot(bytes.NewReader(newer)); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("Expected ErrSnapshotFormat for a newer version, got %v", err)
+	}
+	if _, _, err := client.RestoreSnapshot(bytes.NewReader([]byte("PNG\x00\x01"))); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("Expected ErrSnapshotFormat for another format, got %v", err)
+	}
+}
+
+func TestRestoreSnapshotWritesNothingFromACorruptFile(t *testing.T) {
+	client := newMiniClient(t)
+
+	// More chunks than one write batch, so a bad record near the end comes
+	// after a batch would have gone out
+	for cx := int64(0); cx < snapshotBatch+6; cx++ {
+		client.PaintTile(cx, 0, 0, 5)
+	}
+	var file bytes.Buffer
+	if _, err := client.SnapshotAllChunks(&file); err != nil {
+		t.Fatalf("SnapshotAllChunks failed: %v", err)
+	}
+	for cx := int64(0); cx < snapshotBatch+6; cx++ {
+		client.PaintTile(cx, 0, 0, 9)
+	}
+
+	// Clobber the last record's length
+	corrupt := bytes.Clone(file.Bytes())
+	last := len(corrupt) - 4 - (4 + snapshotRecordHeader + chunkBytes + 2)
+	binary.BigEndian.PutUint32(corrupt[last:], 7)
+	if n, _, err := client.RestoreSnapshot(bytes.NewReader(corrupt)); !errors.Is(err, ErrSnapshotFormat) || n != 0 {
+		t.Fatalf("Expected ErrSnapshotFormat with nothing restored, got %d chunks (err %v)", n, err)
+	}
+	for cx := int64(0); cx < snapshotBatch+6; cx++ {
+		if buf, seq, _ := client.GetChunkSnapshot(cx, 0); seq != 2 || buf[0] != 0x90 {
+			t.Fatalf("chunk %d,0 changed by a rejected restore: seq %d byte %#x", cx, seq, buf[0])
+		}
+	}
+	if epoch, _ := client.CanvasEpoch(); epoch != 0 {
+		t.Errorf("Expected the epoch left at 0, got %d", epoch)
+	}
+}
+
+// flakyScripts fails the first fail script runs, before sending them or,
+// with afterApply, once Redis has applied them, as when a reply is lost.
+// runs counts each run's EVALSHA.
+type flakyScripts struct {
+	fail       int
+	afterApply bool
+	err        error
+	runs       int
+}
+
+func (f *flakyScripts) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() != "evalsha" && cmd.Name() != "eval" {
+		return ctx, nil
+	}
+	if cmd.Name() == "evalsha" {
+		f.runs++
+	}
+	if !f.afterApply && f.fail > 0 {
+		f.fail--
+		return ctx, f.err
+	}
+	return ctx, nil
+}
+
+func (f *flakyScripts) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if f.afterApply && f.fail > 0 && cmd.Err() == nil && (cmd.Name() == "evalsha" || cmd.Name() == "eval") {
+		f.fail--
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyScripts) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (f *flakyScripts) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestPaintTileRetriesTransientErrors(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, tc := range []struct {
+		name  string
+		flaky *flakyScripts
+	}{
+		{"not sent", &flakyScripts{fail: 1, err: refused}},
+		{"reply lost", &flakyScripts{fail: 1, afterApply: true, err: io.EOF}},
+		{"loading", &flakyScripts{fail: 2, err: errors.New("LOADING Redis is loading the dataset in memory")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newMiniClient(t)
+			client.SetWriteRetry(3, time.Millisecond)
+			client.writes.AddHook(tc.flaky)
+
+			seq, _, prev, err := client.PaintTile(2, 3, 5, 9)
+			if err != nil || seq != 1 || prev != 0 {
+				t.Fatalf("Expected the retried paint to return seq 1, got seq %d prev %d: %v", seq, prev, err)
+			}
+
+			// A retry of an applied paint must not paint again
+			if current, _ := client.GetChunkSeq(2, 3); current != 1 {
+				t.Errorf("Expected the chunk at seq 1, got %d", current)
+			}
+			buf, _ := client.GetChunkBits(2, 3)
+			if color := bits.GetNibble(buf, 5); color != 9 {
+				t.Errorf("Expected tile 5 painted 9, got %d", color)
+			}
+		})
+	}
+}
+
+func TestPaintTileReplaysAWriteIDFromTheContext(t *testing.T) {
+	client := newMiniClient(t)
+	ctx := WithWriteID(context.Background(), NewWriteID())
+
+	// Even without retries, a second call under the same id is a replay
+	first, _, _, err := client.PaintTileContext(ctx, 2, 3, 5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, prev, err := client.PaintTileContext(ctx, 2, 3, 5, 9)
+	if err != nil || again != first || prev != 0 {
+		t.Fatalf("Expected the replay to return seq %d prev 0, got seq %d prev %d: %v", first, again, prev, err)
+	}
+	if current, _ := client.GetChunkSeq(2, 3); current != first {
+		t.Errorf("Expected the chunk still at seq %d, got %d", first, current)
+	}
+
+	// Another id is another write
+	if seq, _, _, _ := client.PaintTileContext(context.Background(), 2, 3, 5, 9); seq != first+1 {
+		t.Errorf("Expected a fresh paint at seq %d, got %d", first+1, seq)
+	}
+}
+
+func TestPaintTileFailsFastOnUserErrors(t *testing.T) {
+	client := newMiniClient(t)
+	client.SetWriteRetry(3, time.Millisecond)
+	flaky := &flakyScripts{}
+	client.writes.AddHook(flaky)
+
+	client.SetRegionPalette(2, 3, []uint8{1, 2})
+	if _, _, _, err := client.PaintTile(2, 3, 5, 9); err != ErrColorNotAllowed {
+		t.Fatalf("Expected ErrColorNotAllowed, got %v", err)
+	}
+	if flaky.runs != 1 {
+		t.Errorf("Expected a refused paint to run once, ran %d times", flaky.runs)
+	}
+
+	// Retries are bounded
+	flaky.runs = 0
+	flaky.fail, flaky.err = 10, io.EOF
+	if _, _, _, err := client.PaintTile(2, 3, 5, 1); err == nil {
+		t.Fatal("Expected the paint to fail once retries ran out")
+	}
+	if flaky.runs != 4 {
+		t.Errorf("Expected 4 attempts, got %d", flaky.runs)
+	}
+}
+
+func TestContextEndsCallsToHungRedis(t *testing.T) {
+	// Accepts connections and never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		for conn := range accepted {
+			conn.Close()
+		}
+	})
+
+	// Only the context should end these calls
+	client := newClient(&redis.Options{Addr: ln.Addr().String(), ReadTimeout: time.Minute})
+	t.Cleanup(func() { client.Close() })
+	client.SetWriteRetry(3, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, _, err := client.PaintTileContext(ctx, 0, 0, 5, 9); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a paint past its deadline to fail with DeadlineExceeded, got %v", err)
+	}
+	if _, err := client.GetChunkSnapshotsContext(ctx, []ChunkRef{{Cx: 0, Cy: 0}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a read past its deadline to fail with DeadlineExceeded, got %v", err)
+	}
+	for name, call := range map[string]func() error{
+		"ClaimPaint": func() error {
+			_, err := client.ClaimPaintContext(ctx, "fp", time.Second)
+			return err
+		},
+		"RecordPaint":  func() error { return client.RecordPaintContext(ctx, "fp", 1, 1, time.Second) },
+		"ReleasePaint": func() error { return client.ReleasePaintContext(ctx, "fp") },
+		"AllowUndo":    func() error { return client.AllowUndoContext(ctx, "ip", 0, 0, 5, 1, time.Second) },
+		"TouchStreak": func() error {
+			_, err := client.TouchStreakContext(ctx, "ip", 1)
+			return err
+		},
+		"AddStrike": func() error {
+			_, err := client.AddStrikeContext(ctx, "ip", time.Second)
+			return err
+		},
+		"BanRemaining": func() error {
+			_, err := client.BanRemainingContext(ctx, "ip")
+			return err
+		},
+		"DeltasSince": func() error {
+			_, _, err := client.DeltasSinceContext(ctx, 0, 0, 0)
+			return err
+		},
+		"PublishDelta": func() error { return client.PublishDeltaContext(ctx, 0, 0, []byte("{}")) },
+	} {
+		if err := call(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected %s past its deadline to fail with DeadlineExceeded, got %v", name, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the deadline to end the calls, took %v", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := client.PaintTileContext(canceled, 0, 0, 5, 9); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled paint to fail with Canceled, got %v", err)
+	}
+}
+
+func TestPaintTileRejectsMalformedReplies(t *testing.T) {
+	for _, reply := range []string{
+		`return 'OK'`,
+		`return { 1, 2 }`,
+		`return { 1, 2, 3, 4 }`,
+		`return { 1, 'soon', 3 }`,
+		`return { 1, { 2 }, 3 }`,
+	} {
+		client := newMiniClient(t)
+		client.paintScript = redis.NewScript(reply)
+
+		_, _, _, err := client.PaintTile(0, 0, 5, 9)
+		if !errors.Is(err, ErrUnexpectedReply) {
+			t.Errorf("%s: expected ErrUnexpectedReply, got %v", reply, err)
+		}
+	}
+}
+
+func TestScriptIntsAcceptsOtherNumberForms(t *testing.T) {
+	var out [3]int64
+	if err := scriptInts([]interface{}{int64(7), float64(1730075401), "12"}, out[:]); err != nil {
+		t.Fatalf("scriptInts: %v", err)
+	}
+	if out != [3]int64{7, 1730075401, 12} {
+		t.Errorf("Expected [7 1730075401 12], got %v", out)
+	}
+
+	for _, bad := range []interface{}{1.5, "1.5", nil, []byte("1")} {
+		if err := scriptInts([]interface{}{int64(1), bad, int64(3)}, out[:]); !errors.Is(err, ErrUnexpectedReply) {
+			t.Errorf("%#v: expected ErrUnexpectedReply, got %v", bad, err)
+		}
+	}
+}
+
+func TestFillRectPaintsRectangleWithOneSeq(t *testing.T) {
+	client := newMiniClient(t)
+	client.KeepHistory(16)
+
+	// Tiles sharing a byte with the rectangle's odd left and even right edges
+	for _, x := range []int{2, 9} {
+		if _, _, _, err := client.PaintTile(1, 1, 4*256+x, 7); err != nil {
+			t.Fatalf("PaintTile failed: %v", err)
+		}
+	}
+
+	seq, _, err := client.FillRect(1, 1, 3, 4, 8, 6, 5)
+	if err != nil {
+		t.Fatalf("FillRect failed: %v", err)
+	}
+	if seq != 3 {
+		t.Errorf("Expected the fill to take seq 3, got %d", seq)
+	}
+
+	data, err := client.GetChunkBits(1, 1)
+	if err != nil {
+		t.Fatalf("GetChunkBits failed: %v", err)
+	}
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			want := uint8(0)
+			switch {
+			case x >= 3 && x <= 8 && y >= 4 && y <= 6:
+				want = 5
+			case y == 4 && (x == 2 || x == 9):
+				want = 7
+			}
+			if got := bits.GetNibble(data, y*256+x); got != want {
+				t.Fatalf("tile (%d,%d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+
+	// Resuming from before the fill needs the whole chunk; after it, deltas
+	if _, ok, _ := client.DeltasSince(1, 1, 2); ok {
+		t.Error("Expected a resume from before the fill to need a refetch")
+	}
+	client.PaintTile(1, 1, 0, 1)
+	if entries, ok, _ := client.DeltasSince(1, 1, 3); !ok || len(entries) != 1 || entries[0].Seq != 4 {
+		t.Errorf("Expected the paint after the fill replayed, got ok=%v %+v", ok, entries)
+	}
+
+	// A whole chunk, and an unpainted one
+	if seq, _, err := client.FillRect(2, 2, 0, 0, 255, 255, 15); err != nil || seq != 1 {
+		t.Fatalf("Expected a full-chunk fill at seq 1, got %d (%v)", seq, err)
+	}
+	if data, _ := client.GetChunkBits(2, 2); !bytes.Equal(data, bytes.Repeat([]byte{0xFF}, 32768)) {
+		t.Error("Expected every tile of the chunk filled")
+	}
+}
+
+func TestFillRectIsAllOrNothing(t *testing.T) {
+	client := newMiniClient(t)
+
+	if err := client.SetRegionPalette(1, 1, []uint8{1, 4}); err != nil {
+		t.Fatalf("SetRegionPalette failed: %v", err)
+	}
+	if _, _, err := client.FillRect(1, 1, 0, 0, 15, 15, 5); err != ErrColorNotAllowed {
+		t.Fatalf("Expected ErrColorNotAllowed, got %v", err)
+	}
+	if seq, err := client.GetChunkSeq(1, 1); err != redis.Nil {
+		t.Errorf("Expected no seq after a refused fill, got %d (err %v)", seq, err)
+	}
+
+	for _, rect := range [][4]int{{-1, 0, 3, 3}, {0, 0, 256, 3}, {5, 0, 4, 3}} {
+		if _, _, err := client.FillRect(0, 0, rect[0], rect[1], rect[2], rect[3], 1); err == nil {
+			t.Errorf("Expected rectangle %v refused", rect)
+		}
+	}
+}
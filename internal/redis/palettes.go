@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// paletteIDKey returns the Redis key naming the palette a chunk's color
+// indexes are drawn from. Unlike paletteKey's region palette, it doesn't
+// restrict which indexes may be painted.
+func paletteIDKey(cx, cy int64) string {
+	return fmt.Sprintf("palette_id:%d:%d", cx, cy)
+}
+
+// SetChunkPaletteID assigns a chunk a named palette. An empty id returns it
+// to the default palette. Painted tiles keep their indexes, so they are
+// drawn in the new palette's colors.
+func (c *Client) SetChunkPaletteID(cx, cy int64, id string) error {
+	if id == "" {
+		return c.client.Del(c.ctx, paletteIDKey(cx, cy)).Err()
+	}
+	return c.client.Set(c.ctx, paletteIDKey(cx, cy), id, 0).Err()
+}
+
+// GetChunkPaletteID returns a chunk's palette id, or "" for the default
+// palette
+func (c *Client) GetChunkPaletteID(cx, cy int64) (string, error) {
+	return c.GetChunkPaletteIDContext(c.ctx, cx, cy)
+}
+
+// GetChunkPaletteIDContext is GetChunkPaletteID bounded by ctx
+func (c *Client) GetChunkPaletteIDContext(ctx context.Context, cx, cy int64) (string, error) {
+	id, err := paletteIDVal(c.client.Get(ctx, paletteIDKey(cx, cy)))
+	return id, ctxErr(ctx, err)
+}
+
+// paletteIDVal reads a palette id from a GET, treating a missing key as the
+// default palette
+func paletteIDVal(cmd *redis.StringCmd) (string, error) {
+	id, err := cmd.Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
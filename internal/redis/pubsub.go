@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// deltaChannel returns the pub/sub channel a chunk's deltas are published on
+func deltaChannel(cx, cy int64) string {
+	return fmt.Sprintf("deltas:%d:%d", cx, cy)
+}
+
+// noticeChannel is the pub/sub channel notices are published on. Notices
+// are rare, so every instance receives all of them.
+const noticeChannel = "notices"
+
+// PublishDelta publishes an encoded delta to every instance subscribed to
+// the chunk
+func (c *Client) PublishDelta(cx, cy int64, payload []byte) error {
+	return c.PublishDeltaContext(c.ctx, cx, cy, payload)
+}
+
+// PublishDeltaContext is PublishDelta bounded by ctx
+func (c *Client) PublishDeltaContext(ctx context.Context, cx, cy int64, payload []byte) error {
+	return ctxErr(ctx, c.client.Publish(ctx, deltaChannel(cx, cy), payload).Err())
+}
+
+// PublishNotice publishes an encoded notice to every instance
+func (c *Client) PublishNotice(payload []byte) error {
+	return c.PublishNoticeContext(c.ctx, payload)
+}
+
+// PublishNoticeContext is PublishNotice bounded by ctx
+func (c *Client) PublishNoticeContext(ctx context.Context, payload []byte) error {
+	return ctxErr(ctx, c.client.Publish(ctx, noticeChannel, payload).Err())
+}
+
+// DeltaSubscription receives the deltas published for the chunks it is
+// subscribed to, and notices if it was started with a handler for them. It
+// starts with no chunks.
+type DeltaSubscription struct {
+	c      *Client
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// SubscribeDeltas starts a subscription that passes each received delta to
+// handle and, unless handleNotice is nil, each notice to handleNotice, one
+// at a time in the order they arrive, until Close
+func (c *Client) SubscribeDeltas(handle func(cx, cy int64, payload []byte), handleNotice func(payload []byte)) *DeltaSubscription {
+	var channels []string
+	if handleNotice != nil {
+		channels = append(channels, noticeChannel)
+	}
+	s := &DeltaSubscription{
+		c:      c,
+		pubsub: c.client.Subscribe(c.ctx, channels...),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		for msg := range s.pubsub.Channel() {
+			if msg.Channel == noticeChannel {
+				handleNotice([]byte(msg.Payload))
+				continue
+			}
+			var cx, cy int64
+			if _, err := fmt.Sscanf(msg.Channel, "deltas:%d:%d", &cx, &cy); err != nil {
+				continue
+			}
+			handle(cx, cy, []byte(msg.Payload))
+		}
+	}()
+	return s
+}
+
+// Subscribe adds a chunk to the subscription
+func (s *DeltaSubscription) Subscribe(cx, cy int64) error {
+	return s.pubsub.Subscribe(s.c.ctx, deltaChannel(cx, cy))
+}
+
+// Unsubscribe removes a chunk from the subscription
+func (s *DeltaSubscription) Unsubscribe(cx, cy int64) error {
+	return s.pubsub.Unsubscribe(s.c.ctx, deltaChannel(cx, cy))
+}
+
+// Close ends the subscription and waits for the handler to return
+func (s *DeltaSubscription) Close() error {
+	err := s.pubsub.Close()
+	<-s.done
+	return err
+}
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const archiveChunkScript = `
+-- KEYS[1]=k_bits, KEYS[2]=k_seq, KEYS[3]=k_log
+-- KEYS[4]=k_archive_bits, KEYS[5]=k_archive_seq
+
+local bits = redis.call('GET', KEYS[1])
+if not bits then
+  return 0
+end
+
+redis.call('SET', KEYS[4], bits)
+redis.call('SET', KEYS[5], redis.call('GET', KEYS[2]) or '0')
+redis.call('DEL', KEYS[1], KEYS[3])
+
+-- the seq keeps counting so clients holding the old bits see them as stale
+redis.call('INCR', KEYS[2])
+return 1
+`
+
+var archiveChunkScriptObj = redis.NewScript(archiveChunkScript)
+
+const claimResetScript = `
+-- KEYS[1]=k_claim; ARGV[1]=instance, ARGV[2]=ttl_ms
+
+local owner = redis.call('GET', KEYS[1])
+if owner then
+  -- the holder may claim again to retry a reset that failed
+  return owner == ARGV[1] and 1 or 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`
+
+var claimResetScriptObj = redis.NewScript(claimResetScript)
+
+const releaseResetScript = `
+-- KEYS[1]=k_claim; ARGV[1]=instance
+
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+var releaseResetScriptObj = redis.NewScript(releaseResetScript)
+
+// epochKey holds the canvas epoch, bumped by every reset
+const epochKey = "canvas:epoch"
+
+// resetClaimTTL keeps a reset claim long enough that instances whose clocks
+// disagree by a few minutes still see it
+const resetClaimTTL = time.Hour
+
+// resetClaimKey returns the Redis key claimed by whoever performs the reset
+// scheduled at the given moment
+func resetClaimKey(at time.Time) string {
+	return fmt.Sprintf("canvas:reset:%d", at.Unix())
+}
+
+// archiveKey returns the Redis key an archived chunk field is kept under
+func archiveKey(epoch uint64, cx, cy int64, field string) string {
+	return fmt.Sprintf("archive:%d:%d:%d:%s", epoch, cx, cy, field)
+}
+
+// CanvasEpoch returns how many times the canvas has been reset
+func (c *Client) CanvasEpoch() (uint64, error) {
+	epoch, err := c.client.Get(c.ctx, epochKey).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+// ClaimReset elects the instance that performs the reset scheduled at the
+// given moment. Exactly one instance per moment gets true, and gets it
+// again if it claims once more.
+func (c *Client) ClaimReset(at time.Time, instance string) (bool, error) {
+	won, err := claimResetScriptObj.Run(c.ctx, c.client, []string{resetClaimKey(at)}, instance, resetClaimTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return won == 1, nil
+}
+
+// ReleaseReset gives up instance's claim on the reset scheduled at the given
+// moment, so it can be claimed afresh. A claim held by another instance is
+// left alone.
+func (c *Client) ReleaseReset(at time.Time, instance string) error {
+	return releaseResetScriptObj.Run(c.ctx, c.client, []string{resetClaimKey(at)}, instance).Err()
+}
+
+// ResetCanvas archives every painted chunk under the current epoch, clears
+// them and bumps the epoch, returning the new epoch and how many chunks
+// were archived. Region palettes are left in place.
+func (c *Client) ResetCanvas() (uint64, int, error) {
+	epoch, err := c.CanvasEpoch()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	archived := 0
+	iter := c.client.Scan(c.ctx, 0, "chunk:*:bits", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		var cx, cy int64
+		if _, err := fmt.Sscanf(iter.Val(), "chunk:%d:%d:bits", &cx, &cy); err != nil {
+			continue
+		}
+
+		keys := []string{
+			iter.Val(),
+			fmt.Sprintf("chunk:%d:%d:seq", cx, cy),
+			historyKey(cx, cy),
+			archiveKey(epoch, cx, cy, "bits"),
+			archiveKey(epoch, cx, cy, "seq"),
+		}
+		n, err := archiveChunkScriptObj.Run(c.ctx, c.client, keys).Int()
+		if err != nil {
+			return 0, archived, fmt.Errorf("archive chunk %d,%d: %w", cx, cy, err)
+		}
+		archived += n
+	}
+	if err := iter.Err(); err != nil {
+		return 0, archived, err
+	}
+
+	next, err := c.client.Incr(c.ctx, epochKey).Result()
+	if err != nil {
+		return 0, archived, err
+	}
+	return uint64(next), archived, nil
+}
+
+// GetArchivedChunk returns a chunk's bits and seq as they were when the
+// given epoch ended. Chunks that were never painted read as nil.
+func (c *Client) GetArchivedChunk(epoch uint64, cx, cy int64) ([]byte, uint64, error) {
+	var bitsCmd, seqCmd *redis.StringCmd
+	_, err := c.client.Pipelined(c.ctx, func(pipe redis.Pipeliner) error {
+		bitsCmd = pipe.Get(c.ctx, archiveKey(epoch, cx, cy, "bits"))
+		seqCmd = pipe.Get(c.ctx, archiveKey(epoch, cx, cy, "seq"))
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seq, err := strconv.ParseUint(seqCmd.Val(), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(bitsCmd.Val()), seq, nil
+}
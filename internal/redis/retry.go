@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Default write retries: three more attempts after 10ms, 40ms and 160ms
+const (
+	defaultWriteRetries = 3
+	defaultWriteBackoff = 10 * time.Millisecond
+)
+
+// writeIDTTL is how long a script's result is kept for replays of it. It
+// only has to outlast the retries.
+const writeIDTTL = 10 * time.Second
+
+// SetWriteRetry sets how many times the paint, undo and fill scripts are
+// retried after a transient error, waiting backoff before the first retry
+// and four times longer before each one after. Zero retries disables it.
+func (c *Client) SetWriteRetry(retries int, backoff time.Duration) {
+	c.writeRetries = retries
+	c.writeBackoff = backoff
+}
+
+// retryWrite runs write until it succeeds, fails with an error retrying
+// won't fix, or runs out of retries. Writes must be safe to repeat: a
+// timed-out attempt may have been applied.
+func (c *Client) retryWrite(ctx context.Context, write func() error) error {
+	backoff := c.writeBackoff
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if err == nil || attempt >= c.writeRetries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 4
+	}
+}
+
+// retryable reports whether err is a connection problem or a Redis that
+// is briefly unable to serve, rather than a refusal that would recur
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	for _, prefix := range []string{"LOADING ", "CLUSTERDOWN ", "TRYAGAIN ", "MASTERDOWN "} {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnavailable reports whether err means Redis couldn't be reached, or
+// couldn't answer in time, rather than that it refused or garbled the
+// command, which trying again wouldn't fix
+func IsUnavailable(err error) bool {
+	return err != nil && (errors.Is(err, context.DeadlineExceeded) || retryable(err))
+}
+
+// ctxErr returns the context's error in place of err once the context has
+// ended, so callers see context.DeadlineExceeded rather than the i/o
+// timeout the deadline caused
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// writeIDContextKey is the context key WithWriteID stores an id under
+type writeIDContextKey struct{}
+
+// NewWriteID returns a fresh id for WithWriteID
+func NewWriteID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// WithWriteID returns a copy of ctx under which the paint, undo and fill
+// scripts use id as their write id. A caller that retries a write itself
+// runs every attempt under the same id, so an attempt that was applied
+// isn't applied again.
+func WithWriteID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, writeIDContextKey{}, id)
+}
+
+// writeID returns the id for a script run: ctx's, or a fresh one when
+// writes are retried. The script stores its result under the id, so a retry
+// of a run that was applied, but whose reply was lost, returns that result
+// instead of writing again.
+func (c *Client) writeID(ctx context.Context) string {
+	if id, _ := ctx.Value(writeIDContextKey{}).(string); id != "" {
+		return id
+	}
+	if c.writeRetries <= 0 {
+		return ""
+	}
+	return NewWriteID()
+}
+
+// writeIDLifetime returns how long a script run's result is kept:
+// writeIDTTL, past ctx's deadline when it has one, since a caller may retry
+// until then
+func writeIDLifetime(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline) + writeIDTTL
+	}
+	return writeIDTTL
+}
+
+// writeIDKey returns the Redis key holding a script run's result
+func writeIDKey(id string) string {
+	return "write:" + id
+}
@@ -0,0 +1,297 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Snapshot files start with snapshotMagic and a big-endian uint16 version,
+// followed by one record per chunk and then a zero length marking the end,
+// so a truncated file is detected. Each record is a big-endian uint32
+// length and then that many bytes: cx and cy as int64, seq as
+// uint64, the background color, the length of the bits as uint32, and the
+// bits. After the bits comes the palette id as a uint16 length and its
+// bytes; a record that ends at the bits, as earlier ones did, has the
+// default palette.
+//
+// Later versions may append fields to a record; readers take the fields
+// they know and skip the rest, so only a change to the existing fields
+// needs a new version.
+const (
+	snapshotMagic   = "SPLT"
+	snapshotVersion = 1
+
+	// snapshotRecordHeader is the size of a version 1 record before the bits
+	snapshotRecordHeader = 29
+
+	// maxSnapshotRecord bounds a record's length so a corrupt file can't
+	// make the reader allocate without limit
+	maxSnapshotRecord = 1 << 20
+)
+
+// snapshotBatch is how many chunks are read, or written back, per round
+// trip, keeping each transaction short so paints aren't held up behind it
+const snapshotBatch = 64
+
+// ErrSnapshotFormat is returned by RestoreSnapshot for a file that isn't a
+// snapshot, is cut short, or was written by a newer, incompatible version
+var ErrSnapshotFormat = errors.New("not a supported snapshot file")
+
+// SnapshotAllChunks writes every painted chunk's bits, seq, background and
+// palette id to w, returning how many chunks it wrote. Keys are walked with
+// SCAN and read in small transactions, so paints carry on during the
+// snapshot; each chunk's bits and seq agree, but chunks are captured at
+// slightly different moments.
+func (c *Client) SnapshotAllChunks(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	header := binary.BigEndian.AppendUint16([]byte(snapshotMagic), snapshotVersion)
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	batch := make([]ChunkRef, 0, snapshotBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		snaps, err := c.GetChunkSnapshots(batch)
+		if err != nil {
+			return err
+		}
+		for _, snap := range snaps {
+			if err := writeSnapshotRecord(bw, snap); err != nil {
+				return err
+			}
+			written++
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := c.client.Scan(c.ctx, 0, "chunk:*:bits", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		var chunk ChunkRef
+		if _, err := fmt.Sscanf(iter.Val(), "chunk:%d:%d:bits", &chunk.Cx, &chunk.Cy); err != nil {
+			continue
+		}
+		if batch = append(batch, chunk); len(batch) == snapshotBatch {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return written, err
+	}
+	if err := flush(); err != nil {
+		return written, err
+	}
+	if _, err := bw.Write(make([]byte, 4)); err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// writeSnapshotRecord writes one chunk's record
+func writeSnapshotRecord(w io.Writer, snap ChunkSnapshot) error {
+	size := snapshotRecordHeader + len(snap.Bits) + 2 + len(snap.PaletteID)
+	record := make([]byte, 4, 4+size)
+	binary.BigEndian.PutUint32(record, uint32(size))
+	record = binary.BigEndian.AppendUint64(record, uint64(snap.Cx))
+	record = binary.BigEndian.AppendUint64(record, uint64(snap.Cy))
+	record = binary.BigEndian.AppendUint64(record, snap.Seq)
+	record = append(record, snap.Background)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(snap.Bits)))
+	record = append(record, snap.Bits...)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(snap.PaletteID)))
+	record = append(record, snap.PaletteID...)
+	_, err := w.Write(record)
+	return err
+}
+
+// RestoreSnapshot loads chunks written by SnapshotAllChunks, returning how
+// many it restored. Each chunk's bits, seq, background and palette are
+// overwritten with the snapshot's, so paints made to those chunks since are
+// lost; chunks not in the snapshot are left alone.
+//
+// The whole file is read and checked before anything is written, so one
+// that is cut short or corrupt anywhere leaves the canvas as it was. It is
+// spooled to a temporary file meanwhile rather than held in memory. Once
+// chunks are written the canvas epoch is bumped, as by a reset, since
+// clients' copies of them are stale; the new epoch is returned.
+func (c *Client) RestoreSnapshot(r io.Reader) (chunks int, epoch uint64, err error) {
+	spool, err := os.CreateTemp("", "splat-restore-*")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	bw := bufio.NewWriter(spool)
+	if err := readSnapshot(io.TeeReader(r, bw), func(ChunkSnapshot) error { return nil }); err != nil {
+		return 0, 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, 0, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	pipe := c.client.TxPipeline()
+	pending := 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		if _, err := pipe.Exec(c.ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		chunks += pending
+		pending = 0
+		return nil
+	}
+	err = readSnapshot(spool, func(snap ChunkSnapshot) error {
+		kBits := fmt.Sprintf("chunk:%d:%d:bits", snap.Cx, snap.Cy)
+		kSeq := fmt.Sprintf("chunk:%d:%d:seq", snap.Cx, snap.Cy)
+		pipe.SetRange(c.ctx, kBits, 0, string(snap.Bits))
+		pipe.Set(c.ctx, kSeq, snap.Seq, 0)
+		// SET cleared the seq's TTL but SETRANGE kept the bits', and the
+		// two must expire together
+		if c.chunkTTL > 0 {
+			pipe.PExpire(c.ctx, kBits, c.chunkTTL)
+			pipe.PExpire(c.ctx, kSeq, c.chunkTTL)
+		} else {
+			pipe.Persist(c.ctx, kBits)
+		}
+		// The history describes paints the restored bits may not include
+		pipe.Del(c.ctx, historyKey(snap.Cx, snap.Cy))
+		if snap.Background != 0 {
+			pipe.Set(c.ctx, backgroundKey(snap.Cx, snap.Cy), snap.Background, 0)
+		} else {
+			pipe.Del(c.ctx, backgroundKey(snap.Cx, snap.Cy))
+		}
+		if snap.PaletteID != "" {
+			pipe.Set(c.ctx, paletteIDKey(snap.Cx, snap.Cy), snap.PaletteID, 0)
+		} else {
+			pipe.Del(c.ctx, paletteIDKey(snap.Cx, snap.Cy))
+		}
+
+		if pending++; pending == snapshotBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// A restore that failed partway has still changed chunks
+	if chunks > 0 {
+		next, incrErr := c.client.Incr(c.ctx, epochKey).Result()
+		if incrErr != nil {
+			return chunks, 0, errors.Join(err, incrErr)
+		}
+		epoch = uint64(next)
+	}
+	return chunks, epoch, err
+}
+
+// readSnapshot checks a snapshot file's header and passes each record to
+// fn, up to the end marker
+func readSnapshot(r io.Reader, fn func(ChunkSnapshot) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return ErrSnapshotFormat
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+	if version := binary.BigEndian.Uint16(header[len(snapshotMagic):]); version == 0 || version > snapshotVersion {
+		return fmt.Errorf("%w: version %d", ErrSnapshotFormat, version)
+	}
+
+	for {
+		snap, err := readSnapshotRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+}
+
+// readSnapshotRecord reads one chunk's record, returning io.EOF at the end
+// marker
+func readSnapshotRecord(r io.Reader) (ChunkSnapshot, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return ChunkSnapshot{}, fmt.Errorf("%w: truncated: %v", ErrSnapshotFormat, err)
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 {
+		return ChunkSnapshot{}, io.EOF
+	}
+	if n < snapshotRecordHeader || n > maxSnapshotRecord {
+		return ChunkSnapshot{}, fmt.Errorf("%w: bad record length %d", ErrSnapshotFormat, n)
+	}
+	record := make([]byte, n)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return ChunkSnapshot{}, fmt.Errorf("%w: truncated: %v", ErrSnapshotFormat, err)
+	}
+
+	snap := ChunkSnapshot{
+		ChunkRef: ChunkRef{
+			Cx: int64(binary.BigEndian.Uint64(record[0:])),
+			Cy: int64(binary.BigEndian.Uint64(record[8:])),
+		},
+		Seq:        binary.BigEndian.Uint64(record[16:]),
+		Background: record[24],
+	}
+	bitsLen := binary.BigEndian.Uint32(record[25:])
+	if bitsLen != chunkBytes || bitsLen > n-snapshotRecordHeader {
+		return ChunkSnapshot{}, fmt.Errorf("%w: bad bits length %d", ErrSnapshotFormat, bitsLen)
+	}
+	snap.Bits = record[snapshotRecordHeader : snapshotRecordHeader+bitsLen]
+
+	if rest := record[snapshotRecordHeader+bitsLen:]; len(rest) > 0 {
+		if len(rest) < 2 || int(binary.BigEndian.Uint16(rest)) > len(rest)-2 {
+			return ChunkSnapshot{}, fmt.Errorf("%w: bad palette id", ErrSnapshotFormat)
+		}
+		snap.PaletteID = string(rest[2 : 2+binary.BigEndian.Uint16(rest)])
+	}
+	// Fields a later version appends after the palette id are skipped
+	return snap, nil
+}
+
+// PaintedBounds returns the smallest chunk rectangle, lo to hi inclusive,
+// holding every painted chunk. ok is false when nothing is painted.
+func (c *Client) PaintedBounds() (lo, hi ChunkRef, ok bool, err error) {
+	iter := c.client.Scan(c.ctx, 0, "chunk:*:bits", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		var chunk ChunkRef
+		if _, err := fmt.Sscanf(iter.Val(), "chunk:%d:%d:bits", &chunk.Cx, &chunk.Cy); err != nil {
+			continue
+		}
+		if !ok {
+			lo, hi, ok = chunk, chunk, true
+			continue
+		}
+		lo = ChunkRef{Cx: min(lo.Cx, chunk.Cx), Cy: min(lo.Cy, chunk.Cy)}
+		hi = ChunkRef{Cx: max(hi.Cx, chunk.Cx), Cy: max(hi.Cy, chunk.Cy)}
+	}
+	return lo, hi, ok, iter.Err()
+}
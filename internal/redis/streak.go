@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const streakScript = `
+-- KEYS[1]=k_streak
+-- ARGV[1]=day, ARGV[2]=ttlSeconds
+
+local day = tonumber(ARGV[1])
+local last = tonumber(redis.call('HGET', KEYS[1], 'day') or '-1')
+local count = tonumber(redis.call('HGET', KEYS[1], 'count') or '0')
+
+if last == day then
+  return count
+end
+
+-- consecutive days extend the streak, a missed day restarts it
+if last == day - 1 then
+  count = count + 1
+else
+  count = 1
+end
+
+redis.call('HSET', KEYS[1], 'day', day, 'count', count)
+redis.call('EXPIRE', KEYS[1], tonumber(ARGV[2]))
+return count
+`
+
+// streakTTL keeps a streak key just long enough to survive one idle day
+const streakTTL = 3 * 24 * time.Hour
+
+var streakScriptObj = redis.NewScript(streakScript)
+
+// streakKey returns the Redis key holding a subject's paint streak
+func streakKey(subject string) string {
+	return fmt.Sprintf("streak:%s", subject)
+}
+
+// TouchStreak records a paint by subject on the given day (days since the
+// Unix epoch) and returns the subject's streak of consecutive days
+func (c *Client) TouchStreak(subject string, day int64) (int, error) {
+	return c.TouchStreakContext(c.ctx, subject, day)
+}
+
+// TouchStreakContext is TouchStreak bounded by ctx
+func (c *Client) TouchStreakContext(ctx context.Context, subject string, day int64) (int, error) {
+	n, err := streakScriptObj.Run(ctx, c.client, []string{streakKey(subject)}, day, int64(streakTTL.Seconds())).Int()
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return n, nil
+}
+
+// GetStreak returns the subject's streak as of the given day. A streak
+// whose last paint was before yesterday has lapsed and reads as 0.
+func (c *Client) GetStreak(subject string, day int64) (int, error) {
+	return c.GetStreakContext(c.ctx, subject, day)
+}
+
+// GetStreakContext is GetStreak bounded by ctx
+func (c *Client) GetStreakContext(ctx context.Context, subject string, day int64) (int, error) {
+	vals, err := c.client.HMGet(ctx, streakKey(subject), "day", "count").Result()
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	if vals[0] == nil || vals[1] == nil {
+		return 0, nil
+	}
+
+	last, err := strconv.ParseInt(vals[0].(string), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if last < day-1 {
+		return 0, nil
+	}
+	return strconv.Atoi(vals[1].(string))
+}
@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const undoScript = `
+-- KEYS[1]=k_bits, KEYS[2]=k_seq, KEYS[3]=k_log, KEYS[4]=k_undo,
+-- KEYS[5]=k_write
+-- ARGV[1]=o, ARGV[2]=seq, ARGV[3]=nowTs, ARGV[4]=useRedisTime,
+-- ARGV[5]=historyLen, ARGV[6]=undoVal, ARGV[7]=writeTtlMs, ARGV[8]=chunkTtlMs
+
+-- a retry of an undo that was applied gets its result again, as in the
+-- paint script
+local writeTtl = tonumber(ARGV[7])
+if writeTtl > 0 then
+  local done = redis.call('GET', KEYS[5])
+  if done then
+    local seq, ts, prev, color = string.match(done, '^(%d+),(%d+),(%d+),(%d+)$')
+    return { tonumber(seq), tonumber(ts), tonumber(prev), tonumber(color) }
+  end
+end
+
+-- only the painter's last paint, within the undo window, may be undone;
+-- seq -1 tells the caller
+if redis.call('GET', KEYS[4]) ~= ARGV[6] then
+  return { -1, 0, 0, 0 }
+end
+
+-- the history says what the tile was, and whether it was repainted since;
+-- entries are "seq,o,color,ts,prev", oldest first
+local color, prev
+for _, raw in ipairs(redis.call('LRANGE', KEYS[3], 0, -1)) do
+  local seq, o, c, _, p = string.match(raw, '^(%d+),(%d+),(%d+),(%d+),?(%d*)$')
+  if o == ARGV[1] then
+    if seq == ARGV[2] then
+      color, prev = tonumber(c), tonumber(p)
+    elseif color then
+      -- seq -2: someone painted over it
+      return { -2, 0, 0, 0 }
+    end
+  end
+end
+if not color or not prev then
+  return { -1, 0, 0, 0 }
+end
+
+local now = tonumber(ARGV[3])
+if ARGV[4] == '1' then
+  now = tonumber(redis.call('TIME')[1])
+end
+
+local o = tonumber(ARGV[1])
+local byteIdx = math.floor((o * 4) / 8)
+local b = string.byte(redis.call('GETRANGE', KEYS[1], byteIdx, byteIdx))
+if (o % 2) == 0 then
+  b = prev * 16 + b % 16
+else
+  b = math.floor(b / 16) * 16 + prev
+end
+redis.call('SETRANGE', KEYS[1], byteIdx, string.char(b))
+local seq = redis.call('INCR', KEYS[2])
+
+-- the revert is history like any paint, so resuming clients replay it
+redis.call('RPUSH', KEYS[3], seq .. ',' .. o .. ',' .. prev .. ',' .. now .. ',' .. color)
+redis.call('LTRIM', KEYS[3], -tonumber(ARGV[5]), -1)
+redis.call('DEL', KEYS[4])
+
+-- an undo is activity too; the keys keep one deadline as in the paint script
+local chunkTtl = tonumber(ARGV[8])
+if chunkTtl > 0 then
+  redis.call('PEXPIRE', KEYS[1], chunkTtl)
+  redis.call('PEXPIRE', KEYS[2], chunkTtl)
+  redis.call('PEXPIRE', KEYS[3], chunkTtl)
+end
+
+if writeTtl > 0 then
+  redis.call('SET', KEYS[5], seq .. ',' .. now .. ',' .. prev .. ',' .. color, 'PX', writeTtl)
+end
+
+return { seq, now, prev, color }
+`
+
+// ErrUndoUnavailable is returned by UndoPaint when the paint isn't the
+// painter's last, its undo window has passed or it has left the history
+var ErrUndoUnavailable = errors.New("nothing to undo")
+
+// ErrUndoConflict is returned by UndoPaint when the tile has been painted
+// again since
+var ErrUndoConflict = errors.New("tile painted since")
+
+// undoKey returns the Redis key holding a painter's undoable paint
+func undoKey(ip string) string {
+	return fmt.Sprintf("undo:%s", ip)
+}
+
+// undoVal identifies a paint in an undo key
+func undoVal(cx, cy int64, offset int, seq uint64) string {
+	return fmt.Sprintf("%d,%d,%d,%d", cx, cy, offset, seq)
+}
+
+// AllowUndo lets ip undo its paint at seq for ttl, replacing any earlier
+// paint it could have undone
+func (c *Client) AllowUndo(ip string, cx, cy int64, offset int, seq uint64, ttl time.Duration) error {
+	return c.AllowUndoContext(c.ctx, ip, cx, cy, offset, seq, ttl)
+}
+
+// AllowUndoContext is AllowUndo bounded by ctx
+func (c *Client) AllowUndoContext(ctx context.Context, ip string, cx, cy int64, offset int, seq uint64, ttl time.Duration) error {
+	return ctxErr(ctx, c.client.Set(ctx, undoKey(ip), undoVal(cx, cy, offset, seq), ttl).Err())
+}
+
+// UndoPaint restores the color a tile had before ip's paint at seq, as a
+// new paint with its own seq, provided AllowUndo was called for it and the
+// tile hasn't been painted since. It needs KeepHistory, whose entries say
+// what the tile was. restored is the color written back and undone the one
+// it replaced.
+func (c *Client) UndoPaint(ip string, cx, cy int64, offset int, seq uint64) (newSeq uint64, ts int64, restored, undone uint8, err error) {
+	return c.UndoPaintContext(c.ctx, ip, cx, cy, offset, seq)
+}
+
+// UndoPaintContext is UndoPaint bounded by ctx
+func (c *Client) UndoPaintContext(ctx context.Context, ip string, cx, cy int64, offset int, seq uint64) (newSeq uint64, ts int64, restored, undone uint8, err error) {
+	if c.historyLen <= 0 {
+		return 0, 0, 0, 0, ErrUndoUnavailable
+	}
+
+	id := c.writeID(ctx)
+	var writeTtlMs int64
+	if id != "" {
+		writeTtlMs = writeIDLifetime(ctx).Milliseconds()
+	}
+	keys := []string{
+		fmt.Sprintf("chunk:%d:%d:bits", cx, cy),
+		fmt.Sprintf("chunk:%d:%d:seq", cx, cy),
+		historyKey(cx, cy),
+		undoKey(ip),
+		writeIDKey(id),
+	}
+	useRedisTime := "0"
+	if c.useRedisTime {
+		useRedisTime = "1"
+	}
+
+	now := time.Now().Unix()
+	var result interface{}
+	err = c.retryWrite(ctx, func() (err error) {
+		result, err = c.undoScript.Run(ctx, c.writes, keys, offset, seq, now, useRedisTime, c.historyLen, undoVal(cx, cy, offset, seq), writeTtlMs, c.chunkTTL.Milliseconds()).Result()
+		return err
+	})
+	if err != nil {
+		return 0, 0, 0, 0, ctxErr(ctx, err)
+	}
+	var out [4]int64
+	if err := scriptInts(result, out[:]); err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("undo: %w", err)
+	}
+	switch out[0] {
+	case -1:
+		return 0, 0, 0, 0, ErrUndoUnavailable
+	case -2:
+		return 0, 0, 0, 0, ErrUndoConflict
+	}
+	return uint64(out[0]), out[1], uint8(out[2]), uint8(out[3]), nil
+}
@@ -1,11 +1,14 @@
 package turnstile
 
 import (
-	"bytes"
+	"container/list"
 	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,11 +20,38 @@ type TurnstileResponse struct {
 	ErrorCodes  []string `json:"error-codes"`
 }
 
+// Tokens are single-use, so a paint resubmitted by a retry or double click
+// would fail verification the second time. A success is remembered for
+// verifiedTTL and reused for the same token from the same IP; failures
+// aren't remembered. The TTL is kept short since a reused token skips the
+// challenge.
+const (
+	verifiedTTL  = 5 * time.Second
+	verifiedSize = 4096
+)
+
+// verification is one token's result, shared by callers verifying it
+// together. done is closed once resp and err are set.
+type verification struct {
+	key     string
+	resp    *TurnstileResponse
+	err     error
+	done    chan struct{}
+	expires time.Time // zero while in flight
+	elem    *list.Element
+}
+
 // TurnstileClient handles Turnstile verification
 type TurnstileClient struct {
 	secretKey string
 	client    *http.Client
 	baseURL   string
+
+	// Recent verifications, most recently used first, guarded by mu
+	mu       sync.Mutex
+	verified map[string]*verification
+	lru      *list.List
+	now      func() time.Time
 }
 
 // NewTurnstileClient creates a new Turnstile client
@@ -30,34 +60,84 @@ func NewTurnstileClient(secretKey string) *TurnstileClient {
 		secretKey: secretKey,
 		client:    &http.Client{Timeout: 10 * time.Second},
 		baseURL:   "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		verified:  make(map[string]*verification),
+		lru:       list.New(),
+		now:       time.Now,
 	}
 }
 
-// Verify verifies a Turnstile token
+// SetBaseURL points the client at another siteverify endpoint, such as a
+// test server
+func (tc *TurnstileClient) SetBaseURL(baseURL string) {
+	tc.baseURL = baseURL
+}
+
+// Verify verifies a Turnstile token. A token verified successfully in the
+// last few seconds from the same IP, or being verified right now, gets that
+// result without asking Cloudflare again.
 func (tc *TurnstileClient) Verify(ctx context.Context, token, remoteIP string) (*TurnstileResponse, error) {
-	// Prepare form data
-	formData := map[string]string{
-		"secret":   tc.secretKey,
-		"response": token,
+	key := remoteIP + " " + token
+
+	tc.mu.Lock()
+	v, ok := tc.verified[key]
+	if ok && !v.expires.IsZero() && tc.now().After(v.expires) {
+		tc.forget(v)
+		ok = false
+	}
+	if ok {
+		tc.lru.MoveToFront(v.elem)
+		tc.mu.Unlock()
+		select {
+		case <-v.done:
+			return v.resp, v.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
+	v = &verification{key: key, done: make(chan struct{})}
+	v.elem = tc.lru.PushFront(v)
+	tc.verified[key] = v
+	if tc.lru.Len() > verifiedSize {
+		tc.forget(tc.lru.Back().Value.(*verification))
+	}
+	tc.mu.Unlock()
 
-	if remoteIP != "" {
-		formData["remoteip"] = remoteIP
+	v.resp, v.err = tc.siteverify(ctx, token, remoteIP)
+
+	tc.mu.Lock()
+	if v.err != nil || !v.resp.Success {
+		tc.forget(v)
+	} else {
+		v.expires = tc.now().Add(verifiedTTL)
 	}
+	tc.mu.Unlock()
+	close(v.done)
+	return v.resp, v.err
+}
 
-	// Create form
-	form := make([]byte, 0)
-	for key, value := range formData {
-		if len(form) > 0 {
-			form = append(form, '&')
-		}
-		form = append(form, []byte(key)...)
-		form = append(form, '=')
-		form = append(form, []byte(value)...)
+// forget drops a verification if it is still remembered; callers must hold
+// mu
+func (tc *TurnstileClient) forget(v *verification) {
+	if tc.verified[v.key] != v {
+		return
+	}
+	delete(tc.verified, v.key)
+	tc.lru.Remove(v.elem)
+}
+
+// siteverify asks Cloudflare whether a token is valid
+func (tc *TurnstileClient) siteverify(ctx context.Context, token, remoteIP string) (*TurnstileResponse, error) {
+	// Prepare form data
+	form := url.Values{}
+	form.Set("secret", tc.secretKey)
+	form.Set("response", token)
+
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
 	}
 
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, "POST", tc.baseURL, bytes.NewReader(form))
+	req, err := http.NewRequestWithContext(ctx, "POST", tc.baseURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
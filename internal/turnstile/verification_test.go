@@ -3,8 +3,10 @@ package turnstile
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -152,6 +154,38 @@ func TestTurnstileVerificationWithoutIP(t *testing.T) {
 	}
 }
 
+func TestTurnstileVerificationEncodesReservedCharacters(t *testing.T) {
+	token := "abc&def=ghi+jkl%20 mno"
+	remoteIP := "2001:db8::1"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("response"); got != token {
+			t.Errorf("Expected token %q, got %q", token, got)
+		}
+		if got := r.FormValue("remoteip"); got != remoteIP {
+			t.Errorf("Expected remote IP %q, got %q", remoteIP, got)
+		}
+		if got := r.FormValue("secret"); got != "test_secret" {
+			t.Errorf("Expected secret test_secret, got %q", got)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TurnstileResponse{Success: true})
+	}))
+	defer server.Close()
+
+	client := NewTurnstileClient("test_secret")
+	client.baseURL = server.URL
+
+	resp, err := client.Verify(context.Background(), token, remoteIP)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected success=true, got %v", resp.Success)
+	}
+}
+
 func TestTurnstileVerificationTimeout(t *testing.T) {
 	// Test timeout handling
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -437,6 +471,96 @@ func TestTurnstileVerificationFormData(t *testing.T) {
 	}
 }
 
+// countingServer answers every token with success, or failure for
+// "invalid_token", and counts the calls
+func countingServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		resp := TurnstileResponse{Success: r.FormValue("response") != "invalid_token"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTurnstileVerificationReusesRecentSuccess(t *testing.T) {
+	var calls atomic.Int32
+	client := NewTurnstileClient("test_secret")
+	client.baseURL = countingServer(t, &calls).URL
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Verify(ctx, "valid_token", "192.168.1.1")
+		if err != nil || !resp.Success {
+			t.Fatalf("Verify %d: expected success, got %+v: %v", i, resp, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected one upstream call for a resubmitted token, got %d", n)
+	}
+
+	// Another IP presenting the token is verified upstream
+	client.Verify(ctx, "valid_token", "192.168.1.2")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected a call for the token from another IP, got %d calls", n)
+	}
+
+	// Failures aren't remembered
+	client.Verify(ctx, "invalid_token", "192.168.1.1")
+	client.Verify(ctx, "invalid_token", "192.168.1.1")
+	if n := calls.Load(); n != 4 {
+		t.Errorf("Expected each failed verification to go upstream, got %d calls", n)
+	}
+
+	// Nor are successes past the TTL
+	now = now.Add(verifiedTTL + time.Millisecond)
+	client.Verify(ctx, "valid_token", "192.168.1.1")
+	if n := calls.Load(); n != 5 {
+		t.Errorf("Expected an expired success to go upstream, got %d calls", n)
+	}
+}
+
+func TestTurnstileVerificationSharesInFlightCall(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TurnstileResponse{Success: true})
+	}))
+	defer server.Close()
+
+	client := NewTurnstileClient("test_secret")
+	client.baseURL = server.URL
+
+	// A double click: the second arrives while the first is verifying
+	results := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := client.Verify(context.Background(), "valid_token", "192.168.1.1")
+			results <- err == nil && resp.Success
+		}()
+	}
+	for deadline := time.Now().Add(2 * time.Second); calls.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if !<-results {
+			t.Errorf("Expected both submissions to succeed")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected one upstream call, got %d", n)
+	}
+}
+
 func BenchmarkTurnstileVerification(b *testing.B) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp := TurnstileResponse{
@@ -456,8 +580,9 @@ func BenchmarkTurnstileVerification(b *testing.B) {
 
 	ctx := context.Background()
 
+	// Distinct tokens, so each goes upstream
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		client.Verify(ctx, "valid_token", "192.168.1.1")
+		client.Verify(ctx, fmt.Sprintf("token_%d", i), "192.168.1.1")
 	}
 }
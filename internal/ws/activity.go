@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"sort"
+	"time"
+)
+
+// Activity summarizes the paints in a connection's activity region over
+// the last interval, one entry per chunk painted, for dashboards that want
+// rates rather than every delta. It is sent as a JSON text frame.
+type Activity struct {
+	Type       string          `json:"type"` // always "activity"
+	IntervalMs int64           `json:"intervalMs"`
+	Chunks     []ChunkActivity `json:"chunks"`
+}
+
+// ChunkActivity is how many paints a chunk had in an Activity's interval
+type ChunkActivity struct {
+	Cx     int64 `json:"cx"`
+	Cy     int64 `json:"cy"`
+	Paints int   `json:"paints"`
+}
+
+// activityRegion is an inclusive range of chunks
+type activityRegion struct {
+	minCx, minCy, maxCx, maxCy int64
+}
+
+func (r activityRegion) contains(chunk chunkRef) bool {
+	return chunk.cx >= r.minCx && chunk.cx <= r.maxCx && chunk.cy >= r.minCy && chunk.cy <= r.maxCy
+}
+
+// countActivity counts a published paint toward the next Activity
+func (h *Hub) countActivity(cx, cy int64) {
+	if h.config.ActivityInterval <= 0 {
+		return
+	}
+	h.amu.Lock()
+	h.activity[chunkRef{cx, cy}]++
+	h.amu.Unlock()
+}
+
+// setActivity subscribes a connection to a region's Activity, replacing
+// any region it had, or unsubscribes it
+func (h *Hub) setActivity(conn *Conn, region activityRegion, on bool) {
+	if h.config.ActivityInterval <= 0 {
+		return
+	}
+	h.amu.Lock()
+	defer h.amu.Unlock()
+	if on {
+		h.activitySubs[conn] = region
+	} else {
+		delete(h.activitySubs, conn)
+	}
+}
+
+// runActivity sends each activity subscriber the counts for its region
+// every Config.ActivityInterval, skipping regions with no paints
+func (h *Hub) runActivity() {
+	ticker := time.NewTicker(h.config.ActivityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+
+		h.amu.Lock()
+		counts := h.activity
+		h.activity = make(map[chunkRef]int, len(counts))
+		subs := make(map[*Conn]activityRegion, len(h.activitySubs))
+		for conn, region := range h.activitySubs {
+			subs[conn] = region
+		}
+		h.amu.Unlock()
+
+		if len(counts) == 0 || len(subs) == 0 {
+			continue
+		}
+		chunks := make([]ChunkActivity, 0, len(counts))
+		for chunk, n := range counts {
+			chunks = append(chunks, ChunkActivity{Cx: chunk.cx, Cy: chunk.cy, Paints: n})
+		}
+		sort.Slice(chunks, func(i, j int) bool {
+			if chunks[i].Cy != chunks[j].Cy {
+				return chunks[i].Cy < chunks[j].Cy
+			}
+			return chunks[i].Cx < chunks[j].Cx
+		})
+
+		for conn, region := range subs {
+			var inRegion []ChunkActivity
+			for _, chunk := range chunks {
+				if region.contains(chunkRef{chunk.Cx, chunk.Cy}) {
+					inRegion = append(inRegion, chunk)
+				}
+			}
+			if len(inRegion) > 0 {
+				conn.notify(Activity{Type: "activity", IntervalMs: h.config.ActivityInterval.Milliseconds(), Chunks: inRegion})
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Bus carries deltas between the hubs of several server instances, so a
+// paint that lands on one instance reaches clients connected to any of
+// them. Each hub publishes its own deltas to the bus and subscribes to the
+// chunks it has rooms for; the bus hands other instances' deltas for those
+// chunks to Hub.Receive. Notices, being rare, go to every instance, which
+// the bus hands them to Hub.ReceiveNotice. The bus is responsible for not
+// handing a hub its own deltas and notices back.
+type Bus interface {
+	Publish(delta Delta)
+	PublishNotice(notice BusNotice)
+	Subscribe(cx, cy int64)
+	Unsubscribe(cx, cy int64)
+}
+
+// BusNotice is a notice as carried between instances: the inclusive range
+// of chunks it is for and the JSON their subscribers are sent. A fill's
+// chunk also forgets its recent deltas, as PublishFill's does.
+type BusNotice struct {
+	MinCx, MinCy, MaxCx, MaxCy int64
+	Fill                       bool
+	Payload                    json.RawMessage
+}
+
+// SetBus connects the hub to other instances through bus. Call it before
+// Run.
+func (h *Hub) SetBus(bus Bus) {
+	h.bus = bus
+	h.busRooms = make(map[string]struct{})
+	h.roomsChanged = make(map[string]struct{})
+}
+
+// Receive delivers a delta published by another instance to this hub's
+// subscribers, as Publish does for local ones
+func (h *Hub) Receive(delta Delta) {
+	key := roomKey(delta.Cx, delta.Cy)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	h.deliver(key, delta)
+}
+
+// ReceiveNotice delivers a notice published by another instance to this
+// hub's subscribers, as NotifyChunks and PublishFill do for local ones
+func (h *Hub) ReceiveNotice(notice BusNotice) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if notice.Fill {
+		h.rmu.Lock()
+		delete(h.recent, roomKey(notice.MinCx, notice.MinCy))
+		h.rmu.Unlock()
+	}
+	h.notifyChunks(notice.MinCx, notice.MinCy, notice.MaxCx, notice.MaxCy, notice.Payload)
+}
+
+// publishNotice sends a local notice to the other instances, if any
+func (h *Hub) publishNotice(minCx, minCy, maxCx, maxCy int64, fill bool, notice any) {
+	if h.bus == nil {
+		return
+	}
+	payload, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+	h.bus.PublishNotice(BusNotice{MinCx: minCx, MinCy: minCy, MaxCx: maxCx, MaxCy: maxCy, Fill: fill, Payload: payload})
+}
+
+// roomChanged notes that a room was created or torn down, so syncBus
+// revisits its bus subscription; callers must hold mu
+func (h *Hub) roomChanged(roomID string) {
+	if h.bus != nil {
+		h.roomsChanged[roomID] = struct{}{}
+	}
+}
+
+// syncBus subscribes to the chunks that gained a room since the last call
+// and unsubscribes from those that lost theirs. It runs outside mu since
+// the bus may block on the network, and only Run calls it, so busRooms
+// needs no lock.
+func (h *Hub) syncBus() {
+	if h.bus == nil {
+		return
+	}
+
+	h.mu.Lock()
+	changed := h.roomsChanged
+	h.roomsChanged = make(map[string]struct{})
+	open := make(map[string]bool, len(changed))
+	for roomID := range changed {
+		_, open[roomID] = h.rooms[roomID]
+	}
+	h.mu.Unlock()
+
+	for roomID := range changed {
+		var cx, cy int64
+		if _, err := fmt.Sscanf(roomID, "%d:%d", &cx, &cy); err != nil {
+			continue
+		}
+
+		_, subscribed := h.busRooms[roomID]
+		switch {
+		case open[roomID] && !subscribed:
+			h.busRooms[roomID] = struct{}{}
+			h.bus.Subscribe(cx, cy)
+		case !open[roomID] && subscribed:
+			delete(h.busRooms, roomID)
+			h.bus.Unsubscribe(cx, cy)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultCompressMinBytes is the smallest frame compressed when
+// Config.CompressMinBytes is unset. A delta is well under it: deflate's
+// framing would cost about what it saves.
+const defaultCompressMinBytes = 256
+
+// writeFrame writes a message, compressed if the connection negotiated
+// permessage-deflate and the message is large enough to gain from it
+func (c *Conn) writeFrame(messageType int, data []byte) error {
+	c.ws.EnableWriteCompression(len(data) >= c.hub.compressMinBytes())
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// writeJSON writes v as a JSON text frame through writeFrame
+func (c *Conn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(websocket.TextMessage, data)
+}
+
+// compressMinBytes returns the configured compression threshold or the
+// default
+func (h *Hub) compressMinBytes() int {
+	if h.config.CompressMinBytes > 0 {
+		return h.config.CompressMinBytes
+	}
+	return defaultCompressMinBytes
+}
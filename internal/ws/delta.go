@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// FrameDelta prefixes the binary form of a delta, sent instead of JSON to
+// connections that set ConnOptions.BinaryDeltas
+const FrameDelta byte = 2
+
+// deltaFrameLen is the length of a binary delta: the FrameDelta byte, seq,
+// cx, cy and ts as big-endian 64-bit integers, o as a big-endian uint16 and
+// the color byte
+const deltaFrameLen = 1 + 8 + 8 + 8 + 8 + 2 + 1
+
+// MarshalBinary encodes the delta as a FrameDelta frame. Origin isn't sent,
+// as it isn't in JSON.
+func (d Delta) MarshalBinary() ([]byte, error) {
+	if d.O < 0 || d.O > 0xFFFF {
+		return nil, fmt.Errorf("delta offset %d does not fit in 16 bits", d.O)
+	}
+	frame := make([]byte, deltaFrameLen)
+	frame[0] = FrameDelta
+	binary.BigEndian.PutUint64(frame[1:], d.Seq)
+	binary.BigEndian.PutUint64(frame[9:], uint64(d.Cx))
+	binary.BigEndian.PutUint64(frame[17:], uint64(d.Cy))
+	binary.BigEndian.PutUint64(frame[25:], uint64(d.Ts))
+	binary.BigEndian.PutUint16(frame[33:], uint16(d.O))
+	frame[35] = d.Color
+	return frame, nil
+}
+
+// UnmarshalBinary decodes a FrameDelta frame
+func (d *Delta) UnmarshalBinary(frame []byte) error {
+	if len(frame) != deltaFrameLen || frame[0] != FrameDelta {
+		return fmt.Errorf("not a delta frame (%d bytes)", len(frame))
+	}
+	*d = Delta{
+		Seq:   binary.BigEndian.Uint64(frame[1:]),
+		Cx:    int64(binary.BigEndian.Uint64(frame[9:])),
+		Cy:    int64(binary.BigEndian.Uint64(frame[17:])),
+		Ts:    int64(binary.BigEndian.Uint64(frame[25:])),
+		O:     int(binary.BigEndian.Uint16(frame[33:])),
+		Color: frame[35],
+	}
+	return nil
+}
+
+// writeDelta sends a delta in the connection's chosen encoding
+func (c *Conn) writeDelta(delta Delta) error {
+	if !c.binaryDeltas {
+		return c.writeJSON(delta)
+	}
+	frame, err := delta.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(websocket.BinaryMessage, frame)
+}
@@ -0,0 +1,59 @@
+package ws
+
+import "time"
+
+// EpochNotice tells a client the canvas was reset and it must reload every
+// chunk. It is sent as a JSON text frame.
+type EpochNotice struct {
+	Type  string `json:"type"` // always "epoch"
+	Epoch uint64 `json:"epoch"`
+}
+
+// Epoch returns the canvas epoch the hub last saw
+func (h *Hub) Epoch() uint64 {
+	return h.epoch.Load()
+}
+
+// SetEpoch records the canvas epoch. When it moves forward, the recent
+// deltas belong to the old canvas and are forgotten, and every subscribed
+// connection is told to reload.
+func (h *Hub) SetEpoch(epoch uint64) {
+	for {
+		current := h.epoch.Load()
+		if epoch <= current {
+			return
+		}
+		if h.epoch.CompareAndSwap(current, epoch) {
+			break
+		}
+	}
+
+	h.rmu.Lock()
+	h.recent = make(map[string]*deltaRing)
+	h.rmu.Unlock()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, room := range h.rooms {
+		room.mu.RLock()
+		for conn := range room.subs {
+			conn.notifyEpoch()
+		}
+		room.mu.RUnlock()
+	}
+}
+
+// notifyEpoch queues an epoch notice. A connection in several rooms is
+// reached once per room, but one pending notice is enough.
+func (c *Conn) notifyEpoch() {
+	select {
+	case c.epochs <- struct{}{}:
+	default:
+	}
+}
+
+// writeEpoch sends the hub's current epoch
+func (c *Conn) writeEpoch() error {
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.writeJSON(EpochNotice{Type: "epoch", Epoch: c.hub.Epoch()})
+}
@@ -1,8 +1,13 @@
 package ws
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,64 +16,313 @@ import (
 // Delta represents a paint update message
 type Delta struct {
 	Seq   uint64 `json:"seq"`
-	O     uint16 `json:"o"`
+	O     int    `json:"o"`
 	Color uint8  `json:"color"`
 	Ts    int64  `json:"ts"`
+
+	// Cx and Cy identify the delta's chunk so a connection subscribed to
+	// several chunks can demux
+	Cx int64 `json:"cx"`
+	Cy int64 `json:"cy"`
+
+	// Origin is the client ID of the painter, used to skip echoing the
+	// delta back to connections that opted out of their own edits
+	Origin string `json:"-"`
+}
+
+// FrameSnapshot prefixes the binary frame carrying a full chunk. Deltas are
+// JSON text frames unless the connection asked for FrameDelta frames, so
+// clients tell binary frames apart by this first byte.
+const FrameSnapshot byte = 1
+
+// SnapshotSource reads a chunk's 32KB bits and the seq they are current as of
+type SnapshotSource interface {
+	GetChunkSnapshot(cx, cy int64) ([]byte, uint64, error)
+}
+
+// HistorySource returns a chunk's deltas after a seq, oldest first, with ok
+// false when some are no longer retained
+type HistorySource interface {
+	DeltasSince(cx, cy int64, seq uint64) (deltas []Delta, ok bool, err error)
+}
+
+// Resync tells a client it hasn't been sent some of a chunk's deltas (they
+// aged out, were shed, or the room is over its rate cap) and must refetch
+// the chunk. It is sent as a JSON text frame.
+type Resync struct {
+	Type string `json:"type"` // always "resync"
+	Cx   int64  `json:"cx"`
+	Cy   int64  `json:"cy"`
+}
+
+// chunkRef identifies a chunk
+type chunkRef struct {
+	cx, cy int64
+}
+
+// catchUp is a newly joined chunk whose current state WritePump must send
+// before relying on deltas alone
+type catchUp struct {
+	chunk chunkRef
+	// resume replays deltas after since instead of sending a snapshot
+	resume bool
+	since  uint64
+}
+
+// encodeSnapshot builds a snapshot frame: the FrameSnapshot byte, then cx,
+// cy and seq as big-endian 64-bit integers, then the chunk bits
+func encodeSnapshot(cx, cy int64, seq uint64, bits []byte) []byte {
+	frame := make([]byte, 25, 25+len(bits))
+	frame[0] = FrameSnapshot
+	binary.BigEndian.PutUint64(frame[1:], uint64(cx))
+	binary.BigEndian.PutUint64(frame[9:], uint64(cy))
+	binary.BigEndian.PutUint64(frame[17:], seq)
+	return append(frame, bits...)
 }
 
 // Conn represents a WebSocket connection
 type Conn struct {
-	ws     *websocket.Conn
-	send   chan Delta
-	hub    *Hub
+	ws   *websocket.Conn
+	send chan Delta
+	hub  *Hub
+
+	// roomID is the room joined on registration, if any. rooms is the full
+	// set of joined rooms and is only touched by Hub.Run.
+	roomID string
+	chunk  chunkRef
+	rooms  map[string]struct{}
+
+	// catchUps queues chunks joined since the last write, so WritePump
+	// sends their state; nil unless the connection asked for snapshots or
+	// to resume
+	catchUps     chan catchUp
+	wantSnapshot bool
+	resume       bool
+	sinceSeq     uint64
+
+	// dropped is closed when a room gives up on the connection because its
+	// send buffer is full. send itself is never closed since other rooms
+	// may still be delivering to it.
+	dropped  chan struct{}
+	dropOnce sync.Once
+
+	subject      string
+	clientID     string
+	suppressEcho bool
+	binaryDeltas bool
+
+	// focus is the chunk the client says it is painting, if any. While the
+	// connection lags, deltas for its other chunks are shed rather than
+	// queued, and each shed chunk is queued once on resyncs so the client
+	// refetches it; so is each chunk whose delta DropOldest discarded.
+	// Guarded by prio since rooms deliver concurrently.
+	prio     sync.Mutex
+	focus    chunkRef
+	hasFocus bool
+	shed     map[chunkRef]bool
+	resyncs  chan chunkRef
+
+	// epochs signals WritePump that the canvas was reset; notices queues
+	// other JSON messages from the hub, such as mask changes
+	epochs  chan struct{}
+	notices chan any
+
+	// presence queues chunks whose subscriber count WritePump should send
+	presence chan chunkRef
+
+	// unregistered is set by Run once the connection has left, so a
+	// registration still queued behind it is ignored
+	unregistered bool
+}
+
+// ConnOptions holds per-connection subscription settings
+type ConnOptions struct {
+	// Subject is who the connection belongs to for limits (the client IP)
+	Subject string
+	// ClientID identifies the client across its paint requests and
+	// subscriptions
+	ClientID string
+	// SuppressEcho skips deltas whose origin matches ClientID
+	SuppressEcho bool
+	// Snapshot sends a FrameSnapshot for each chunk as it is joined, if the
+	// hub has a SnapshotSource. Off by default since older clients expect
+	// only JSON deltas.
+	Snapshot bool
+	// Resume replays the initial chunk's deltas after SinceSeq, for a client
+	// reconnecting after a brief drop. If they are no longer retained it
+	// gets a snapshot when Snapshot is set and a Resync otherwise.
+	Resume   bool
+	SinceSeq uint64
+	// BinaryDeltas sends deltas as FrameDelta binary frames instead of
+	// JSON. Notices such as resyncs stay JSON.
+	BinaryDeltas bool
+}
+
+// isEcho reports whether the delta originated from this connection's client
+// and the connection asked not to receive its own edits
+func (c *Conn) isEcho(delta Delta) bool {
+	return c.suppressEcho && c.clientID != "" && delta.Origin == c.clientID
+}
+
+// drop tells WritePump to close the connection
+func (c *Conn) drop() {
+	c.dropOnce.Do(func() {
+		if c.dropped != nil {
+			close(c.dropped)
+		}
+	})
+}
+
+// defaultPingInterval is how often connections are pinged when
+// Config.PingInterval is unset
+const defaultPingInterval = 20 * time.Second
+
+// pongWaitPings is how many ping intervals a connection may stay silent.
+// More than one, so a pong delayed past the next ping isn't a disconnect.
+const pongWaitPings = 3
+
+// defaultMaxRoomsPerConn bounds how many chunks one connection may
+// subscribe to when Config.MaxRoomsPerConn is unset
+const defaultMaxRoomsPerConn = 64
+
+// controlMessage is a client-sent request to change subscriptions or focus.
+// Activity subscriptions give a region instead of a chunk.
+type controlMessage struct {
+	Op string `json:"op"`
+	Cx int64  `json:"cx"`
+	Cy int64  `json:"cy"`
+
+	MinCx int64 `json:"minCx"`
+	MinCy int64 `json:"minCy"`
+	MaxCx int64 `json:"maxCx"`
+	MaxCy int64 `json:"maxCy"`
+}
+
+// roomOp is a queued subscription change for Hub.Run
+type roomOp struct {
+	conn   *Conn
 	roomID string
+	chunk  chunkRef
+	join   bool
+}
+
+// roomKey returns the room ID for a chunk
+func roomKey(cx, cy int64) string {
+	return fmt.Sprintf("%d:%d", cx, cy)
 }
 
-// readPump reads messages from the WebSocket connection
+// readPump reads messages from the WebSocket connection. When the client
+// goes away it unregisters the connection and drops it, so WritePump exits
+// at once rather than at its next failed write.
 func (c *Conn) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+		c.drop()
 		c.ws.Close()
 	}()
 
+	pongWait := c.hub.pongWait()
 	c.ws.SetReadLimit(512)
-	c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error {
-		c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
 	for {
-		_, _, err := c.ws.ReadMessage()
+		_, message, err := c.ws.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				// Log error
+				slog.Info("ws: read failed", "remote", c.ws.RemoteAddr().String(), "err", err)
 			}
 			break
 		}
+
+		// Subscription changes; anything else is ignored
+		var msg controlMessage
+		if json.Unmarshal(message, &msg) != nil {
+			continue
+		}
+		switch msg.Op {
+		case "sub":
+			c.hub.queueOp(roomOp{conn: c, roomID: roomKey(msg.Cx, msg.Cy), chunk: chunkRef{msg.Cx, msg.Cy}, join: true})
+		case "unsub":
+			c.hub.queueOp(roomOp{conn: c, roomID: roomKey(msg.Cx, msg.Cy), chunk: chunkRef{msg.Cx, msg.Cy}})
+		case "focus":
+			c.setFocus(chunkRef{msg.Cx, msg.Cy}, true)
+		case "unfocus":
+			c.setFocus(chunkRef{}, false)
+		case "activity":
+			c.hub.setActivity(c, activityRegion{msg.MinCx, msg.MinCy, msg.MaxCx, msg.MaxCy}, true)
+		case "unactivity":
+			c.hub.setActivity(c, activityRegion{}, false)
+		}
 	}
 }
 
 // writePump writes messages to the WebSocket connection
 func (c *Conn) WritePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	c.hub.pumpStarted()
+	ticker := time.NewTicker(c.hub.pingInterval())
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
+		c.hub.pumpStopped()
 	}()
 
+	var expired <-chan time.Time
+	if lifetime := c.hub.lifetime(); lifetime > 0 {
+		timer := time.NewTimer(lifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
 	for {
 		select {
-		case delta, ok := <-c.send:
+		case delta := <-c.send:
+			// send is never closed; dropped is how a connection ends
 			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+			if err := c.writeDelta(delta); err != nil {
 				return
 			}
+		case req := <-c.catchUps:
+			if err := c.writeCatchUp(req); err != nil {
+				return
+			}
+		case chunk := <-c.resyncs:
+			c.prio.Lock()
+			delete(c.shed, chunk)
+			c.prio.Unlock()
 
-			if err := c.ws.WriteJSON(delta); err != nil {
+			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.writeJSON(Resync{Type: "resync", Cx: chunk.cx, Cy: chunk.cy}); err != nil {
+				return
+			}
+		case <-c.epochs:
+			if err := c.writeEpoch(); err != nil {
+				return
+			}
+		case notice := <-c.notices:
+			if err := c.writeNotice(notice); err != nil {
 				return
 			}
+		case chunk := <-c.presence:
+			if err := c.writePresence(chunk); err != nil {
+				return
+			}
+		case <-c.dropped:
+			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case <-expired:
+			c.writeReconnect("max lifetime reached")
+			return
+		case <-c.hub.done:
+			c.writeReconnect("server shutting down")
+			return
 		case <-ticker.C:
 			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -78,11 +332,191 @@ func (c *Conn) WritePump() {
 	}
 }
 
+// writeSnapshot sends a chunk's current state. It is read after the
+// connection joined the room, so deltas with a seq above the snapshot's are
+// all delivered; the client discards those at or below it.
+func (c *Conn) writeSnapshot(chunk chunkRef) error {
+	bits, seq, err := c.hub.snapshots.GetChunkSnapshot(chunk.cx, chunk.cy)
+	if err != nil {
+		slog.Error("ws: snapshot read failed", "cx", chunk.cx, "cy", chunk.cy, "err", err)
+		return err
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.writeFrame(websocket.BinaryMessage, encodeSnapshot(chunk.cx, chunk.cy, seq, bits))
+}
+
+// writeCatchUp replays missed deltas when resuming and they are all still
+// retained, and otherwise falls back to a snapshot or a Resync
+func (c *Conn) writeCatchUp(req catchUp) error {
+	if req.resume && c.hub.history != nil {
+		deltas, ok, err := c.hub.history.DeltasSince(req.chunk.cx, req.chunk.cy, req.since)
+		if err != nil {
+			slog.Error("ws: delta history read failed", "cx", req.chunk.cx, "cy", req.chunk.cy, "err", err)
+			return err
+		}
+		if ok {
+			for _, delta := range deltas {
+				delta.Cx, delta.Cy = req.chunk.cx, req.chunk.cy
+				c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.writeDelta(delta); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if c.wantSnapshot && c.hub.snapshots != nil {
+		return c.writeSnapshot(req.chunk)
+	}
+	if req.resume {
+		c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return c.writeJSON(Resync{Type: "resync", Cx: req.chunk.cx, Cy: req.chunk.cy})
+	}
+	return nil
+}
+
+// wantsCatchUp reports whether the connection asked for a newly joined
+// chunk's snapshot or missed deltas
+func (c *Conn) wantsCatchUp(req catchUp) bool {
+	return c.catchUps != nil && (req.resume || c.wantSnapshot)
+}
+
+// requestCatchUp queues a newly joined chunk for WritePump if the
+// connection wants its state. A connection that has somehow queued more
+// than it could subscribe to is dropped rather than blocking Run.
+func (c *Conn) requestCatchUp(req catchUp) {
+	if !c.wantsCatchUp(req) {
+		return
+	}
+	select {
+	case c.catchUps <- req:
+	default:
+		c.drop()
+	}
+}
+
+// Config holds the hub's tunables. The zero value keeps the default
+// behavior of sending every delta straight to every subscriber.
+type Config struct {
+	// LagRatio is the fraction of a room's subscribers that may be lagging
+	// before the room switches to coalesced delivery. Zero disables it.
+	LagRatio float64
+	// CoalesceInterval is how often a coalescing room flushes its deltas
+	CoalesceInterval time.Duration
+	// RegisterBuffer is the capacity of the register/unregister queues.
+	// Connections block in RegisterConn once it is full.
+	RegisterBuffer int
+	// RecentDeltas is how many of each chunk's latest deltas the hub keeps
+	// in memory, replaying those published within RecentWindow to
+	// connections as they join the chunk. Zero disables it.
+	RecentDeltas int
+	RecentWindow time.Duration
+	// MaxRoomRate is how many deltas per second a room delivers one by one.
+	// Beyond it the room withholds deltas and instead sends subscribers a
+	// Resync every SnapshotInterval, so they refetch the chunk. Zero
+	// disables it.
+	MaxRoomRate      int
+	SnapshotInterval time.Duration
+	// PresenceInterval is the least time between a room's Presence
+	// updates, sent when its subscribers change. Zero disables them.
+	PresenceInterval time.Duration
+	// CompressMinBytes is the smallest frame compressed on connections
+	// that negotiated permessage-deflate; zero uses the default
+	CompressMinBytes int
+	// ActivityInterval is how often activity subscribers are sent their
+	// region's paint counts. Zero disables activity subscriptions.
+	ActivityInterval time.Duration
+	// MaxLifetime closes connections after this long with CloseReconnect,
+	// so clients rebalance across instances and pick up config changes.
+	// Each connection's is shortened by up to LifetimeJitter. Zero keeps
+	// connections open indefinitely.
+	MaxLifetime    time.Duration
+	LifetimeJitter time.Duration
+	// MaxRoomsPerConn bounds how many chunks one connection may subscribe
+	// to; further "sub"s are refused with a RoomLimit. Zero uses the
+	// default of 64.
+	MaxRoomsPerConn int
+	// PingInterval is how often connections are pinged. One that sends
+	// nothing, not even a pong, for three intervals is closed. Zero uses
+	// the default of 20s.
+	PingInterval time.Duration
+	// Backpressure is what a room does when a subscriber's send queue is
+	// full. The zero value drops the connection.
+	Backpressure BackpressurePolicy
+	// Bounds limits the chunks connections may subscribe to; "sub"s
+	// outside them are refused with an InvalidChunk. The zero value allows
+	// any chunk.
+	Bounds ChunkBounds
+}
+
+// ChunkBounds is an inclusive range of chunks
+type ChunkBounds struct {
+	MinCx, MinCy, MaxCx, MaxCy int64
+}
+
+// Contains reports whether a chunk is in the bounds. Zero bounds contain
+// every chunk.
+func (b ChunkBounds) Contains(cx, cy int64) bool {
+	if b == (ChunkBounds{}) {
+		return true
+	}
+	return cx >= b.MinCx && cx <= b.MaxCx && cy >= b.MinCy && cy <= b.MaxCy
+}
+
+// BackpressurePolicy says how a room treats a subscriber too slow to keep
+// up with its deltas
+type BackpressurePolicy int
+
+const (
+	// DropConnection closes the connection, so the client reconnects and
+	// refetches its chunks
+	DropConnection BackpressurePolicy = iota
+	// DropOldest discards the connection's oldest queued delta to make room
+	// for the newest, keeping it connected. The discarded delta's chunk is
+	// resynced so the client doesn't keep the missed tile.
+	DropOldest
+)
+
+const (
+	defaultCoalesceInterval = 250 * time.Millisecond
+	defaultRegisterBuffer   = 1024
+
+	// maxRegisterBatch bounds how many queued registrations Run applies
+	// under a single hub lock
+	maxRegisterBatch = 256
+)
+
 // Room represents a chat room for a specific chunk
 type Room struct {
 	subs map[*Conn]struct{}
 	ch   chan Delta
 	mu   sync.RWMutex
+
+	config *Config
+
+	// Coalescing state, guarded by cmu
+	cmu        sync.Mutex
+	coalescing bool
+	pending    map[int]Delta
+
+	// Rate cap state, guarded by capmu
+	capmu sync.Mutex
+	rate  rateCap
+
+	// Presence debounce state, guarded by pmu
+	pmu          sync.Mutex
+	presenceDue  bool
+	presenceSent time.Time
+}
+
+// newRoom creates an empty room using the hub's config
+func newRoom(config *Config) *Room {
+	return &Room{
+		subs:   make(map[*Conn]struct{}),
+		ch:     make(chan Delta, 256),
+		config: config,
+	}
 }
 
 // addSubscriber adds a subscriber to the room
@@ -99,85 +533,539 @@ func (r *Room) removeSubscriber(conn *Conn) {
 	delete(r.subs, conn)
 }
 
-// broadcast sends a delta to all subscribers in the room
+// removeSubscribers removes the connections send dropped, which it can't
+// itself under the read lock
+func (r *Room) removeSubscribers(conns []*Conn) {
+	if len(conns) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range conns {
+		delete(r.subs, conn)
+	}
+}
+
+// broadcast sends a delta to all subscribers in the room, withholds it
+// while the room is over its rate cap, or queues it for the next flush
+// while the room is coalescing
 func (r *Room) broadcast(delta Delta) {
+	if r.capRate(delta) || r.coalesce(delta) {
+		return
+	}
+
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	dropped := r.send(delta, nil)
+	r.mu.RUnlock()
 
+	r.removeSubscribers(dropped)
+}
+
+// send delivers a delta to every subscriber and returns dropped with the
+// connections it dropped on backpressure appended. Callers must hold mu for
+// reading, and remove those connections once they have released it.
+func (r *Room) send(delta Delta, dropped []*Conn) []*Conn {
 	for conn := range r.subs {
+		if conn.isEcho(delta) || conn.shouldShed(delta) {
+			continue
+		}
 		select {
 		case conn.send <- delta:
 		default:
-			// Drop on backpressure
-			close(conn.send)
-			delete(r.subs, conn)
+			if r.config != nil && r.config.Backpressure == DropOldest && conn.sendDroppingOldest(delta) {
+				continue
+			}
+			// Drop on backpressure, or when a discarded delta couldn't be
+			// resynced. drop is once-only, so a connection
+			// dropped by several rooms, or already leaving, is closed once.
+			conn.drop()
+			dropped = append(dropped, conn)
+		}
+	}
+	return dropped
+}
+
+// sendDroppingOldest queues a delta on a full send queue by discarding the
+// oldest queued one, resyncing its chunk. Rooms deliver concurrently, so if
+// another fills the slot first the new delta is resynced instead. It
+// reports false when a discarded delta's resync couldn't be queued either,
+// so the client would silently miss it; the caller drops the connection.
+func (c *Conn) sendDroppingOldest(delta Delta) bool {
+	c.prio.Lock()
+	defer c.prio.Unlock()
+
+	select {
+	case oldest := <-c.send:
+		if !c.queueResync(chunkRef{oldest.Cx, oldest.Cy}) {
+			return false
+		}
+	default:
+		// WritePump drained it meanwhile
+	}
+	select {
+	case c.send <- delta:
+		return true
+	default:
+		return c.queueResync(chunkRef{delta.Cx, delta.Cy})
+	}
+}
+
+// setFocus sets or clears the chunk whose deltas the connection keeps
+// receiving when it falls behind
+func (c *Conn) setFocus(chunk chunkRef, on bool) {
+	c.prio.Lock()
+	defer c.prio.Unlock()
+	c.focus, c.hasFocus = chunk, on
+}
+
+// shouldShed reports whether to skip a delta because the connection is
+// lagging and it isn't for the focus chunk. The first delta shed for a
+// chunk queues a resync for it.
+func (c *Conn) shouldShed(delta Delta) bool {
+	if !c.isLagging() {
+		return false
+	}
+
+	c.prio.Lock()
+	defer c.prio.Unlock()
+	chunk := chunkRef{delta.Cx, delta.Cy}
+	if !c.hasFocus || chunk == c.focus {
+		return false
+	}
+	// With too many resyncs pending it is delivered, so nothing goes missing
+	return c.queueResync(chunk)
+}
+
+// queueResync queues a Resync for a chunk once until WritePump sends it,
+// and reports whether one is queued. Callers must hold prio.
+func (c *Conn) queueResync(chunk chunkRef) bool {
+	if c.shed[chunk] {
+		return true
+	}
+	select {
+	case c.resyncs <- chunk:
+		if c.shed == nil {
+			c.shed = make(map[chunkRef]bool)
+		}
+		c.shed[chunk] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// isLagging reports whether a connection's send queue is at least half full
+func (c *Conn) isLagging() bool {
+	return cap(c.send) > 0 && len(c.send)*2 >= cap(c.send)
+}
+
+// measureLag returns the fraction of subscribers that are lagging
+func (r *Room) measureLag() float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.subs) == 0 {
+		return 0
+	}
+
+	lagging := 0
+	for conn := range r.subs {
+		if conn.isLagging() {
+			lagging++
+		}
+	}
+	return float64(lagging) / float64(len(r.subs))
+}
+
+// coalesce queues the delta if the room is (or should now be) coalescing.
+// It returns false when the delta should be sent immediately.
+func (r *Room) coalesce(delta Delta) bool {
+	if r.config == nil || r.config.LagRatio <= 0 {
+		return false
+	}
+
+	lag := r.measureLag()
+
+	r.cmu.Lock()
+	defer r.cmu.Unlock()
+
+	if !r.coalescing {
+		if lag <= r.config.LagRatio {
+			return false
 		}
+		r.coalescing = true
+		r.pending = make(map[int]Delta)
+		time.AfterFunc(r.coalesceInterval(), r.flush)
+	}
+
+	// Later writes to the same tile supersede earlier ones
+	if prev, ok := r.pending[delta.O]; !ok || delta.Seq > prev.Seq {
+		r.pending[delta.O] = delta
+	}
+	return true
+}
+
+// flush delivers the coalesced deltas in seq order and leaves coalescing
+// mode once the room has recovered
+func (r *Room) flush() {
+	r.cmu.Lock()
+	pending := r.pending
+	r.pending = make(map[int]Delta)
+	r.cmu.Unlock()
+
+	deltas := make([]Delta, 0, len(pending))
+	for _, delta := range pending {
+		deltas = append(deltas, delta)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Seq < deltas[j].Seq })
+
+	var dropped []*Conn
+	r.mu.RLock()
+	for _, delta := range deltas {
+		dropped = r.send(delta, dropped)
+	}
+	r.mu.RUnlock()
+	r.removeSubscribers(dropped)
+
+	lag := r.measureLag()
+
+	r.cmu.Lock()
+	defer r.cmu.Unlock()
+
+	if lag <= r.config.LagRatio && len(r.pending) == 0 {
+		r.coalescing = false
+		r.pending = nil
+		return
 	}
+	time.AfterFunc(r.coalesceInterval(), r.flush)
+}
+
+// coalesceInterval returns the configured flush interval or the default
+func (r *Room) coalesceInterval() time.Duration {
+	if r.config.CoalesceInterval > 0 {
+		return r.config.CoalesceInterval
+	}
+	return defaultCoalesceInterval
 }
 
 // Hub manages WebSocket connections and rooms
 type Hub struct {
-	mu    sync.RWMutex
-	rooms map[string]*Room
+	mu     sync.RWMutex
+	rooms  map[string]*Room
+	config Config
 
 	register   chan *Conn
 	unregister chan *Conn
+	ops        chan roomOp
+
+	// snapshots and history, when set, are read to bring newly joined
+	// connections up to date
+	snapshots SnapshotSource
+	history   HistorySource
+
+	// recent holds each chunk's latest deltas, whether or not anyone is
+	// subscribed, guarded by rmu
+	rmu    sync.Mutex
+	recent map[string]*deltaRing
+
+	// epoch is the latest canvas epoch passed to SetEpoch
+	epoch atomic.Uint64
+
+	// bus, when set, exchanges deltas with other instances. roomsChanged
+	// collects rooms created or torn down since the last syncBus, guarded
+	// by mu; busRooms is the chunks the bus is subscribed to.
+	bus          Bus
+	roomsChanged map[string]struct{}
+	busRooms     map[string]struct{}
+
+	// activity counts this instance's paints per chunk since the last
+	// Activity; activitySubs is each activity subscriber's region. Both
+	// are guarded by amu.
+	amu          sync.Mutex
+	activity     map[chunkRef]int
+	activitySubs map[*Conn]activityRegion
+
+	// done is closed by Close, ending Run and every connection's
+	// WritePump. pumps counts the WritePumps running, guarded by pmu;
+	// pumpsDone is signalled when it reaches zero.
+	done      chan struct{}
+	closeOnce sync.Once
+	pmu       sync.Mutex
+	pumps     int
+	pumpsDone *sync.Cond
 }
 
 // NewHub creates a new WebSocket hub
 func NewHub() *Hub {
-	return &Hub{
+	return NewHubWithConfig(Config{})
+}
+
+// NewHubWithConfig creates a new WebSocket hub with the given tunables
+func NewHubWithConfig(config Config) *Hub {
+	buffer := config.RegisterBuffer
+	if buffer <= 0 {
+		buffer = defaultRegisterBuffer
+	}
+	h := &Hub{
 		rooms:      make(map[string]*Room),
-		register:   make(chan *Conn),
-		unregister: make(chan *Conn),
+		config:     config,
+		register:   make(chan *Conn, buffer),
+		unregister: make(chan *Conn, buffer),
+		ops:        make(chan roomOp, buffer),
+		recent:     make(map[string]*deltaRing),
+
+		activity:     make(map[chunkRef]int),
+		activitySubs: make(map[*Conn]activityRegion),
+
+		done: make(chan struct{}),
 	}
+	h.pumpsDone = sync.NewCond(&h.pmu)
+	return h
+}
+
+// SetSnapshotSource lets connections that set ConnOptions.Snapshot receive
+// a chunk snapshot whenever they join a room. Call it before Run.
+func (h *Hub) SetSnapshotSource(src SnapshotSource) {
+	h.snapshots = src
+}
+
+// SetHistorySource lets connections that set ConnOptions.Resume replay the
+// deltas they missed. Call it before Run.
+func (h *Hub) SetHistorySource(src HistorySource) {
+	h.history = src
 }
 
-// Run starts the hub's main loop
+// Run starts the hub's main loop. Queued registrations and unregistrations
+// are applied in batches so a burst of subscribes takes the hub lock once
+// per batch instead of once per connection. It returns once Close is
+// called.
 func (h *Hub) Run() {
+	regs := make([]*Conn, 0, maxRegisterBatch)
+	unregs := make([]*Conn, 0, maxRegisterBatch)
+	ops := make([]roomOp, 0, maxRegisterBatch)
+
+	// Idle chunks' recent deltas are swept once per window
+	var prune <-chan time.Time
+	if h.config.RecentDeltas > 0 {
+		ticker := time.NewTicker(h.recentWindow())
+		defer ticker.Stop()
+		prune = ticker.C
+	}
+	if h.config.ActivityInterval > 0 {
+		go h.runActivity()
+	}
+
 	for {
 		select {
 		case conn := <-h.register:
-			h.mu.Lock()
-			roomKey := conn.roomID
-			room, exists := h.rooms[roomKey]
-			if !exists {
-				room = &Room{
-					subs: make(map[*Conn]struct{}),
-					ch:   make(chan Delta, 256),
-				}
-				h.rooms[roomKey] = room
+			regs = append(regs, conn)
+		case conn := <-h.unregister:
+			unregs = append(unregs, conn)
+		case op := <-h.ops:
+			ops = append(ops, op)
+		case <-prune:
+			h.pruneRecent()
+			continue
+		case <-h.done:
+			return
+		}
+
+	drain:
+		for len(regs)+len(unregs)+len(ops) < maxRegisterBatch {
+			select {
+			case conn := <-h.register:
+				regs = append(regs, conn)
+			case conn := <-h.unregister:
+				unregs = append(unregs, conn)
+			case op := <-h.ops:
+				ops = append(ops, op)
+			default:
+				break drain
 			}
-			h.mu.Unlock()
+		}
 
-			room.addSubscriber(conn)
+		h.applyBatch(regs, ops, unregs)
+		regs, unregs, ops = regs[:0], unregs[:0], ops[:0]
 
-		case conn := <-h.unregister:
-			h.mu.Lock()
-			roomKey := conn.roomID
-			if room, exists := h.rooms[roomKey]; exists {
-				room.removeSubscriber(conn)
-				if len(room.subs) == 0 {
-					delete(h.rooms, roomKey)
-				}
+		h.syncBus()
+	}
+}
+
+// applyBatch adds and removes subscribers, creating rooms on first use and
+// tearing them down once empty. Registrations are applied first and
+// unregistrations last, so a connection that joined and left within one
+// batch ends up removed.
+func (h *Hub) applyBatch(regs []*Conn, ops []roomOp, unregs []*Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, conn := range regs {
+		if conn.unregistered || conn.roomID == "" {
+			continue
+		}
+		if h.join(conn, conn.roomID) {
+			h.bringUpToDate(conn, catchUp{chunk: conn.chunk, resume: conn.resume, since: conn.sinceSeq})
+		}
+	}
+
+	for _, op := range ops {
+		if op.conn.unregistered {
+			continue
+		}
+		if op.join {
+			if !h.config.Bounds.Contains(op.chunk.cx, op.chunk.cy) {
+				op.conn.notify(InvalidChunk{Type: "error", Code: "INVALID_CHUNK", Cx: op.chunk.cx, Cy: op.chunk.cy})
+				continue
+			}
+			if _, in := op.conn.rooms[op.roomID]; !in && len(op.conn.rooms) >= h.maxRooms() {
+				op.conn.notify(RoomLimit{Type: "error", Code: "ROOM_LIMIT", Cx: op.chunk.cx, Cy: op.chunk.cy, Max: h.maxRooms()})
+				continue
+			}
+			if h.join(op.conn, op.roomID) {
+				h.bringUpToDate(op.conn, catchUp{chunk: op.chunk})
 			}
-			h.mu.Unlock()
+		} else {
+			h.leave(op.conn, op.roomID)
 		}
 	}
+
+	for _, conn := range unregs {
+		// Its registration may still be queued behind this batch
+		conn.unregistered = true
+		h.setActivity(conn, activityRegion{}, false)
+
+		for roomID := range conn.rooms {
+			h.leave(conn, roomID)
+		}
+	}
+}
+
+// bringUpToDate sends a newly joined connection the chunk's state: the
+// snapshot or replay it asked for, or else the chunk's recent deltas.
+// Callers must hold mu.
+func (h *Hub) bringUpToDate(conn *Conn, req catchUp) {
+	if conn.wantsCatchUp(req) {
+		conn.requestCatchUp(req)
+		return
+	}
+	h.replayRecent(conn, roomKey(req.chunk.cx, req.chunk.cy))
+}
+
+// join adds a connection to a room and reports whether it wasn't already
+// in it; callers must hold mu
+func (h *Hub) join(conn *Conn, roomID string) bool {
+	if conn.rooms == nil {
+		conn.rooms = make(map[string]struct{})
+	}
+	if _, ok := conn.rooms[roomID]; ok {
+		return false
+	}
+	if len(conn.rooms) >= h.maxRooms() {
+		return false
+	}
+	conn.rooms[roomID] = struct{}{}
+
+	room, exists := h.rooms[roomID]
+	if !exists {
+		room = newRoom(&h.config)
+		h.rooms[roomID] = room
+		h.roomChanged(roomID)
+	}
+	room.addSubscriber(conn)
+	h.presenceChanged(roomID, room)
+	return true
+}
+
+// maxRooms returns how many chunks one connection may subscribe to
+func (h *Hub) maxRooms() int {
+	if h.config.MaxRoomsPerConn > 0 {
+		return h.config.MaxRoomsPerConn
+	}
+	return defaultMaxRoomsPerConn
+}
+
+// pingInterval returns how often WritePump pings
+func (h *Hub) pingInterval() time.Duration {
+	if h.config.PingInterval > 0 {
+		return h.config.PingInterval
+	}
+	return defaultPingInterval
 }
 
-// Publish publishes a delta to a specific chunk's room
+// pongWait returns how long ReadPump waits for any frame, pongs included,
+// before closing the connection
+func (h *Hub) pongWait() time.Duration {
+	return pongWaitPings * h.pingInterval()
+}
+
+// leave removes a connection from a room and tears the room down once
+// empty; callers must hold mu
+func (h *Hub) leave(conn *Conn, roomID string) {
+	delete(conn.rooms, roomID)
+
+	room, exists := h.rooms[roomID]
+	if !exists {
+		return
+	}
+	room.removeSubscriber(conn)
+
+	room.mu.RLock()
+	empty := len(room.subs) == 0
+	room.mu.RUnlock()
+	if empty {
+		delete(h.rooms, roomID)
+		h.roomChanged(roomID)
+		return
+	}
+	h.presenceChanged(roomID, room)
+}
+
+// Publish publishes a delta to a specific chunk's room, and to other
+// instances when the hub has a bus
 func (h *Hub) Publish(cx, cy int64, delta Delta) {
-	key := fmt.Sprintf("%d:%d", cx, cy)
+	key := roomKey(cx, cy)
+	delta.Cx, delta.Cy = cx, cy
+
 	h.mu.RLock()
-	room, exists := h.rooms[key]
+	h.deliver(key, delta)
+	h.mu.RUnlock()
+	h.countActivity(cx, cy)
+
+	if h.bus != nil {
+		h.bus.Publish(delta)
+	}
+}
+
+// deliver records a delta and broadcasts it to its room. Callers hold mu
+// so a connection joining concurrently gets the delta either from the
+// recent deltas or from the room, not both.
+func (h *Hub) deliver(key string, delta Delta) {
+	h.remember(key, delta)
+	if room, exists := h.rooms[key]; exists {
+		room.broadcast(delta)
+	}
+}
+
+// IsSubscribed reports whether any connection belonging to subject is
+// subscribed to the chunk. It scans the room, so it is meant for opt-in
+// checks rather than every request.
+func (h *Hub) IsSubscribed(subject string, cx, cy int64) bool {
+	h.mu.RLock()
+	room, exists := h.rooms[roomKey(cx, cy)]
 	h.mu.RUnlock()
 
 	if !exists {
-		return
+		return false
 	}
 
-	room.broadcast(delta)
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for conn := range room.subs {
+		if conn.subject == subject {
+			return true
+		}
+	}
+	return false
 }
 
 // GetRoomCount returns the number of active rooms
@@ -199,16 +1087,131 @@ func (h *Hub) GetSubscriberCount(roomKey string) int {
 	return 0
 }
 
+// TotalSubscribers returns the number of subscriptions across all rooms; a
+// connection following several chunks counts once per chunk
+func (h *Hub) TotalSubscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	total := 0
+	for _, room := range h.rooms {
+		room.mu.RLock()
+		total += len(room.subs)
+		room.mu.RUnlock()
+	}
+	return total
+}
+
+// RoomStat is a point-in-time count of a room's subscribers
+type RoomStat struct {
+	RoomID      string `json:"roomId"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// ListRooms returns every active room's subscriber count, sorted by room
+// ID. Unlike DebugStats it doesn't measure lag, so it is cheap enough to
+// poll.
+func (h *Hub) ListRooms() []RoomStat {
+	h.mu.RLock()
+	stats := make([]RoomStat, 0, len(h.rooms))
+	for key, room := range h.rooms {
+		room.mu.RLock()
+		stats = append(stats, RoomStat{RoomID: key, Subscribers: len(room.subs)})
+		room.mu.RUnlock()
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].RoomID < stats[j].RoomID })
+	return stats
+}
+
+// RoomDebug is a point-in-time view of a room's delivery health
+type RoomDebug struct {
+	RoomID      string  `json:"roomId"`
+	Subscribers int     `json:"subscribers"`
+	LagRatio    float64 `json:"lagRatio"`
+	Coalescing  bool    `json:"coalescing"`
+}
+
+// DebugStats returns delivery health for every active room
+func (h *Hub) DebugStats() []RoomDebug {
+	h.mu.RLock()
+	rooms := make(map[string]*Room, len(h.rooms))
+	for key, room := range h.rooms {
+		rooms[key] = room
+	}
+	h.mu.RUnlock()
+
+	stats := make([]RoomDebug, 0, len(rooms))
+	for key, room := range rooms {
+		lag := room.measureLag()
+
+		room.mu.RLock()
+		subs := len(room.subs)
+		room.mu.RUnlock()
+
+		room.cmu.Lock()
+		coalescing := room.coalescing
+		room.cmu.Unlock()
+
+		stats = append(stats, RoomDebug{
+			RoomID:      key,
+			Subscribers: subs,
+			LagRatio:    lag,
+			Coalescing:  coalescing,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].RoomID < stats[j].RoomID })
+	return stats
+}
+
 // RegisterConn registers a new connection with a room ID
 func (h *Hub) RegisterConn(ws *websocket.Conn, cx, cy int64) *Conn {
-	conn := &Conn{
-		ws:     ws,
-		send:   make(chan Delta, 256),
-		hub:    h,
-		roomID: fmt.Sprintf("%d:%d", cx, cy),
-	}
+	return h.RegisterConnWithOptions(ws, cx, cy, ConnOptions{})
+}
+
+// RegisterConnWithOptions registers a new connection with per-connection
+// subscription settings
+func (h *Hub) RegisterConnWithOptions(ws *websocket.Conn, cx, cy int64, opts ConnOptions) *Conn {
+	conn := newConn(h, ws, opts)
+	conn.roomID = roomKey(cx, cy)
+	conn.chunk = chunkRef{cx, cy}
+
+	h.queueRegister(conn)
+
+	return conn
+}
+
+// Connect registers a connection that starts with no subscriptions; the
+// client joins chunks with "sub" control messages
+func (h *Hub) Connect(ws *websocket.Conn, opts ConnOptions) *Conn {
+	conn := newConn(h, ws, opts)
 
-	h.register <- conn
+	h.queueRegister(conn)
 
 	return conn
 }
+
+// newConn creates a connection that has not joined any room
+func newConn(h *Hub, ws *websocket.Conn, opts ConnOptions) *Conn {
+	conn := &Conn{
+		ws:           ws,
+		send:         make(chan Delta, 256),
+		hub:          h,
+		dropped:      make(chan struct{}),
+		subject:      opts.Subject,
+		clientID:     opts.ClientID,
+		suppressEcho: opts.SuppressEcho,
+		binaryDeltas: opts.BinaryDeltas,
+		wantSnapshot: opts.Snapshot,
+		resume:       opts.Resume,
+		sinceSeq:     opts.SinceSeq,
+		resyncs:      make(chan chunkRef, h.maxRooms()),
+		epochs:       make(chan struct{}, 1),
+		notices:      make(chan any, noticeBuffer),
+		presence:     make(chan chunkRef, h.maxRooms()),
+	}
+	if opts.Snapshot || opts.Resume {
+		conn.catchUps = make(chan catchUp, h.maxRooms())
+	}
+	return conn
+}
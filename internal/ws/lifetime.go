@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseReconnect is the close code sent to a connection that reached its
+// maximum lifetime, or whose server is shutting down. Clients should
+// reconnect at once, usually reaching another instance, rather than back
+// off as after an error.
+const CloseReconnect = websocket.CloseServiceRestart
+
+// Reconnect is sent just before a connection is closed with CloseReconnect,
+// for clients that can't see close codes. It is sent as a JSON text frame.
+type Reconnect struct {
+	Type string `json:"type"` // always "reconnect"
+}
+
+// lifetime picks how long a new connection may stay open: MaxLifetime less
+// up to LifetimeJitter, at most half of it, so connections opened together
+// don't all reconnect together. Zero means no limit.
+func (h *Hub) lifetime() time.Duration {
+	lifetime := h.config.MaxLifetime
+	if lifetime <= 0 {
+		return 0
+	}
+	if jitter := min(h.config.LifetimeJitter, lifetime/2); jitter > 0 {
+		lifetime -= time.Duration(rand.Int63n(int64(jitter) + 1))
+	}
+	return lifetime
+}
+
+// writeReconnect tells the client to reconnect and closes the connection
+func (c *Conn) writeReconnect(reason string) {
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if c.writeJSON(Reconnect{Type: "reconnect"}) != nil {
+		return
+	}
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseReconnect, reason))
+}
@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaskChange tells clients that tiles in a rectangle, inclusive and in
+// global tile coordinates, were opened or closed to painting. It is sent
+// as a JSON text frame.
+type MaskChange struct {
+	Type    string `json:"type"` // always "mask"
+	MinX    int64  `json:"minX"`
+	MinY    int64  `json:"minY"`
+	MaxX    int64  `json:"maxX"`
+	MaxY    int64  `json:"maxY"`
+	Allowed bool   `json:"allowed"`
+}
+
+// BackgroundChange tells clients a chunk's unpainted tiles are now drawn in
+// another color; 0 means they are drawn as unpainted again. It is sent as a
+// JSON text frame.
+type BackgroundChange struct {
+	Type  string `json:"type"` // always "background"
+	Cx    int64  `json:"cx"`
+	Cy    int64  `json:"cy"`
+	Color uint8  `json:"color"`
+}
+
+// PaletteChange tells clients a chunk's colors are now drawn from another
+// palette; an empty PaletteID means the default. It is sent as a JSON text
+// frame.
+type PaletteChange struct {
+	Type      string `json:"type"` // always "palette"
+	Cx        int64  `json:"cx"`
+	Cy        int64  `json:"cy"`
+	PaletteID string `json:"paletteId"`
+}
+
+// RegionFill tells clients every tile in a rectangle of a chunk, in tile
+// coordinates within it and inclusive, was painted Color at Seq. It stands
+// in for a delta per tile. Notices and deltas are queued separately, so a
+// client that has already applied a later seq should refetch the chunk
+// rather than apply the fill over it. It is sent as a JSON text frame.
+type RegionFill struct {
+	Type  string `json:"type"` // always "fill"
+	Cx    int64  `json:"cx"`
+	Cy    int64  `json:"cy"`
+	Seq   uint64 `json:"seq"`
+	Ts    int64  `json:"ts"`
+	MinX  int    `json:"minX"`
+	MinY  int    `json:"minY"`
+	MaxX  int    `json:"maxX"`
+	MaxY  int    `json:"maxY"`
+	Color uint8  `json:"color"`
+}
+
+// RoomLimit tells a client its "sub" for a chunk was refused because the
+// connection already follows Max chunks; its other subscriptions carry on.
+// It is sent as a JSON text frame.
+type RoomLimit struct {
+	Type string `json:"type"` // always "error"
+	Code string `json:"code"` // always "ROOM_LIMIT"
+	Cx   int64  `json:"cx"`
+	Cy   int64  `json:"cy"`
+	Max  int    `json:"max"`
+}
+
+// InvalidChunk tells a client its "sub" for a chunk outside Config.Bounds
+// was refused. It is sent as a JSON text frame.
+type InvalidChunk struct {
+	Type string `json:"type"` // always "error"
+	Code string `json:"code"` // always "INVALID_CHUNK"
+	Cx   int64  `json:"cx"`
+	Cy   int64  `json:"cy"`
+}
+
+// noticeBuffer is how many notices a connection may have queued before
+// it is dropped
+const noticeBuffer = 16
+
+// NotifyChunks sends a notice, such as a MaskChange, to every connection
+// subscribed to a chunk in the inclusive range, once per connection, on
+// this instance and through the bus on the others
+func (h *Hub) NotifyChunks(minCx, minCy, maxCx, maxCy int64, notice any) {
+	h.mu.RLock()
+	h.notifyChunks(minCx, minCy, maxCx, maxCy, notice)
+	h.mu.RUnlock()
+
+	h.publishNotice(minCx, minCy, maxCx, maxCy, false, notice)
+}
+
+// notifyChunks sends a notice to this hub's connections subscribed to a
+// chunk in the range. Callers hold mu.
+func (h *Hub) notifyChunks(minCx, minCy, maxCx, maxCy int64, notice any) {
+	notified := make(map[*Conn]bool)
+	for roomID, room := range h.rooms {
+		var cx, cy int64
+		if _, err := fmt.Sscanf(roomID, "%d:%d", &cx, &cy); err != nil {
+			continue
+		}
+		if cx < minCx || cx > maxCx || cy < minCy || cy > maxCy {
+			continue
+		}
+
+		room.mu.RLock()
+		for conn := range room.subs {
+			if notified[conn] {
+				continue
+			}
+			notified[conn] = true
+			conn.notify(notice)
+		}
+		room.mu.RUnlock()
+	}
+}
+
+// PublishFill sends a fill to the chunk's subscribers, here and on other
+// instances. The chunk's recent deltas predate it, so every instance
+// forgets them rather than replay them to clients joining afterwards.
+func (h *Hub) PublishFill(fill RegionFill) {
+	fill.Type = "fill"
+	key := roomKey(fill.Cx, fill.Cy)
+
+	h.mu.RLock()
+	h.rmu.Lock()
+	delete(h.recent, key)
+	h.rmu.Unlock()
+
+	if room, exists := h.rooms[key]; exists {
+		room.mu.RLock()
+		for conn := range room.subs {
+			conn.notify(fill)
+		}
+		room.mu.RUnlock()
+	}
+	h.mu.RUnlock()
+
+	h.publishNotice(fill.Cx, fill.Cy, fill.Cx, fill.Cy, true, fill)
+}
+
+// notify queues a notice for WritePump, dropping a connection too far
+// behind to take it
+func (c *Conn) notify(notice any) {
+	select {
+	case c.notices <- notice:
+	default:
+		c.drop()
+	}
+}
+
+// writeNotice sends a queued notice
+func (c *Conn) writeNotice(notice any) error {
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.writeJSON(notice)
+}
@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+)
+
+// Presence tells a chunk's subscribers how many connections to this
+// instance are watching it. It is sent as a JSON text frame, like the other
+// notices; deltas carry no type, so clients tell them apart by it.
+type Presence struct {
+	Type  string `json:"type"` // always "presence"
+	Cx    int64  `json:"cx"`
+	Cy    int64  `json:"cy"`
+	Count int    `json:"count"`
+}
+
+// presenceChanged schedules a presence update for a room whose subscribers
+// changed: at once if it hasn't sent one within Config.PresenceInterval,
+// otherwise when the interval is up. Changes in between fold into that one
+// update. Callers must hold mu.
+func (h *Hub) presenceChanged(roomID string, room *Room) {
+	if h.config.PresenceInterval <= 0 {
+		return
+	}
+
+	room.pmu.Lock()
+	defer room.pmu.Unlock()
+	if room.presenceDue {
+		return
+	}
+	room.presenceDue = true
+	wait := max(h.config.PresenceInterval-time.Since(room.presenceSent), 0)
+	time.AfterFunc(wait, func() { h.sendPresence(roomID, room) })
+}
+
+// sendPresence queues a presence update on each of the room's subscribers,
+// unless the room has since been torn down. Each connection reads the count
+// as it writes the update, so it is never staler than the frame.
+func (h *Hub) sendPresence(roomID string, room *Room) {
+	room.pmu.Lock()
+	room.presenceDue = false
+	room.presenceSent = time.Now()
+	room.pmu.Unlock()
+
+	var chunk chunkRef
+	if _, err := fmt.Sscanf(roomID, "%d:%d", &chunk.cx, &chunk.cy); err != nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.rooms[roomID] != room {
+		return
+	}
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for conn := range room.subs {
+		conn.notifyPresence(chunk)
+	}
+}
+
+// notifyPresence queues a presence update for one of the connection's
+// chunks. Presence is advisory, so a connection too far behind to take it
+// misses it rather than being dropped.
+func (c *Conn) notifyPresence(chunk chunkRef) {
+	select {
+	case c.presence <- chunk:
+	default:
+	}
+}
+
+// writePresence sends a chunk's current subscriber count
+func (c *Conn) writePresence(chunk chunkRef) error {
+	count := c.hub.GetSubscriberCount(roomKey(chunk.cx, chunk.cy))
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.writeJSON(Presence{Type: "presence", Cx: chunk.cx, Cy: chunk.cy, Count: count})
+}
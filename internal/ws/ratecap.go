@@ -0,0 +1,89 @@
+package ws
+
+import "time"
+
+// defaultSnapshotInterval is how often a capped room signals its
+// subscribers when Config.SnapshotInterval is unset
+const defaultSnapshotInterval = time.Second
+
+// rateCap switches a room from deltas to periodic refetch signals while it
+// publishes faster than Config.MaxRoomRate
+type rateCap struct {
+	windowStart time.Time
+	windowCount int
+
+	capped bool
+	chunk  chunkRef
+	// missed counts deltas withheld since the last signal
+	missed int
+}
+
+// capRate counts a delta against the room's rate and reports whether it
+// should be withheld because the room is over its cap
+func (r *Room) capRate(delta Delta) bool {
+	if r.config == nil || r.config.MaxRoomRate <= 0 {
+		return false
+	}
+
+	r.capmu.Lock()
+	defer r.capmu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.rate.windowStart) >= time.Second {
+		r.rate.windowStart, r.rate.windowCount = now, 0
+	}
+	r.rate.windowCount++
+
+	if !r.rate.capped {
+		if r.rate.windowCount <= r.config.MaxRoomRate {
+			return false
+		}
+		r.rate.capped = true
+		r.rate.chunk = chunkRef{delta.Cx, delta.Cy}
+		time.AfterFunc(r.snapshotInterval(), r.signalSnapshot)
+	}
+	r.rate.missed++
+	return true
+}
+
+// signalSnapshot tells every subscriber to refetch the chunk if deltas were
+// withheld since the last signal, and lifts the cap once the room's rate
+// over the interval is back under it. The signal is sent under capmu, so a
+// delta published after it is delivered rather than withheld.
+func (r *Room) signalSnapshot() {
+	r.capmu.Lock()
+	defer r.capmu.Unlock()
+
+	interval := r.snapshotInterval()
+	missed := r.rate.missed
+	r.rate.missed = 0
+
+	if missed > 0 {
+		r.mu.RLock()
+		for conn := range r.subs {
+			select {
+			case conn.resyncs <- r.rate.chunk:
+			default:
+				// One is already pending for this connection
+			}
+		}
+		r.mu.RUnlock()
+	}
+
+	// The quiet interval stands in for the window, so the burst that
+	// triggered the cap doesn't count again
+	if float64(missed) <= float64(r.config.MaxRoomRate)*interval.Seconds() {
+		r.rate.capped = false
+		r.rate.windowStart, r.rate.windowCount = time.Now(), 0
+		return
+	}
+	time.AfterFunc(interval, r.signalSnapshot)
+}
+
+// snapshotInterval returns the configured signal interval or the default
+func (r *Room) snapshotInterval() time.Duration {
+	if r.config.SnapshotInterval > 0 {
+		return r.config.SnapshotInterval
+	}
+	return defaultSnapshotInterval
+}
@@ -0,0 +1,127 @@
+package ws
+
+import "time"
+
+// defaultRecentWindow is how long a delta stays eligible for replay when
+// Config.RecentWindow is unset
+const defaultRecentWindow = 5 * time.Second
+
+// recentDelta is a published delta and when the hub saw it
+type recentDelta struct {
+	delta Delta
+	at    time.Time
+}
+
+// deltaRing holds a chunk's latest deltas in publish order, overwriting the
+// oldest once full
+type deltaRing struct {
+	entries []recentDelta
+	start   int
+	n       int
+}
+
+func newDeltaRing(size int) *deltaRing {
+	return &deltaRing{entries: make([]recentDelta, size)}
+}
+
+// push appends a delta, evicting the oldest if the ring is full
+func (r *deltaRing) push(entry recentDelta) {
+	if r.n < len(r.entries) {
+		r.entries[(r.start+r.n)%len(r.entries)] = entry
+		r.n++
+		return
+	}
+	r.entries[r.start] = entry
+	r.start = (r.start + 1) % len(r.entries)
+}
+
+// since returns the deltas seen after cutoff, oldest first
+func (r *deltaRing) since(cutoff time.Time) []Delta {
+	var deltas []Delta
+	for i := 0; i < r.n; i++ {
+		entry := r.entries[(r.start+i)%len(r.entries)]
+		if entry.at.After(cutoff) {
+			deltas = append(deltas, entry.delta)
+		}
+	}
+	return deltas
+}
+
+// newest returns when the latest delta was seen
+func (r *deltaRing) newest() time.Time {
+	if r.n == 0 {
+		return time.Time{}
+	}
+	return r.entries[(r.start+r.n-1)%len(r.entries)].at
+}
+
+// recentWindow returns the configured replay window or the default
+func (h *Hub) recentWindow() time.Duration {
+	if h.config.RecentWindow > 0 {
+		return h.config.RecentWindow
+	}
+	return defaultRecentWindow
+}
+
+// remember records a published delta in its chunk's ring
+func (h *Hub) remember(roomID string, delta Delta) {
+	if h.config.RecentDeltas <= 0 {
+		return
+	}
+
+	h.rmu.Lock()
+	defer h.rmu.Unlock()
+	ring, ok := h.recent[roomID]
+	if !ok {
+		ring = newDeltaRing(h.config.RecentDeltas)
+		h.recent[roomID] = ring
+	}
+	ring.push(recentDelta{delta: delta, at: time.Now()})
+}
+
+// replayRecent queues a chunk's recent deltas for a connection that just
+// joined it; callers must hold mu so no broadcast interleaves. A tile's
+// deltas only make sense together, so if the connection's queue can't take
+// all of them it gets none.
+func (h *Hub) replayRecent(conn *Conn, roomID string) {
+	if h.config.RecentDeltas <= 0 {
+		return
+	}
+
+	h.rmu.Lock()
+	ring, ok := h.recent[roomID]
+	var deltas []Delta
+	if ok {
+		deltas = ring.since(time.Now().Add(-h.recentWindow()))
+	}
+	h.rmu.Unlock()
+
+	if len(deltas) == 0 || cap(conn.send)-len(conn.send) < len(deltas) {
+		return
+	}
+	for _, delta := range deltas {
+		if conn.isEcho(delta) {
+			continue
+		}
+		select {
+		case conn.send <- delta:
+		default:
+			// A coalescing flush filled the queue meanwhile
+			conn.drop()
+			return
+		}
+	}
+}
+
+// pruneRecent forgets chunks with nothing published within the window
+func (h *Hub) pruneRecent() {
+	cutoff := time.Now().Add(-h.recentWindow())
+
+	h.rmu.Lock()
+	defer h.rmu.Unlock()
+	for roomID, ring := range h.recent {
+		if !ring.newest().After(cutoff) {
+			delete(h.recent, roomID)
+		}
+	}
+}
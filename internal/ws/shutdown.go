@@ -0,0 +1,48 @@
+package ws
+
+// Close stops Run and tells every connection to reconnect, as with
+// CloseReconnect, then waits for their WritePumps to close them. It is
+// meant for after the HTTP server has shut down: connections registered
+// later are dropped, and deltas published later go nowhere.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+
+	h.pmu.Lock()
+	defer h.pmu.Unlock()
+	for h.pumps > 0 {
+		h.pumpsDone.Wait()
+	}
+}
+
+// pumpStarted and pumpStopped count a WritePump for Close to wait on
+func (h *Hub) pumpStarted() {
+	h.pmu.Lock()
+	h.pumps++
+	h.pmu.Unlock()
+}
+
+func (h *Hub) pumpStopped() {
+	h.pmu.Lock()
+	if h.pumps--; h.pumps == 0 {
+		h.pumpsDone.Broadcast()
+	}
+	h.pmu.Unlock()
+}
+
+// queueRegister queues a connection for Run, dropping it instead if the
+// hub has closed
+func (h *Hub) queueRegister(conn *Conn) {
+	select {
+	case h.register <- conn:
+	case <-h.done:
+		conn.drop()
+	}
+}
+
+// queueOp queues a subscription change for Run, unless the hub has closed
+func (h *Hub) queueOp(op roomOp) {
+	select {
+	case h.ops <- op:
+	case <-h.done:
+	}
+}
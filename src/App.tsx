@@ -4,9 +4,9 @@ import L from 'leaflet';
 import 'leaflet/dist/leaflet.css';
 import './App.css';
 import { latLonToTileXY, chunkOf, offsetOf, tileXYToLatLon, tileBounds } from './utils/coords';
-import { getNibble, setNibble, createEmptyChunk, CHUNK_SIZE } from './utils/nibbles';
+import { getNibble, setNibble, fillRect, createEmptyChunk, CHUNK_SIZE } from './utils/nibbles';
 import { fetchChunk, paintTile as apiPaintTile } from './api/client';
-import { ChunkWebSocketManager, Delta } from './api/websocket';
+import { ChunkWebSocketManager, Delta, RegionFill } from './api/websocket';
 
 // Fix for default markers in React Leaflet
 import markerIcon2x from 'leaflet/dist/images/marker-icon-2x.png';
@@ -484,15 +484,10 @@ const App: React.FC = () => {
     );
   }, []);
 
-  // Load chunk data from backend
-  const loadChunk = useCallback(async (cx: number, cy: number) => {
+  // Fetch chunk data from backend, replacing any already loaded
+  const fetchAndStoreChunk = useCallback(async (cx: number, cy: number) => {
     const key = chunkKey(cx, cy);
     
-    // Skip if already loaded
-    if (loadedChunks.has(key)) {
-      return;
-    }
-    
     setLoadingChunk(true);
     try {
       const { data, seq } = await fetchChunk(cx, cy);
@@ -542,7 +537,17 @@ const App: React.FC = () => {
     } finally {
       setLoadingChunk(false);
     }
-  }, [loadedChunks]);
+  }, []);
+
+  // Load chunk data from backend
+  const loadChunk = useCallback(async (cx: number, cy: number) => {
+    // Skip if already loaded
+    if (loadedChunks.has(chunkKey(cx, cy))) {
+      return;
+    }
+    
+    await fetchAndStoreChunk(cx, cy);
+  }, [loadedChunks, fetchAndStoreChunk]);
 
   // Handle delta updates from WebSocket
   const handleDelta = useCallback((cx: number, cy: number, delta: Delta) => {
@@ -594,6 +599,61 @@ const App: React.FC = () => {
     });
   }, []);
 
+  // Handle region fills from WebSocket
+  const handleFill = useCallback((cx: number, cy: number, fill: RegionFill, stale: boolean) => {
+    // A later delta was already applied, which the fill would paint over
+    if (stale) {
+      fetchAndStoreChunk(cx, cy);
+      return;
+    }
+    
+    const key = chunkKey(cx, cy);
+    
+    // Update chunk data
+    setLoadedChunks(prev => {
+      const chunk = prev.get(key);
+      if (!chunk || fill.seq <= chunk.seq) {
+        return prev; // not loaded, or loaded since the fill
+      }
+      
+      const newData = new Uint8Array(chunk.data);
+      fillRect(newData, fill.minX, fill.minY, fill.maxX, fill.maxY, fill.color);
+      
+      const updated = new Map(prev);
+      updated.set(key, { ...chunk, data: newData, seq: fill.seq });
+      return updated;
+    });
+    
+    // Update painted tiles
+    setPaintedTiles(prev => {
+      const updated = new Map(prev);
+      
+      for (let localY = fill.minY; localY <= fill.maxY; localY++) {
+        for (let localX = fill.minX; localX <= fill.maxX; localX++) {
+          const x = cx * CHUNK_SIZE + localX;
+          const y = cy * CHUNK_SIZE + localY;
+          const tileKey = `${x}_${y}`;
+          
+          if (fill.color === 0) {
+            updated.delete(tileKey);
+          } else {
+            const { lat, lon: lng } = tileXYToLatLon(x, y);
+            updated.set(tileKey, {
+              x,
+              y,
+              lat,
+              lng,
+              color: colorIndexToHex(fill.color),
+              colorIndex: fill.color,
+            });
+          }
+        }
+      }
+      
+      return updated;
+    });
+  }, [fetchAndStoreChunk]);
+
   // Handle viewport changes and manage chunk loading/subscriptions
   const handleViewportChange = useCallback((chunks: Array<{ cx: number; cy: number }>) => {
     const newVisibleChunks = new Set(chunks.map(c => chunkKey(c.cx, c.cy)));
@@ -630,7 +690,9 @@ const App: React.FC = () => {
           () => {
             console.log(`WebSocket opened for chunk (${cx}, ${cy})`);
             setWsConnected(true);
-          }
+          },
+          undefined,
+          (fill, stale) => handleFill(cx, cy, fill, stale)
         );
       }
     });
@@ -651,7 +713,7 @@ const App: React.FC = () => {
       // Cleanup: unsubscribe from all on unmount
       manager.unsubscribeAll();
     };
-  }, [visibleChunks, handleDelta, loadedChunks]);
+  }, [visibleChunks, handleDelta, handleFill, loadedChunks]);
 
   // Unload chunks that are far outside the viewport to save memory
   useEffect(() => {
@@ -1,5 +1,11 @@
 // API client for backend communication
 
+import {
+  PROTOBUF_CONTENT_TYPE,
+  decodePaintResponse,
+  encodePaintRequest,
+} from './protobuf';
+
 const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080';
 
 export interface PaintRequest {
@@ -10,6 +16,7 @@ export interface PaintRequest {
   o: number;
   color: number;
   turnstileToken: string;
+  clientId?: string;
 }
 
 export interface PaintResponse {
@@ -18,6 +25,35 @@ export interface PaintResponse {
   ts: number;
 }
 
+export type WireFormat = 'json' | 'protobuf';
+
+/**
+ * Thrown by paintTile while the client is in cooldown
+ */
+export class CooldownError extends Error {
+  constructor(public retryAfterMs: number) {
+    super('Cooldown: Please wait before painting again');
+    this.name = 'CooldownError';
+  }
+}
+
+/**
+ * Body of a failed request: {"ok":false,"error":{"code":"...","message":"..."}}
+ */
+interface ErrorBody {
+  error?: { code?: string; message?: string };
+  retryAfterMs?: number;
+}
+
+function parseErrorBody(text: string): ErrorBody {
+  try {
+    return JSON.parse(text);
+  } catch {
+    // Older servers reply with plain text
+    return {};
+  }
+}
+
 export interface ChunkData {
   data: Uint8Array;
   seq: number;
@@ -50,36 +86,46 @@ export async function fetchChunk(cx: number, cy: number): Promise<ChunkData> {
 /**
  * Paint a tile on the backend
  * @param request Paint request
+ * @param format Wire format for the request and response (JSON by default)
  * @returns Paint response
  */
-export async function paintTile(request: PaintRequest): Promise<PaintResponse> {
+export async function paintTile(
+  request: PaintRequest,
+  format: WireFormat = 'json'
+): Promise<PaintResponse> {
   const url = `${API_BASE_URL}/paint`;
   
   const response = await fetch(url, {
     method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-    },
-    body: JSON.stringify(request),
+    headers: format === 'protobuf'
+      ? { 'Content-Type': PROTOBUF_CONTENT_TYPE, Accept: PROTOBUF_CONTENT_TYPE }
+      : { 'Content-Type': 'application/json' },
+    body: format === 'protobuf' ? encodePaintRequest(request) : JSON.stringify(request),
   });
   
   if (!response.ok) {
     const errorText = await response.text();
+    const body = parseErrorBody(errorText);
+    const message = body.error?.message || errorText;
     
     // Handle specific error codes
     if (response.status === 429) {
-      throw new Error('Cooldown: Please wait before painting again');
+      throw new CooldownError(body.retryAfterMs || 0);
     } else if (response.status === 403) {
       throw new Error('Geofence: Outside allowed area or speed limit exceeded');
     } else if (response.status === 401) {
       throw new Error('Turnstile verification failed');
     } else if (response.status === 400) {
-      throw new Error(`Invalid request: ${errorText}`);
+      throw new Error(`Invalid request: ${message}`);
     } else {
-      throw new Error(`Failed to paint tile: ${errorText}`);
+      throw new Error(`Failed to paint tile: ${message}`);
     }
   }
   
+  if (format === 'protobuf') {
+    return decodePaintResponse(new Uint8Array(await response.arrayBuffer()));
+  }
+  
   return await response.json();
 }
 
@@ -0,0 +1,176 @@
+// Minimal protobuf codec for the messages in internal/api/pb/paint.proto.
+// Hand-written to avoid pulling a protobuf runtime into the web bundle.
+
+import type { PaintRequest, PaintResponse } from './client';
+
+export const PROTOBUF_CONTENT_TYPE = 'application/x-protobuf';
+
+const WIRE_VARINT = 0;
+const WIRE_FIXED64 = 1;
+const WIRE_BYTES = 2;
+const WIRE_FIXED32 = 5;
+
+const TWO_POW_32 = 4294967296;
+
+class Writer {
+  private bytes: number[] = [];
+
+  private tag(field: number, wireType: number): void {
+    this.varint32(((field << 3) | wireType) >>> 0);
+  }
+
+  private varint32(v: number): void {
+    while (v > 127) {
+      this.bytes.push((v & 0x7f) | 0x80);
+      v >>>= 7;
+    }
+    this.bytes.push(v);
+  }
+
+  // Encodes a signed integer (up to 2^53) as a 64-bit two's complement varint
+  int64(field: number, v: number): void {
+    if (v === 0) return;
+    this.tag(field, WIRE_VARINT);
+    let lo = Math.abs(v) >>> 0;
+    let hi = Math.floor(Math.abs(v) / TWO_POW_32) >>> 0;
+    if (v < 0) {
+      lo = (~lo + 1) >>> 0;
+      hi = (~hi + (lo === 0 ? 1 : 0)) >>> 0;
+    }
+    while (hi > 0 || lo > 127) {
+      this.bytes.push((lo & 0x7f) | 0x80);
+      lo = ((lo >>> 7) | (hi << 25)) >>> 0;
+      hi >>>= 7;
+    }
+    this.bytes.push(lo);
+  }
+
+  double(field: number, v: number): void {
+    if (v === 0) return;
+    this.tag(field, WIRE_FIXED64);
+    const view = new DataView(new ArrayBuffer(8));
+    view.setFloat64(0, v, true);
+    for (let i = 0; i < 8; i++) {
+      this.bytes.push(view.getUint8(i));
+    }
+  }
+
+  string(field: number, v: string): void {
+    if (!v) return;
+    const encoded = new TextEncoder().encode(v);
+    this.tag(field, WIRE_BYTES);
+    this.varint32(encoded.length);
+    for (let i = 0; i < encoded.length; i++) {
+      this.bytes.push(encoded[i]);
+    }
+  }
+
+  finish(): Uint8Array {
+    return new Uint8Array(this.bytes);
+  }
+}
+
+class Reader {
+  private pos = 0;
+
+  constructor(private buf: Uint8Array) {}
+
+  done(): boolean {
+    return this.pos >= this.buf.length;
+  }
+
+  // Reads a varint as a signed 64-bit integer (exact up to 2^53)
+  varint(): number {
+    let lo = 0;
+    let hi = 0;
+    let shift = 0;
+    for (;;) {
+      if (this.pos >= this.buf.length) {
+        throw new Error('protobuf: truncated varint');
+      }
+      const b = this.buf[this.pos++];
+      if (shift < 28) {
+        lo |= (b & 0x7f) << shift;
+      } else if (shift === 28) {
+        lo |= (b & 0x0f) << 28;
+        hi |= (b & 0x7f) >> 4;
+      } else {
+        hi |= (b & 0x7f) << (shift - 32);
+      }
+      shift += 7;
+      if ((b & 0x80) === 0) break;
+    }
+    lo >>>= 0;
+    hi >>>= 0;
+    if (hi & 0x80000000) {
+      return -((~hi >>> 0) * TWO_POW_32 + (~lo >>> 0) + 1);
+    }
+    return hi * TWO_POW_32 + lo;
+  }
+
+  skip(wireType: number): void {
+    switch (wireType) {
+      case WIRE_VARINT:
+        this.varint();
+        return;
+      case WIRE_FIXED64:
+        this.pos += 8;
+        return;
+      case WIRE_BYTES:
+        this.pos += this.varint();
+        return;
+      case WIRE_FIXED32:
+        this.pos += 4;
+        return;
+      default:
+        throw new Error(`protobuf: unsupported wire type ${wireType}`);
+    }
+  }
+}
+
+/**
+ * Encode a paint request as a splat.v1.PaintRequest message
+ */
+export function encodePaintRequest(request: PaintRequest): Uint8Array {
+  const w = new Writer();
+  w.double(1, request.lat);
+  w.double(2, request.lon);
+  w.int64(3, request.cx);
+  w.int64(4, request.cy);
+  w.int64(5, request.o);
+  w.int64(6, request.color);
+  w.string(7, request.turnstileToken);
+  w.string(8, request.clientId || '');
+  return w.finish();
+}
+
+/**
+ * Decode a splat.v1.PaintResponse message
+ */
+export function decodePaintResponse(buf: Uint8Array): PaintResponse {
+  const r = new Reader(buf);
+  const response: PaintResponse = { ok: false, seq: 0, ts: 0 };
+  while (!r.done()) {
+    const tag = r.varint();
+    const field = tag >>> 3;
+    const wireType = tag & 7;
+    if (wireType !== WIRE_VARINT) {
+      r.skip(wireType);
+      continue;
+    }
+    switch (field) {
+      case 1:
+        response.ok = r.varint() !== 0;
+        break;
+      case 2:
+        response.seq = r.varint();
+        break;
+      case 3:
+        response.ts = r.varint();
+        break;
+      default:
+        r.skip(wireType);
+    }
+  }
+  return response;
+}
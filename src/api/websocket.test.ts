@@ -0,0 +1,101 @@
+import { ChunkWebSocket, Delta, RegionFill } from './websocket';
+import { createEmptyChunk, fillRect, getNibble } from '../utils/nibbles';
+
+// Stands in for the browser's WebSocket, keeping the last one opened
+class FakeWebSocket {
+  static last: FakeWebSocket;
+  binaryType = '';
+  onopen: (() => void) | null = null;
+  onmessage: ((event: { data: unknown }) => void) | null = null;
+  onerror: ((event: Event) => void) | null = null;
+  onclose: (() => void) | null = null;
+
+  constructor(public url: string) {
+    FakeWebSocket.last = this;
+  }
+
+  close(): void {}
+
+  receive(message: object): void {
+    this.onmessage!({ data: JSON.stringify(message) });
+  }
+}
+
+const fill: RegionFill = {
+  type: 'fill', cx: 3, cy: 4, seq: 5, ts: 1730000000,
+  minX: 1, minY: 2, maxX: 3, maxY: 4, color: 6,
+};
+
+function connect() {
+  const deltas: Delta[] = [];
+  const fills: Array<{ fill: RegionFill; stale: boolean }> = [];
+  const ws = new ChunkWebSocket(
+    3, 4,
+    (delta) => deltas.push(delta),
+    undefined, undefined, undefined, undefined,
+    (received, stale) => fills.push({ fill: received, stale })
+  );
+  ws.connect();
+  return { socket: FakeWebSocket.last, deltas, fills };
+}
+
+beforeEach(() => {
+  (global as any).WebSocket = FakeWebSocket;
+  jest.spyOn(console, 'log').mockImplementation(() => {});
+});
+
+afterEach(() => {
+  jest.restoreAllMocks();
+});
+
+test('fills are passed on rather than treated as deltas', () => {
+  const { socket, deltas, fills } = connect();
+
+  socket.receive({ seq: 4, o: 9, color: 2, ts: 0, cx: 3, cy: 4 });
+  socket.receive(fill);
+
+  expect(deltas.map((d) => d.seq)).toEqual([4]);
+  expect(fills).toEqual([{ fill, stale: false }]);
+});
+
+test('a fill behind an applied delta is stale', () => {
+  const { socket, deltas, fills } = connect();
+
+  socket.receive({ seq: 6, o: 9, color: 2, ts: 0, cx: 3, cy: 4 });
+  socket.receive(fill);
+
+  expect(deltas.map((d) => d.seq)).toEqual([6]);
+  expect(fills).toEqual([{ fill, stale: true }]);
+});
+
+test('deltas the fill painted over are dropped', () => {
+  const { socket, deltas } = connect();
+
+  socket.receive(fill);
+  socket.receive({ seq: 3, o: 9, color: 2, ts: 0, cx: 3, cy: 4 });
+  socket.receive({ seq: 7, o: 9, color: 2, ts: 0, cx: 3, cy: 4 });
+
+  expect(deltas.map((d) => d.seq)).toEqual([7]);
+});
+
+test('other notices are not treated as deltas', () => {
+  const { socket, deltas, fills } = connect();
+
+  socket.receive({ type: 'epoch', epoch: 2, seq: 8 });
+  socket.receive({ type: 'palette', cx: 3, cy: 4, paletteId: 'neon' });
+
+  expect(deltas).toEqual([]);
+  expect(fills).toEqual([]);
+});
+
+test('fillRect paints the rectangle inclusively and nothing else', () => {
+  const data = createEmptyChunk();
+  fillRect(data, fill.minX, fill.minY, fill.maxX, fill.maxY, fill.color);
+
+  for (let o = 0; o < 256 * 256; o++) {
+    const x = o % 256;
+    const y = Math.floor(o / 256);
+    const inside = x >= 1 && x <= 3 && y >= 2 && y <= 4;
+    expect(getNibble(data, o)).toBe(inside ? 6 : 0);
+  }
+});
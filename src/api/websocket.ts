@@ -7,9 +7,64 @@ export interface Delta {
   o: number;
   color: number;
   ts: number;
+  cx: number;
+  cy: number;
+}
+
+/**
+ * Every tile in a rectangle of a chunk, in tile coordinates within it and
+ * inclusive, painted one color at seq; sent in place of a delta per tile
+ */
+export interface RegionFill {
+  type: 'fill';
+  cx: number;
+  cy: number;
+  seq: number;
+  ts: number;
+  minX: number;
+  minY: number;
+  maxX: number;
+  maxY: number;
+  color: number;
+}
+
+/** Full chunk state sent on subscribe when requested */
+export interface Snapshot {
+  cx: number;
+  cy: number;
+  seq: number;
+  bits: Uint8Array; // 32KB, same layout as /state/chunk
+}
+
+const FRAME_SNAPSHOT = 1;
+
+/**
+ * Decode a binary snapshot frame: type byte, then cx, cy and seq as
+ * big-endian 64-bit integers, then the chunk bits
+ */
+function decodeSnapshot(data: ArrayBuffer): Snapshot | null {
+  const view = new DataView(data);
+  if (data.byteLength < 25 || view.getUint8(0) !== FRAME_SNAPSHOT) {
+    return null;
+  }
+  // No BigInt in es2015; chunk coords and seqs fit in a double
+  const int64 = (at: number) => view.getInt32(at) * 4294967296 + view.getUint32(at + 4);
+  return {
+    cx: int64(1),
+    cy: int64(9),
+    seq: int64(17),
+    bits: new Uint8Array(data, 25),
+  };
 }
 
 export type DeltaCallback = (delta: Delta) => void;
+export type SnapshotCallback = (snapshot: Snapshot) => void;
+/**
+ * Called with each fill. Fills and deltas are queued separately on the
+ * server, so when a later delta has already been passed on, stale is true
+ * and the chunk should be refetched rather than the fill applied over it.
+ */
+export type FillCallback = (fill: RegionFill, stale: boolean) => void;
 export type ErrorCallback = (error: Event) => void;
 export type CloseCallback = () => void;
 export type OpenCallback = () => void;
@@ -25,10 +80,15 @@ export class ChunkWebSocket {
   private onError?: ErrorCallback;
   private onClose?: CloseCallback;
   private onOpen?: OpenCallback;
+  private onSnapshot?: SnapshotCallback;
+  private onFill?: FillCallback;
   private reconnectAttempts = 0;
   private maxReconnectAttempts = 5;
   private reconnectDelay = 1000;
   private shouldReconnect = true;
+  private snapshotSeq = 0;
+  private fillSeq = 0;
+  private deltaSeq = 0;
 
   constructor(
     cx: number,
@@ -36,7 +96,9 @@ export class ChunkWebSocket {
     onDelta: DeltaCallback,
     onError?: ErrorCallback,
     onClose?: CloseCallback,
-    onOpen?: OpenCallback
+    onOpen?: OpenCallback,
+    onSnapshot?: SnapshotCallback,
+    onFill?: FillCallback
   ) {
     this.cx = cx;
     this.cy = cy;
@@ -44,16 +106,23 @@ export class ChunkWebSocket {
     this.onError = onError;
     this.onClose = onClose;
     this.onOpen = onOpen;
+    this.onSnapshot = onSnapshot;
+    this.onFill = onFill;
   }
 
   /**
    * Connect to the WebSocket
    */
   connect(): void {
-    const url = `${WS_BASE_URL}/sub?cx=${this.cx}&cy=${this.cy}`;
+    let url = `${WS_BASE_URL}/sub?cx=${this.cx}&cy=${this.cy}`;
+    if (this.onSnapshot) {
+      // Reconnects get a fresh snapshot too, covering any missed deltas
+      url += '&snapshot=1';
+    }
     
     try {
       this.ws = new WebSocket(url);
+      this.ws.binaryType = 'arraybuffer';
       
       this.ws.onopen = () => {
         console.log(`WebSocket connected: chunk (${this.cx}, ${this.cy})`);
@@ -64,8 +133,29 @@ export class ChunkWebSocket {
       };
       
       this.ws.onmessage = (event) => {
+        if (event.data instanceof ArrayBuffer) {
+          const snapshot = decodeSnapshot(event.data);
+          if (snapshot && this.onSnapshot) {
+            this.snapshotSeq = snapshot.seq;
+            this.onSnapshot(snapshot);
+          }
+          return;
+        }
         try {
-          const delta: Delta = JSON.parse(event.data);
+          const message = JSON.parse(event.data);
+          if (message.type === 'fill') {
+            this.handleFill(message as RegionFill);
+            return;
+          }
+          // Deltas carry no type; anything else is a notice
+          const delta: Delta = message;
+          if (message.type !== undefined || delta.seq === undefined) {
+            return;
+          }
+          if (delta.seq <= Math.max(this.snapshotSeq, this.fillSeq)) {
+            return; // already in the snapshot, or painted over by a fill
+          }
+          this.deltaSeq = Math.max(this.deltaSeq, delta.seq);
           this.onDelta(delta);
         } catch (error) {
           console.error('Failed to parse delta:', error);
@@ -98,6 +188,19 @@ export class ChunkWebSocket {
     }
   }
 
+  /**
+   * Pass a fill on, unless the snapshot already has it
+   */
+  private handleFill(fill: RegionFill): void {
+    if (fill.seq <= this.snapshotSeq) {
+      return;
+    }
+    this.fillSeq = Math.max(this.fillSeq, fill.seq);
+    if (this.onFill) {
+      this.onFill(fill, fill.seq < this.deltaSeq);
+    }
+  }
+
   /**
    * Disconnect from the WebSocket
    */
@@ -132,7 +235,9 @@ export class ChunkWebSocketManager {
     onDelta: DeltaCallback,
     onError?: ErrorCallback,
     onClose?: CloseCallback,
-    onOpen?: OpenCallback
+    onOpen?: OpenCallback,
+    onSnapshot?: SnapshotCallback,
+    onFill?: FillCallback
   ): void {
     const key = `${cx}:${cy}`;
     
@@ -141,7 +246,7 @@ export class ChunkWebSocketManager {
       this.unsubscribe(cx, cy);
     }
     
-    const ws = new ChunkWebSocket(cx, cy, onDelta, onError, onClose, onOpen);
+    const ws = new ChunkWebSocket(cx, cy, onDelta, onError, onClose, onOpen, onSnapshot, onFill);
     ws.connect();
     this.connections.set(key, ws);
   }
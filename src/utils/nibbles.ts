@@ -58,6 +58,27 @@ export function setNibble(data: Uint8Array, offset: number, color: number): void
   data[byteIdx] = byte;
 }
 
+/**
+ * Set every tile in a rectangle, in tile coordinates within the chunk and
+ * inclusive, to one color
+ * @param data Chunk data (32KB Uint8Array) - modified in place
+ * @param color Color index (0-15)
+ */
+export function fillRect(
+  data: Uint8Array,
+  minX: number,
+  minY: number,
+  maxX: number,
+  maxY: number,
+  color: number
+): void {
+  for (let y = minY; y <= maxY; y++) {
+    for (let x = minX; x <= maxX; x++) {
+      setNibble(data, y * CHUNK_SIZE + x, color);
+    }
+  }
+}
+
 /**
  * Create an empty chunk (all zeros)
  */